@@ -2,14 +2,28 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net"
+	"net/http"
+	"os/signal"
+	"strings"
+	"syscall"
 	"tablelink/internal/cache"
 	"tablelink/internal/config"
+	delivery "tablelink/internal/delivery/grpc"
+	"tablelink/internal/delivery/oauth"
+	"tablelink/internal/directory"
+	"tablelink/internal/federation"
+	"tablelink/internal/mailer"
 	"tablelink/internal/repository"
+	"tablelink/internal/token"
 	"tablelink/internal/usecase"
+	"tablelink/proto/proto/authpb"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
+	"google.golang.org/grpc"
 )
 
 func main() {
@@ -18,7 +32,10 @@ func main() {
 		log.Fatal(err)
 	}
 
-	pool, err := pgxpool.New(context.Background(), cfg.PgURL)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	pool, err := pgxpool.New(ctx, cfg.PgURL)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -28,9 +45,82 @@ func main() {
 	defer rdb.Close()
 
 	userRepo := repository.NewUserRepository(pool)
-	rightRepo := repository.NewRoleRightRepository(pool)
-	userUC := usecase.NewUserUseCase(userRepo, rightRepo)
+	sessionRepo := repository.NewSessionRepository(rdb)
+	tokenRepo := repository.NewTokenRepository(rdb)
+	issuer := token.NewIssuer(cfg.TokenSecret, tokenRepo)
+	mail := mailer.New(cfg.MailerDriver, cfg.SMTPAddr, cfg.SMTPFrom, cfg.SMTPUsername, cfg.SMTPPassword)
+	outboxRepo := repository.NewOutboxRepository(pool)
+	authenticator := usecase.NewPasswordAuthenticator(userRepo)
+	if cfg.AuthLDAPDomains != "" {
+		dir, err := directory.New(cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ldapAuthenticator := usecase.NewLDAPAuthenticator(dir, userRepo, cfg)
+		byDomain := make(map[string]usecase.Authenticator)
+		for _, d := range strings.Split(cfg.AuthLDAPDomains, ",") {
+			if d = strings.TrimSpace(d); d != "" {
+				byDomain[d] = ldapAuthenticator
+			}
+		}
+		authenticator = usecase.NewDomainAuthenticator(authenticator, byDomain)
+	}
+	webAuthnRepo := repository.NewWebAuthnRepository(pool)
+	authUC := usecase.NewAuthUseCase(authenticator, userRepo, sessionRepo, outboxRepo,
+		repository.NewRateLimitRepository(rdb), webAuthnRepo, issuer, mail, cfg)
+	relyingParty, err := usecase.NewRelyingParty(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	webAuthnUC := usecase.NewWebAuthnUseCase(relyingParty, webAuthnRepo, repository.NewWebAuthnCeremonyRepository(rdb),
+		userRepo, sessionRepo, outboxRepo, issuer, cfg)
+	signer, err := token.LoadSigner(cfg.OIDCSigningKeyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	oauthUC := usecase.NewOAuthUseCase(repository.NewOAuthRepository(pool), userRepo, repository.NewRoleRightRepository(pool),
+		sessionRepo, signer, cfg)
 
-	lis, err := net.Listen("tcp", ":"+cfg.PortUsers)
+	var federationUC usecase.FederationUseCase
+	if cfg.FederationProvidersFile != "" {
+		providers, err := federation.LoadProviders(cfg.FederationProvidersFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		federationUC = usecase.NewFederationUseCase(providers, repository.NewFederationStateRepository(rdb),
+			repository.NewIdentityRepository(pool), userRepo, sessionRepo, outboxRepo, cfg)
+	}
 
+	lis, err := net.Listen("tcp", ":"+cfg.PortAuth)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	srv := grpc.NewServer()
+	authpb.RegisterAuthServiceServer(srv, delivery.NewAuthHandler(authUC, webAuthnUC))
+
+	oauthSrv := &http.Server{
+		Addr:              ":" + cfg.PortOAuth,
+		Handler:           oauth.NewServer(oauthUC, authUC, federationUC, signer, cfg.OAuthIssuer),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		log.Printf("OAuth server listening on :%s", cfg.PortOAuth)
+		if err := oauthSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = oauthSrv.Shutdown(shutdownCtx)
+		srv.GracefulStop()
+	}()
+
+	log.Printf("Auth service listening on :%s", cfg.PortAuth)
+	if err := srv.Serve(lis); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,45 @@
+// Command fakeidp serves a fake OpenID Connect identity provider for trying
+// federated login locally. Every authorization request signs in the user
+// given by -claims.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"tablelink/internal/federation"
+	"time"
+)
+
+const defaultClaims = `{"sub": "fake-user-1", "email": "ada@example.org", "email_verified": true, "name": "Ada Lovelace", "groups": ["staff"]}`
+
+func main() {
+	addr := flag.String("addr", ":9090", "address to listen on")
+	issuer := flag.String("issuer", "http://localhost:9090", "issuer URL the provider is reachable at")
+	clientID := flag.String("client-id", "tablelink", "client ID of the relying party")
+	clientSecret := flag.String("client-secret", "fake-secret", "client secret of the relying party")
+	rawClaims := flag.String("claims", defaultClaims, "ID token claims of the signed-in user, as JSON")
+	flag.Parse()
+
+	var claims map[string]any
+	if err := json.Unmarshal([]byte(*rawClaims), &claims); err != nil {
+		log.Fatalf("Failed to parse -claims with err %v", err)
+	}
+	if _, ok := claims["sub"].(string); !ok {
+		log.Fatal("-claims needs a sub")
+	}
+
+	idp, err := federation.NewFakeIdP(*issuer, *clientID, *clientSecret, claims)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           idp,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	log.Printf("Fake identity provider %s listening on %s", *issuer, *addr)
+	log.Fatal(srv.ListenAndServe())
+}
@@ -0,0 +1,92 @@
+// Command fakepasskey plays the browser and authenticator for trying
+// passkeys locally. It reads the options returned by
+// BeginWebAuthnRegistration or BeginWebAuthnLogin from stdin and writes
+// the credential to finish the ceremony with to stdout, keeping its
+// passkeys in the file given by -state.
+//
+//	fakepasskey register < creation-options.json
+//	fakepasskey login < request-options.json
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"tablelink/internal/passkey"
+)
+
+func main() {
+	state := flag.String("state", "fakepasskey.json", "file the passkeys are kept in")
+	origin := flag.String("origin", "http://localhost:3000", "origin the browser reports")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: fakepasskey [flags] register|login < options.json")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	authenticator, err := load(*state, *origin)
+	if err != nil {
+		log.Fatal(err)
+	}
+	options, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		log.Fatalf("Failed to read options with err %v", err)
+	}
+
+	var credential []byte
+	switch flag.Arg(0) {
+	case "register":
+		credential, err = authenticator.Register(options)
+	case "login":
+		credential, err = authenticator.Login(options)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Save first, so the sign count never goes back even if the
+	// credential is not used.
+	if err := save(*state, authenticator); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(credential))
+}
+
+func load(path, origin string) (*passkey.FakeAuthenticator, error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return passkey.NewFakeAuthenticator(origin), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s with err %v", path, err)
+	}
+
+	authenticator := new(passkey.FakeAuthenticator)
+	if err := json.Unmarshal(raw, authenticator); err != nil {
+		return nil, fmt.Errorf("Failed to parse %s with err %v", path, err)
+	}
+	authenticator.Origin = origin
+	return authenticator, nil
+}
+
+func save(path string, authenticator *passkey.FakeAuthenticator) error {
+	raw, err := json.MarshalIndent(authenticator, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, raw, 0o600); err != nil {
+		return fmt.Errorf("Failed to write %s with err %v", path, err)
+	}
+	return nil
+}
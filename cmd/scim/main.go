@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"tablelink/internal/cache"
+	"tablelink/internal/config"
+	"tablelink/internal/delivery/scim"
+	"tablelink/internal/repository"
+	"tablelink/internal/usecase"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.SCIMToken == "" {
+		log.Fatal("APP_SCIM_TOKEN is required")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	pool, err := pgxpool.New(ctx, cfg.PgURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer pool.Close()
+
+	rdb := cache.NewRedis(cfg.RedisAddr)
+	defer rdb.Close()
+
+	userRepo := repository.NewUserRepository(pool)
+	roleRepo := repository.NewRoleRepository(pool)
+	sessionRepo := repository.NewSessionRepository(rdb)
+	provisioningUC := usecase.NewProvisioningUseCase(userRepo, roleRepo, sessionRepo, cfg)
+
+	mux := http.NewServeMux()
+	mux.Handle("/scim/v2/", http.StripPrefix("/scim/v2", scim.NewServer(provisioningUC, cfg.SCIMToken, cfg.SCIMBaseURL)))
+
+	srv := &http.Server{
+		Addr:              ":" + cfg.PortSCIM,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("SCIM service listening on :%s", cfg.PortSCIM)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"tablelink/proto/proto/userpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const importChunkSize = 32 * 1024
+
+func importUsers(args []string) error {
+	fs := flag.NewFlagSet("import-users", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:50052", "users service address")
+	file := fs.String("file", "", "CSV or JSON lines file to import")
+	format := fs.String("format", "", "csv or jsonl, detected from the file extension when empty")
+	dryRun := fs.Bool("dry-run", false, "validate the file without importing")
+	roleID := fs.Int("role-id", 0, "role of the caller")
+	section := fs.String("section", "", "section of the caller right")
+	route := fs.String("route", "", "route of the caller right")
+	fs.Parse(args)
+
+	if *file == "" {
+		return errors.New("-file is required")
+	}
+	if *format == "" {
+		switch strings.ToLower(filepath.Ext(*file)) {
+		case ".csv":
+			*format = "csv"
+		case ".jsonl", ".ndjson", ".json":
+			*format = "jsonl"
+		default:
+			return errors.New("cannot detect the format, pass -format")
+		}
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	conn, err := grpc.NewClient(*addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	stream, err := userpb.NewUsersServiceClient(conn).ImportUsers(context.Background())
+	if err != nil {
+		return err
+	}
+
+	req := &userpb.ImportUsersRequest{
+		RoleId:  int32(*roleID),
+		Section: *section,
+		Route:   *route,
+		Format:  *format,
+		DryRun:  *dryRun,
+	}
+	buf := make([]byte, importChunkSize)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 || (errors.Is(err, io.EOF) && req.Format != "") {
+			// The first message always goes out so the server gets the
+			// request fields even for an empty file.
+			req.Chunk = buf[:n]
+			if err := stream.Send(req); err != nil {
+				return err
+			}
+			req = &userpb.ImportUsersRequest{}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return err
+	}
+	if !resp.GetStatus() {
+		return errors.New(resp.GetMessage())
+	}
+
+	for _, result := range resp.GetResults() {
+		if !result.GetOk() {
+			fmt.Printf("row %d\t%s\t%s\n", result.GetRow(), result.GetEmail(), result.GetError())
+		} else if result.GetWarning() != "" {
+			fmt.Printf("row %d\t%s\twarning: %s\n", result.GetRow(), result.GetEmail(), result.GetWarning())
+		}
+	}
+	fmt.Printf("%s: %d rows, %d imported, %d failed\n", resp.GetMessage(), resp.GetTotal(), resp.GetImported(), resp.GetFailed())
+	return nil
+}
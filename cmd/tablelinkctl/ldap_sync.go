@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"tablelink/internal/cache"
+	"tablelink/internal/config"
+	"tablelink/internal/directory"
+	"tablelink/internal/repository"
+	"tablelink/internal/usecase"
+	"text/tabwriter"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ldapSync runs the directory sync once against the database configured in
+// the environment, printing the diff.
+func ldapSync(args []string) error {
+	fs := flag.NewFlagSet("ldap-sync", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "print the changes without applying them")
+	fake := fs.String("fake-directory", "", "JSON file of directory users to use instead of the LDAP server")
+	fs.Parse(args)
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	if *fake != "" {
+		cfg.LDAPFakeDirectory = *fake
+	}
+
+	dir, err := directory.New(cfg)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, cfg.PgURL)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	rdb := cache.NewRedis(cfg.RedisAddr)
+	defer rdb.Close()
+
+	syncUC := usecase.NewDirectorySyncUseCase(dir, repository.NewUserRepository(pool), repository.NewRoleRepository(pool),
+		repository.NewSessionRepository(rdb), cfg)
+	report, err := syncUC.Sync(ctx, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ACTION\tEMAIL\tDN\tCHANGES\tERROR")
+	for _, a := range report.Actions {
+		var errMsg string
+		if a.Err != nil {
+			errMsg = a.Err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", a.Kind, a.Email, a.DN, strings.Join(a.Changes, "; "), errMsg)
+	}
+	tw.Flush()
+
+	verb := "applied"
+	if report.DryRun {
+		verb = "planned"
+	}
+	fmt.Printf("%d changes %s, %d failed, %d unchanged\n", len(report.Actions), verb, report.Failed(), report.Unchanged)
+	if report.Failed() > 0 {
+		return fmt.Errorf("%d directory changes failed", report.Failed())
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const usage = `usage: tablelinkctl <command> [flags]
+
+commands:
+  import-users   import users from a CSV or JSON lines file
+  ldap-sync      sync users and roles from the LDAP directory
+  oauth-client   register, list and delete OAuth clients
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "import-users":
+		err = importUsers(os.Args[2:])
+	case "ldap-sync":
+		err = ldapSync(os.Args[2:])
+	case "oauth-client":
+		err = oauthClient(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
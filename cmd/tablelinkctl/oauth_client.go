@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"tablelink/internal/config"
+	"tablelink/internal/domain"
+	"tablelink/internal/repository"
+	"tablelink/internal/usecase"
+	"text/tabwriter"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const oauthClientUsage = `usage: tablelinkctl oauth-client <create|list|delete> [flags]`
+
+// oauthClient registers, lists and deletes OAuth clients.
+func oauthClient(args []string) error {
+	if len(args) < 1 {
+		return errors.New(oauthClientUsage)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, cfg.PgURL)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	// Managing clients needs neither sessions nor the token signer.
+	oauthUC := usecase.NewOAuthUseCase(repository.NewOAuthRepository(pool), repository.NewUserRepository(pool),
+		repository.NewRoleRightRepository(pool), nil, nil, cfg)
+
+	switch args[0] {
+	case "create":
+		return createOAuthClient(ctx, oauthUC, args[1:])
+	case "list":
+		return listOAuthClients(ctx, oauthUC)
+	case "delete":
+		if len(args) != 2 {
+			return errors.New("usage: tablelinkctl oauth-client delete <client-id>")
+		}
+		return oauthUC.DeleteClient(ctx, args[1])
+	}
+	return errors.New(oauthClientUsage)
+}
+
+func createOAuthClient(ctx context.Context, oauthUC usecase.OAuthUseCase, args []string) error {
+	fs := flag.NewFlagSet("oauth-client create", flag.ExitOnError)
+	name := fs.String("name", "", "name shown to users on the consent page")
+	redirectURIs := fs.String("redirect-uris", "", "comma separated redirect uris")
+	postLogoutURIs := fs.String("post-logout-redirect-uris", "", "comma separated uris to return to after logout")
+	grantTypes := fs.String("grant-types", "authorization_code,refresh_token", "comma separated grant types")
+	scopes := fs.String("scopes", "", `comma separated scopes such as "openid,email,users:read"`)
+	roleID := fs.Int("role-id", 0, "role the client acts with under the client credentials grant")
+	public := fs.Bool("public", false, "register a public client without a secret, e.g. a single-page app")
+	fs.Parse(args)
+
+	client := &domain.OAuthClient{
+		Name:                   *name,
+		RedirectURIs:           splitList(*redirectURIs),
+		PostLogoutRedirectURIs: splitList(*postLogoutURIs),
+		GrantTypes:             splitList(*grantTypes),
+		Scopes:                 splitList(*scopes),
+	}
+	if *roleID > 0 {
+		client.RoleID = roleID
+	}
+
+	created, secret, err := oauthUC.RegisterClient(ctx, client, !*public)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("client_id:     %s\n", created.ID)
+	if secret != "" {
+		fmt.Printf("client_secret: %s\n", secret)
+		fmt.Println("The secret is shown only once.")
+	}
+	return nil
+}
+
+func listOAuthClients(ctx context.Context, oauthUC usecase.OAuthUseCase) error {
+	clients, err := oauthUC.ListClients(ctx)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CLIENT ID\tNAME\tTYPE\tGRANTS\tSCOPES\tROLE")
+	for _, c := range clients {
+		kind := "confidential"
+		if c.Public() {
+			kind = "public"
+		}
+		role := "-"
+		if c.RoleID != nil {
+			role = strconv.Itoa(*c.RoleID)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", c.ID, c.Name, kind, strings.Join(c.GrantTypes, ","),
+			strings.Join(c.Scopes, ","), role)
+	}
+	return tw.Flush()
+}
+
+func splitList(raw string) []string {
+	var items []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
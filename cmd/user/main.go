@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"os/signal"
+	"syscall"
+	"tablelink/internal/cache"
+	"tablelink/internal/config"
+	delivery "tablelink/internal/delivery/grpc"
+	"tablelink/internal/directory"
+	"tablelink/internal/mailer"
+	"tablelink/internal/publisher"
+	"tablelink/internal/repository"
+	"tablelink/internal/token"
+	"tablelink/internal/usecase"
+	"tablelink/internal/webhook"
+	"tablelink/internal/worker"
+	"tablelink/proto/proto/apikeypb"
+	"tablelink/proto/proto/serviceaccountpb"
+	"tablelink/proto/proto/userpb"
+	"tablelink/proto/proto/webhookpb"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"google.golang.org/grpc"
+)
+
+func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	pool, err := pgxpool.New(ctx, cfg.PgURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer pool.Close()
+
+	rdb := cache.NewRedis(cfg.RedisAddr)
+	defer rdb.Close()
+
+	userRepo := repository.NewUserRepository(pool)
+	rightRepo := repository.NewRoleRightRepository(pool)
+	roleRepo := repository.NewRoleRepository(pool)
+	sessionRepo := repository.NewSessionRepository(rdb)
+	tokenRepo := repository.NewTokenRepository(rdb)
+	issuer := token.NewIssuer(cfg.TokenSecret, tokenRepo)
+	mail := mailer.New(cfg.MailerDriver, cfg.SMTPAddr, cfg.SMTPFrom, cfg.SMTPUsername, cfg.SMTPPassword)
+	userUC := usecase.NewUserUseCase(userRepo, rightRepo, roleRepo, sessionRepo, issuer, mail, cfg)
+
+	purger := worker.NewUserPurger(userRepo, cfg.UserRetention, cfg.UserPurgeInterval)
+	go purger.Run(ctx)
+
+	if cfg.LDAPSyncInterval > 0 {
+		dir, err := directory.New(cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		syncUC := usecase.NewDirectorySyncUseCase(dir, userRepo, roleRepo, sessionRepo, cfg)
+		go worker.NewDirectorySyncer(syncUC, cfg.LDAPSyncInterval).Run(ctx)
+	}
+
+	webhookRepo := repository.NewWebhookRepository(pool)
+	webhookUC := usecase.NewWebhookUseCase(webhookRepo, rightRepo, cfg)
+
+	outboxRepo := repository.NewOutboxRepository(pool)
+	notifier := repository.NewOutboxNotifier(pool)
+	go notifier.Run(ctx)
+	watchUC := usecase.NewWatchUseCase(outboxRepo, notifier, rightRepo)
+
+	events := publisher.NewMultiPublisher(
+		publisher.NewRedisStreamPublisher(rdb, cfg.OutboxStream),
+		webhook.NewDispatcher(webhookRepo),
+	)
+	relay := worker.NewOutboxRelay(outboxRepo, events, cfg.OutboxBatchSize, cfg.OutboxPollInterval)
+	go relay.Run(ctx)
+
+	// The lease outlives the HTTP timeout so a delivery is never claimed
+	// twice while it is still being sent.
+	deliverer := worker.NewWebhookDeliverer(webhookRepo, webhook.NewSender(cfg.WebhookTimeout, cfg.WebhookAllowPrivate), cfg.WebhookMaxAttempts,
+		cfg.WebhookBackoffBase, cfg.WebhookBackoffMax, cfg.WebhookBatchSize, cfg.WebhookPollInterval,
+		time.Duration(cfg.WebhookBatchSize+1)*cfg.WebhookTimeout)
+	go deliverer.Run(ctx)
+
+	lis, err := net.Listen("tcp", ":"+cfg.PortUsers)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	apiKeyRepo := repository.NewAPIKeyRepository(pool)
+	apiKeyUC := usecase.NewAPIKeyUseCase(apiKeyRepo, rightRepo)
+	serviceAccountRepo := repository.NewServiceAccountRepository(pool)
+	serviceAccountUC := usecase.NewServiceAccountUseCase(serviceAccountRepo, userRepo, rightRepo)
+	impersonationUC := usecase.NewImpersonationUseCase(userRepo, rightRepo, sessionRepo, outboxRepo, cfg)
+	auth := delivery.NewAuthInterceptor(usecase.NewCredentialUseCase(sessionRepo, apiKeyRepo, serviceAccountRepo, userRepo, cfg),
+		impersonationUC)
+
+	idempotency := delivery.NewIdempotencyInterceptor(repository.NewIdempotencyRepository(rdb), cfg.IdempotencyTTL,
+		userpb.UsersService_CreateUser_FullMethodName,
+		userpb.UsersService_UpdateUser_FullMethodName,
+		userpb.UsersService_DeleteUser_FullMethodName,
+	)
+	// Authentication runs first, so idempotent replays are only served to
+	// callers that could have made the call.
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(auth.Unary(), idempotency.Unary()),
+		grpc.ChainStreamInterceptor(auth.Stream()),
+	)
+	userpb.RegisterUsersServiceServer(srv, delivery.NewUserHandler(userUC, watchUC, impersonationUC))
+	webhookpb.RegisterWebhookServiceServer(srv, delivery.NewWebhookHandler(webhookUC))
+	apikeypb.RegisterAPIKeyServiceServer(srv, delivery.NewAPIKeyHandler(apiKeyUC))
+	serviceaccountpb.RegisterServiceAccountServiceServer(srv, delivery.NewServiceAccountHandler(serviceAccountUC))
+
+	go func() {
+		<-ctx.Done()
+		// WatchChanges streams never end on their own, so give in-flight
+		// calls a moment and then cut the rest off.
+		stopped := make(chan struct{})
+		go func() {
+			srv.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(10 * time.Second):
+			srv.Stop()
+		}
+	}()
+
+	log.Printf("Users service listening on :%s", cfg.PortUsers)
+	if err := srv.Serve(lis); err != nil {
+		log.Fatal(err)
+	}
+}
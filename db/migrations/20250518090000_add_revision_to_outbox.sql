@@ -0,0 +1,53 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Outbox ids are handed out when rows are inserted, not when they commit,
+-- so they can become visible out of order. The relay assigns revisions to
+-- committed rows one batch at a time, which gives watchers a sequence they
+-- can resume from without missing events.
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS revision BIGINT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS outbox_revision_key ON outbox (revision);
+CREATE INDEX IF NOT EXISTS outbox_unsequenced_idx ON outbox (id) WHERE revision IS NULL;
+
+-- role_rights has no repository writes of its own, so changes made by hand
+-- or by other tools are captured here.
+CREATE OR REPLACE FUNCTION role_rights_outbox() RETURNS TRIGGER AS $$
+DECLARE
+    rr role_rights;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        rr := OLD;
+    ELSE
+        rr := NEW;
+    END IF;
+
+    INSERT INTO outbox (aggregate_type, aggregate_id, event_type, payload)
+    VALUES ('role_right', rr.id, 'RoleRightChanged', jsonb_build_object(
+        'role_right', jsonb_build_object(
+            'id', rr.id,
+            'role_id', rr.role_id,
+            'section', rr.section,
+            'route', rr.route,
+            'create', rr.r_created,
+            'read', rr.r_read,
+            'update', rr.r_update,
+            'delete', rr.r_delete
+        ),
+        'deleted', TG_OP = 'DELETE'
+    ));
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER role_rights_outbox AFTER INSERT OR UPDATE OR DELETE ON role_rights
+FOR EACH ROW EXECUTE FUNCTION role_rights_outbox();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER IF EXISTS role_rights_outbox ON role_rights;
+DROP FUNCTION IF EXISTS role_rights_outbox();
+DROP INDEX IF EXISTS outbox_unsequenced_idx;
+DROP INDEX IF EXISTS outbox_revision_key;
+ALTER TABLE outbox DROP COLUMN IF EXISTS revision;
+-- +goose StatementEnd
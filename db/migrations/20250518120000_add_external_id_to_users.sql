@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+-- external_id is the identity provider's id of a user provisioned over SCIM.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS external_id TEXT;
+
+-- SCIM list filters compare userName (the email) and externalId case
+-- insensitively, for equality or as a prefix.
+CREATE INDEX IF NOT EXISTS users_email_lower_idx ON users (LOWER(email) text_pattern_ops);
+CREATE INDEX IF NOT EXISTS users_external_id_lower_idx ON users (LOWER(external_id) text_pattern_ops)
+WHERE external_id IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS users_external_id_lower_idx;
+DROP INDEX IF EXISTS users_email_lower_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS external_id;
+-- +goose StatementEnd
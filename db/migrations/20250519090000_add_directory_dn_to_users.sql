@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+-- directory_dn marks users managed by the directory sync. Users without it
+-- are never deactivated by the sync.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS directory_dn TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_directory_dn_key ON users (LOWER(directory_dn))
+WHERE directory_dn IS NOT NULL AND deleted_at IS NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS users_directory_dn_key;
+ALTER TABLE users DROP COLUMN IF EXISTS directory_dn;
+-- +goose StatementEnd
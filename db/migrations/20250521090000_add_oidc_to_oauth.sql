@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS post_logout_redirect_uris TEXT[] NOT NULL DEFAULT '{}';
+
+ALTER TABLE oauth_codes ADD COLUMN IF NOT EXISTS nonce TEXT NOT NULL DEFAULT '';
+ALTER TABLE oauth_codes ADD COLUMN IF NOT EXISTS auth_time TIMESTAMPTZ;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE oauth_codes DROP COLUMN IF EXISTS auth_time;
+ALTER TABLE oauth_codes DROP COLUMN IF EXISTS nonce;
+
+ALTER TABLE oauth_clients DROP COLUMN IF EXISTS post_logout_redirect_uris;
+-- +goose StatementEnd
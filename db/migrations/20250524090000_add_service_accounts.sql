@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users ADD COLUMN IF NOT EXISTS kind TEXT NOT NULL DEFAULT 'human' CHECK (kind IN ('human', 'service'));
+
+CREATE TABLE IF NOT EXISTS service_account_credentials (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    type TEXT NOT NULL CHECK (type IN ('secret', 'public_key')),
+    key_id TEXT NOT NULL UNIQUE,
+    secret_hash TEXT,
+    public_key TEXT,
+    expires_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS service_account_credentials_user_idx ON service_account_credentials (user_id);
+
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS actor JSONB;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE outbox DROP COLUMN IF EXISTS actor;
+DROP TABLE IF EXISTS service_account_credentials;
+ALTER TABLE users DROP COLUMN IF EXISTS kind;
+-- +goose StatementEnd
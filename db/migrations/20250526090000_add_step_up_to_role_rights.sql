@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+-- step_up_actions lists the actions ('create', 'read', 'update', 'delete')
+-- that need the user to have logged in with a password or passkey within
+-- the last step_up_minutes, e.g. '{delete}' on the users route.
+ALTER TABLE role_rights
+    ADD COLUMN IF NOT EXISTS step_up_actions TEXT[] NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS step_up_minutes INT NOT NULL DEFAULT 0 CHECK (step_up_minutes >= 0);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE role_rights
+    DROP COLUMN IF EXISTS step_up_minutes,
+    DROP COLUMN IF EXISTS step_up_actions;
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+-- The relay publishes in revision order, which unlike id order is the
+-- order events committed in.
+CREATE INDEX IF NOT EXISTS outbox_unpublished_revision_idx ON outbox (revision) WHERE published_at IS NULL;
+DROP INDEX IF EXISTS outbox_unpublished_idx;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS outbox_unpublished_idx ON outbox (id) WHERE published_at IS NULL;
+DROP INDEX IF EXISTS outbox_unpublished_revision_idx;
+-- +goose StatementEnd
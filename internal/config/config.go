@@ -1,23 +1,294 @@
 package config
 
-import "github.com/spf13/viper"
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spf13/viper"
+)
 
 type Config struct {
 	PgURL     string
 	RedisAddr string
 	PortAuth  string
 	PortUsers string
+	PortSCIM  string
+	PortOAuth string
+
+	UserRetention     time.Duration
+	UserPurgeInterval time.Duration
+
+	TokenSecret string
+	InviteURL   string
+	InviteTTL   time.Duration
+
+	VerifyEmailURL  string
+	VerificationTTL time.Duration
+
+	IdempotencyTTL time.Duration
+
+	OutboxStream       string
+	OutboxBatchSize    int
+	OutboxPollInterval time.Duration
+
+	WebhookTimeout      time.Duration
+	WebhookMaxAttempts  int
+	WebhookBackoffBase  time.Duration
+	WebhookBackoffMax   time.Duration
+	WebhookBatchSize    int
+	WebhookPollInterval time.Duration
+	// WebhookAllowPrivate lets subscriptions point at private, loopback and
+	// link-local addresses, for local development only.
+	WebhookAllowPrivate bool
+
+	SCIMToken         string
+	SCIMBaseURL       string
+	SCIMDefaultRoleID int
+
+	LDAPURL             string
+	LDAPStartTLS        bool
+	LDAPBindDN          string
+	LDAPBindPassword    string
+	LDAPBaseDN          string
+	LDAPUserFilter      string
+	LDAPEmailAttr       string
+	LDAPNameAttr        string
+	LDAPGroupBaseDN     string
+	LDAPGroupFilter     string
+	LDAPGroupMemberAttr string
+	LDAPGroupNameAttr   string
+	// LDAPGroupRoles maps group names to role IDs, e.g. "admins=1,staff=2".
+	// The first listed group a user belongs to decides the role.
+	LDAPGroupRoles    string
+	LDAPDefaultRoleID int
+	LDAPSyncInterval  time.Duration
+	// LDAPAdoptByEmail lets the sync and LDAP logins take over an existing
+	// user whose email matches a directory entry. Without it such entries
+	// are skipped and their logins refused.
+	LDAPAdoptByEmail bool
+	// LDAPFakeDirectory is a JSON file of directory users to sync from
+	// instead of a real server, for local runs.
+	LDAPFakeDirectory string
+	// AuthLDAPDomains lists the email domains, e.g. "example.org,corp.example",
+	// whose users log in with their directory password instead of a local one.
+	AuthLDAPDomains string
+
+	// OAuthIssuer is the public base URL of the OAuth server, used in its
+	// metadata document.
+	OAuthIssuer          string
+	OAuthCodeTTL         time.Duration
+	OAuthAccessTokenTTL  time.Duration
+	OAuthRefreshTokenTTL time.Duration
+
+	// OIDCSigningKeyFile is a PEM RSA private key for signing ID tokens. A
+	// temporary key is generated when it is empty.
+	OIDCSigningKeyFile string
+	OIDCIDTokenTTL     time.Duration
+
+	// FederationProvidersFile is a JSON file of external OpenID Connect
+	// providers users can sign in with. Federated login is off without it.
+	FederationProvidersFile string
+	FederationStateTTL      time.Duration
+
+	// ServiceAccountAudience is the aud service accounts put in the
+	// assertions they sign.
+	ServiceAccountAudience string
+
+	ImpersonationTTL time.Duration
+
+	// MagicLinkEnabled turns on passwordless login with emailed links.
+	MagicLinkEnabled bool
+	MagicLinkURL     string
+	MagicLinkTTL     time.Duration
+	// MagicLinkRateLimit is how many links one email address can be sent
+	// per MagicLinkRateWindow.
+	MagicLinkRateLimit  int
+	MagicLinkRateWindow time.Duration
+
+	// WebAuthnRPID is the domain passkeys are registered for, and
+	// WebAuthnRPOrigins the comma separated origins, e.g.
+	// "https://app.example.org", allowed to use them.
+	WebAuthnRPID          string
+	WebAuthnRPDisplayName string
+	WebAuthnRPOrigins     string
+	WebAuthnCeremonyTTL   time.Duration
+	// WebAuthnStepUpMaxAge is how recent a strong login must be to add or
+	// delete a passkey.
+	WebAuthnStepUpMaxAge time.Duration
+	// SecondFactorTTL is how long a user who has passkeys has to confirm a
+	// password login with one.
+	SecondFactorTTL time.Duration
+
+	MailerDriver string
+	SMTPAddr     string
+	SMTPFrom     string
+	SMTPUsername string
+	SMTPPassword string
 }
 
 func Load() (*Config, error) {
 	viper.SetEnvPrefix("APP")
 	viper.AutomaticEnv()
 
+	viper.SetDefault("USER_RETENTION", 30*24*time.Hour)
+	viper.SetDefault("USER_PURGE_INTERVAL", time.Hour)
+	viper.SetDefault("INVITE_URL", "http://localhost:3000/invite")
+	viper.SetDefault("INVITE_TTL", 72*time.Hour)
+	viper.SetDefault("VERIFY_EMAIL_URL", "http://localhost:3000/verify-email")
+	viper.SetDefault("VERIFICATION_TTL", 24*time.Hour)
+	viper.SetDefault("IDEMPOTENCY_TTL", 24*time.Hour)
+	viper.SetDefault("MAILER_DRIVER", "log")
+	viper.SetDefault("OUTBOX_STREAM", "tablelink:events")
+	viper.SetDefault("OUTBOX_BATCH_SIZE", 100)
+	viper.SetDefault("OUTBOX_POLL_INTERVAL", time.Second)
+	viper.SetDefault("WEBHOOK_TIMEOUT", 10*time.Second)
+	viper.SetDefault("WEBHOOK_MAX_ATTEMPTS", 8)
+	viper.SetDefault("WEBHOOK_BACKOFF_BASE", 30*time.Second)
+	viper.SetDefault("WEBHOOK_BACKOFF_MAX", 6*time.Hour)
+	viper.SetDefault("WEBHOOK_BATCH_SIZE", 50)
+	viper.SetDefault("WEBHOOK_POLL_INTERVAL", 5*time.Second)
+	viper.SetDefault("PORT_SCIM", "8080")
+	viper.SetDefault("SCIM_BASE_URL", "http://localhost:8080/scim/v2")
+	viper.SetDefault("PORT_OAUTH", "8081")
+	viper.SetDefault("OAUTH_ISSUER", "http://localhost:8081")
+	viper.SetDefault("OAUTH_CODE_TTL", 10*time.Minute)
+	viper.SetDefault("OAUTH_ACCESS_TOKEN_TTL", time.Hour)
+	viper.SetDefault("OAUTH_REFRESH_TOKEN_TTL", 30*24*time.Hour)
+	viper.SetDefault("OIDC_ID_TOKEN_TTL", time.Hour)
+	viper.SetDefault("FEDERATION_STATE_TTL", 10*time.Minute)
+	viper.SetDefault("SERVICE_ACCOUNT_AUDIENCE", "tablelink")
+	viper.SetDefault("IMPERSONATION_TTL", 15*time.Minute)
+	viper.SetDefault("MAGIC_LINK_URL", "http://localhost:3000/magic-link")
+	viper.SetDefault("MAGIC_LINK_TTL", 15*time.Minute)
+	viper.SetDefault("MAGIC_LINK_RATE_LIMIT", 5)
+	viper.SetDefault("MAGIC_LINK_RATE_WINDOW", time.Hour)
+	viper.SetDefault("WEBAUTHN_RP_ID", "localhost")
+	viper.SetDefault("WEBAUTHN_RP_DISPLAY_NAME", "Tablelink")
+	viper.SetDefault("WEBAUTHN_RP_ORIGINS", "http://localhost:3000")
+	viper.SetDefault("WEBAUTHN_CEREMONY_TTL", 5*time.Minute)
+	viper.SetDefault("WEBAUTHN_STEP_UP_MAX_AGE", 5*time.Minute)
+	viper.SetDefault("SECOND_FACTOR_TTL", 5*time.Minute)
+	viper.SetDefault("LDAP_USER_FILTER", "(objectClass=inetOrgPerson)")
+	viper.SetDefault("LDAP_EMAIL_ATTR", "mail")
+	viper.SetDefault("LDAP_NAME_ATTR", "cn")
+	viper.SetDefault("LDAP_GROUP_FILTER", "(objectClass=groupOfNames)")
+	viper.SetDefault("LDAP_GROUP_MEMBER_ATTR", "member")
+	viper.SetDefault("LDAP_GROUP_NAME_ATTR", "cn")
+
 	cfg := &Config{
 		PgURL:     viper.GetString("PG_URL"),
 		RedisAddr: viper.GetString("REDIS_ADDR"),
 		PortAuth:  viper.GetString("PORT_AUTH"),
 		PortUsers: viper.GetString("PORT_USERS"),
+		PortSCIM:  viper.GetString("PORT_SCIM"),
+		PortOAuth: viper.GetString("PORT_OAUTH"),
+
+		UserRetention:     viper.GetDuration("USER_RETENTION"),
+		UserPurgeInterval: viper.GetDuration("USER_PURGE_INTERVAL"),
+
+		TokenSecret: viper.GetString("TOKEN_SECRET"),
+		InviteURL:   viper.GetString("INVITE_URL"),
+		InviteTTL:   viper.GetDuration("INVITE_TTL"),
+
+		VerifyEmailURL:  viper.GetString("VERIFY_EMAIL_URL"),
+		VerificationTTL: viper.GetDuration("VERIFICATION_TTL"),
+
+		IdempotencyTTL: viper.GetDuration("IDEMPOTENCY_TTL"),
+
+		OutboxStream:       viper.GetString("OUTBOX_STREAM"),
+		OutboxBatchSize:    viper.GetInt("OUTBOX_BATCH_SIZE"),
+		OutboxPollInterval: viper.GetDuration("OUTBOX_POLL_INTERVAL"),
+
+		WebhookTimeout:      viper.GetDuration("WEBHOOK_TIMEOUT"),
+		WebhookMaxAttempts:  viper.GetInt("WEBHOOK_MAX_ATTEMPTS"),
+		WebhookBackoffBase:  viper.GetDuration("WEBHOOK_BACKOFF_BASE"),
+		WebhookBackoffMax:   viper.GetDuration("WEBHOOK_BACKOFF_MAX"),
+		WebhookBatchSize:    viper.GetInt("WEBHOOK_BATCH_SIZE"),
+		WebhookPollInterval: viper.GetDuration("WEBHOOK_POLL_INTERVAL"),
+		WebhookAllowPrivate: viper.GetBool("WEBHOOK_ALLOW_PRIVATE"),
+
+		SCIMToken:         viper.GetString("SCIM_TOKEN"),
+		SCIMBaseURL:       viper.GetString("SCIM_BASE_URL"),
+		SCIMDefaultRoleID: viper.GetInt("SCIM_DEFAULT_ROLE_ID"),
+
+		LDAPURL:             viper.GetString("LDAP_URL"),
+		LDAPStartTLS:        viper.GetBool("LDAP_START_TLS"),
+		LDAPBindDN:          viper.GetString("LDAP_BIND_DN"),
+		LDAPBindPassword:    viper.GetString("LDAP_BIND_PASSWORD"),
+		LDAPBaseDN:          viper.GetString("LDAP_BASE_DN"),
+		LDAPUserFilter:      viper.GetString("LDAP_USER_FILTER"),
+		LDAPEmailAttr:       viper.GetString("LDAP_EMAIL_ATTR"),
+		LDAPNameAttr:        viper.GetString("LDAP_NAME_ATTR"),
+		LDAPGroupBaseDN:     viper.GetString("LDAP_GROUP_BASE_DN"),
+		LDAPGroupFilter:     viper.GetString("LDAP_GROUP_FILTER"),
+		LDAPGroupMemberAttr: viper.GetString("LDAP_GROUP_MEMBER_ATTR"),
+		LDAPGroupNameAttr:   viper.GetString("LDAP_GROUP_NAME_ATTR"),
+		LDAPGroupRoles:      viper.GetString("LDAP_GROUP_ROLES"),
+		LDAPDefaultRoleID:   viper.GetInt("LDAP_DEFAULT_ROLE_ID"),
+		LDAPSyncInterval:    viper.GetDuration("LDAP_SYNC_INTERVAL"),
+		LDAPAdoptByEmail:    viper.GetBool("LDAP_ADOPT_BY_EMAIL"),
+		LDAPFakeDirectory:   viper.GetString("LDAP_FAKE_DIRECTORY"),
+		AuthLDAPDomains:     viper.GetString("AUTH_LDAP_DOMAINS"),
+
+		OAuthIssuer:          viper.GetString("OAUTH_ISSUER"),
+		OAuthCodeTTL:         viper.GetDuration("OAUTH_CODE_TTL"),
+		OAuthAccessTokenTTL:  viper.GetDuration("OAUTH_ACCESS_TOKEN_TTL"),
+		OAuthRefreshTokenTTL: viper.GetDuration("OAUTH_REFRESH_TOKEN_TTL"),
+
+		OIDCSigningKeyFile: viper.GetString("OIDC_SIGNING_KEY_FILE"),
+		OIDCIDTokenTTL:     viper.GetDuration("OIDC_ID_TOKEN_TTL"),
+
+		FederationProvidersFile: viper.GetString("FEDERATION_PROVIDERS_FILE"),
+		FederationStateTTL:      viper.GetDuration("FEDERATION_STATE_TTL"),
+
+		ServiceAccountAudience: viper.GetString("SERVICE_ACCOUNT_AUDIENCE"),
+
+		ImpersonationTTL: viper.GetDuration("IMPERSONATION_TTL"),
+
+		MagicLinkEnabled:    viper.GetBool("MAGIC_LINK_ENABLED"),
+		MagicLinkURL:        viper.GetString("MAGIC_LINK_URL"),
+		MagicLinkTTL:        viper.GetDuration("MAGIC_LINK_TTL"),
+		MagicLinkRateLimit:  viper.GetInt("MAGIC_LINK_RATE_LIMIT"),
+		MagicLinkRateWindow: viper.GetDuration("MAGIC_LINK_RATE_WINDOW"),
+
+		WebAuthnRPID:          viper.GetString("WEBAUTHN_RP_ID"),
+		WebAuthnRPDisplayName: viper.GetString("WEBAUTHN_RP_DISPLAY_NAME"),
+		WebAuthnRPOrigins:     viper.GetString("WEBAUTHN_RP_ORIGINS"),
+		WebAuthnCeremonyTTL:   viper.GetDuration("WEBAUTHN_CEREMONY_TTL"),
+		WebAuthnStepUpMaxAge:  viper.GetDuration("WEBAUTHN_STEP_UP_MAX_AGE"),
+		SecondFactorTTL:       viper.GetDuration("SECOND_FACTOR_TTL"),
+
+		MailerDriver: viper.GetString("MAILER_DRIVER"),
+		SMTPAddr:     viper.GetString("SMTP_ADDR"),
+		SMTPFrom:     viper.GetString("SMTP_FROM"),
+		SMTPUsername: viper.GetString("SMTP_USERNAME"),
+		SMTPPassword: viper.GetString("SMTP_PASSWORD"),
+	}
+
+	if cfg.TokenSecret == "" {
+		return nil, errors.New("APP_TOKEN_SECRET is required")
+	}
+	// The purger hard-deletes users soft-deleted longer ago than the
+	// retention, so without one nothing could be restored.
+	if cfg.UserRetention <= 0 {
+		return nil, fmt.Errorf("APP_USER_RETENTION must be a positive duration, got %s", cfg.UserRetention)
+	}
+	// The workers poll on a ticker, which panics on an interval that is not
+	// positive. LDAP sync alone treats zero as turned off.
+	for _, interval := range []struct {
+		name     string
+		value    time.Duration
+		optional bool
+	}{
+		{"APP_USER_PURGE_INTERVAL", cfg.UserPurgeInterval, false},
+		{"APP_OUTBOX_POLL_INTERVAL", cfg.OutboxPollInterval, false},
+		{"APP_WEBHOOK_POLL_INTERVAL", cfg.WebhookPollInterval, false},
+		{"APP_LDAP_SYNC_INTERVAL", cfg.LDAPSyncInterval, true},
+	} {
+		if interval.value < 0 || (interval.value == 0 && !interval.optional) {
+			return nil, fmt.Errorf("%s must be a positive duration, got %s", interval.name, interval.value)
+		}
 	}
 	return cfg, nil
 
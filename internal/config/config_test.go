@@ -0,0 +1,48 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadIntervals(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		// wantErr names the variable the error must be about.
+		wantErr string
+	}{
+		{name: "accepts the defaults"},
+		{name: "accepts LDAP sync turned off", env: map[string]string{"APP_LDAP_SYNC_INTERVAL": "0"}},
+		{name: "refuses a zero user retention", env: map[string]string{"APP_USER_RETENTION": "0"}, wantErr: "APP_USER_RETENTION"},
+		{name: "refuses a negative user retention", env: map[string]string{"APP_USER_RETENTION": "-24h"}, wantErr: "APP_USER_RETENTION"},
+		{name: "refuses a zero purge interval", env: map[string]string{"APP_USER_PURGE_INTERVAL": "0s"}, wantErr: "APP_USER_PURGE_INTERVAL"},
+		{name: "refuses a negative outbox poll interval", env: map[string]string{"APP_OUTBOX_POLL_INTERVAL": "-1s"}, wantErr: "APP_OUTBOX_POLL_INTERVAL"},
+		{name: "refuses a zero webhook poll interval", env: map[string]string{"APP_WEBHOOK_POLL_INTERVAL": "0"}, wantErr: "APP_WEBHOOK_POLL_INTERVAL"},
+		{name: "refuses a negative LDAP sync interval", env: map[string]string{"APP_LDAP_SYNC_INTERVAL": "-1m"}, wantErr: "APP_LDAP_SYNC_INTERVAL"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("APP_TOKEN_SECRET", "secret")
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			cfg, err := Load()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load() err = %v, want an error about %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() err = %v", err)
+			}
+			if cfg.OutboxPollInterval != time.Second {
+				t.Errorf("OutboxPollInterval = %s, want the default 1s", cfg.OutboxPollInterval)
+			}
+		})
+	}
+}
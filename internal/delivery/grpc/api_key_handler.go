@@ -0,0 +1,114 @@
+package grpc
+
+import (
+	"context"
+	"tablelink/internal/domain"
+	"tablelink/internal/usecase"
+	"tablelink/proto/proto/apikeypb"
+	"time"
+)
+
+type APIKeyHandler struct {
+	apiKeyUC usecase.APIKeyUseCase
+	apikeypb.UnimplementedAPIKeyServiceServer
+}
+
+func NewAPIKeyHandler(uc usecase.APIKeyUseCase) *APIKeyHandler {
+	return &APIKeyHandler{apiKeyUC: uc}
+}
+
+func toPbAPIKey(k *domain.APIKey) *apikeypb.APIKey {
+	pbKey := &apikeypb.APIKey{
+		Id:         int32(k.ID),
+		Name:       k.Name,
+		Prefix:     domain.APIKeyPrefix + k.Prefix,
+		KeyRoleId:  int32(k.RoleID),
+		AllowedIps: k.AllowedIPs,
+		CreatedAt:  k.CreatedAt.Format(time.RFC3339),
+	}
+	if k.ExpiresAt != nil {
+		pbKey.ExpiresAt = k.ExpiresAt.Format(time.RFC3339)
+	}
+	if k.CreatedBy != nil {
+		pbKey.CreatedBy = int32(*k.CreatedBy)
+	}
+	if k.LastUsedAt != nil {
+		pbKey.LastUsedAt = k.LastUsedAt.Format(time.RFC3339)
+	}
+	if k.LastUsedIP != nil {
+		pbKey.LastUsedIp = *k.LastUsedIP
+	}
+	if k.RevokedAt != nil {
+		pbKey.RevokedAt = k.RevokedAt.Format(time.RFC3339)
+	}
+	return pbKey
+}
+
+func (h *APIKeyHandler) CreateAPIKey(ctx context.Context, req *apikeypb.CreateAPIKeyRequest) (*apikeypb.CreateAPIKeyResponse, error) {
+	pbKey := req.GetApiKey()
+	key := &domain.APIKey{
+		Name:       pbKey.GetName(),
+		RoleID:     int(pbKey.GetKeyRoleId()),
+		AllowedIPs: pbKey.GetAllowedIps(),
+	}
+	if v := pbKey.GetExpiresAt(); v != "" {
+		expiresAt, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return &apikeypb.CreateAPIKeyResponse{
+				Status:  false,
+				Message: "expires_at must be an RFC 3339 time",
+			}, nil
+		}
+		key.ExpiresAt = &expiresAt
+	}
+
+	created, raw, err := h.apiKeyUC.CreateAPIKey(ctx, int(req.GetRoleId()), req.GetSection(), req.GetRoute(), key)
+	if err != nil {
+		return &apikeypb.CreateAPIKeyResponse{
+			Status:  false,
+			Message: errorMessage(ctx, err),
+		}, nil
+	}
+
+	return &apikeypb.CreateAPIKeyResponse{
+		Status:  true,
+		Message: "Successfully create api key",
+		ApiKey:  toPbAPIKey(created),
+		Key:     raw,
+	}, nil
+}
+
+func (h *APIKeyHandler) ListAPIKeys(ctx context.Context, req *apikeypb.ListAPIKeysRequest) (*apikeypb.ListAPIKeysResponse, error) {
+	keys, err := h.apiKeyUC.ListAPIKeys(ctx, int(req.GetRoleId()), req.GetSection(), req.GetRoute())
+	if err != nil {
+		return &apikeypb.ListAPIKeysResponse{
+			Status:  false,
+			Message: errorMessage(ctx, err),
+		}, nil
+	}
+
+	var pbKeys []*apikeypb.APIKey
+	for _, k := range keys {
+		pbKeys = append(pbKeys, toPbAPIKey(k))
+	}
+
+	return &apikeypb.ListAPIKeysResponse{
+		Status:  true,
+		Message: "Successfully get list api keys",
+		ApiKeys: pbKeys,
+	}, nil
+}
+
+func (h *APIKeyHandler) RevokeAPIKey(ctx context.Context, req *apikeypb.RevokeAPIKeyRequest) (*apikeypb.RevokeAPIKeyResponse, error) {
+	if err := h.apiKeyUC.RevokeAPIKey(ctx, int(req.GetRoleId()), req.GetSection(), req.GetRoute(), int(req.GetApiKeyId())); err != nil {
+		return &apikeypb.RevokeAPIKeyResponse{
+			Status:  false,
+			Message: errorMessage(ctx, err),
+		}, nil
+	}
+
+	return &apikeypb.RevokeAPIKeyResponse{
+		Status:  true,
+		Message: "Successfully revoke api key",
+	}, nil
+}
@@ -2,27 +2,42 @@ package grpc
 
 import (
 	"context"
+	"errors"
+	"tablelink/internal/domain"
 	"tablelink/internal/usecase"
 	"tablelink/proto/proto/authpb"
 )
 
 type AuthHandler struct {
-	authUC usecase.AuthUseCase
+	authUC     usecase.AuthUseCase
+	webAuthnUC usecase.WebAuthnUseCase
 	authpb.UnimplementedAuthServiceServer
 }
 
-func NewAuthHandler(uc usecase.AuthUseCase) *AuthHandler {
+func NewAuthHandler(uc usecase.AuthUseCase, webAuthnUC usecase.WebAuthnUseCase) *AuthHandler {
 	return &AuthHandler{
-		authUC: uc,
+		authUC:     uc,
+		webAuthnUC: webAuthnUC,
 	}
 }
 
+// secondFactorData returns the token to finish the login with when err
+// asks for a passkey.
+func secondFactorData(err error) *authpb.LoginData {
+	var secondFactor *domain.SecondFactorRequiredError
+	if errors.As(err, &secondFactor) {
+		return &authpb.LoginData{SecondFactorToken: secondFactor.Token}
+	}
+	return nil
+}
+
 func (h *AuthHandler) Login(ctx context.Context, req *authpb.LoginRequest) (*authpb.LoginResponse, error) {
 	token, err := h.authUC.Login(ctx, req.Email, req.Password)
 	if err != nil {
 		return &authpb.LoginResponse{
 			Status:  false,
 			Message: err.Error(),
+			Data:    secondFactorData(err),
 		}, nil
 	}
 
@@ -50,3 +65,105 @@ func (h *AuthHandler) Logout(ctx context.Context, req *authpb.LogoutRequest) (*a
 	}, nil
 
 }
+
+func (h *AuthHandler) AcceptInvite(ctx context.Context, req *authpb.AcceptInviteRequest) (*authpb.AcceptInviteResponse, error) {
+	token, err := h.authUC.AcceptInvite(ctx, req.GetInviteToken(), req.GetPassword())
+	if err != nil {
+		return &authpb.AcceptInviteResponse{
+			Status:  false,
+			Message: err.Error(),
+		}, nil
+	}
+
+	return &authpb.AcceptInviteResponse{
+		Status:  true,
+		Message: "Invitation accepted",
+		Data: &authpb.LoginData{
+			AccessToken: token,
+		},
+	}, nil
+}
+
+func (h *AuthHandler) SendVerificationEmail(ctx context.Context, req *authpb.SendVerificationEmailRequest) (*authpb.SendVerificationEmailResponse, error) {
+	if err := h.authUC.SendVerificationEmail(ctx, req.GetAccessToken()); err != nil {
+		return &authpb.SendVerificationEmailResponse{
+			Status:  false,
+			Message: err.Error(),
+		}, nil
+	}
+
+	return &authpb.SendVerificationEmailResponse{
+		Status:  true,
+		Message: "Verification email sent",
+	}, nil
+}
+
+func (h *AuthHandler) VerifyEmail(ctx context.Context, req *authpb.VerifyEmailRequest) (*authpb.VerifyEmailResponse, error) {
+	if err := h.authUC.VerifyEmail(ctx, req.GetVerificationToken()); err != nil {
+		return &authpb.VerifyEmailResponse{
+			Status:  false,
+			Message: err.Error(),
+		}, nil
+	}
+
+	return &authpb.VerifyEmailResponse{
+		Status:  true,
+		Message: "Email verified",
+	}, nil
+}
+
+func (h *AuthHandler) RequestMagicLink(ctx context.Context, req *authpb.RequestMagicLinkRequest) (*authpb.RequestMagicLinkResponse, error) {
+	clientToken, err := h.authUC.RequestMagicLink(ctx, req.GetEmail())
+	if err != nil {
+		return &authpb.RequestMagicLinkResponse{
+			Status:  false,
+			Message: err.Error(),
+		}, nil
+	}
+
+	return &authpb.RequestMagicLinkResponse{
+		Status:      true,
+		Message:     "If the email belongs to an account, a login link has been sent",
+		ClientToken: clientToken,
+	}, nil
+}
+
+func (h *AuthHandler) ConsumeMagicLink(ctx context.Context, req *authpb.ConsumeMagicLinkRequest) (*authpb.ConsumeMagicLinkResponse, error) {
+	token, err := h.authUC.ConsumeMagicLink(ctx, req.GetMagicLinkToken(), req.GetClientToken())
+	if err != nil {
+		return &authpb.ConsumeMagicLinkResponse{
+			Status:  false,
+			Message: err.Error(),
+			Data:    secondFactorData(err),
+		}, nil
+	}
+
+	return &authpb.ConsumeMagicLinkResponse{
+		Status:  true,
+		Message: "Login successful",
+		Data: &authpb.LoginData{
+			AccessToken: token,
+		},
+	}, nil
+}
+
+func (h *AuthHandler) Reauthenticate(ctx context.Context, req *authpb.ReauthenticateRequest) (*authpb.ReauthenticateResponse, error) {
+	var err error
+	if req.GetCeremonyId() != "" {
+		err = h.webAuthnUC.Reauthenticate(ctx, req.GetAccessToken(), req.GetCeremonyId(), []byte(req.GetCredential()))
+	} else {
+		err = h.authUC.Reauthenticate(ctx, req.GetAccessToken(), req.GetPassword())
+	}
+	if err != nil {
+		return &authpb.ReauthenticateResponse{
+			Status:            false,
+			Message:           err.Error(),
+			SecondFactorToken: secondFactorData(err).GetSecondFactorToken(),
+		}, nil
+	}
+
+	return &authpb.ReauthenticateResponse{
+		Status:  true,
+		Message: "Reauthenticated",
+	}, nil
+}
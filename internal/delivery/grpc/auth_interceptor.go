@@ -0,0 +1,152 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"tablelink/internal/domain"
+	"tablelink/internal/usecase"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const authorizationHeader = "authorization"
+
+// AuthInterceptor authenticates every call by the session token or API key
+// in its "authorization: Bearer <credential>" header, and puts the
+// principal in the context.
+//
+// Requests carry the role_id, section and route they are authorized with,
+// which predate authentication. The interceptor overwrites them with the
+// principal's role and the RPC's route in methodRoutes, so a caller can
+// neither claim another role nor pick the rights it is checked on.
+//
+// Every call made while impersonating is audited before it runs, and
+// refused if it cannot be.
+type AuthInterceptor struct {
+	credentialUC    usecase.CredentialUseCase
+	impersonationUC usecase.ImpersonationUseCase
+}
+
+func NewAuthInterceptor(uc usecase.CredentialUseCase, impersonationUC usecase.ImpersonationUseCase) *AuthInterceptor {
+	return &AuthInterceptor{credentialUC: uc, impersonationUC: impersonationUC}
+}
+
+func (i *AuthInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		principal, err := i.authenticate(ctx)
+		if err != nil {
+			return nil, err
+		}
+		ctx = domain.WithPrincipal(ctx, principal)
+		if err := i.audit(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		bindRole(req, principal)
+		bindRoute(req, info.FullMethod)
+		return handler(ctx, req)
+	}
+}
+
+func (i *AuthInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		principal, err := i.authenticate(ss.Context())
+		if err != nil {
+			return err
+		}
+		ctx := domain.WithPrincipal(ss.Context(), principal)
+		if err := i.audit(ctx, info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, &authenticatedStream{
+			ServerStream: ss,
+			ctx:          ctx,
+			principal:    principal,
+			method:       info.FullMethod,
+		})
+	}
+}
+
+func (i *AuthInterceptor) authenticate(ctx context.Context) (*domain.Principal, error) {
+	var credential string
+	if values := metadata.ValueFromIncomingContext(ctx, authorizationHeader); len(values) > 0 {
+		scheme, value, ok := strings.Cut(values[0], " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			credential = strings.TrimSpace(value)
+		}
+	}
+
+	principal, err := i.credentialUC.Authenticate(ctx, credential, peerIP(ctx))
+	switch {
+	case err == nil:
+		return principal, nil
+	case errors.Is(err, domain.ErrUnauthenticated), errors.Is(err, domain.ErrInvalidAPIKey):
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, domain.ErrAPIKeyIPNotAllowed):
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	return nil, status.Errorf(codes.Unavailable, "Failed to authenticate with err %v", err)
+}
+
+func (i *AuthInterceptor) audit(ctx context.Context, method string) error {
+	if err := i.impersonationUC.RecordCall(ctx, method); err != nil {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return nil
+}
+
+// peerIP is the address the call comes from. Forwarding headers are not
+// trusted, so API key allowlists must name the addresses that connect to
+// this service.
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// bindRole sets the top-level role_id of a request to the principal's role.
+func bindRole(req any, principal *domain.Principal) {
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return
+	}
+	m := msg.ProtoReflect()
+	field := m.Descriptor().Fields().ByName("role_id")
+	if field == nil || field.Kind() != protoreflect.Int32Kind || field.IsList() {
+		return
+	}
+	m.Set(field, protoreflect.ValueOfInt32(int32(principal.RoleID)))
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx       context.Context
+	principal *domain.Principal
+	method    string
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *authenticatedStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	bindRole(m, s.principal)
+	bindRoute(m, s.method)
+	return nil
+}
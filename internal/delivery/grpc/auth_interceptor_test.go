@@ -0,0 +1,177 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"tablelink/internal/domain"
+	"tablelink/internal/usecase"
+	"tablelink/proto/proto/userpb"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+type fakeCredentialUC struct {
+	usecase.CredentialUseCase
+	principal *domain.Principal
+}
+
+func (f fakeCredentialUC) Authenticate(ctx context.Context, credential, ip string) (*domain.Principal, error) {
+	if credential != "token" {
+		return nil, domain.ErrUnauthenticated
+	}
+	return f.principal, nil
+}
+
+type fakeImpersonationUC struct {
+	usecase.ImpersonationUseCase
+	calls []string
+}
+
+func (f *fakeImpersonationUC) RecordCall(ctx context.Context, method string) error {
+	if p := domain.PrincipalFromContext(ctx); p != nil && p.ImpersonatorID != 0 {
+		f.calls = append(f.calls, method)
+	}
+	return nil
+}
+
+func TestAuthInterceptorBindsRoleAndRoute(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		req       *userpb.ListUsersRequest
+		wantRoute string
+	}{
+		{
+			name:      "claims another role and route",
+			method:    userpb.UsersService_ListUsers_FullMethodName,
+			req:       &userpb.ListUsersRequest{RoleId: 1, Section: "reports", Route: "no_step_up"},
+			wantRoute: domain.UsersRoute,
+		},
+		{
+			name:      "names nothing",
+			method:    userpb.UsersService_ListUsers_FullMethodName,
+			req:       &userpb.ListUsersRequest{},
+			wantRoute: domain.UsersRoute,
+		},
+		{
+			name:   "unbound method",
+			method: "/proto.UsersService/Unknown",
+			req:    &userpb.ListUsersRequest{Section: "users", Route: "users"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			impersonation := &fakeImpersonationUC{}
+			principal := &domain.Principal{Kind: domain.PrincipalUser, UserID: 7, RoleID: 3, ImpersonatorID: 9}
+			interceptor := NewAuthInterceptor(fakeCredentialUC{principal: principal}, impersonation)
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(authorizationHeader, "Bearer token"))
+
+			var got *userpb.ListUsersRequest
+			_, err := interceptor.Unary()(ctx, tt.req, &grpc.UnaryServerInfo{FullMethod: tt.method},
+				func(ctx context.Context, req any) (any, error) {
+					got = req.(*userpb.ListUsersRequest)
+					return nil, nil
+				})
+			if err != nil {
+				t.Fatalf("Unary() err = %v", err)
+			}
+			wantSection := ""
+			if tt.wantRoute != "" {
+				wantSection = domain.UsersSection
+			}
+			if got.GetRoleId() != 3 || got.GetSection() != wantSection || got.GetRoute() != tt.wantRoute {
+				t.Errorf("request = role %d %q/%q, want role 3 %q/%q", got.GetRoleId(), got.GetSection(), got.GetRoute(), wantSection, tt.wantRoute)
+			}
+			if len(impersonation.calls) != 1 || impersonation.calls[0] != tt.method {
+				t.Errorf("audited calls = %v, want [%s]", impersonation.calls, tt.method)
+			}
+		})
+	}
+}
+
+// fakeServerStream receives the requests in reqs, one per RecvMsg.
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	reqs []*userpb.ImportUsersRequest
+}
+
+func (f *fakeServerStream) Context() context.Context {
+	return f.ctx
+}
+
+func (f *fakeServerStream) RecvMsg(m any) error {
+	if len(f.reqs) == 0 {
+		return io.EOF
+	}
+	proto.Merge(m.(*userpb.ImportUsersRequest), f.reqs[0])
+	f.reqs = f.reqs[1:]
+	return nil
+}
+
+func TestAuthInterceptorBindsStreamedRequests(t *testing.T) {
+	tests := []struct {
+		name string
+		reqs []*userpb.ImportUsersRequest
+	}{
+		{name: "first message claims another role", reqs: []*userpb.ImportUsersRequest{{RoleId: 1, Section: "reports", Route: "reports"}}},
+		{name: "later message claims another role", reqs: []*userpb.ImportUsersRequest{{}, {RoleId: 1, Route: "reports"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal := &domain.Principal{Kind: domain.PrincipalUser, UserID: 7, RoleID: 3}
+			interceptor := NewAuthInterceptor(fakeCredentialUC{principal: principal}, &fakeImpersonationUC{})
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(authorizationHeader, "Bearer token"))
+			stream := &fakeServerStream{ctx: ctx, reqs: tt.reqs}
+
+			err := interceptor.Stream()(nil, stream, &grpc.StreamServerInfo{FullMethod: userpb.UsersService_ImportUsers_FullMethodName},
+				func(srv any, ss grpc.ServerStream) error {
+					for {
+						req := new(userpb.ImportUsersRequest)
+						if err := ss.RecvMsg(req); err == io.EOF {
+							return nil
+						} else if err != nil {
+							return err
+						}
+						if req.GetRoleId() != 3 || req.GetSection() != domain.UsersSection || req.GetRoute() != domain.UsersRoute {
+							t.Errorf("request = role %d %q/%q, want role 3 %q/%q", req.GetRoleId(), req.GetSection(), req.GetRoute(),
+								domain.UsersSection, domain.UsersRoute)
+						}
+					}
+				})
+			if err != nil {
+				t.Fatalf("Stream() err = %v", err)
+			}
+		})
+	}
+}
+
+// Every RPC whose request names a section must be bound, or its rights
+// would be checked on an empty route and it could never be allowed.
+func TestMethodRoutesCoverEveryRPC(t *testing.T) {
+	protoregistry.GlobalFiles.RangeFiles(func(file protoreflect.FileDescriptor) bool {
+		services := file.Services()
+		for i := 0; i < services.Len(); i++ {
+			methods := services.Get(i).Methods()
+			for j := 0; j < methods.Len(); j++ {
+				method := methods.Get(j)
+				if method.Input().Fields().ByName("section") == nil {
+					continue
+				}
+				name := fmt.Sprintf("/%s/%s", services.Get(i).FullName(), method.Name())
+				if _, ok := methodRoutes[name]; !ok {
+					t.Errorf("%s names a section but is not in methodRoutes", name)
+				}
+			}
+		}
+		return true
+	})
+}
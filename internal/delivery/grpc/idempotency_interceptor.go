@@ -0,0 +1,187 @@
+package grpc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"tablelink/internal/domain"
+	"tablelink/internal/repository"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+const (
+	idempotencyKeyHeader      = "idempotency-key"
+	idempotencyReplayedHeader = "idempotency-replayed"
+	maxIdempotencyKeyLength   = 255
+)
+
+// deterministicErrors are the failures a retry of the same request fails
+// with again, so their response is remembered like a success. Any other
+// failure, such as a database timeout or a missing step-up, releases the key
+// so the retry runs the call again.
+var deterministicErrors = []error{
+	domain.ErrInvalidEmail,
+	domain.ErrWeakPassword,
+	domain.ErrEmailTaken,
+	domain.ErrVersionRequired,
+	domain.ErrUserNotFound,
+	domain.ErrRoleNotFound,
+}
+
+// callFailure holds the error a handler reports through errorMessage, which
+// its Status false response does not carry.
+type callFailure struct {
+	err error
+}
+
+type callFailureKey struct{}
+
+// recordFailure notes err for the IdempotencyInterceptor around the call,
+// if there is one.
+func recordFailure(ctx context.Context, err error) {
+	if f, ok := ctx.Value(callFailureKey{}).(*callFailure); ok {
+		f.err = err
+	}
+}
+
+func retryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, deterministic := range deterministicErrors {
+		if errors.Is(err, deterministic) {
+			return false
+		}
+	}
+	return true
+}
+
+// IdempotencyInterceptor remembers the response of mutating calls made with
+// an idempotency-key header, so a client retrying after a timeout gets the
+// original response back instead of running the call twice.
+type IdempotencyInterceptor struct {
+	repo    repository.IdempotencyRepository
+	ttl     time.Duration
+	methods map[string]bool
+}
+
+func NewIdempotencyInterceptor(repo repository.IdempotencyRepository, ttl time.Duration, methods ...string) *IdempotencyInterceptor {
+	enabled := make(map[string]bool, len(methods))
+	for _, method := range methods {
+		enabled[method] = true
+	}
+
+	return &IdempotencyInterceptor{
+		repo:    repo,
+		ttl:     ttl,
+		methods: enabled,
+	}
+}
+
+func (i *IdempotencyInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if !i.methods[info.FullMethod] {
+			return handler(ctx, req)
+		}
+
+		key := metadata.ValueFromIncomingContext(ctx, idempotencyKeyHeader)
+		if len(key) == 0 || key[0] == "" {
+			return handler(ctx, req)
+		}
+		if len(key[0]) > maxIdempotencyKeyLength {
+			return nil, status.Errorf(codes.InvalidArgument, "%s must be at most %d characters", idempotencyKeyHeader, maxIdempotencyKeyLength)
+		}
+
+		hash, err := requestHash(info.FullMethod, req)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		// Keys are per caller, so one caller cannot see another's response.
+		storeKey := info.FullMethod + ":" + key[0]
+		if p := domain.PrincipalFromContext(ctx); p != nil {
+			storeKey = fmt.Sprintf("%s:%d:%d:%s", p.Kind, p.UserID, p.APIKeyID, storeKey)
+		}
+		record, reserved, err := i.repo.Reserve(ctx, storeKey, &domain.IdempotencyRecord{RequestHash: hash}, i.ttl)
+		if err != nil {
+			return nil, status.Errorf(codes.Unavailable, "Failed to check idempotency key with err %v", err)
+		}
+		if !reserved {
+			return replay(ctx, record, hash)
+		}
+
+		// The outcome must be recorded even if the client has gone away.
+		storeCtx := context.WithoutCancel(ctx)
+		failure := new(callFailure)
+		resp, err := handler(context.WithValue(ctx, callFailureKey{}, failure), req)
+		if err != nil || retryable(failure.err) {
+			if releaseErr := i.repo.Release(storeCtx, storeKey); releaseErr != nil {
+				log.Printf("Failed to release idempotency key with err %v", releaseErr)
+			}
+			if err != nil {
+				return nil, err
+			}
+			return resp, nil
+		}
+
+		msg, ok := resp.(proto.Message)
+		if !ok {
+			return resp, nil
+		}
+		payload, err := proto.Marshal(msg)
+		if err == nil {
+			record.ResponseType = string(msg.ProtoReflect().Descriptor().FullName())
+			record.Response = payload
+			err = i.repo.Complete(storeCtx, storeKey, record, i.ttl)
+		}
+		if err != nil {
+			log.Printf("Failed to store idempotent response with err %v", err)
+		}
+		return resp, nil
+	}
+}
+
+func replay(ctx context.Context, record *domain.IdempotencyRecord, hash string) (any, error) {
+	if record.RequestHash != hash {
+		return nil, status.Errorf(codes.InvalidArgument, "%s was already used with a different request", idempotencyKeyHeader)
+	}
+	if !record.Completed() {
+		return nil, status.Error(codes.Aborted, "a request with this idempotency key is still being processed")
+	}
+
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(record.ResponseType))
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	msg := mt.New().Interface()
+	if err := proto.Unmarshal(record.Response, msg); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	_ = grpc.SetHeader(ctx, metadata.Pairs(idempotencyReplayedHeader, "true"))
+	return msg, nil
+}
+
+func requestHash(method string, req any) (string, error) {
+	h := sha256.New()
+	h.Write([]byte(method))
+	if msg, ok := req.(proto.Message); ok {
+		payload, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+		if err != nil {
+			return "", err
+		}
+		h.Write(payload)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
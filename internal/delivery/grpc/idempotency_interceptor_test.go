@@ -0,0 +1,89 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"tablelink/internal/domain"
+	"tablelink/proto/proto/userpb"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+type fakeIdempotencyRepo struct {
+	records map[string]*domain.IdempotencyRecord
+}
+
+func (f *fakeIdempotencyRepo) Reserve(ctx context.Context, key string, record *domain.IdempotencyRecord, ttl time.Duration) (*domain.IdempotencyRecord, bool, error) {
+	if existing, ok := f.records[key]; ok {
+		return existing, false, nil
+	}
+	f.records[key] = record
+	return record, true, nil
+}
+
+func (f *fakeIdempotencyRepo) Complete(ctx context.Context, key string, record *domain.IdempotencyRecord, ttl time.Duration) error {
+	f.records[key] = record
+	return nil
+}
+
+func (f *fakeIdempotencyRepo) Release(ctx context.Context, key string) error {
+	delete(f.records, key)
+	return nil
+}
+
+func TestIdempotencyInterceptorStoresOnlyFinalResponses(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStored bool
+	}{
+		{name: "success", wantStored: true},
+		{name: "validation failure", err: domain.ErrEmailTaken, wantStored: true},
+		{name: "wrapped validation failure", err: fmt.Errorf("Failed to create user with err %w", domain.ErrInvalidEmail), wantStored: true},
+		{name: "database failure", err: fmt.Errorf("Failed to create user with err %v", context.DeadlineExceeded)},
+		{name: "step-up required", err: &domain.StepUpRequiredError{MaxAge: time.Minute}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &fakeIdempotencyRepo{records: map[string]*domain.IdempotencyRecord{}}
+			method := userpb.UsersService_CreateUser_FullMethodName
+			interceptor := NewIdempotencyInterceptor(repo, time.Hour, method)
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(idempotencyKeyHeader, "key"))
+
+			calls := 0
+			handler := func(ctx context.Context, req any) (any, error) {
+				calls++
+				if calls > 1 || tt.err == nil {
+					return &userpb.CreateUserReponse{Status: true}, nil
+				}
+				return &userpb.CreateUserReponse{Status: false, Message: errorMessage(ctx, tt.err)}, nil
+			}
+			req := &userpb.CreateUserRequest{User: &userpb.User{Email: "ada@example.org"}}
+			info := &grpc.UnaryServerInfo{FullMethod: method}
+
+			first, err := interceptor.Unary()(ctx, req, info, handler)
+			if err != nil {
+				t.Fatalf("Unary() err = %v", err)
+			}
+			if stored := len(repo.records) == 1; stored != tt.wantStored {
+				t.Fatalf("stored = %v, want %v", stored, tt.wantStored)
+			}
+
+			retry, err := interceptor.Unary()(ctx, req, info, handler)
+			if err != nil {
+				t.Fatalf("Unary() retry err = %v", err)
+			}
+			wantStatus := !tt.wantStored || tt.err == nil
+			if got := retry.(*userpb.CreateUserReponse).GetStatus(); got != wantStatus {
+				t.Errorf("retry status = %v, want %v", got, wantStatus)
+			}
+			if tt.wantStored && first.(*userpb.CreateUserReponse).GetMessage() != retry.(*userpb.CreateUserReponse).GetMessage() {
+				t.Errorf("retry = %v, want the stored %v", retry, first)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package grpc
+
+import (
+	"tablelink/internal/domain"
+	"tablelink/proto/proto/apikeypb"
+	"tablelink/proto/proto/serviceaccountpb"
+	"tablelink/proto/proto/userpb"
+	"tablelink/proto/proto/webhookpb"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+type rightRoute struct {
+	section string
+	route   string
+}
+
+var (
+	usersRoute           = rightRoute{domain.UsersSection, domain.UsersRoute}
+	webhooksRoute        = rightRoute{domain.UsersSection, domain.WebhooksRoute}
+	apiKeysRoute         = rightRoute{domain.UsersSection, domain.APIKeysRoute}
+	serviceAccountsRoute = rightRoute{domain.UsersSection, domain.ServiceAccountsRoute}
+)
+
+// methodRoutes binds every RPC whose request names a section and route to
+// the ones its rights are checked on.
+var methodRoutes = map[string]rightRoute{
+	userpb.UsersService_ListUsers_FullMethodName:        usersRoute,
+	userpb.UsersService_CreateUser_FullMethodName:       usersRoute,
+	userpb.UsersService_UpdateUser_FullMethodName:       usersRoute,
+	userpb.UsersService_DeleteUser_FullMethodName:       usersRoute,
+	userpb.UsersService_RestoreUser_FullMethodName:      usersRoute,
+	userpb.UsersService_PurgeUser_FullMethodName:        usersRoute,
+	userpb.UsersService_ChangeUserStatus_FullMethodName: usersRoute,
+	userpb.UsersService_InviteUser_FullMethodName:       usersRoute,
+	userpb.UsersService_ImportUsers_FullMethodName:      usersRoute,
+	userpb.UsersService_ExportUsers_FullMethodName:      usersRoute,
+	userpb.UsersService_StreamUsers_FullMethodName:      usersRoute,
+	userpb.UsersService_BatchUpdateUsers_FullMethodName: usersRoute,
+	userpb.UsersService_BatchDeleteUsers_FullMethodName: usersRoute,
+	userpb.UsersService_WatchChanges_FullMethodName:     usersRoute,
+
+	webhookpb.WebhookService_CreateWebhook_FullMethodName:         webhooksRoute,
+	webhookpb.WebhookService_ListWebhooks_FullMethodName:          webhooksRoute,
+	webhookpb.WebhookService_UpdateWebhook_FullMethodName:         webhooksRoute,
+	webhookpb.WebhookService_DeleteWebhook_FullMethodName:         webhooksRoute,
+	webhookpb.WebhookService_ListWebhookDeliveries_FullMethodName: webhooksRoute,
+	webhookpb.WebhookService_RedeliverWebhook_FullMethodName:      webhooksRoute,
+
+	apikeypb.APIKeyService_CreateAPIKey_FullMethodName: apiKeysRoute,
+	apikeypb.APIKeyService_ListAPIKeys_FullMethodName:  apiKeysRoute,
+	apikeypb.APIKeyService_RevokeAPIKey_FullMethodName: apiKeysRoute,
+
+	serviceaccountpb.ServiceAccountService_CreateServiceAccount_FullMethodName:           serviceAccountsRoute,
+	serviceaccountpb.ServiceAccountService_ListServiceAccounts_FullMethodName:            serviceAccountsRoute,
+	serviceaccountpb.ServiceAccountService_DeleteServiceAccount_FullMethodName:           serviceAccountsRoute,
+	serviceaccountpb.ServiceAccountService_AddServiceAccountCredential_FullMethodName:    serviceAccountsRoute,
+	serviceaccountpb.ServiceAccountService_ListServiceAccountCredentials_FullMethodName:  serviceAccountsRoute,
+	serviceaccountpb.ServiceAccountService_RevokeServiceAccountCredential_FullMethodName: serviceAccountsRoute,
+}
+
+// bindRoute sets the top-level section and route of a request to the ones
+// of method, as bindRole does for role_id. A method missing from
+// methodRoutes gets empty ones, which no right is granted on.
+func bindRoute(req any, method string) {
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return
+	}
+	m := msg.ProtoReflect()
+	bound := methodRoutes[method]
+	setString(m, "section", bound.section)
+	setString(m, "route", bound.route)
+}
+
+func setString(m protoreflect.Message, name protoreflect.Name, value string) {
+	field := m.Descriptor().Fields().ByName(name)
+	if field == nil || field.Kind() != protoreflect.StringKind || field.IsList() {
+		return
+	}
+	m.Set(field, protoreflect.ValueOfString(value))
+}
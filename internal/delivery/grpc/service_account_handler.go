@@ -0,0 +1,182 @@
+package grpc
+
+import (
+	"context"
+	"tablelink/internal/domain"
+	"tablelink/internal/usecase"
+	"tablelink/proto/proto/serviceaccountpb"
+	"time"
+)
+
+type ServiceAccountHandler struct {
+	serviceAccountUC usecase.ServiceAccountUseCase
+	serviceaccountpb.UnimplementedServiceAccountServiceServer
+}
+
+func NewServiceAccountHandler(uc usecase.ServiceAccountUseCase) *ServiceAccountHandler {
+	return &ServiceAccountHandler{serviceAccountUC: uc}
+}
+
+func toPbServiceAccount(u *domain.User) *serviceaccountpb.ServiceAccount {
+	return &serviceaccountpb.ServiceAccount{
+		Id:            int32(u.ID),
+		Name:          u.Name,
+		Email:         u.Email,
+		AccountRoleId: int32(u.RoleID),
+		Status:        string(u.Status),
+		Version:       int32(u.Version),
+	}
+}
+
+func toPbCredential(c *domain.ServiceAccountCredential) *serviceaccountpb.ServiceAccountCredential {
+	pbCredential := &serviceaccountpb.ServiceAccountCredential{
+		Id:        int32(c.ID),
+		Type:      string(c.Type),
+		KeyId:     c.KeyID,
+		CreatedAt: c.CreatedAt.Format(time.RFC3339),
+	}
+	if c.Type == domain.CredentialSecret {
+		pbCredential.KeyId = domain.ServiceAccountSecretPrefix + c.KeyID
+	}
+	if c.PublicKey != nil {
+		pbCredential.PublicKey = *c.PublicKey
+	}
+	if c.ExpiresAt != nil {
+		pbCredential.ExpiresAt = c.ExpiresAt.Format(time.RFC3339)
+	}
+	if c.LastUsedAt != nil {
+		pbCredential.LastUsedAt = c.LastUsedAt.Format(time.RFC3339)
+	}
+	if c.RevokedAt != nil {
+		pbCredential.RevokedAt = c.RevokedAt.Format(time.RFC3339)
+	}
+	return pbCredential
+}
+
+func (h *ServiceAccountHandler) CreateServiceAccount(ctx context.Context, req *serviceaccountpb.CreateServiceAccountRequest) (*serviceaccountpb.CreateServiceAccountResponse, error) {
+	account := &domain.User{
+		Name:   req.GetServiceAccount().GetName(),
+		RoleID: int(req.GetServiceAccount().GetAccountRoleId()),
+	}
+	created, err := h.serviceAccountUC.CreateServiceAccount(ctx, int(req.GetRoleId()), req.GetSection(), req.GetRoute(), account)
+	if err != nil {
+		return &serviceaccountpb.CreateServiceAccountResponse{
+			Status:  false,
+			Message: errorMessage(ctx, err),
+		}, nil
+	}
+
+	return &serviceaccountpb.CreateServiceAccountResponse{
+		Status:         true,
+		Message:        "Successfully create service account",
+		ServiceAccount: toPbServiceAccount(created),
+	}, nil
+}
+
+func (h *ServiceAccountHandler) ListServiceAccounts(ctx context.Context, req *serviceaccountpb.ListServiceAccountsRequest) (*serviceaccountpb.ListServiceAccountsResponse, error) {
+	accounts, err := h.serviceAccountUC.ListServiceAccounts(ctx, int(req.GetRoleId()), req.GetSection(), req.GetRoute())
+	if err != nil {
+		return &serviceaccountpb.ListServiceAccountsResponse{
+			Status:  false,
+			Message: errorMessage(ctx, err),
+		}, nil
+	}
+
+	var pbAccounts []*serviceaccountpb.ServiceAccount
+	for _, a := range accounts {
+		pbAccounts = append(pbAccounts, toPbServiceAccount(a))
+	}
+
+	return &serviceaccountpb.ListServiceAccountsResponse{
+		Status:          true,
+		Message:         "Successfully get list service accounts",
+		ServiceAccounts: pbAccounts,
+	}, nil
+}
+
+func (h *ServiceAccountHandler) DeleteServiceAccount(ctx context.Context, req *serviceaccountpb.DeleteServiceAccountRequest) (*serviceaccountpb.DeleteServiceAccountResponse, error) {
+	err := h.serviceAccountUC.DeleteServiceAccount(ctx, int(req.GetRoleId()), req.GetSection(), req.GetRoute(),
+		int(req.GetServiceAccountId()), int(req.GetVersion()))
+	if err != nil {
+		return &serviceaccountpb.DeleteServiceAccountResponse{
+			Status:  false,
+			Message: errorMessage(ctx, err),
+		}, nil
+	}
+
+	return &serviceaccountpb.DeleteServiceAccountResponse{
+		Status:  true,
+		Message: "Successfully delete service account",
+	}, nil
+}
+
+func (h *ServiceAccountHandler) AddServiceAccountCredential(ctx context.Context, req *serviceaccountpb.AddServiceAccountCredentialRequest) (*serviceaccountpb.AddServiceAccountCredentialResponse, error) {
+	var expiresAt *time.Time
+	if v := req.GetExpiresAt(); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return &serviceaccountpb.AddServiceAccountCredentialResponse{
+				Status:  false,
+				Message: "expires_at must be an RFC 3339 time",
+			}, nil
+		}
+		expiresAt = &t
+	}
+
+	credential, returned, err := h.serviceAccountUC.AddCredential(ctx, int(req.GetRoleId()), req.GetSection(), req.GetRoute(),
+		int(req.GetServiceAccountId()), domain.CredentialType(req.GetType()), req.GetPublicKey(), expiresAt)
+	if err != nil {
+		return &serviceaccountpb.AddServiceAccountCredentialResponse{
+			Status:  false,
+			Message: errorMessage(ctx, err),
+		}, nil
+	}
+
+	resp := &serviceaccountpb.AddServiceAccountCredentialResponse{
+		Status:     true,
+		Message:    "Successfully add service account credential",
+		Credential: toPbCredential(credential),
+	}
+	if credential.Type == domain.CredentialSecret {
+		resp.Secret = returned
+	}
+	return resp, nil
+}
+
+func (h *ServiceAccountHandler) ListServiceAccountCredentials(ctx context.Context, req *serviceaccountpb.ListServiceAccountCredentialsRequest) (*serviceaccountpb.ListServiceAccountCredentialsResponse, error) {
+	credentials, err := h.serviceAccountUC.ListCredentials(ctx, int(req.GetRoleId()), req.GetSection(), req.GetRoute(),
+		int(req.GetServiceAccountId()))
+	if err != nil {
+		return &serviceaccountpb.ListServiceAccountCredentialsResponse{
+			Status:  false,
+			Message: errorMessage(ctx, err),
+		}, nil
+	}
+
+	var pbCredentials []*serviceaccountpb.ServiceAccountCredential
+	for _, c := range credentials {
+		pbCredentials = append(pbCredentials, toPbCredential(c))
+	}
+
+	return &serviceaccountpb.ListServiceAccountCredentialsResponse{
+		Status:      true,
+		Message:     "Successfully get list service account credentials",
+		Credentials: pbCredentials,
+	}, nil
+}
+
+func (h *ServiceAccountHandler) RevokeServiceAccountCredential(ctx context.Context, req *serviceaccountpb.RevokeServiceAccountCredentialRequest) (*serviceaccountpb.RevokeServiceAccountCredentialResponse, error) {
+	err := h.serviceAccountUC.RevokeCredential(ctx, int(req.GetRoleId()), req.GetSection(), req.GetRoute(),
+		int(req.GetServiceAccountId()), int(req.GetCredentialId()))
+	if err != nil {
+		return &serviceaccountpb.RevokeServiceAccountCredentialResponse{
+			Status:  false,
+			Message: errorMessage(ctx, err),
+		}, nil
+	}
+
+	return &serviceaccountpb.RevokeServiceAccountCredentialResponse{
+		Status:  true,
+		Message: "Successfully revoke service account credential",
+	}, nil
+}
@@ -0,0 +1,54 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"tablelink/internal/domain"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// stepUpTrailer tells the client of a call that failed for want of a
+// recent login how recent, in seconds, so it can call Reauthenticate and
+// retry without parsing the message.
+const stepUpTrailer = "step-up-max-age"
+
+const (
+	stepUpReason = "STEP_UP_REQUIRED"
+	errorDomain  = "tablelink"
+)
+
+// errorMessage is the message of a call that failed with err, which also
+// sets stepUpTrailer when err asks the user to re-authenticate and records
+// err for the IdempotencyInterceptor.
+func errorMessage(ctx context.Context, err error) string {
+	recordFailure(ctx, err)
+	var stepUp *domain.StepUpRequiredError
+	if errors.As(err, &stepUp) {
+		maxAge := strconv.Itoa(int(stepUp.MaxAge.Seconds()))
+		_ = grpc.SetTrailer(ctx, metadata.Pairs(stepUpTrailer, maxAge))
+	}
+	return err.Error()
+}
+
+// stepUpStatus is the status of a streaming call that failed for want of a
+// recent login, with the same detail as stepUpTrailer as an ErrorInfo.
+func stepUpStatus(stepUp *domain.StepUpRequiredError) error {
+	st := status.New(codes.PermissionDenied, stepUp.Error())
+	detailed, err := st.WithDetails(&errdetails.ErrorInfo{
+		Reason: stepUpReason,
+		Domain: errorDomain,
+		Metadata: map[string]string{
+			stepUpTrailer: strconv.Itoa(int(stepUp.MaxAge.Seconds())),
+		},
+	})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
@@ -2,38 +2,72 @@ package grpc
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"tablelink/internal/domain"
+	"tablelink/internal/export"
 	"tablelink/internal/usecase"
 	"tablelink/proto/proto/userpb"
 	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type UserHandler struct {
-	userUC usecase.UserUseCase
+	userUC          usecase.UserUseCase
+	watchUC         usecase.WatchUseCase
+	impersonationUC usecase.ImpersonationUseCase
 	userpb.UnimplementedUsersServiceServer
 }
 
-func NewUserHandler(uc usecase.UserUseCase) *UserHandler {
-	return &UserHandler{userUC: uc}
+func NewUserHandler(uc usecase.UserUseCase, watchUC usecase.WatchUseCase, impersonationUC usecase.ImpersonationUseCase) *UserHandler {
+	return &UserHandler{userUC: uc, watchUC: watchUC, impersonationUC: impersonationUC}
+}
+
+func toUserFilter(f *userpb.UserFilter) *domain.UserFilter {
+	return &domain.UserFilter{
+		RoleID: int(f.GetRoleId()),
+		Status: domain.UserStatus(f.GetStatus()),
+		Query:  strings.TrimSpace(f.GetQuery()),
+
+		IncludeServiceAccounts: f.GetIncludeServiceAccounts(),
+	}
+}
+
+func toPbUser(u *domain.User) *userpb.User {
+	pbUser := &userpb.User{
+		Id:            int32(u.ID),
+		Name:          u.Name,
+		Email:         u.Email,
+		RoleId:        int32(u.RoleID),
+		Version:       int32(u.Version),
+		Status:        string(u.Status),
+		EmailVerified: u.EmailVerifiedAt != nil,
+		Kind:          string(u.Kind),
+	}
+	if u.LastAccess != nil {
+		pbUser.LastAccess = u.LastAccess.Format(time.RFC3339)
+	}
+	if u.PendingEmail != nil {
+		pbUser.PendingEmail = *u.PendingEmail
+	}
+	return pbUser
 }
 
 func (h *UserHandler) ListUsers(ctx context.Context, req *userpb.ListUsersRequest) (*userpb.ListUsersResponse, error) {
-	users, err := h.userUC.ListUser(ctx, int(req.GetRoleId()), req.GetSection(), req.GetRoute())
+	users, err := h.userUC.ListUser(ctx, int(req.GetRoleId()), req.GetSection(), req.GetRoute(), toUserFilter(req.GetFilter()))
 	if err != nil {
 		return &userpb.ListUsersResponse{
 			Status:  false,
-			Message: err.Error(),
+			Message: errorMessage(ctx, err),
 		}, nil
 	}
 
 	var pbUsers []*userpb.User
 	for _, u := range users {
-		pbUsers = append(pbUsers, &userpb.User{
-			Id:         int32(u.ID),
-			Name:       u.Name,
-			Email:      u.Email,
-			RoleId:     int32(u.RoleID),
-			LastAccess: u.LastAccess.Format(time.RFC3339),
-		})
+		pbUsers = append(pbUsers, toPbUser(u))
 	}
 
 	return &userpb.ListUsersResponse{
@@ -42,3 +76,413 @@ func (h *UserHandler) ListUsers(ctx context.Context, req *userpb.ListUsersReques
 		Users:   pbUsers,
 	}, nil
 }
+
+func (h *UserHandler) CreateUser(ctx context.Context, req *userpb.CreateUserRequest) (*userpb.CreateUserReponse, error) {
+	pbUser := req.GetUser()
+	user := &domain.User{
+		Name:     pbUser.GetName(),
+		Email:    pbUser.GetEmail(),
+		Password: pbUser.GetPassword(),
+		RoleID:   int(pbUser.GetRoleId()),
+	}
+
+	user, err := h.userUC.CreateUser(ctx, int(req.GetRoleId()), req.GetSection(), req.GetRoute(), user)
+	if err != nil {
+		return &userpb.CreateUserReponse{
+			Status:  false,
+			Message: errorMessage(ctx, err),
+		}, nil
+	}
+
+	return &userpb.CreateUserReponse{
+		Status:  true,
+		Message: "Successfully create user",
+		User:    toPbUser(user),
+	}, nil
+}
+
+func (h *UserHandler) UpdateUser(ctx context.Context, req *userpb.UpdateUserRequest) (*userpb.UpdateUserReponse, error) {
+	pbUser := req.GetUser()
+	user := &domain.User{
+		ID:       int(pbUser.GetId()),
+		Name:     pbUser.GetName(),
+		Email:    pbUser.GetEmail(),
+		Password: pbUser.GetPassword(),
+		RoleID:   int(pbUser.GetRoleId()),
+		Version:  int(pbUser.GetVersion()),
+	}
+
+	updated, err := h.userUC.UpdateUser(ctx, int(req.GetRoleId()), req.GetSection(), req.GetRoute(), user)
+	if err != nil {
+		if errors.Is(err, domain.ErrVersionConflict) {
+			return nil, status.Error(codes.Aborted, err.Error())
+		}
+		return &userpb.UpdateUserReponse{
+			Status:  false,
+			Message: errorMessage(ctx, err),
+		}, nil
+	}
+
+	return &userpb.UpdateUserReponse{
+		Status:  true,
+		Message: "Successfully update user",
+		User:    toPbUser(updated),
+	}, nil
+}
+
+func (h *UserHandler) DeleteUser(ctx context.Context, req *userpb.DeleteUserRequest) (*userpb.DeleteeUserReponse, error) {
+	pbUser := req.GetUser()
+	if err := h.userUC.DeleteUser(ctx, int(req.GetRoleId()), req.GetSection(), req.GetRoute(), int(pbUser.GetId()), int(pbUser.GetVersion())); err != nil {
+		if errors.Is(err, domain.ErrVersionConflict) {
+			return nil, status.Error(codes.Aborted, err.Error())
+		}
+		return &userpb.DeleteeUserReponse{
+			Status:  false,
+			Message: errorMessage(ctx, err),
+		}, nil
+	}
+
+	return &userpb.DeleteeUserReponse{
+		Status:  true,
+		Message: "Successfully delete user",
+	}, nil
+}
+
+func (h *UserHandler) RestoreUser(ctx context.Context, req *userpb.RestoreUserRequest) (*userpb.RestoreUserResponse, error) {
+	pbUser := req.GetUser()
+	if err := h.userUC.RestoreUser(ctx, int(req.GetRoleId()), req.GetSection(), req.GetRoute(), int(pbUser.GetId()), int(pbUser.GetVersion())); err != nil {
+		if errors.Is(err, domain.ErrVersionConflict) {
+			return nil, status.Error(codes.Aborted, err.Error())
+		}
+		return &userpb.RestoreUserResponse{
+			Status:  false,
+			Message: errorMessage(ctx, err),
+		}, nil
+	}
+
+	return &userpb.RestoreUserResponse{
+		Status:  true,
+		Message: "Successfully restore user",
+	}, nil
+}
+
+func (h *UserHandler) PurgeUser(ctx context.Context, req *userpb.PurgeUserRequest) (*userpb.PurgeUserResponse, error) {
+	pbUser := req.GetUser()
+	if err := h.userUC.PurgeUser(ctx, int(req.GetRoleId()), req.GetSection(), req.GetRoute(), int(pbUser.GetId()), int(pbUser.GetVersion())); err != nil {
+		if errors.Is(err, domain.ErrVersionConflict) {
+			return nil, status.Error(codes.Aborted, err.Error())
+		}
+		return &userpb.PurgeUserResponse{
+			Status:  false,
+			Message: errorMessage(ctx, err),
+		}, nil
+	}
+
+	return &userpb.PurgeUserResponse{
+		Status:  true,
+		Message: "Successfully purge user",
+	}, nil
+}
+
+func (h *UserHandler) ChangeUserStatus(ctx context.Context, req *userpb.ChangeUserStatusRequest) (*userpb.ChangeUserStatusResponse, error) {
+	pbUser := req.GetUser()
+	user, err := h.userUC.ChangeUserStatus(ctx, int(req.GetRoleId()), req.GetSection(), req.GetRoute(),
+		int(pbUser.GetId()), int(pbUser.GetVersion()), domain.UserStatus(req.GetStatus()), req.GetReason())
+	if err != nil {
+		if errors.Is(err, domain.ErrVersionConflict) {
+			return nil, status.Error(codes.Aborted, err.Error())
+		}
+		if errors.Is(err, domain.ErrInvalidTransition) {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		return &userpb.ChangeUserStatusResponse{
+			Status:  false,
+			Message: errorMessage(ctx, err),
+		}, nil
+	}
+
+	return &userpb.ChangeUserStatusResponse{
+		Status:  true,
+		Message: "Successfully change user status",
+		User:    toPbUser(user),
+	}, nil
+}
+
+func (h *UserHandler) InviteUser(ctx context.Context, req *userpb.InviteUserRequest) (*userpb.InviteUserResponse, error) {
+	pbUser := req.GetUser()
+	user := &domain.User{
+		Name:   pbUser.GetName(),
+		Email:  pbUser.GetEmail(),
+		RoleID: int(pbUser.GetRoleId()),
+	}
+
+	user, err := h.userUC.InviteUser(ctx, int(req.GetRoleId()), req.GetSection(), req.GetRoute(), user)
+	if err != nil {
+		return &userpb.InviteUserResponse{
+			Status:  false,
+			Message: errorMessage(ctx, err),
+		}, nil
+	}
+
+	return &userpb.InviteUserResponse{
+		Status:  true,
+		Message: "Successfully invite user",
+		User:    toPbUser(user),
+	}, nil
+}
+
+func (h *UserHandler) ImportUsers(stream userpb.UsersService_ImportUsersServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return status.Error(codes.InvalidArgument, "import stream is empty")
+	}
+
+	r := &importChunkReader{stream: stream, buf: first.GetChunk()}
+	report, err := h.userUC.ImportUsers(stream.Context(), int(first.GetRoleId()), first.GetSection(), first.GetRoute(),
+		domain.ImportFormat(first.GetFormat()), first.GetDryRun(), r)
+	if err != nil {
+		return stream.SendAndClose(&userpb.ImportUsersResponse{
+			Status:  false,
+			Message: errorMessage(stream.Context(), err),
+		})
+	}
+
+	results := make([]*userpb.ImportUserResult, 0, len(report.Results))
+	for _, result := range report.Results {
+		results = append(results, &userpb.ImportUserResult{
+			Row:     int32(result.Row),
+			Email:   result.Email,
+			Ok:      result.OK(),
+			Error:   result.Error,
+			Warning: result.Warning,
+		})
+	}
+
+	message := "Successfully import users"
+	if report.DryRun {
+		message = "Dry run finished, no users were imported"
+	}
+	return stream.SendAndClose(&userpb.ImportUsersResponse{
+		Status:   true,
+		Message:  message,
+		Total:    int32(report.Total),
+		Imported: int32(report.Imported),
+		Failed:   int32(report.Failed),
+		DryRun:   report.DryRun,
+		Results:  results,
+	})
+}
+
+// importChunkReader exposes the chunks of an ImportUsers stream as a single
+// io.Reader.
+type importChunkReader struct {
+	stream userpb.UsersService_ImportUsersServer
+	buf    []byte
+}
+
+func (r *importChunkReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		msg, err := r.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+		r.buf = msg.GetChunk()
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (h *UserHandler) ExportUsers(req *userpb.ExportUsersRequest, stream userpb.UsersService_ExportUsersServer) error {
+	w := &exportChunkWriter{stream: stream}
+	err := h.userUC.ExportUsers(stream.Context(), int(req.GetRoleId()), req.GetSection(), req.GetRoute(),
+		toUserFilter(req.GetFilter()), export.Format(req.GetFormat()), w)
+	if err != nil {
+		return streamError(err)
+	}
+
+	return w.Flush()
+}
+
+func (h *UserHandler) StreamUsers(req *userpb.StreamUsersRequest, stream userpb.UsersService_StreamUsersServer) error {
+	err := h.userUC.StreamUsers(stream.Context(), int(req.GetRoleId()), req.GetSection(), req.GetRoute(),
+		toUserFilter(req.GetFilter()), int(req.GetChunkSize()), func(users []*domain.User) error {
+			pbUsers := make([]*userpb.User, 0, len(users))
+			for _, u := range users {
+				pbUsers = append(pbUsers, toPbUser(u))
+			}
+			return stream.Send(&userpb.StreamUsersResponse{Users: pbUsers})
+		})
+	if err != nil {
+		return streamError(err)
+	}
+	return nil
+}
+
+// streamError converts usecase errors for streaming RPCs, which cannot
+// report failures through a status field like the unary ones do.
+func streamError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	var stepUp *domain.StepUpRequiredError
+	switch {
+	case errors.As(err, &stepUp):
+		return stepUpStatus(stepUp)
+	case errors.Is(err, domain.ErrPermissionDenied), errors.Is(err, domain.ErrStepUpUnavailable):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, domain.ErrInvalidUserStatus), errors.Is(err, export.ErrUnsupportedFormat):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, domain.ErrRevisionAhead):
+		return status.Error(codes.OutOfRange, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+// exportChunkSize is the size of the chunks ExportUsers sends to the client.
+const exportChunkSize = 32 * 1024
+
+// exportChunkWriter buffers encoded output and sends it to the client in
+// fixed size chunks.
+type exportChunkWriter struct {
+	stream userpb.UsersService_ExportUsersServer
+	buf    []byte
+}
+
+func (w *exportChunkWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for len(w.buf) >= exportChunkSize {
+		if err := w.stream.Send(&userpb.ExportUsersResponse{Chunk: w.buf[:exportChunkSize]}); err != nil {
+			return 0, err
+		}
+		w.buf = w.buf[exportChunkSize:]
+	}
+	return len(p), nil
+}
+
+func (w *exportChunkWriter) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	err := w.stream.Send(&userpb.ExportUsersResponse{Chunk: w.buf})
+	w.buf = nil
+	return err
+}
+
+func (h *UserHandler) BatchUpdateUsers(ctx context.Context, req *userpb.BatchUpdateUsersRequest) (*userpb.BatchUpdateUsersResponse, error) {
+	updates := make([]*domain.UserBatchUpdate, 0, len(req.GetUpdates()))
+	for _, upd := range req.GetUpdates() {
+		updates = append(updates, &domain.UserBatchUpdate{
+			ID:      int(upd.GetId()),
+			Version: int(upd.GetVersion()),
+			Status:  domain.UserStatus(upd.GetStatus()),
+			RoleID:  int(upd.GetNewRoleId()),
+			Reason:  upd.GetReason(),
+		})
+	}
+
+	results, err := h.userUC.BatchUpdateUsers(ctx, int(req.GetRoleId()), req.GetSection(), req.GetRoute(), updates, req.GetAtomic())
+	if err != nil {
+		return &userpb.BatchUpdateUsersResponse{
+			Status:  false,
+			Message: errorMessage(ctx, err),
+		}, nil
+	}
+
+	return &userpb.BatchUpdateUsersResponse{
+		Status:  true,
+		Message: batchMessage(results),
+		Results: toPbBatchResults(results),
+	}, nil
+}
+
+func (h *UserHandler) BatchDeleteUsers(ctx context.Context, req *userpb.BatchDeleteUsersRequest) (*userpb.BatchDeleteUsersResponse, error) {
+	deletes := make([]*domain.UserBatchDelete, 0, len(req.GetUsers()))
+	for _, pbUser := range req.GetUsers() {
+		deletes = append(deletes, &domain.UserBatchDelete{
+			ID:      int(pbUser.GetId()),
+			Version: int(pbUser.GetVersion()),
+		})
+	}
+
+	results, err := h.userUC.BatchDeleteUsers(ctx, int(req.GetRoleId()), req.GetSection(), req.GetRoute(), deletes, req.GetAtomic())
+	if err != nil {
+		return &userpb.BatchDeleteUsersResponse{
+			Status:  false,
+			Message: errorMessage(ctx, err),
+		}, nil
+	}
+
+	return &userpb.BatchDeleteUsersResponse{
+		Status:  true,
+		Message: batchMessage(results),
+		Results: toPbBatchResults(results),
+	}, nil
+}
+
+func batchMessage(results []*domain.BatchResult) string {
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+		}
+	}
+	return fmt.Sprintf("%d of %d users processed, %d failed", len(results)-failed, len(results), failed)
+}
+
+func toPbBatchResults(results []*domain.BatchResult) []*userpb.BatchUserResult {
+	pbResults := make([]*userpb.BatchUserResult, 0, len(results))
+	for _, result := range results {
+		pbResult := &userpb.BatchUserResult{
+			Id:     int32(result.ID),
+			Result: status.New(codes.OK, "").Proto(),
+		}
+		if result.Err != nil {
+			pbResult.Result = status.New(batchErrorCode(result.Err), result.Err.Error()).Proto()
+		}
+		if result.User != nil {
+			pbResult.User = toPbUser(result.User)
+		}
+		pbResults = append(pbResults, pbResult)
+	}
+	return pbResults
+}
+
+func batchErrorCode(err error) codes.Code {
+	switch {
+	case errors.Is(err, domain.ErrVersionConflict), errors.Is(err, domain.ErrBatchAborted):
+		return codes.Aborted
+	case errors.Is(err, domain.ErrUserNotFound):
+		return codes.NotFound
+	case errors.Is(err, domain.ErrInvalidTransition):
+		return codes.FailedPrecondition
+	case errors.Is(err, domain.ErrVersionRequired), errors.Is(err, domain.ErrInvalidUserStatus),
+		errors.Is(err, domain.ErrStatusReasonRequired), errors.Is(err, domain.ErrRoleNotFound),
+		errors.Is(err, domain.ErrNothingToDo):
+		return codes.InvalidArgument
+	default:
+		return codes.Internal
+	}
+}
+
+func (h *UserHandler) Impersonate(ctx context.Context, req *userpb.ImpersonateRequest) (*userpb.ImpersonateResponse, error) {
+	token, expiresAt, err := h.impersonationUC.Impersonate(ctx, int(req.GetRoleId()), int(req.GetUserId()), req.GetReason())
+	if err != nil {
+		return &userpb.ImpersonateResponse{
+			Status:  false,
+			Message: errorMessage(ctx, err),
+		}, nil
+	}
+
+	return &userpb.ImpersonateResponse{
+		Status:      true,
+		Message:     "Successfully impersonate user",
+		AccessToken: token,
+		ExpiresAt:   expiresAt.Format(time.RFC3339),
+	}, nil
+}
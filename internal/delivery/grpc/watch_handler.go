@@ -0,0 +1,73 @@
+package grpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"tablelink/internal/domain"
+	"tablelink/proto/proto/userpb"
+)
+
+func (h *UserHandler) WatchChanges(req *userpb.WatchChangesRequest, stream userpb.UsersService_WatchChangesServer) error {
+	err := h.watchUC.WatchChanges(stream.Context(), int(req.GetRoleId()), req.GetSection(), req.GetRoute(),
+		req.GetFromRevision(), func(revision int64, events []*domain.Event) error {
+			changes := make([]*userpb.Change, 0, len(events))
+			for _, event := range events {
+				change, err := toPbChange(event)
+				if err != nil {
+					return err
+				}
+				changes = append(changes, change)
+			}
+			return stream.Send(&userpb.WatchChangesResponse{Revision: revision, Changes: changes})
+		})
+	if err != nil {
+		return streamError(err)
+	}
+	return nil
+}
+
+func toPbChange(event *domain.Event) (*userpb.Change, error) {
+	change := &userpb.Change{
+		Revision:  event.Revision,
+		EventType: string(event.Type),
+	}
+
+	switch event.AggregateType {
+	case domain.AggregateUser:
+		var payload domain.UserEventPayload
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			return nil, fmt.Errorf("Failed to decode event %d with err %v", event.ID, err)
+		}
+		u := payload.User
+		change.User = &userpb.User{
+			Id:            int32(u.ID),
+			Name:          u.Name,
+			Email:         u.Email,
+			RoleId:        int32(u.RoleID),
+			Version:       int32(u.Version),
+			Status:        string(u.Status),
+			EmailVerified: u.EmailVerified,
+			Kind:          string(u.Kind),
+		}
+		change.Deleted = event.Type == domain.EventUserDeleted
+		change.PreviousRoleId = int32(payload.PreviousRoleID)
+	case domain.AggregateRoleRight:
+		var payload domain.RoleRightEventPayload
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			return nil, fmt.Errorf("Failed to decode event %d with err %v", event.ID, err)
+		}
+		rr := payload.RoleRight
+		change.RoleRight = &userpb.RoleRight{
+			Id:      int32(rr.ID),
+			RoleId:  int32(rr.RoleID),
+			Section: rr.Section,
+			Route:   rr.Route,
+			Create:  rr.Create,
+			Read:    rr.Read,
+			Update:  rr.Update,
+			Delete:  rr.Delete,
+		}
+		change.Deleted = payload.Deleted
+	}
+	return change, nil
+}
@@ -0,0 +1,30 @@
+package grpc
+
+import (
+	"tablelink/internal/domain"
+	"testing"
+)
+
+func TestToPbChangeCarriesUserKind(t *testing.T) {
+	tests := []struct {
+		name string
+		kind domain.UserKind
+	}{
+		{name: "human", kind: domain.UserKindHuman},
+		{name: "service account", kind: domain.UserKindService},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := domain.NewUserEvent(domain.EventUserUpdated, &domain.User{ID: 1, Email: "ada@example.org", Kind: tt.kind})
+
+			change, err := toPbChange(event)
+			if err != nil {
+				t.Fatalf("toPbChange() err = %v", err)
+			}
+			if got := change.GetUser().GetKind(); got != string(tt.kind) {
+				t.Errorf("kind = %q, want %q", got, tt.kind)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package grpc
+
+import (
+	"context"
+	"tablelink/internal/domain"
+	"tablelink/proto/proto/authpb"
+	"time"
+)
+
+func toPbWebAuthnCredential(c *domain.WebAuthnCredential) *authpb.WebAuthnCredential {
+	pbCredential := &authpb.WebAuthnCredential{
+		Id:             int32(c.ID),
+		Name:           c.Name,
+		Transports:     c.Transports,
+		BackupEligible: c.BackupEligible,
+		CreatedAt:      c.CreatedAt.Format(time.RFC3339),
+	}
+	if c.LastUsedAt != nil {
+		pbCredential.LastUsedAt = c.LastUsedAt.Format(time.RFC3339)
+	}
+	return pbCredential
+}
+
+func (h *AuthHandler) BeginWebAuthnRegistration(ctx context.Context, req *authpb.BeginWebAuthnRegistrationRequest) (*authpb.BeginWebAuthnRegistrationResponse, error) {
+	ceremonyID, options, err := h.webAuthnUC.BeginRegistration(ctx, req.GetAccessToken(), req.GetName())
+	if err != nil {
+		return &authpb.BeginWebAuthnRegistrationResponse{
+			Status:  false,
+			Message: errorMessage(ctx, err),
+		}, nil
+	}
+
+	return &authpb.BeginWebAuthnRegistrationResponse{
+		Status:     true,
+		Message:    "Passkey registration started",
+		CeremonyId: ceremonyID,
+		Options:    string(options),
+	}, nil
+}
+
+func (h *AuthHandler) FinishWebAuthnRegistration(ctx context.Context, req *authpb.FinishWebAuthnRegistrationRequest) (*authpb.FinishWebAuthnRegistrationResponse, error) {
+	credential, err := h.webAuthnUC.FinishRegistration(ctx, req.GetAccessToken(), req.GetCeremonyId(), []byte(req.GetCredential()))
+	if err != nil {
+		return &authpb.FinishWebAuthnRegistrationResponse{
+			Status:  false,
+			Message: err.Error(),
+		}, nil
+	}
+
+	return &authpb.FinishWebAuthnRegistrationResponse{
+		Status:     true,
+		Message:    "Passkey registered",
+		Credential: toPbWebAuthnCredential(credential),
+	}, nil
+}
+
+func (h *AuthHandler) BeginWebAuthnLogin(ctx context.Context, req *authpb.BeginWebAuthnLoginRequest) (*authpb.BeginWebAuthnLoginResponse, error) {
+	ceremonyID, options, err := h.webAuthnUC.BeginLogin(ctx, req.GetEmail(), req.GetSecondFactorToken())
+	if err != nil {
+		return &authpb.BeginWebAuthnLoginResponse{
+			Status:  false,
+			Message: err.Error(),
+		}, nil
+	}
+
+	return &authpb.BeginWebAuthnLoginResponse{
+		Status:     true,
+		Message:    "Passkey login started",
+		CeremonyId: ceremonyID,
+		Options:    string(options),
+	}, nil
+}
+
+func (h *AuthHandler) FinishWebAuthnLogin(ctx context.Context, req *authpb.FinishWebAuthnLoginRequest) (*authpb.FinishWebAuthnLoginResponse, error) {
+	token, err := h.webAuthnUC.FinishLogin(ctx, req.GetCeremonyId(), []byte(req.GetCredential()))
+	if err != nil {
+		return &authpb.FinishWebAuthnLoginResponse{
+			Status:  false,
+			Message: err.Error(),
+		}, nil
+	}
+
+	return &authpb.FinishWebAuthnLoginResponse{
+		Status:  true,
+		Message: "Login successful",
+		Data: &authpb.LoginData{
+			AccessToken: token,
+		},
+	}, nil
+}
+
+func (h *AuthHandler) ListWebAuthnCredentials(ctx context.Context, req *authpb.ListWebAuthnCredentialsRequest) (*authpb.ListWebAuthnCredentialsResponse, error) {
+	credentials, err := h.webAuthnUC.ListCredentials(ctx, req.GetAccessToken())
+	if err != nil {
+		return &authpb.ListWebAuthnCredentialsResponse{
+			Status:  false,
+			Message: err.Error(),
+		}, nil
+	}
+
+	pbCredentials := make([]*authpb.WebAuthnCredential, 0, len(credentials))
+	for _, c := range credentials {
+		pbCredentials = append(pbCredentials, toPbWebAuthnCredential(c))
+	}
+
+	return &authpb.ListWebAuthnCredentialsResponse{
+		Status:      true,
+		Message:     "Passkeys listed",
+		Credentials: pbCredentials,
+	}, nil
+}
+
+func (h *AuthHandler) DeleteWebAuthnCredential(ctx context.Context, req *authpb.DeleteWebAuthnCredentialRequest) (*authpb.DeleteWebAuthnCredentialResponse, error) {
+	if err := h.webAuthnUC.DeleteCredential(ctx, req.GetAccessToken(), int(req.GetId())); err != nil {
+		return &authpb.DeleteWebAuthnCredentialResponse{
+			Status:  false,
+			Message: errorMessage(ctx, err),
+		}, nil
+	}
+
+	return &authpb.DeleteWebAuthnCredentialResponse{
+		Status:  true,
+		Message: "Passkey deleted",
+	}, nil
+}
@@ -0,0 +1,192 @@
+package grpc
+
+import (
+	"context"
+	"tablelink/internal/domain"
+	"tablelink/internal/usecase"
+	"tablelink/proto/proto/webhookpb"
+	"time"
+)
+
+type WebhookHandler struct {
+	webhookUC usecase.WebhookUseCase
+	webhookpb.UnimplementedWebhookServiceServer
+}
+
+func NewWebhookHandler(uc usecase.WebhookUseCase) *WebhookHandler {
+	return &WebhookHandler{webhookUC: uc}
+}
+
+func toWebhook(w *webhookpb.Webhook) *domain.WebhookSubscription {
+	return &domain.WebhookSubscription{
+		ID:         int(w.GetId()),
+		URL:        w.GetUrl(),
+		EventTypes: w.GetEventTypes(),
+		Secret:     w.GetSecret(),
+		Active:     w.GetActive(),
+	}
+}
+
+// toPbWebhook leaves the secret out unless withSecret is set, which is only
+// the case right after it was generated.
+func toPbWebhook(s *domain.WebhookSubscription, withSecret bool) *webhookpb.Webhook {
+	pbWebhook := &webhookpb.Webhook{
+		Id:         int32(s.ID),
+		Url:        s.URL,
+		EventTypes: s.EventTypes,
+		Active:     s.Active,
+		CreatedAt:  s.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:  s.UpdatedAt.Format(time.RFC3339),
+	}
+	if withSecret {
+		pbWebhook.Secret = s.Secret
+	}
+	return pbWebhook
+}
+
+func toPbDelivery(d *domain.WebhookDelivery) *webhookpb.WebhookDelivery {
+	pbDelivery := &webhookpb.WebhookDelivery{
+		Id:            d.ID,
+		WebhookId:     int32(d.SubscriptionID),
+		EventId:       d.EventID,
+		EventType:     string(d.EventType),
+		Status:        string(d.Status),
+		Attempts:      int32(d.Attempts),
+		NextAttemptAt: d.NextAttemptAt.Format(time.RFC3339),
+		CreatedAt:     d.CreatedAt.Format(time.RFC3339),
+	}
+	if d.LastStatusCode != nil {
+		pbDelivery.LastStatusCode = int32(*d.LastStatusCode)
+	}
+	if d.LastError != nil {
+		pbDelivery.LastError = *d.LastError
+	}
+	if d.DeliveredAt != nil {
+		pbDelivery.DeliveredAt = d.DeliveredAt.Format(time.RFC3339)
+	}
+	for _, a := range d.AttemptLog {
+		pbAttempt := &webhookpb.WebhookAttempt{
+			Attempt:     int32(a.Attempt),
+			DurationMs:  int32(a.DurationMs),
+			AttemptedAt: a.AttemptedAt.Format(time.RFC3339),
+		}
+		if a.StatusCode != nil {
+			pbAttempt.StatusCode = int32(*a.StatusCode)
+		}
+		if a.Error != nil {
+			pbAttempt.Error = *a.Error
+		}
+		pbDelivery.AttemptLog = append(pbDelivery.AttemptLog, pbAttempt)
+	}
+	return pbDelivery
+}
+
+func (h *WebhookHandler) CreateWebhook(ctx context.Context, req *webhookpb.CreateWebhookRequest) (*webhookpb.CreateWebhookResponse, error) {
+	sub, err := h.webhookUC.CreateWebhook(ctx, int(req.GetRoleId()), req.GetSection(), req.GetRoute(), toWebhook(req.GetWebhook()))
+	if err != nil {
+		return &webhookpb.CreateWebhookResponse{
+			Status:  false,
+			Message: errorMessage(ctx, err),
+		}, nil
+	}
+
+	return &webhookpb.CreateWebhookResponse{
+		Status:  true,
+		Message: "Successfully create webhook",
+		Webhook: toPbWebhook(sub, true),
+	}, nil
+}
+
+func (h *WebhookHandler) ListWebhooks(ctx context.Context, req *webhookpb.ListWebhooksRequest) (*webhookpb.ListWebhooksResponse, error) {
+	subs, err := h.webhookUC.ListWebhooks(ctx, int(req.GetRoleId()), req.GetSection(), req.GetRoute())
+	if err != nil {
+		return &webhookpb.ListWebhooksResponse{
+			Status:  false,
+			Message: errorMessage(ctx, err),
+		}, nil
+	}
+
+	var pbWebhooks []*webhookpb.Webhook
+	for _, s := range subs {
+		pbWebhooks = append(pbWebhooks, toPbWebhook(s, false))
+	}
+
+	return &webhookpb.ListWebhooksResponse{
+		Status:   true,
+		Message:  "Successfully get list webhooks",
+		Webhooks: pbWebhooks,
+	}, nil
+}
+
+func (h *WebhookHandler) UpdateWebhook(ctx context.Context, req *webhookpb.UpdateWebhookRequest) (*webhookpb.UpdateWebhookResponse, error) {
+	sub, err := h.webhookUC.UpdateWebhook(ctx, int(req.GetRoleId()), req.GetSection(), req.GetRoute(),
+		toWebhook(req.GetWebhook()), req.GetRotateSecret())
+	if err != nil {
+		return &webhookpb.UpdateWebhookResponse{
+			Status:  false,
+			Message: errorMessage(ctx, err),
+		}, nil
+	}
+
+	return &webhookpb.UpdateWebhookResponse{
+		Status:  true,
+		Message: "Successfully update webhook",
+		Webhook: toPbWebhook(sub, req.GetRotateSecret()),
+	}, nil
+}
+
+func (h *WebhookHandler) DeleteWebhook(ctx context.Context, req *webhookpb.DeleteWebhookRequest) (*webhookpb.DeleteWebhookResponse, error) {
+	if err := h.webhookUC.DeleteWebhook(ctx, int(req.GetRoleId()), req.GetSection(), req.GetRoute(), int(req.GetWebhookId())); err != nil {
+		return &webhookpb.DeleteWebhookResponse{
+			Status:  false,
+			Message: errorMessage(ctx, err),
+		}, nil
+	}
+
+	return &webhookpb.DeleteWebhookResponse{
+		Status:  true,
+		Message: "Successfully delete webhook",
+	}, nil
+}
+
+func (h *WebhookHandler) ListWebhookDeliveries(ctx context.Context, req *webhookpb.ListWebhookDeliveriesRequest) (*webhookpb.ListWebhookDeliveriesResponse, error) {
+	filter := &domain.WebhookDeliveryFilter{
+		SubscriptionID: int(req.GetWebhookId()),
+		Status:         domain.WebhookDeliveryStatus(req.GetDeliveryStatus()),
+		Limit:          int(req.GetLimit()),
+	}
+	deliveries, err := h.webhookUC.ListWebhookDeliveries(ctx, int(req.GetRoleId()), req.GetSection(), req.GetRoute(), filter)
+	if err != nil {
+		return &webhookpb.ListWebhookDeliveriesResponse{
+			Status:  false,
+			Message: errorMessage(ctx, err),
+		}, nil
+	}
+
+	var pbDeliveries []*webhookpb.WebhookDelivery
+	for _, d := range deliveries {
+		pbDeliveries = append(pbDeliveries, toPbDelivery(d))
+	}
+
+	return &webhookpb.ListWebhookDeliveriesResponse{
+		Status:     true,
+		Message:    "Successfully get list webhook deliveries",
+		Deliveries: pbDeliveries,
+	}, nil
+}
+
+func (h *WebhookHandler) RedeliverWebhook(ctx context.Context, req *webhookpb.RedeliverWebhookRequest) (*webhookpb.RedeliverWebhookResponse, error) {
+	err := h.webhookUC.RedeliverWebhook(ctx, int(req.GetRoleId()), req.GetSection(), req.GetRoute(),
+		int(req.GetWebhookId()), req.GetDeliveryId())
+	if err != nil {
+		return &webhookpb.RedeliverWebhookResponse{
+			Status:  false,
+			Message: errorMessage(ctx, err),
+		}, nil
+	}
+
+	return &webhookpb.RedeliverWebhookResponse{
+		Status:  true,
+		Message: "Webhook delivery queued for redelivery",
+	}, nil
+}
@@ -0,0 +1,276 @@
+package oauth
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"tablelink/internal/domain"
+	"time"
+)
+
+// sessionCookie holds the token of the session created by
+// AuthUseCase.Login, which is what makes single sign-on work across
+// clients.
+const (
+	sessionCookie    = "tablelink_session"
+	sessionCookieTTL = 24 * time.Hour
+)
+
+var authorizeTemplate = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Sign in to {{.Client}}</title></head>
+<body>
+{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+{{if .Message}}<p>{{.Message}}</p>{{end}}
+{{if .Client}}
+<h1>{{.Client}} wants to access your account</h1>
+<p>It will be able to:</p>
+<ul>{{range .Scopes}}<li>{{.}}</li>{{end}}</ul>
+<form method="post" action="/oauth/authorize">
+{{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+{{end}}{{if .SignedInAs}}<p>Signed in as {{.SignedInAs}}</p>
+<button type="submit" name="decision" value="allow">Allow</button>
+{{else}}<label>Email <input type="email" name="email" value="{{.Email}}" required autofocus></label>
+<label>Password <input type="password" name="password" required></label>
+<button type="submit" name="decision" value="allow">Sign in and allow</button>
+{{end}}<button type="submit" name="decision" value="deny" formnovalidate>Deny</button>
+</form>
+{{if and .Providers (not .SignedInAs)}}<ul>{{range .Providers}}<li><a href="{{.URL}}">Sign in with {{.Name}}</a></li>{{end}}</ul>{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+type authorizeView struct {
+	Client     string
+	Scopes     []string
+	Params     map[string]string
+	SignedInAs string
+	Email      string
+	Error      string
+	Message    string
+	Providers  []providerLink
+}
+
+// authorizationParams are the request parameters the login form carries
+// from the GET to the POST of the authorization endpoint.
+var authorizationParams = []string{"response_type", "client_id", "redirect_uri", "scope", "state", "code_challenge",
+	"code_challenge_method", "nonce", "prompt"}
+
+func authorizationRequest(values url.Values) *domain.AuthorizationRequest {
+	return &domain.AuthorizationRequest{
+		ClientID:            values.Get("client_id"),
+		RedirectURI:         values.Get("redirect_uri"),
+		Scope:               values.Get("scope"),
+		State:               values.Get("state"),
+		CodeChallenge:       values.Get("code_challenge"),
+		CodeChallengeMethod: values.Get("code_challenge_method"),
+		Nonce:               values.Get("nonce"),
+	}
+}
+
+func (s *Server) authorizePage(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("prompt") != "none" {
+		s.authorizeForm(w, r, query, nil)
+		return
+	}
+
+	// prompt=none asks for a code without any interaction, which works
+	// only when the user is already signed in and allowed the client these
+	// scopes before.
+	req := authorizationRequest(query)
+	if _, _, err := s.validateAuthorization(r, query); err != nil {
+		s.authorizeError(w, r, req, err)
+		return
+	}
+	code, err := s.oauthUC.AuthorizeWithoutPrompt(r.Context(), req, sessionToken(r))
+	if err != nil {
+		s.authorizeError(w, r, req, err)
+		return
+	}
+	redirect(w, r, req.RedirectURI, req.State, url.Values{"code": {code}})
+}
+
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		renderAuthorize(w, http.StatusBadRequest, &authorizeView{Error: "invalid request"})
+		return
+	}
+	form := r.PostForm
+	req := authorizationRequest(form)
+
+	// Only redirect once the client and redirect uri are known to be good,
+	// or the form could be used as an open redirector.
+	if _, _, err := s.validateAuthorization(r, form); err != nil {
+		s.authorizeError(w, r, req, err)
+		return
+	}
+	if form.Get("decision") != "allow" {
+		s.authorizeError(w, r, req, &oauthError{status: http.StatusForbidden, code: "access_denied", description: "the user denied the request"})
+		return
+	}
+
+	session := sessionToken(r)
+	if email := form.Get("email"); email != "" {
+		token, err := s.authUC.Login(r.Context(), email, form.Get("password"))
+		if errors.Is(err, domain.ErrInvalidCredentials) || errors.Is(err, domain.ErrUserNotActive) ||
+			errors.Is(err, domain.ErrSecondFactorRequired) {
+			// Let the user try again rather than bouncing them to the
+			// client. The form cannot ask for a passkey, so users who
+			// have one are told to finish the login with it.
+			s.authorizeForm(w, r, form, err)
+			return
+		}
+		if err != nil {
+			s.authorizeError(w, r, req, err)
+			return
+		}
+		if session != "" {
+			if err := s.authUC.Logout(r.Context(), session); err != nil {
+				log.Printf("Failed to end replaced session with err %v", err)
+			}
+		}
+		session = token
+		s.setSessionCookie(w, session)
+	}
+
+	code, err := s.oauthUC.Authorize(r.Context(), req, session)
+	if errors.Is(err, domain.ErrLoginRequired) || errors.Is(err, domain.ErrUserNotActive) {
+		s.authorizeForm(w, r, form, err)
+		return
+	}
+	if err != nil {
+		s.authorizeError(w, r, req, err)
+		return
+	}
+
+	redirect(w, r, req.RedirectURI, req.State, url.Values{"code": {code}})
+}
+
+func (s *Server) validateAuthorization(r *http.Request, values url.Values) (*domain.OAuthClient, []string, error) {
+	client, scopes, err := s.oauthUC.ValidateAuthorization(r.Context(), authorizationRequest(values))
+	if err == nil && values.Get("response_type") != "code" {
+		err = &oauthError{status: http.StatusBadRequest, code: "unsupported_response_type", description: "response_type must be code"}
+	}
+	return client, scopes, err
+}
+
+// authorizeForm renders the consent page for the request in values. Users
+// without a session, or asked to log in again with prompt=login, also get
+// the login fields. loginErr is the error of a failed login attempt.
+func (s *Server) authorizeForm(w http.ResponseWriter, r *http.Request, values url.Values, loginErr error) {
+	client, scopes, err := s.validateAuthorization(r, values)
+	if err != nil {
+		s.authorizeError(w, r, authorizationRequest(values), err)
+		return
+	}
+
+	view := &authorizeView{
+		Client: client.Name,
+		Scopes: scopes,
+		Params: make(map[string]string, len(authorizationParams)),
+		Email:  values.Get("email"),
+	}
+	for _, name := range authorizationParams {
+		view.Params[name] = values.Get(name)
+	}
+	// A login replaces the prompt, or the form would ask again after it.
+	if values.Get("prompt") == "login" {
+		view.Params["prompt"] = ""
+	} else if user, err := s.oauthUC.SessionUser(r.Context(), sessionToken(r)); err == nil {
+		view.SignedInAs = user.Email
+	}
+	if view.SignedInAs == "" {
+		// Coming back to the authorization request signed in shows the
+		// consent page again.
+		params := make(url.Values, len(view.Params))
+		for name, value := range view.Params {
+			if value != "" {
+				params.Set(name, value)
+			}
+		}
+		view.Providers = s.federationLinks("/oauth/authorize?" + params.Encode())
+	}
+
+	status := http.StatusOK
+	if loginErr != nil {
+		status, view.Error = http.StatusUnauthorized, loginErr.Error()
+	}
+	renderAuthorize(w, status, view)
+}
+
+// authorizeError reports a failed authorization request. Errors about the
+// client or redirect uri are shown to the user; everything else goes back
+// to the client as in RFC 6749 section 4.1.2.1.
+func (s *Server) authorizeError(w http.ResponseWriter, r *http.Request, req *domain.AuthorizationRequest, err error) {
+	if errors.Is(err, domain.ErrOAuthClientNotFound) || errors.Is(err, domain.ErrInvalidRedirectURI) {
+		renderAuthorize(w, http.StatusBadRequest, &authorizeView{Error: err.Error()})
+		return
+	}
+
+	oe := toOAuthError(err)
+	redirect(w, r, req.RedirectURI, req.State, url.Values{"error": {oe.code}, "error_description": {oe.description}})
+}
+
+func redirect(w http.ResponseWriter, r *http.Request, uri, state string, params url.Values) {
+	target, err := url.Parse(uri)
+	if err != nil {
+		renderAuthorize(w, http.StatusBadRequest, &authorizeView{Error: domain.ErrInvalidRedirectURI.Error()})
+		return
+	}
+	query := target.Query()
+	for name, values := range params {
+		query[name] = values
+	}
+	if state != "" {
+		query.Set("state", state)
+	}
+	target.RawQuery = query.Encode()
+	http.Redirect(w, r, target.String(), http.StatusSeeOther)
+}
+
+func renderAuthorize(w http.ResponseWriter, status int, view *authorizeView) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	// The page takes a password, so it must not be framed.
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.WriteHeader(status)
+	if err := authorizeTemplate.Execute(w, view); err != nil {
+		log.Printf("Failed to render authorize page with err %v", err)
+	}
+}
+
+func sessionToken(r *http.Request) string {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+func (s *Server) setSessionCookie(w http.ResponseWriter, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(sessionCookieTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   s.secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (s *Server) clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   s.secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,119 @@
+package oauth
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"tablelink/internal/domain"
+	"time"
+)
+
+// federationStateCookie keeps the state of a federated login in the
+// browser that started it, so the callback can only finish the login
+// there. It lasts as long as the default FEDERATION_STATE_TTL.
+const (
+	federationStateCookie    = "tablelink_federation_state"
+	federationStateCookieTTL = 10 * time.Minute
+)
+
+type providerLink struct {
+	Name string
+	URL  string
+}
+
+// federationLinks are the "Sign in with" links of the login page, which
+// come back to returnTo once the user has signed in.
+func (s *Server) federationLinks(returnTo string) []providerLink {
+	if s.federationUC == nil {
+		return nil
+	}
+	var links []providerLink
+	for _, p := range s.federationUC.Providers() {
+		links = append(links, providerLink{
+			Name: p.Name,
+			URL:  "/federation/" + url.PathEscape(p.ID) + "/login?" + url.Values{"return_to": {returnTo}}.Encode(),
+		})
+	}
+	return links
+}
+
+func (s *Server) federationLogin(w http.ResponseWriter, r *http.Request) {
+	returnTo := r.URL.Query().Get("return_to")
+	// Only paths on this server, or the login could send users anywhere.
+	if returnTo != "" && (!strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") || strings.Contains(returnTo, `\`)) {
+		renderAuthorize(w, http.StatusBadRequest, &authorizeView{Error: "invalid return_to"})
+		return
+	}
+
+	target, state, err := s.federationUC.Begin(r.Context(), r.PathValue("provider"), returnTo)
+	if err != nil {
+		s.federationError(w, err)
+		return
+	}
+	s.setFederationStateCookie(w, state, int(federationStateCookieTTL.Seconds()))
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+func (s *Server) federationCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if code := query.Get("error"); code != "" {
+		renderAuthorize(w, http.StatusForbidden, &authorizeView{Error: "sign-in failed: " + code + " " + query.Get("error_description")})
+		return
+	}
+
+	var browserState string
+	if cookie, err := r.Cookie(federationStateCookie); err == nil {
+		browserState = cookie.Value
+	}
+	// The state is single use, so the cookie is done with either way.
+	s.setFederationStateCookie(w, "", -1)
+
+	token, returnTo, err := s.federationUC.Complete(r.Context(), r.PathValue("provider"), query.Get("state"), browserState, query.Get("code"))
+	if err != nil {
+		s.federationError(w, err)
+		return
+	}
+	if previous := sessionToken(r); previous != "" {
+		if err := s.authUC.Logout(r.Context(), previous); err != nil {
+			log.Printf("Failed to end replaced session with err %v", err)
+		}
+	}
+	s.setSessionCookie(w, token)
+
+	if returnTo == "" {
+		renderAuthorize(w, http.StatusOK, &authorizeView{Message: "You are signed in."})
+		return
+	}
+	http.Redirect(w, r, returnTo, http.StatusSeeOther)
+}
+
+func (s *Server) federationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, domain.ErrIdentityProviderNotFound):
+		renderAuthorize(w, http.StatusNotFound, &authorizeView{Error: err.Error()})
+	case errors.Is(err, domain.ErrFederationState):
+		renderAuthorize(w, http.StatusBadRequest, &authorizeView{Error: err.Error()})
+	case errors.Is(err, domain.ErrNoLinkedAccount), errors.Is(err, domain.ErrUnverifiedEmail),
+		errors.Is(err, domain.ErrNoMappedRole), errors.Is(err, domain.ErrIdentityTaken),
+		errors.Is(err, domain.ErrUserNotActive):
+		renderAuthorize(w, http.StatusForbidden, &authorizeView{Error: err.Error()})
+	default:
+		log.Printf("Failed federated login with err %v", err)
+		renderAuthorize(w, http.StatusBadGateway, &authorizeView{Error: "sign-in with the identity provider failed, please try again"})
+	}
+}
+
+func (s *Server) setFederationStateCookie(w http.ResponseWriter, state string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     federationStateCookie,
+		Value:    state,
+		Path:     "/federation/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   s.secureCookies,
+		// Lax, as the provider sends the browser back with a top-level GET.
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,226 @@
+package oauth
+
+import (
+	"context"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"tablelink/internal/config"
+	"tablelink/internal/domain"
+	"tablelink/internal/federation"
+	"tablelink/internal/repository"
+	"tablelink/internal/usecase"
+	"testing"
+	"time"
+)
+
+type fakeStates struct {
+	mu     sync.Mutex
+	states map[string]*domain.FederationState
+}
+
+func (f *fakeStates) Save(ctx context.Context, state string, value *domain.FederationState, ttl time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.states[state] = value
+	return nil
+}
+
+func (f *fakeStates) Consume(ctx context.Context, state string) (*domain.FederationState, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	value, ok := f.states[state]
+	if !ok {
+		return nil, domain.ErrFederationState
+	}
+	delete(f.states, state)
+	return value, nil
+}
+
+type fakeIdentities struct {
+	repository.IdentityRepository
+	identities []*domain.UserIdentity
+}
+
+func (f *fakeIdentities) Get(ctx context.Context, provider, subject string) (*domain.UserIdentity, error) {
+	for _, i := range f.identities {
+		if i.Provider == provider && i.Subject == subject {
+			return i, nil
+		}
+	}
+	return nil, domain.ErrIdentityNotFound
+}
+
+func (f *fakeIdentities) Link(ctx context.Context, identity *domain.UserIdentity) (*domain.UserIdentity, error) {
+	f.identities = append(f.identities, identity)
+	return identity, nil
+}
+
+func (f *fakeIdentities) Touch(ctx context.Context, id int, email string) error {
+	return nil
+}
+
+type fakeUsers struct {
+	repository.UserRepository
+	users []*domain.User
+}
+
+func (f *fakeUsers) GetByID(ctx context.Context, id int) (*domain.User, error) {
+	for _, u := range f.users {
+		if u.ID == id {
+			return u, nil
+		}
+	}
+	return nil, domain.ErrUserNotFound
+}
+
+func (f *fakeUsers) GetByEmail(ctx context.Context, email string) (*domain.User, error) {
+	for _, u := range f.users {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	return nil, domain.ErrUserNotFound
+}
+
+type fakeSessions struct {
+	repository.SessionRepository
+	sessions map[string]*domain.Session
+}
+
+func (f *fakeSessions) Create(ctx context.Context, token string, session *domain.Session, ttl time.Duration) error {
+	f.sessions[token] = session
+	return nil
+}
+
+type fakeOutbox struct{ repository.OutboxRepository }
+
+func (fakeOutbox) Append(ctx context.Context, events ...*domain.Event) error {
+	return nil
+}
+
+// newFederationServer serves the federation endpoints in front of a
+// FakeIdP that signs in the user described by claims.
+func newFederationServer(t *testing.T, claims map[string]any) (*httptest.Server, *fakeSessions) {
+	t.Helper()
+	idp, err := federation.NewFakeIdP("", "tablelink", "secret", claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idpServer := httptest.NewServer(idp)
+	t.Cleanup(idpServer.Close)
+	idp.Issuer = idpServer.URL
+
+	sessions := &fakeSessions{sessions: make(map[string]*domain.Session)}
+	server := httptest.NewUnstartedServer(nil)
+	issuer := "http://" + server.Listener.Addr().String()
+	provider := &domain.IdentityProvider{ID: "fake", Name: "Fake", Issuer: idpServer.URL, ClientID: "tablelink", ClientSecret: "secret", LinkByEmail: true}
+	users := &fakeUsers{users: []*domain.User{{ID: 1, Email: "ada@example.org", Status: domain.UserStatusActive, Kind: domain.UserKindHuman}}}
+	federationUC := usecase.NewFederationUseCase([]*domain.IdentityProvider{provider},
+		&fakeStates{states: make(map[string]*domain.FederationState)}, &fakeIdentities{}, users, sessions, fakeOutbox{},
+		&config.Config{OAuthIssuer: issuer, FederationStateTTL: time.Minute})
+	server.Config.Handler = NewServer(nil, nil, federationUC, nil, issuer)
+	server.Start()
+	t.Cleanup(server.Close)
+	return server, sessions
+}
+
+// newBrowser returns a client with its own cookies that stops at the first
+// response that is not a redirect to the servers under test.
+func newBrowser(t *testing.T) *http.Client {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{Jar: jar, CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if req.URL.Path == "/back" {
+			return http.ErrUseLastResponse
+		}
+		return nil
+	}}
+}
+
+func TestFederationLogin(t *testing.T) {
+	verified := map[string]any{"sub": "s-1", "email": "ada@example.org", "email_verified": true}
+	tests := []struct {
+		name   string
+		claims map[string]any
+		// otherBrowser finishes the login in a browser that did not start
+		// it.
+		otherBrowser bool
+		returnTo     string
+		wantStatus   int
+	}{
+		{name: "signs in and returns", claims: verified, returnTo: "/back", wantStatus: http.StatusSeeOther},
+		{name: "refuses a callback in another browser", claims: verified, returnTo: "/back", otherBrowser: true, wantStatus: http.StatusBadRequest},
+		{name: "refuses an unverified email", claims: map[string]any{"sub": "s-1", "email": "ada@example.org"}, returnTo: "/back", wantStatus: http.StatusForbidden},
+		{name: "refuses a return_to off the server", claims: verified, returnTo: "//evil.example", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, sessions := newFederationServer(t, tt.claims)
+			browser := newBrowser(t)
+			loginURL := server.URL + "/federation/fake/login?" + url.Values{"return_to": {tt.returnTo}}.Encode()
+
+			var resp *http.Response
+			var err error
+			if tt.otherBrowser {
+				// Stop at the IdP, then hand its redirect to the callback
+				// to a second browser.
+				browser.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+					if req.URL.Path == "/federation/fake/callback" {
+						return http.ErrUseLastResponse
+					}
+					return nil
+				}
+				if resp, err = browser.Get(loginURL); err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+				callback, err := resp.Location()
+				if err != nil {
+					t.Fatalf("no redirect to the callback: %v", err)
+				}
+				resp, err = newBrowser(t).Get(callback.String())
+			} else {
+				resp, err = browser.Get(loginURL)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusSeeOther {
+				if len(sessions.sessions) != 0 {
+					t.Errorf("failed login created %d sessions", len(sessions.sessions))
+				}
+				return
+			}
+
+			if location := resp.Header.Get("Location"); location != "/back" {
+				t.Errorf("Location = %q, want /back", location)
+			}
+			var session, state *http.Cookie
+			for _, c := range resp.Cookies() {
+				switch c.Name {
+				case sessionCookie:
+					session = c
+				case federationStateCookie:
+					state = c
+				}
+			}
+			if session == nil || sessions.sessions[session.Value] == nil || !session.HttpOnly {
+				t.Errorf("session cookie = %+v, want an HttpOnly cookie of a new session", session)
+			}
+			if state == nil || state.MaxAge >= 0 {
+				t.Errorf("state cookie = %+v, want it cleared", state)
+			}
+		})
+	}
+}
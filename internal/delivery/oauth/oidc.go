@@ -0,0 +1,83 @@
+package oauth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"tablelink/internal/domain"
+)
+
+var signedOutTemplate = template.Must(template.New("signed-out").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Signed out</title></head>
+<body><p>You have been signed out.</p></body>
+</html>
+`))
+
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	if err := json.NewEncoder(w).Encode(s.signer.JWKS()); err != nil {
+		log.Printf("Failed to write JWKS with err %v", err)
+	}
+}
+
+func (s *Server) userinfo(w http.ResponseWriter, r *http.Request) {
+	accessToken, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok && r.Method == http.MethodPost {
+		if err := parseForm(r); err == nil {
+			accessToken = r.PostForm.Get("access_token")
+		}
+	}
+	if accessToken == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="oauth"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := s.oauthUC.UserInfo(r.Context(), accessToken)
+	if err != nil {
+		// Bearer token errors go in the header (RFC 6750 section 3).
+		oe := toOAuthError(err)
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="oauth", error=%q, error_description=%q`, oe.code, oe.description))
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, claims)
+}
+
+// logout is the RP-initiated logout endpoint. It ends the session whatever
+// the parameters say, but only redirects to a registered post-logout uri.
+func (s *Server) logout(w http.ResponseWriter, r *http.Request) {
+	if err := parseForm(r); err != nil {
+		writeError(w, err)
+		return
+	}
+	form := r.Form
+	uri := form.Get("post_logout_redirect_uri")
+	err := s.oauthUC.ValidateLogout(r.Context(), form.Get("id_token_hint"), form.Get("client_id"), uri)
+	if err != nil && !errors.Is(err, domain.ErrInvalidRedirectURI) && !errors.Is(err, domain.ErrOAuthClientNotFound) {
+		log.Printf("Rejected post-logout redirect with err %v", err)
+	}
+
+	if session := sessionToken(r); session != "" {
+		if err := s.authUC.Logout(r.Context(), session); err != nil {
+			log.Printf("Failed to end session on logout with err %v", err)
+		}
+	}
+	s.clearSessionCookie(w)
+
+	if err == nil && uri != "" {
+		redirect(w, r, uri, form.Get("state"), nil)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := signedOutTemplate.Execute(w, nil); err != nil {
+		log.Printf("Failed to render signed out page with err %v", err)
+	}
+}
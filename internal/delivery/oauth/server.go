@@ -0,0 +1,188 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"tablelink/internal/domain"
+	"tablelink/internal/token"
+	"tablelink/internal/usecase"
+)
+
+const maxBodySize = 1 << 16
+
+// Server is the OAuth 2.0 authorization server and OpenID Connect provider:
+// the authorization endpoint with its login and consent page, the token
+// endpoint, token revocation (RFC 7009), introspection (RFC 7662), userinfo,
+// RP-initiated logout and the discovery documents. With a federation use
+// case it also signs users in through external identity providers.
+type Server struct {
+	oauthUC       usecase.OAuthUseCase
+	authUC        usecase.AuthUseCase
+	federationUC  usecase.FederationUseCase
+	signer        *token.Signer
+	issuer        string
+	secureCookies bool
+	mux           *http.ServeMux
+}
+
+// NewServer creates the server. federationUC may be nil when no identity
+// providers are configured.
+func NewServer(oauthUC usecase.OAuthUseCase, authUC usecase.AuthUseCase, federationUC usecase.FederationUseCase,
+	signer *token.Signer, issuer string) *Server {
+	s := &Server{
+		oauthUC:       oauthUC,
+		authUC:        authUC,
+		federationUC:  federationUC,
+		signer:        signer,
+		issuer:        strings.TrimSuffix(issuer, "/"),
+		secureCookies: strings.HasPrefix(issuer, "https://"),
+		mux:           http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("GET /.well-known/oauth-authorization-server", s.metadata)
+	s.mux.HandleFunc("GET /.well-known/openid-configuration", s.metadata)
+	s.mux.HandleFunc("GET /oauth/jwks", s.jwks)
+	s.mux.HandleFunc("GET /oauth/authorize", s.authorizePage)
+	s.mux.HandleFunc("POST /oauth/authorize", s.authorize)
+	s.mux.HandleFunc("POST /oauth/token", s.token)
+	s.mux.HandleFunc("POST /oauth/revoke", s.revoke)
+	s.mux.HandleFunc("POST /oauth/introspect", s.introspect)
+	s.mux.HandleFunc("GET /oauth/userinfo", s.userinfo)
+	s.mux.HandleFunc("POST /oauth/userinfo", s.userinfo)
+	s.mux.HandleFunc("GET /oauth/logout", s.logout)
+	s.mux.HandleFunc("POST /oauth/logout", s.logout)
+	if federationUC != nil {
+		s.mux.HandleFunc("GET /federation/{provider}/login", s.federationLogin)
+		s.mux.HandleFunc("GET /federation/{provider}/callback", s.federationCallback)
+	}
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	s.mux.ServeHTTP(w, r)
+}
+
+// oauthError is an error response as defined in RFC 6749 section 5.2.
+type oauthError struct {
+	status      int
+	code        string
+	description string
+}
+
+func (e *oauthError) Error() string {
+	return e.description
+}
+
+func errInvalidRequest(description string) error {
+	return &oauthError{status: http.StatusBadRequest, code: "invalid_request", description: description}
+}
+
+func toOAuthError(err error) *oauthError {
+	var oe *oauthError
+	if errors.As(err, &oe) {
+		return oe
+	}
+
+	switch {
+	case errors.Is(err, domain.ErrInvalidClient):
+		return &oauthError{status: http.StatusUnauthorized, code: "invalid_client", description: err.Error()}
+	case errors.Is(err, domain.ErrInvalidGrant):
+		return &oauthError{status: http.StatusBadRequest, code: "invalid_grant", description: err.Error()}
+	case errors.Is(err, domain.ErrInvalidScope):
+		return &oauthError{status: http.StatusBadRequest, code: "invalid_scope", description: err.Error()}
+	case errors.Is(err, domain.ErrUnauthorizedClient):
+		return &oauthError{status: http.StatusBadRequest, code: "unauthorized_client", description: err.Error()}
+	case errors.Is(err, domain.ErrUnsupportedGrantType):
+		return &oauthError{status: http.StatusBadRequest, code: "unsupported_grant_type", description: err.Error()}
+	case errors.Is(err, domain.ErrPKCERequired):
+		return &oauthError{status: http.StatusBadRequest, code: "invalid_request", description: err.Error()}
+	case errors.Is(err, domain.ErrInvalidCredentials), errors.Is(err, domain.ErrUserNotActive):
+		return &oauthError{status: http.StatusForbidden, code: "access_denied", description: err.Error()}
+	case errors.Is(err, domain.ErrLoginRequired):
+		return &oauthError{status: http.StatusUnauthorized, code: "login_required", description: err.Error()}
+	case errors.Is(err, domain.ErrConsentRequired):
+		return &oauthError{status: http.StatusForbidden, code: "consent_required", description: err.Error()}
+	case errors.Is(err, domain.ErrInvalidAccessToken):
+		return &oauthError{status: http.StatusUnauthorized, code: "invalid_token", description: err.Error()}
+	case errors.Is(err, domain.ErrInsufficientScope):
+		return &oauthError{status: http.StatusForbidden, code: "insufficient_scope", description: err.Error()}
+	case errors.Is(err, context.Canceled):
+		return &oauthError{status: 499, code: "server_error", description: err.Error()}
+	}
+
+	log.Printf("Failed to serve OAuth request with err %v", err)
+	return &oauthError{status: http.StatusInternalServerError, code: "server_error", description: "internal error"}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	oe := toOAuthError(err)
+	if oe.code == "invalid_client" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	writeJSON(w, oe.status, map[string]string{
+		"error":             oe.code,
+		"error_description": oe.description,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Failed to write OAuth response with err %v", err)
+	}
+}
+
+// clientCredentials reads client authentication from HTTP Basic or, failing
+// that, from the client_id and client_secret form parameters.
+func clientCredentials(r *http.Request) (string, string, error) {
+	if id, secret, ok := r.BasicAuth(); ok {
+		if r.PostForm.Get("client_secret") != "" {
+			return "", "", errInvalidRequest("use only one client authentication method")
+		}
+		return id, secret, nil
+	}
+	id := r.PostForm.Get("client_id")
+	if id == "" {
+		return "", "", domain.ErrInvalidClient
+	}
+	return id, r.PostForm.Get("client_secret"), nil
+}
+
+func parseForm(r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return errInvalidRequest(err.Error())
+	}
+	return nil
+}
+
+// metadata serves both the OAuth server metadata (RFC 8414) and the OpenID
+// Connect discovery document, which share their fields.
+func (s *Server) metadata(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                s.issuer,
+		"authorization_endpoint":                s.issuer + "/oauth/authorize",
+		"token_endpoint":                        s.issuer + "/oauth/token",
+		"revocation_endpoint":                   s.issuer + "/oauth/revoke",
+		"introspection_endpoint":                s.issuer + "/oauth/introspect",
+		"userinfo_endpoint":                     s.issuer + "/oauth/userinfo",
+		"end_session_endpoint":                  s.issuer + "/oauth/logout",
+		"jwks_uri":                              s.issuer + "/oauth/jwks",
+		"response_types_supported":              []string{"code"},
+		"response_modes_supported":              []string{"query"},
+		"grant_types_supported":                 []string{domain.GrantAuthorizationCode, domain.GrantClientCredentials, domain.GrantRefreshToken},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      []string{domain.ScopeOpenID, domain.ScopeProfile, domain.ScopeEmail},
+		"claims_supported":                      []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "name", "preferred_username", "email", "email_verified"},
+		"prompt_values_supported":               []string{"none", "login"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+	})
+}
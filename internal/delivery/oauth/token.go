@@ -0,0 +1,125 @@
+package oauth
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"tablelink/internal/domain"
+)
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope"`
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if err := parseForm(r); err != nil {
+		writeError(w, err)
+		return
+	}
+	clientID, secret, err := clientCredentials(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	form := r.PostForm
+	var set *domain.TokenSet
+	switch form.Get("grant_type") {
+	case domain.GrantAuthorizationCode:
+		set, err = s.oauthUC.ExchangeCode(r.Context(), clientID, secret, form.Get("code"), form.Get("redirect_uri"), form.Get("code_verifier"))
+	case domain.GrantClientCredentials:
+		set, err = s.oauthUC.ClientCredentials(r.Context(), clientID, secret, form.Get("scope"))
+	case domain.GrantRefreshToken:
+		set, err = s.oauthUC.Refresh(r.Context(), clientID, secret, form.Get("refresh_token"), form.Get("scope"))
+	case "":
+		err = errInvalidRequest("grant_type is required")
+	default:
+		err = domain.ErrUnsupportedGrantType
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &tokenResponse{
+		AccessToken:  set.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(set.ExpiresIn.Seconds()),
+		RefreshToken: set.RefreshToken,
+		IDToken:      set.IDToken,
+		Scope:        strings.Join(set.Scopes, " "),
+	})
+}
+
+func (s *Server) revoke(w http.ResponseWriter, r *http.Request) {
+	if err := parseForm(r); err != nil {
+		writeError(w, err)
+		return
+	}
+	clientID, secret, err := clientCredentials(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	token := r.PostForm.Get("token")
+	if token == "" {
+		writeError(w, errInvalidRequest("token is required"))
+		return
+	}
+
+	// token_type_hint is only an optimisation and tokens are looked up by
+	// hash whatever their type, so it is ignored.
+	if err := s.oauthUC.Revoke(r.Context(), clientID, secret, token); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) introspect(w http.ResponseWriter, r *http.Request) {
+	if err := parseForm(r); err != nil {
+		writeError(w, err)
+		return
+	}
+	clientID, secret, err := clientCredentials(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	token := r.PostForm.Get("token")
+	if token == "" {
+		writeError(w, errInvalidRequest("token is required"))
+		return
+	}
+
+	info, err := s.oauthUC.Introspect(r.Context(), clientID, secret, token)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if info == nil {
+		writeJSON(w, http.StatusOK, map[string]bool{"active": false})
+		return
+	}
+
+	body := map[string]any{
+		"active":     true,
+		"client_id":  info.ClientID,
+		"scope":      strings.Join(info.Scopes, " "),
+		"token_type": "Bearer",
+		"exp":        info.ExpiresAt.Unix(),
+		"iat":        info.IssuedAt.Unix(),
+		"iss":        s.issuer,
+		"role_id":    info.RoleID,
+	}
+	if info.UserID != nil {
+		body["sub"] = strconv.Itoa(*info.UserID)
+	}
+	writeJSON(w, http.StatusOK, body)
+}
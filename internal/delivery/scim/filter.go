@@ -0,0 +1,271 @@
+package scim
+
+import (
+	"encoding/json"
+	"strings"
+	"tablelink/internal/domain"
+
+	filter "github.com/scim2/filter-parser/v2"
+)
+
+// parseFilter parses the filter query parameter. An empty filter matches
+// everything and is returned as nil.
+func parseFilter(raw string) (filter.Expression, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	expr, err := filter.ParseFilter([]byte(raw))
+	if err != nil {
+		return nil, &scimError{status: 400, scimType: "invalidFilter", detail: err.Error()}
+	}
+	return expr, nil
+}
+
+// toAttributes renders a resource as the generic JSON object that filters
+// and patches operate on.
+func toAttributes(resource any) (map[string]any, error) {
+	raw, err := json.Marshal(resource)
+	if err != nil {
+		return nil, err
+	}
+	var attrs map[string]any
+	err = json.Unmarshal(raw, &attrs)
+	return attrs, err
+}
+
+func fromAttributes(attrs map[string]any, resource any) error {
+	raw, err := json.Marshal(attrs)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, resource); err != nil {
+		return errInvalidValue("%v", err)
+	}
+	return nil
+}
+
+// matches evaluates expr against a resource as rendered by toAttributes.
+// Attribute names are case insensitive and so are string comparisons, which
+// is the SCIM default.
+func matches(expr filter.Expression, attrs map[string]any) bool {
+	switch e := expr.(type) {
+	case nil:
+		return true
+	case *filter.LogicalExpression:
+		if e.Operator == filter.AND {
+			return matches(e.Left, attrs) && matches(e.Right, attrs)
+		}
+		return matches(e.Left, attrs) || matches(e.Right, attrs)
+	case *filter.NotExpression:
+		return !matches(e.Expression, attrs)
+	case *filter.ValuePath:
+		for _, elem := range asList(lookup(attrs, e.AttributePath.AttributeName)) {
+			if sub, ok := elem.(map[string]any); ok && matches(e.ValueFilter, sub) {
+				return true
+			}
+		}
+		return false
+	case *filter.AttributeExpression:
+		values := attributeValues(attrs, e.AttributePath)
+		if e.Operator == filter.PR {
+			for _, v := range values {
+				if v != nil && v != "" {
+					return true
+				}
+			}
+			return false
+		}
+		for _, v := range values {
+			if compare(e.Operator, v, e.CompareValue) {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+// attributeValues resolves path to the values it refers to. Multi-valued
+// attributes yield one value per element, compared on their "value"
+// sub-attribute unless another one is named.
+func attributeValues(attrs map[string]any, path filter.AttributePath) []any {
+	var values []any
+	for _, elem := range asList(lookup(attrs, path.AttributeName)) {
+		sub := path.SubAttributeName()
+		if obj, ok := elem.(map[string]any); ok {
+			if sub == "" {
+				sub = "value"
+			}
+			values = append(values, lookup(obj, sub))
+			continue
+		}
+		if sub == "" {
+			values = append(values, elem)
+		}
+	}
+	return values
+}
+
+func compare(op filter.CompareOperator, actual, expected any) bool {
+	switch a := actual.(type) {
+	case string:
+		b, ok := expected.(string)
+		if !ok {
+			return false
+		}
+		a, b = strings.ToLower(a), strings.ToLower(b)
+		switch op {
+		case filter.EQ:
+			return a == b
+		case filter.NE:
+			return a != b
+		case filter.CO:
+			return strings.Contains(a, b)
+		case filter.SW:
+			return strings.HasPrefix(a, b)
+		case filter.EW:
+			return strings.HasSuffix(a, b)
+		case filter.GT:
+			return a > b
+		case filter.GE:
+			return a >= b
+		case filter.LT:
+			return a < b
+		case filter.LE:
+			return a <= b
+		}
+	case bool:
+		b, ok := expected.(bool)
+		if !ok {
+			return false
+		}
+		switch op {
+		case filter.EQ:
+			return a == b
+		case filter.NE:
+			return a != b
+		}
+	case float64:
+		var b float64
+		switch v := expected.(type) {
+		case int:
+			b = float64(v)
+		case float64:
+			b = v
+		default:
+			return false
+		}
+		switch op {
+		case filter.EQ:
+			return a == b
+		case filter.NE:
+			return a != b
+		case filter.GT:
+			return a > b
+		case filter.GE:
+			return a >= b
+		case filter.LT:
+			return a < b
+		case filter.LE:
+			return a <= b
+		}
+	}
+	return false
+}
+
+// lookup finds an attribute by its case insensitive name.
+func lookup(attrs map[string]any, name string) any {
+	if key, ok := findKey(attrs, name); ok {
+		return attrs[key]
+	}
+	return nil
+}
+
+func findKey(attrs map[string]any, name string) (string, bool) {
+	if _, ok := attrs[name]; ok {
+		return name, true
+	}
+	for key := range attrs {
+		if strings.EqualFold(key, name) {
+			return key, true
+		}
+	}
+	return name, false
+}
+
+func asList(v any) []any {
+	switch l := v.(type) {
+	case nil:
+		return nil
+	case []any:
+		return l
+	default:
+		return []any{v}
+	}
+}
+
+// userFilter translates expr into conditions the database applies: eq and
+// sw on userName, emails and externalId, joined by and. ok is false for any
+// other filter, which is then evaluated in memory by matches.
+func userFilter(expr filter.Expression) (*domain.UserFilter, bool) {
+	f := new(domain.UserFilter)
+	if !addUserCondition(f, expr) {
+		return nil, false
+	}
+	return f, true
+}
+
+func addUserCondition(f *domain.UserFilter, expr filter.Expression) bool {
+	switch e := expr.(type) {
+	case nil:
+		return true
+	case *filter.LogicalExpression:
+		return e.Operator == filter.AND && addUserCondition(f, e.Left) && addUserCondition(f, e.Right)
+	case *filter.ValuePath:
+		// emails[value eq "ada@example.org"]
+		sub, ok := e.ValueFilter.(*filter.AttributeExpression)
+		if !ok || !userAttribute(e.AttributePath, "emails") || e.AttributePath.SubAttribute != nil ||
+			!strings.EqualFold(sub.AttributePath.AttributeName, "value") || sub.AttributePath.SubAttribute != nil {
+			return false
+		}
+		return setUserCondition(&f.Email, &f.EmailPrefix, sub)
+	case *filter.AttributeExpression:
+		path := e.AttributePath
+		switch {
+		case userAttribute(path, "userName") && path.SubAttribute == nil,
+			userAttribute(path, "emails") && (path.SubAttribute == nil || strings.EqualFold(*path.SubAttribute, "value")):
+			return setUserCondition(&f.Email, &f.EmailPrefix, e)
+		case userAttribute(path, "externalId") && path.SubAttribute == nil:
+			return setUserCondition(&f.ExternalID, &f.ExternalIDPrefix, e)
+		}
+	}
+	return false
+}
+
+// userAttribute reports whether path names the core User attribute name.
+func userAttribute(path filter.AttributePath, name string) bool {
+	return strings.EqualFold(path.AttributeName, name) &&
+		(path.URIPrefix == nil || strings.EqualFold(*path.URIPrefix, schemaUser))
+}
+
+// setUserCondition stores the value of an eq or sw comparison in exact or
+// prefix. A second comparison of the same kind is left to matches.
+func setUserCondition(exact, prefix *string, e *filter.AttributeExpression) bool {
+	value, ok := e.CompareValue.(string)
+	if !ok || value == "" {
+		return false
+	}
+	target := exact
+	switch e.Operator {
+	case filter.EQ:
+	case filter.SW:
+		target = prefix
+	default:
+		return false
+	}
+	if *target != "" {
+		return false
+	}
+	*target = value
+	return true
+}
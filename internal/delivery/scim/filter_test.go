@@ -0,0 +1,142 @@
+package scim
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"tablelink/internal/domain"
+	"tablelink/internal/usecase"
+	"testing"
+)
+
+func TestUserFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   *domain.UserFilter
+	}{
+		{filter: ``, want: &domain.UserFilter{}},
+		{filter: `userName eq "ada@example.org"`, want: &domain.UserFilter{Email: "ada@example.org"}},
+		{filter: `userName sw "ada"`, want: &domain.UserFilter{EmailPrefix: "ada"}},
+		{filter: `emails eq "ada@example.org"`, want: &domain.UserFilter{Email: "ada@example.org"}},
+		{filter: `emails.value sw "ada"`, want: &domain.UserFilter{EmailPrefix: "ada"}},
+		{filter: `emails[value eq "ada@example.org"]`, want: &domain.UserFilter{Email: "ada@example.org"}},
+		{filter: `externalId eq "okta-1"`, want: &domain.UserFilter{ExternalID: "okta-1"}},
+		{
+			filter: `externalId sw "okta" and urn:ietf:params:scim:schemas:core:2.0:User:userName eq "ada@example.org"`,
+			want:   &domain.UserFilter{ExternalIDPrefix: "okta", Email: "ada@example.org"},
+		},
+		{filter: `userName eq "ada@example.org" or userName eq "grace@example.org"`},
+		{filter: `userName eq "ada@example.org" and userName eq "grace@example.org"`},
+		{filter: `userName co "ada"`},
+		{filter: `displayName eq "Ada"`},
+		{filter: `emails.type eq "work"`},
+		{filter: `emails[type eq "work"]`},
+		{filter: `not (userName eq "ada@example.org")`},
+		{filter: `active eq true`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			expr, err := parseFilter(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := userFilter(expr)
+			if ok != (tt.want != nil) {
+				t.Fatalf("userFilter() ok = %t, want %t", ok, tt.want != nil)
+			}
+			if ok && *got != *tt.want {
+				t.Errorf("userFilter() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeProvisioning pages through users the way the database would.
+type fakeProvisioning struct {
+	usecase.ProvisioningUseCase
+	users []*domain.User
+	// listed records the filters ListUsers was called with.
+	listed []domain.UserFilter
+}
+
+func (f *fakeProvisioning) matching(filter *domain.UserFilter) []*domain.User {
+	var users []*domain.User
+	for _, u := range f.users {
+		if filter.Email == "" || filter.Email == u.Email {
+			users = append(users, u)
+		}
+	}
+	return users
+}
+
+func (f *fakeProvisioning) ListUsers(ctx context.Context, filter *domain.UserFilter) ([]*domain.User, error) {
+	f.listed = append(f.listed, *filter)
+	users := f.matching(filter)
+	if filter.Limit > 0 {
+		users = users[min(filter.Offset, len(users)):min(filter.Offset+filter.Limit, len(users))]
+	}
+	return users, nil
+}
+
+func (f *fakeProvisioning) CountUsers(ctx context.Context, filter *domain.UserFilter) (int, error) {
+	return len(f.matching(filter)), nil
+}
+
+func TestListUsers(t *testing.T) {
+	users := []*domain.User{
+		{ID: 1, Email: "ada@example.org", Status: domain.UserStatusActive},
+		{ID: 2, Email: "grace@example.org", Status: domain.UserStatusActive},
+		{ID: 3, Email: "linus@example.org", Status: domain.UserStatusSuspended},
+	}
+	tests := []struct {
+		name      string
+		query     url.Values
+		wantTotal int
+		wantIDs   []string
+		// wantPaged is set when the database must be asked for the page.
+		wantPaged bool
+	}{
+		{name: "pages in the database", query: url.Values{"startIndex": {"2"}, "count": {"1"}}, wantTotal: 3, wantIDs: []string{"2"}, wantPaged: true},
+		{name: "filters in the database", query: url.Values{"filter": {`userName eq "grace@example.org"`}}, wantTotal: 1, wantIDs: []string{"2"}, wantPaged: true},
+		{name: "counts without listing for count=0", query: url.Values{"count": {"0"}}, wantTotal: 3},
+		{name: "returns nothing past the end", query: url.Values{"startIndex": {"5"}}, wantTotal: 3},
+		{name: "filters other attributes in memory", query: url.Values{"filter": {`active eq false`}}, wantTotal: 1, wantIDs: []string{"3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uc := &fakeProvisioning{users: users}
+			req := httptest.NewRequest(http.MethodGet, "/Users?"+tt.query.Encode(), nil)
+			rec := httptest.NewRecorder()
+			NewServer(uc, "token", "https://tablelink.test/scim/v2").listUsers(rec, req)
+
+			var resp struct {
+				TotalResults int `json:"totalResults"`
+				Resources    []struct {
+					ID string `json:"id"`
+				} `json:"Resources"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("status %d: %v", rec.Code, err)
+			}
+			if resp.TotalResults != tt.wantTotal {
+				t.Errorf("totalResults = %d, want %d", resp.TotalResults, tt.wantTotal)
+			}
+			var ids []string
+			for _, r := range resp.Resources {
+				ids = append(ids, r.ID)
+			}
+			if !slices.Equal(ids, tt.wantIDs) {
+				t.Errorf("ids = %q, want %q", ids, tt.wantIDs)
+			}
+			paged := len(uc.listed) == 1 && uc.listed[0].Limit > 0
+			if paged != tt.wantPaged {
+				t.Errorf("ListUsers calls = %+v, want a paged call %t", uc.listed, tt.wantPaged)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package scim
+
+import (
+	"net/http"
+)
+
+func (s *Server) listGroups(w http.ResponseWriter, r *http.Request) {
+	groups, err := s.provisioningUC.ListGroups(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resources := make([]*Group, 0, len(groups))
+	for _, g := range groups {
+		resources = append(resources, s.toSCIMGroup(g))
+	}
+	writeList(w, r, resources)
+}
+
+func (s *Server) getGroup(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	group, err := s.provisioningUC.GetGroup(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resource := s.toSCIMGroup(group)
+	if notModified(w, r, resource.Meta.Version) {
+		return
+	}
+	writeResource(w, http.StatusOK, resource, resource.Meta)
+}
+
+func (s *Server) createGroup(w http.ResponseWriter, r *http.Request) {
+	var body Group
+	if err := decodeBody(r, &body); err != nil {
+		writeError(w, err)
+		return
+	}
+	ids, err := memberIDs(&body)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	group, err := s.provisioningUC.CreateGroup(r.Context(), body.DisplayName, ids)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resource := s.toSCIMGroup(group)
+	writeResource(w, http.StatusCreated, resource, resource.Meta)
+}
+
+func (s *Server) replaceGroup(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var body Group
+	if err := decodeBody(r, &body); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	s.updateGroup(w, r, id, &body)
+}
+
+func (s *Server) patchGroup(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var patch PatchRequest
+	if err := decodeBody(r, &patch); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	current, err := s.provisioningUC.GetGroup(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	attrs, err := toAttributes(s.toSCIMGroup(current))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := applyPatch(attrs, patch.Operations); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var body Group
+	if err := fromAttributes(attrs, &body); err != nil {
+		writeError(w, err)
+		return
+	}
+	s.updateGroup(w, r, id, &body)
+}
+
+func (s *Server) updateGroup(w http.ResponseWriter, r *http.Request, id int, body *Group) {
+	current, err := s.provisioningUC.GetGroup(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := checkIfMatch(r, groupETag(current)); err != nil {
+		writeError(w, err)
+		return
+	}
+	ids, err := memberIDs(body)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	group, err := s.provisioningUC.ReplaceGroup(r.Context(), id, body.DisplayName, ids)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resource := s.toSCIMGroup(group)
+	writeResource(w, http.StatusOK, resource, resource.Meta)
+}
+
+func (s *Server) deleteGroup(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	current, err := s.provisioningUC.GetGroup(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := checkIfMatch(r, groupETag(current)); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := s.provisioningUC.DeleteGroup(r.Context(), id); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
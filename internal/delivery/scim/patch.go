@@ -0,0 +1,165 @@
+package scim
+
+import (
+	"encoding/json"
+	"strings"
+
+	filter "github.com/scim2/filter-parser/v2"
+)
+
+// applyPatch applies the operations of a PATCH request, in order, to a
+// resource rendered by toAttributes.
+func applyPatch(attrs map[string]any, ops []PatchOperation) error {
+	if len(ops) == 0 {
+		return errInvalidValue("patch request has no operations")
+	}
+
+	for _, op := range ops {
+		kind := strings.ToLower(op.Op)
+		if kind != "add" && kind != "replace" && kind != "remove" {
+			return &scimError{status: 400, scimType: "invalidSyntax", detail: "unsupported patch op " + op.Op}
+		}
+
+		var value any
+		if len(op.Value) > 0 {
+			if err := json.Unmarshal(op.Value, &value); err != nil {
+				return &scimError{status: 400, scimType: "invalidSyntax", detail: err.Error()}
+			}
+		}
+
+		if op.Path == "" {
+			// Without a path the value is an object of attributes to add or
+			// replace. Some providers put dotted paths in its keys.
+			obj, ok := value.(map[string]any)
+			if !ok || kind == "remove" {
+				return &scimError{status: 400, scimType: "noTarget", detail: "patch op without a path needs an object value"}
+			}
+			for key, v := range obj {
+				if err := applyPath(attrs, kind, key, v); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if kind != "remove" && value == nil {
+			return errInvalidValue("patch op %s on %s has no value", op.Op, op.Path)
+		}
+		if err := applyPath(attrs, kind, op.Path, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyPath(attrs map[string]any, kind, rawPath string, value any) error {
+	path, err := filter.ParsePath([]byte(rawPath))
+	if err != nil {
+		return &scimError{status: 400, scimType: "invalidPath", detail: err.Error()}
+	}
+
+	name := path.AttributePath.AttributeName
+	if path.ValueExpression != nil {
+		return applyFiltered(attrs, kind, name, path.ValueExpression, path.SubAttributeName(), value)
+	}
+
+	target := attrs
+	if sub := path.AttributePath.SubAttributeName(); sub != "" {
+		key, _ := findKey(attrs, name)
+		container, ok := attrs[key].(map[string]any)
+		if !ok {
+			if kind == "remove" {
+				return nil
+			}
+			container = make(map[string]any)
+			attrs[key] = container
+		}
+		target, name = container, sub
+	}
+
+	key, _ := findKey(target, name)
+	switch kind {
+	case "remove":
+		delete(target, key)
+	case "add":
+		if existing, ok := target[key].([]any); ok {
+			target[key] = appendUnique(existing, asList(value))
+			return nil
+		}
+		target[key] = value
+	case "replace":
+		target[key] = value
+	}
+	return nil
+}
+
+// applyFiltered handles paths like members[value eq "2"] and
+// emails[type eq "work"].value, which address the matching elements of a
+// multi-valued attribute.
+func applyFiltered(attrs map[string]any, kind, name string, expr filter.Expression, sub string, value any) error {
+	key, _ := findKey(attrs, name)
+	elems := asList(attrs[key])
+
+	kept := make([]any, 0, len(elems))
+	var matched bool
+	for _, elem := range elems {
+		obj, ok := elem.(map[string]any)
+		if !ok || !matches(expr, obj) {
+			kept = append(kept, elem)
+			continue
+		}
+		matched = true
+
+		switch {
+		case kind == "remove" && sub == "":
+			continue
+		case kind == "remove":
+			subKey, _ := findKey(obj, sub)
+			delete(obj, subKey)
+		case sub != "":
+			subKey, _ := findKey(obj, sub)
+			obj[subKey] = value
+		default:
+			fields, ok := value.(map[string]any)
+			if !ok {
+				return errInvalidValue("value for %s must be an object", name)
+			}
+			for k, v := range fields {
+				fieldKey, _ := findKey(obj, k)
+				obj[fieldKey] = v
+			}
+		}
+		kept = append(kept, obj)
+	}
+
+	if !matched && kind == "replace" {
+		return &scimError{status: 400, scimType: "noTarget", detail: "no values of " + name + " match the filter"}
+	}
+	attrs[key] = kept
+	return nil
+}
+
+// appendUnique adds values to a multi-valued attribute, skipping elements
+// whose value is already present.
+func appendUnique(existing, values []any) []any {
+	seen := make(map[string]bool, len(existing))
+	for _, elem := range existing {
+		if obj, ok := elem.(map[string]any); ok {
+			if v, ok := lookup(obj, "value").(string); ok {
+				seen[v] = true
+			}
+		}
+	}
+	for _, elem := range values {
+		if obj, ok := elem.(map[string]any); ok {
+			if v, ok := lookup(obj, "value").(string); ok {
+				if seen[v] {
+					continue
+				}
+				seen[v] = true
+			}
+		}
+		existing = append(existing, elem)
+	}
+	return existing
+}
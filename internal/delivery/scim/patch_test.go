@@ -0,0 +1,123 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestApplyPatch(t *testing.T) {
+	const user = `{
+		"userName": "ada@example.org",
+		"active": true,
+		"name": {"givenName": "Ada", "familyName": "Lovelace"},
+		"emails": [{"value": "ada@example.org", "type": "work", "primary": true}]
+	}`
+	const group = `{"displayName": "admins", "members": [{"value": "1"}, {"value": "2"}]}`
+
+	tests := []struct {
+		name  string
+		attrs string
+		ops   string
+		want  string
+		// wantType is the scimType of the error the patch must fail with.
+		wantType string
+	}{
+		{
+			name:  "replaces a top level attribute",
+			attrs: user,
+			ops:   `[{"op": "Replace", "path": "active", "value": false}]`,
+			want: `{"userName": "ada@example.org", "active": false, "name": {"givenName": "Ada", "familyName": "Lovelace"},
+				"emails": [{"value": "ada@example.org", "type": "work", "primary": true}]}`,
+		},
+		{
+			name:  "replaces a sub-attribute case insensitively",
+			attrs: user,
+			ops:   `[{"op": "replace", "path": "name.GIVENNAME", "value": "Augusta"}]`,
+			want: `{"userName": "ada@example.org", "active": true, "name": {"givenName": "Augusta", "familyName": "Lovelace"},
+				"emails": [{"value": "ada@example.org", "type": "work", "primary": true}]}`,
+		},
+		{
+			name:  "applies an object without a path",
+			attrs: user,
+			ops:   `[{"op": "replace", "value": {"active": false, "name.familyName": "King"}}]`,
+			want: `{"userName": "ada@example.org", "active": false, "name": {"givenName": "Ada", "familyName": "King"},
+				"emails": [{"value": "ada@example.org", "type": "work", "primary": true}]}`,
+		},
+		{
+			name:  "replaces the value of a filtered element",
+			attrs: user,
+			ops:   `[{"op": "replace", "path": "emails[type eq \"work\"].value", "value": "ada@lovelace.org"}]`,
+			want: `{"userName": "ada@example.org", "active": true, "name": {"givenName": "Ada", "familyName": "Lovelace"},
+				"emails": [{"value": "ada@lovelace.org", "type": "work", "primary": true}]}`,
+		},
+		{
+			name:     "refuses to replace an element no filter matches",
+			attrs:    user,
+			ops:      `[{"op": "replace", "path": "emails[type eq \"home\"].value", "value": "ada@home.org"}]`,
+			wantType: "noTarget",
+		},
+		{
+			name:  "adds members without duplicates",
+			attrs: group,
+			ops:   `[{"op": "add", "path": "members", "value": [{"value": "2"}, {"value": "3"}]}]`,
+			want:  `{"displayName": "admins", "members": [{"value": "1"}, {"value": "2"}, {"value": "3"}]}`,
+		},
+		{
+			name:  "removes a filtered member",
+			attrs: group,
+			ops:   `[{"op": "remove", "path": "members[value eq \"1\"]"}]`,
+			want:  `{"displayName": "admins", "members": [{"value": "2"}]}`,
+		},
+		{
+			name:  "removes every member",
+			attrs: group,
+			ops:   `[{"op": "remove", "path": "members"}]`,
+			want:  `{"displayName": "admins"}`,
+		},
+		{
+			name:  "applies operations in order",
+			attrs: group,
+			ops:   `[{"op": "remove", "path": "members"}, {"op": "add", "path": "members", "value": [{"value": "3"}]}]`,
+			want:  `{"displayName": "admins", "members": [{"value": "3"}]}`,
+		},
+		{name: "refuses an empty patch", attrs: group, ops: `[]`, wantType: "invalidValue"},
+		{name: "refuses an unknown op", attrs: group, ops: `[{"op": "move", "path": "members"}]`, wantType: "invalidSyntax"},
+		{name: "refuses a replace without a value", attrs: group, ops: `[{"op": "replace", "path": "displayName"}]`, wantType: "invalidValue"},
+		{name: "refuses a remove without a path", attrs: group, ops: `[{"op": "remove", "value": {"displayName": "x"}}]`, wantType: "noTarget"},
+		{name: "refuses an invalid path", attrs: group, ops: `[{"op": "replace", "path": "members[", "value": "x"}]`, wantType: "invalidPath"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attrs map[string]any
+			if err := json.Unmarshal([]byte(tt.attrs), &attrs); err != nil {
+				t.Fatal(err)
+			}
+			var ops []PatchOperation
+			if err := json.Unmarshal([]byte(tt.ops), &ops); err != nil {
+				t.Fatal(err)
+			}
+
+			err := applyPatch(attrs, ops)
+			if tt.wantType != "" {
+				var scimErr *scimError
+				if !errors.As(err, &scimErr) || scimErr.scimType != tt.wantType {
+					t.Fatalf("applyPatch() err = %v, want a %s error", err, tt.wantType)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyPatch() err = %v", err)
+			}
+			var want map[string]any
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(attrs, want) {
+				t.Errorf("attrs = %v, want %v", attrs, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package scim
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"tablelink/internal/domain"
+)
+
+const (
+	schemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	schemaGroup                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	schemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	schemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	schemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+	schemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	schemaResourceType          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+)
+
+type Meta struct {
+	ResourceType string `json:"resourceType"`
+	Location     string `json:"location,omitempty"`
+	Version      string `json:"version,omitempty"`
+}
+
+type Name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+// MultiValue is an entry of a multi-valued attribute such as emails, groups
+// or members.
+type MultiValue struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+type User struct {
+	Schemas     []string     `json:"schemas"`
+	ID          string       `json:"id,omitempty"`
+	ExternalID  string       `json:"externalId,omitempty"`
+	UserName    string       `json:"userName"`
+	Name        *Name        `json:"name,omitempty"`
+	DisplayName string       `json:"displayName,omitempty"`
+	Emails      []MultiValue `json:"emails,omitempty"`
+	Active      *bool        `json:"active,omitempty"`
+	Password    string       `json:"password,omitempty"`
+	Groups      []MultiValue `json:"groups,omitempty"`
+	Meta        *Meta        `json:"meta,omitempty"`
+}
+
+type Group struct {
+	Schemas     []string     `json:"schemas"`
+	ID          string       `json:"id,omitempty"`
+	DisplayName string       `json:"displayName"`
+	Members     []MultiValue `json:"members,omitempty"`
+	Meta        *Meta        `json:"meta,omitempty"`
+}
+
+type ListResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int      `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    []any    `json:"Resources"`
+}
+
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail"`
+}
+
+func (s *Server) toSCIMUser(u *domain.User) *User {
+	active := u.Status.CanLogin()
+	location := s.baseURL + "/Users/" + strconv.Itoa(u.ID)
+	var externalID string
+	if u.ExternalID != nil {
+		externalID = *u.ExternalID
+	}
+	return &User{
+		Schemas:     []string{schemaUser},
+		ID:          strconv.Itoa(u.ID),
+		ExternalID:  externalID,
+		UserName:    u.Email,
+		Name:        &Name{Formatted: u.Name},
+		DisplayName: u.Name,
+		Emails:      []MultiValue{{Value: u.Email, Type: "work", Primary: true}},
+		Active:      &active,
+		Groups: []MultiValue{{
+			Value: strconv.Itoa(u.RoleID),
+			Ref:   s.baseURL + "/Groups/" + strconv.Itoa(u.RoleID),
+		}},
+		Meta: &Meta{
+			ResourceType: "User",
+			Location:     location,
+			Version:      userETag(u),
+		},
+	}
+}
+
+// fromSCIMUser maps the writable attributes of a SCIM user. userName is the
+// email address; the display name falls back to the structured name and
+// then to userName.
+func fromSCIMUser(s *User) (*domain.User, bool) {
+	user := &domain.User{
+		Email:    s.UserName,
+		Name:     s.DisplayName,
+		Password: s.Password,
+	}
+	if user.Name == "" && s.Name != nil {
+		user.Name = s.Name.Formatted
+		if user.Name == "" {
+			user.Name = strings.TrimSpace(s.Name.GivenName + " " + s.Name.FamilyName)
+		}
+	}
+	if user.Name == "" {
+		user.Name = s.UserName
+	}
+	if s.ExternalID != "" {
+		user.ExternalID = &s.ExternalID
+	}
+
+	active := s.Active == nil || *s.Active
+	return user, active
+}
+
+func (s *Server) toSCIMGroup(g *domain.Group) *Group {
+	group := &Group{
+		Schemas:     []string{schemaGroup},
+		ID:          strconv.Itoa(g.Role.ID),
+		DisplayName: g.Role.Name,
+		Meta: &Meta{
+			ResourceType: "Group",
+			Location:     s.baseURL + "/Groups/" + strconv.Itoa(g.Role.ID),
+			Version:      groupETag(g),
+		},
+	}
+	for _, m := range g.Members {
+		group.Members = append(group.Members, MultiValue{
+			Value:   strconv.Itoa(m.ID),
+			Display: m.Name,
+			Ref:     s.baseURL + "/Users/" + strconv.Itoa(m.ID),
+		})
+	}
+	return group
+}
+
+func memberIDs(g *Group) ([]int, error) {
+	ids := make([]int, 0, len(g.Members))
+	for _, m := range g.Members {
+		id, err := strconv.Atoi(m.Value)
+		if err != nil {
+			return nil, errInvalidValue("member value %q is not a user id", m.Value)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func userETag(u *domain.User) string {
+	return `W/"` + strconv.Itoa(u.Version) + `"`
+}
+
+// groupETag hashes what a group consists of, since roles are not versioned.
+func groupETag(g *domain.Group) string {
+	ids := make([]int, 0, len(g.Members))
+	for _, m := range g.Members {
+		ids = append(ids, m.ID)
+	}
+	sort.Ints(ids)
+
+	h := sha256.New()
+	h.Write([]byte(g.Role.Name))
+	for _, id := range ids {
+		h.Write([]byte("," + strconv.Itoa(id)))
+	}
+	return `W/"` + hex.EncodeToString(h.Sum(nil)[:8]) + `"`
+}
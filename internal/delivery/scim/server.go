@@ -0,0 +1,305 @@
+package scim
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"tablelink/internal/domain"
+	"tablelink/internal/usecase"
+
+	filter "github.com/scim2/filter-parser/v2"
+)
+
+const (
+	contentType     = "application/scim+json"
+	maxBodySize     = 1 << 20
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// Server is the SCIM 2.0 HTTP API. It serves Users and Groups, where groups
+// are roles, and expects to be mounted at baseURL.
+type Server struct {
+	provisioningUC usecase.ProvisioningUseCase
+	token          string
+	baseURL        string
+	mux            *http.ServeMux
+}
+
+func NewServer(uc usecase.ProvisioningUseCase, token, baseURL string) *Server {
+	s := &Server{
+		provisioningUC: uc,
+		token:          token,
+		baseURL:        strings.TrimSuffix(baseURL, "/"),
+		mux:            http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("GET /ServiceProviderConfig", s.serviceProviderConfig)
+	s.mux.HandleFunc("GET /ResourceTypes", s.resourceTypes)
+
+	s.mux.HandleFunc("GET /Users", s.listUsers)
+	s.mux.HandleFunc("POST /Users", s.createUser)
+	s.mux.HandleFunc("GET /Users/{id}", s.getUser)
+	s.mux.HandleFunc("PUT /Users/{id}", s.replaceUser)
+	s.mux.HandleFunc("PATCH /Users/{id}", s.patchUser)
+	s.mux.HandleFunc("DELETE /Users/{id}", s.deleteUser)
+
+	s.mux.HandleFunc("GET /Groups", s.listGroups)
+	s.mux.HandleFunc("POST /Groups", s.createGroup)
+	s.mux.HandleFunc("GET /Groups/{id}", s.getGroup)
+	s.mux.HandleFunc("PUT /Groups/{id}", s.replaceGroup)
+	s.mux.HandleFunc("PATCH /Groups/{id}", s.patchGroup)
+	s.mux.HandleFunc("DELETE /Groups/{id}", s.deleteGroup)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	token, ok := strings.CutPrefix(auth, "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="scim"`)
+		writeError(w, &scimError{status: http.StatusUnauthorized, detail: "missing or invalid bearer token"})
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	s.mux.ServeHTTP(w, r)
+}
+
+// scimError is an error with the HTTP status and scimType it is reported
+// with.
+type scimError struct {
+	status   int
+	scimType string
+	detail   string
+}
+
+func (e *scimError) Error() string {
+	return e.detail
+}
+
+func errInvalidValue(format string, args ...any) error {
+	return &scimError{status: http.StatusBadRequest, scimType: "invalidValue", detail: fmt.Sprintf(format, args...)}
+}
+
+var errPreconditionFailed = &scimError{status: http.StatusPreconditionFailed, detail: "resource has been modified, reload and try again"}
+
+func toSCIMError(err error) *scimError {
+	var se *scimError
+	if errors.As(err, &se) {
+		return se
+	}
+
+	switch {
+	case errors.Is(err, domain.ErrUserNotFound), errors.Is(err, domain.ErrRoleNotFound):
+		return &scimError{status: http.StatusNotFound, detail: err.Error()}
+	case errors.Is(err, domain.ErrEmailTaken), errors.Is(err, domain.ErrRoleNameTaken):
+		return &scimError{status: http.StatusConflict, scimType: "uniqueness", detail: err.Error()}
+	case errors.Is(err, domain.ErrVersionConflict):
+		return errPreconditionFailed
+	case errors.Is(err, domain.ErrInvalidEmail), errors.Is(err, domain.ErrWeakPassword),
+		errors.Is(err, domain.ErrInvalidRoleName), errors.Is(err, domain.ErrInvalidTransition),
+		errors.Is(err, domain.ErrNoDefaultRole), errors.Is(err, domain.ErrDefaultRoleLocked),
+		errors.Is(err, domain.ErrRoleInUse):
+		return &scimError{status: http.StatusBadRequest, scimType: "invalidValue", detail: err.Error()}
+	case errors.Is(err, context.Canceled):
+		return &scimError{status: 499, detail: err.Error()}
+	}
+
+	log.Printf("Failed to serve SCIM request with err %v", err)
+	return &scimError{status: http.StatusInternalServerError, detail: "internal error"}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	se := toSCIMError(err)
+	writeJSON(w, se.status, &Error{
+		Schemas:  []string{schemaError},
+		Status:   strconv.Itoa(se.status),
+		ScimType: se.scimType,
+		Detail:   se.detail,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Failed to write SCIM response with err %v", err)
+	}
+}
+
+// writeResource writes a single resource with its ETag and, for new
+// resources, its Location.
+func writeResource(w http.ResponseWriter, status int, body any, meta *Meta) {
+	w.Header().Set("ETag", meta.Version)
+	if status == http.StatusCreated {
+		w.Header().Set("Location", meta.Location)
+	}
+	writeJSON(w, status, body)
+}
+
+func decodeBody(r *http.Request, v any) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return &scimError{status: http.StatusBadRequest, scimType: "invalidSyntax", detail: err.Error()}
+	}
+	return nil
+}
+
+func pathID(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return 0, &scimError{status: http.StatusNotFound, detail: "resource not found"}
+	}
+	return id, nil
+}
+
+// checkIfMatch enforces an If-Match precondition against the current ETag.
+func checkIfMatch(r *http.Request, etag string) error {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" || ifMatch == "*" {
+		return nil
+	}
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		if strings.TrimSpace(candidate) == etag {
+			return nil
+		}
+	}
+	return errPreconditionFailed
+}
+
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	if r.Header.Get("If-None-Match") == etag {
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// writeList filters resources and writes the requested page of them.
+func writeList[T any](w http.ResponseWriter, r *http.Request, resources []T) {
+	expr, err := parseFilter(r.URL.Query().Get("filter"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var matched []any
+	for _, resource := range resources {
+		if ok, err := filterResource(expr, resource); err != nil {
+			writeError(w, err)
+			return
+		} else if ok {
+			matched = append(matched, resource)
+		}
+	}
+
+	start, count, err := pagination(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	page := []any{}
+	if start-1 < len(matched) {
+		page = matched[start-1 : min(start-1+count, len(matched))]
+	}
+	writePage(w, start, len(matched), page)
+}
+
+// writePage writes page, which starts at the 1-based start of total results.
+func writePage(w http.ResponseWriter, start, total int, page []any) {
+	writeJSON(w, http.StatusOK, &ListResponse{
+		Schemas:      []string{schemaListResponse},
+		TotalResults: total,
+		StartIndex:   start,
+		ItemsPerPage: len(page),
+		Resources:    page,
+	})
+}
+
+func filterResource(expr filter.Expression, resource any) (bool, error) {
+	if expr == nil {
+		return true, nil
+	}
+	attrs, err := toAttributes(resource)
+	if err != nil {
+		return false, err
+	}
+	return matches(expr, attrs), nil
+}
+
+func pagination(r *http.Request) (int, int, error) {
+	start, count := 1, defaultPageSize
+	q := r.URL.Query()
+	if v := q.Get("startIndex"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, 0, errInvalidValue("startIndex must be a number")
+		}
+		start = max(n, 1)
+	}
+	if v := q.Get("count"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, 0, errInvalidValue("count must be a number")
+		}
+		count = min(max(n, 0), maxPageSize)
+	}
+	return start, count, nil
+}
+
+func (s *Server) serviceProviderConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"schemas":        []string{schemaServiceProviderConfig},
+		"patch":          map[string]bool{"supported": true},
+		"bulk":           map[string]any{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]any{"supported": true, "maxResults": maxPageSize},
+		"changePassword": map[string]bool{"supported": true},
+		"sort":           map[string]bool{"supported": false},
+		"etag":           map[string]bool{"supported": true},
+		"authenticationSchemes": []map[string]any{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer Token",
+			"description": "Authentication with a static bearer token",
+			"primary":     true,
+		}},
+		"meta": map[string]string{
+			"resourceType": "ServiceProviderConfig",
+			"location":     s.baseURL + "/ServiceProviderConfig",
+		},
+	})
+}
+
+func (s *Server) resourceTypes(w http.ResponseWriter, r *http.Request) {
+	types := []any{
+		map[string]any{
+			"schemas":  []string{schemaResourceType},
+			"id":       "User",
+			"name":     "User",
+			"endpoint": "/Users",
+			"schema":   schemaUser,
+			"meta":     map[string]string{"resourceType": "ResourceType", "location": s.baseURL + "/ResourceTypes/User"},
+		},
+		map[string]any{
+			"schemas":  []string{schemaResourceType},
+			"id":       "Group",
+			"name":     "Group",
+			"endpoint": "/Groups",
+			"schema":   schemaGroup,
+			"meta":     map[string]string{"resourceType": "ResourceType", "location": s.baseURL + "/ResourceTypes/Group"},
+		},
+	}
+	writeJSON(w, http.StatusOK, &ListResponse{
+		Schemas:      []string{schemaListResponse},
+		TotalResults: len(types),
+		StartIndex:   1,
+		ItemsPerPage: len(types),
+		Resources:    types,
+	})
+}
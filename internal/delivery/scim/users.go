@@ -0,0 +1,209 @@
+package scim
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"tablelink/internal/domain"
+)
+
+// listUsers lets the database filter and page when userFilter can translate
+// the filter, as it can for the lookups identity providers make before
+// provisioning. Other filters are evaluated on every user in memory.
+func (s *Server) listUsers(w http.ResponseWriter, r *http.Request) {
+	expr, err := parseFilter(r.URL.Query().Get("filter"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	f, ok := userFilter(expr)
+	if !ok {
+		users, err := s.provisioningUC.ListUsers(r.Context(), &domain.UserFilter{})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		resources := make([]*User, 0, len(users))
+		for _, u := range users {
+			resources = append(resources, s.toSCIMUser(u))
+		}
+		writeList(w, r, resources)
+		return
+	}
+
+	start, count, err := pagination(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	total, err := s.provisioningUC.CountUsers(r.Context(), f)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	page := []any{}
+	if count > 0 && start-1 < total {
+		f.Offset, f.Limit = start-1, count
+		users, err := s.provisioningUC.ListUsers(r.Context(), f)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		for _, u := range users {
+			page = append(page, s.toSCIMUser(u))
+		}
+	}
+	writePage(w, start, total, page)
+}
+
+func (s *Server) getUser(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	user, err := s.provisioningUC.GetUser(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resource := s.toSCIMUser(user)
+	if notModified(w, r, resource.Meta.Version) {
+		return
+	}
+	writeResource(w, http.StatusOK, resource, resource.Meta)
+}
+
+func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
+	var body User
+	if err := decodeBody(r, &body); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	user, active := fromSCIMUser(&body)
+	created, err := s.provisioningUC.CreateUser(r.Context(), user, active)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resource := s.toSCIMUser(created)
+	writeResource(w, http.StatusCreated, resource, resource.Meta)
+}
+
+func (s *Server) replaceUser(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var body User
+	if err := decodeBody(r, &body); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	s.updateUser(w, r, id, &body)
+}
+
+func (s *Server) patchUser(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var patch PatchRequest
+	if err := decodeBody(r, &patch); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	current, err := s.provisioningUC.GetUser(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	attrs, err := toAttributes(s.toSCIMUser(current))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := applyPatch(attrs, patch.Operations); err != nil {
+		writeError(w, err)
+		return
+	}
+	normalizeActive(attrs)
+
+	var body User
+	if err := fromAttributes(attrs, &body); err != nil {
+		writeError(w, err)
+		return
+	}
+	s.updateUser(w, r, id, &body)
+}
+
+// updateUser replaces user id with body. The version is taken from If-Match
+// when given, so concurrent writers get a precondition failure instead of
+// overwriting each other.
+func (s *Server) updateUser(w http.ResponseWriter, r *http.Request, id int, body *User) {
+	current, err := s.provisioningUC.GetUser(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := checkIfMatch(r, userETag(current)); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	user, active := fromSCIMUser(body)
+	user.ID = id
+	user.Version = current.Version
+	updated, err := s.provisioningUC.ReplaceUser(r.Context(), user, active)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resource := s.toSCIMUser(updated)
+	writeResource(w, http.StatusOK, resource, resource.Meta)
+}
+
+func (s *Server) deleteUser(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	current, err := s.provisioningUC.GetUser(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := checkIfMatch(r, userETag(current)); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := s.provisioningUC.DeleteUser(r.Context(), id, current.Version); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// normalizeActive accepts "True" and "False" strings for active, which some
+// identity providers send in PATCH requests.
+func normalizeActive(attrs map[string]any) {
+	key, ok := findKey(attrs, "active")
+	if !ok {
+		return
+	}
+	if v, ok := attrs[key].(string); ok {
+		if b, err := strconv.ParseBool(strings.ToLower(v)); err == nil {
+			attrs[key] = b
+		}
+	}
+}
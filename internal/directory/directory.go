@@ -0,0 +1,44 @@
+package directory
+
+import (
+	"context"
+	"errors"
+	"tablelink/internal/config"
+	"tablelink/internal/domain"
+)
+
+// Directory is an external source of users, such as an LDAP server.
+type Directory interface {
+	Users(ctx context.Context) ([]*domain.DirectoryUser, error)
+	// Bind checks password against the directory entry with the given
+	// email and returns the entry. It fails with
+	// domain.ErrInvalidCredentials when there is no single such entry or
+	// the password is wrong.
+	Bind(ctx context.Context, email, password string) (*domain.DirectoryUser, error)
+}
+
+// New picks the fake directory when a fake directory file is configured and
+// the LDAP server otherwise.
+func New(cfg *config.Config) (Directory, error) {
+	if cfg.LDAPFakeDirectory != "" {
+		return LoadFakeDirectory(cfg.LDAPFakeDirectory)
+	}
+	if cfg.LDAPURL == "" || cfg.LDAPBaseDN == "" {
+		return nil, errors.New("APP_LDAP_URL and APP_LDAP_BASE_DN are required")
+	}
+
+	return NewLDAPDirectory(&LDAPConfig{
+		URL:             cfg.LDAPURL,
+		StartTLS:        cfg.LDAPStartTLS,
+		BindDN:          cfg.LDAPBindDN,
+		BindPassword:    cfg.LDAPBindPassword,
+		BaseDN:          cfg.LDAPBaseDN,
+		UserFilter:      cfg.LDAPUserFilter,
+		EmailAttr:       cfg.LDAPEmailAttr,
+		NameAttr:        cfg.LDAPNameAttr,
+		GroupBaseDN:     cfg.LDAPGroupBaseDN,
+		GroupFilter:     cfg.LDAPGroupFilter,
+		GroupMemberAttr: cfg.LDAPGroupMemberAttr,
+		GroupNameAttr:   cfg.LDAPGroupNameAttr,
+	}), nil
+}
@@ -0,0 +1,90 @@
+package directory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"tablelink/internal/domain"
+)
+
+// FakeDirectory serves a fixed list of users. It stands in for an LDAP
+// server in local runs and tests.
+type FakeDirectory struct {
+	users     []*domain.DirectoryUser
+	passwords map[string]string
+}
+
+func NewFakeDirectory(users ...*domain.DirectoryUser) *FakeDirectory {
+	return &FakeDirectory{users: users, passwords: make(map[string]string)}
+}
+
+// SetPassword sets the password Bind accepts for the user with email. Users
+// without a password cannot bind.
+func (d *FakeDirectory) SetPassword(email, password string) {
+	d.passwords[strings.ToLower(email)] = password
+}
+
+type fakeEntry struct {
+	domain.DirectoryUser
+	Password string `json:"password"`
+}
+
+// LoadFakeDirectory reads a JSON array of users, for example
+//
+//	[{"dn": "uid=ada,ou=people,dc=example,dc=org", "email": "ada@example.org",
+//	  "name": "Ada Lovelace", "groups": ["admins"], "password": "secret"}]
+func LoadFakeDirectory(path string) (*FakeDirectory, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []*fakeEntry
+	if err := json.Unmarshal(raw, &entries); err != nil {
+		return nil, fmt.Errorf("Failed to parse fake directory %s with err %v", path, err)
+	}
+
+	d := NewFakeDirectory()
+	for _, entry := range entries {
+		user := entry.DirectoryUser
+		d.users = append(d.users, &user)
+		if entry.Password != "" {
+			d.SetPassword(user.Email, entry.Password)
+		}
+	}
+	return d, nil
+}
+
+func (d *FakeDirectory) Users(ctx context.Context) ([]*domain.DirectoryUser, error) {
+	users := make([]*domain.DirectoryUser, 0, len(d.users))
+	for _, u := range d.users {
+		copied := *u
+		copied.Groups = append([]string(nil), u.Groups...)
+		users = append(users, &copied)
+	}
+	return users, nil
+}
+
+func (d *FakeDirectory) Bind(ctx context.Context, email, password string) (*domain.DirectoryUser, error) {
+	want, ok := d.passwords[strings.ToLower(email)]
+	if !ok || password == "" || password != want {
+		return nil, domain.ErrInvalidCredentials
+	}
+
+	var found *domain.DirectoryUser
+	for _, u := range d.users {
+		if strings.EqualFold(u.Email, email) {
+			if found != nil {
+				return nil, domain.ErrInvalidCredentials
+			}
+			found = u
+		}
+	}
+	if found == nil {
+		return nil, domain.ErrInvalidCredentials
+	}
+	copied := *found
+	copied.Groups = append([]string(nil), found.Groups...)
+	return &copied, nil
+}
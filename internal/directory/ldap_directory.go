@@ -0,0 +1,194 @@
+package directory
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"tablelink/internal/domain"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const ldapPageSize = 500
+
+type LDAPConfig struct {
+	URL          string
+	StartTLS     bool
+	BindDN       string
+	BindPassword string
+
+	BaseDN     string
+	UserFilter string
+	EmailAttr  string
+	NameAttr   string
+
+	// GroupBaseDN defaults to BaseDN.
+	GroupBaseDN     string
+	GroupFilter     string
+	GroupMemberAttr string
+	GroupNameAttr   string
+}
+
+// LDAPDirectory reads users and their group memberships with a service
+// account. Memberships come from the member attribute of group entries, so
+// no memberOf overlay is needed.
+type LDAPDirectory struct {
+	cfg *LDAPConfig
+}
+
+func NewLDAPDirectory(cfg *LDAPConfig) *LDAPDirectory {
+	if cfg.GroupBaseDN == "" {
+		cfg.GroupBaseDN = cfg.BaseDN
+	}
+	return &LDAPDirectory{cfg: cfg}
+}
+
+// Dial connects and binds with the service account.
+func (d *LDAPDirectory) Dial(ctx context.Context) (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(d.cfg.URL, ldap.DialWithDialer(dialer(ctx)))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to connect to LDAP with err %v", err)
+	}
+	if d.cfg.StartTLS {
+		if err := conn.StartTLS(&tls.Config{ServerName: hostOf(d.cfg.URL)}); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("Failed to start TLS with err %v", err)
+		}
+	}
+	if d.cfg.BindDN != "" {
+		if err := conn.Bind(d.cfg.BindDN, d.cfg.BindPassword); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("Failed to bind to LDAP with err %v", err)
+		}
+	}
+	return conn, nil
+}
+
+func (d *LDAPDirectory) Users(ctx context.Context) ([]*domain.DirectoryUser, error) {
+	conn, err := d.Dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	userResult, err := conn.SearchWithPaging(ldap.NewSearchRequest(
+		d.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		d.cfg.UserFilter, []string{d.cfg.EmailAttr, d.cfg.NameAttr}, nil,
+	), ldapPageSize)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to search LDAP users with err %v", err)
+	}
+
+	groupResult, err := conn.SearchWithPaging(ldap.NewSearchRequest(
+		d.cfg.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		d.cfg.GroupFilter, []string{d.cfg.GroupNameAttr, d.cfg.GroupMemberAttr}, nil,
+	), ldapPageSize)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to search LDAP groups with err %v", err)
+	}
+
+	groups := make(map[string][]string)
+	for _, entry := range groupResult.Entries {
+		name := entry.GetAttributeValue(d.cfg.GroupNameAttr)
+		for _, member := range entry.GetAttributeValues(d.cfg.GroupMemberAttr) {
+			key := normalizeDN(member)
+			groups[key] = append(groups[key], name)
+		}
+	}
+
+	users := make([]*domain.DirectoryUser, 0, len(userResult.Entries))
+	for _, entry := range userResult.Entries {
+		users = append(users, &domain.DirectoryUser{
+			DN:     entry.DN,
+			Email:  entry.GetAttributeValue(d.cfg.EmailAttr),
+			Name:   entry.GetAttributeValue(d.cfg.NameAttr),
+			Groups: groups[normalizeDN(entry.DN)],
+		})
+	}
+	return users, nil
+}
+
+func (d *LDAPDirectory) Bind(ctx context.Context, email, password string) (*domain.DirectoryUser, error) {
+	// An empty password would be an unauthenticated bind, which servers
+	// accept for any DN.
+	if email == "" || password == "" {
+		return nil, domain.ErrInvalidCredentials
+	}
+
+	conn, err := d.Dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	filter := fmt.Sprintf("(&%s(%s=%s))", d.cfg.UserFilter, d.cfg.EmailAttr, ldap.EscapeFilter(email))
+	result, err := conn.Search(ldap.NewSearchRequest(
+		d.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		filter, []string{d.cfg.EmailAttr, d.cfg.NameAttr}, nil,
+	))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("Failed to search LDAP user with err %v", err)
+	}
+	if result == nil || len(result.Entries) != 1 {
+		return nil, domain.ErrInvalidCredentials
+	}
+	entry := result.Entries[0]
+
+	// Read the groups while still bound as the service account.
+	filter = fmt.Sprintf("(&%s(%s=%s))", d.cfg.GroupFilter, d.cfg.GroupMemberAttr, ldap.EscapeFilter(entry.DN))
+	groupResult, err := conn.Search(ldap.NewSearchRequest(
+		d.cfg.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		filter, []string{d.cfg.GroupNameAttr}, nil,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to search LDAP groups with err %v", err)
+	}
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, domain.ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("Failed to bind to LDAP with err %v", err)
+	}
+
+	user := &domain.DirectoryUser{
+		DN:    entry.DN,
+		Email: entry.GetAttributeValue(d.cfg.EmailAttr),
+		Name:  entry.GetAttributeValue(d.cfg.NameAttr),
+	}
+	for _, group := range groupResult.Entries {
+		user.Groups = append(user.Groups, group.GetAttributeValue(d.cfg.GroupNameAttr))
+	}
+	return user, nil
+}
+
+// normalizeDN makes DNs comparable regardless of case and spacing.
+func normalizeDN(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return strings.ToLower(dn)
+	}
+	return strings.ToLower(parsed.String())
+}
+
+func hostOf(url string) string {
+	host := url
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	if i := strings.IndexAny(host, ":/"); i >= 0 {
+		host = host[:i]
+	}
+	return host
+}
+
+func dialer(ctx context.Context) *net.Dialer {
+	d := &net.Dialer{Timeout: 10 * time.Second}
+	if deadline, ok := ctx.Deadline(); ok {
+		d.Deadline = deadline
+	}
+	return d
+}
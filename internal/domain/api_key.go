@@ -0,0 +1,77 @@
+package domain
+
+import (
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// APIKeyPrefix starts every API key, so credentials can tell keys from
+// session tokens and secret scanners can find leaked keys.
+const APIKeyPrefix = "tlk_"
+
+// APIKey lets a program call the API with the rights of RoleID. The key
+// itself is shown once when created; only its hash is stored, next to
+// Prefix, the public part of the key it is looked up by.
+type APIKey struct {
+	ID         int        `db:"id"`
+	Name       string     `db:"name"`
+	Prefix     string     `db:"prefix"`
+	KeyHash    string     `db:"key_hash"`
+	RoleID     int        `db:"role_id"`
+	ExpiresAt  *time.Time `db:"expires_at"`
+	AllowedIPs []string   `db:"allowed_ips"`
+	CreatedBy  *int       `db:"created_by"`
+	CreatedAt  time.Time  `db:"created_at"`
+	LastUsedAt *time.Time `db:"last_used_at"`
+	LastUsedIP *string    `db:"last_used_ip"`
+	RevokedAt  *time.Time `db:"revoked_at"`
+}
+
+// Active reports whether the key is neither revoked nor expired at now.
+func (k *APIKey) Active(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// AllowsIP reports whether the key may be used from ip. AllowedIPs holds
+// addresses and CIDR prefixes; an empty list allows any address.
+func (k *APIKey) AllowsIP(ip string) bool {
+	if len(k.AllowedIPs) == 0 {
+		return true
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, allowed := range k.AllowedIPs {
+		if prefix, err := parseIPPrefix(allowed); err == nil && prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateAllowedIPs checks that every entry is an address or CIDR prefix.
+func ValidateAllowedIPs(allowed []string) error {
+	for _, entry := range allowed {
+		if _, err := parseIPPrefix(entry); err != nil {
+			return ErrInvalidAllowedIP
+		}
+	}
+	return nil
+}
+
+func parseIPPrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
@@ -0,0 +1,48 @@
+package domain
+
+// DirectoryUser is a user entry read from an external directory such as
+// LDAP. Groups holds the names of the groups the entry belongs to.
+type DirectoryUser struct {
+	DN     string   `json:"dn"`
+	Email  string   `json:"email"`
+	Name   string   `json:"name"`
+	Groups []string `json:"groups"`
+}
+
+type SyncActionKind string
+
+const (
+	SyncCreate     SyncActionKind = "create"
+	SyncUpdate     SyncActionKind = "update"
+	SyncDeactivate SyncActionKind = "deactivate"
+	SyncSkip       SyncActionKind = "skip"
+)
+
+// SyncAction is one line of a directory sync diff. Changes describes what
+// differs, e.g. "role: 2 -> 3". Err is set when the action could not be
+// planned or applied.
+type SyncAction struct {
+	Kind    SyncActionKind
+	DN      string
+	Email   string
+	UserID  int
+	Changes []string
+	Err     error
+}
+
+type SyncReport struct {
+	DryRun    bool
+	Actions   []*SyncAction
+	Unchanged int
+}
+
+// Failed returns the number of actions that could not be applied.
+func (r *SyncReport) Failed() int {
+	var n int
+	for _, a := range r.Actions {
+		if a.Err != nil {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,111 @@
+package domain
+
+import "errors"
+
+var (
+	ErrPermissionDenied   = errors.New("permission denied")
+	ErrInvalidCredentials = errors.New("Make sure you have provide valid email or password")
+
+	ErrUserNotFound    = errors.New("user not found")
+	ErrVersionRequired = errors.New("user version is required")
+	ErrVersionConflict = errors.New("user has been modified by someone else, reload and try again")
+
+	ErrInvalidUserStatus    = errors.New("invalid user status")
+	ErrInvalidTransition    = errors.New("user status transition is not allowed")
+	ErrStatusReasonRequired = errors.New("a reason is required to change user status")
+	ErrUserNotActive        = errors.New("Your account is not active, please contact your administrator")
+	ErrInvalidSession       = errors.New("invalid or expired session")
+
+	ErrInvalidEmail     = errors.New("invalid email address")
+	ErrWeakPassword     = errors.New("password must be at least 8 characters")
+	ErrInviteNotPending = errors.New("invitation has already been accepted or revoked")
+
+	ErrEmailAlreadyVerified  = errors.New("email address is already verified")
+	ErrEmailTaken            = errors.New("email address is already in use")
+	ErrEmailChangeNotPending = errors.New("email change is no longer pending")
+
+	ErrBatchEmpty    = errors.New("batch has no items")
+	ErrBatchTooLarge = errors.New("batch has too many items")
+	ErrBatchAborted  = errors.New("not applied because another item of the atomic batch failed")
+	ErrRoleNotFound  = errors.New("role not found")
+	ErrNothingToDo   = errors.New("item does not change anything")
+
+	ErrWebhookNotFound       = errors.New("webhook not found")
+	ErrInvalidWebhookURL     = errors.New("webhook url must be an absolute http or https url")
+	ErrWebhookAddress        = errors.New("webhook url must not point at a private, loopback or link-local address")
+	ErrInvalidEventType      = errors.New("unknown event type")
+	ErrWebhookNotDead        = errors.New("webhook delivery is not in the dead-letter state")
+	ErrInvalidDeliveryStatus = errors.New("invalid webhook delivery status")
+
+	ErrRevisionAhead = errors.New("revision is ahead of the latest change")
+
+	ErrInvalidRoleName   = errors.New("role name is required")
+	ErrRoleNameTaken     = errors.New("role name is already in use")
+	ErrRoleInUse         = errors.New("role still has users")
+	ErrNoDefaultRole     = errors.New("no default role is configured for users removed from a group")
+	ErrDefaultRoleLocked = errors.New("the default role cannot lose members or be deleted")
+
+	ErrEmptyDirectory  = errors.New("directory returned no users, refusing to deactivate everyone")
+	ErrNoDirectoryRole = errors.New("no role is mapped for the directory user's groups")
+	ErrDirectoryEmail  = errors.New("email belongs to a user the directory does not manage")
+
+	ErrOAuthClientNotFound  = errors.New("oauth client not found")
+	ErrInvalidClient        = errors.New("client authentication failed")
+	ErrInvalidGrant         = errors.New("authorization grant is invalid, expired or revoked")
+	ErrInvalidScope         = errors.New("requested scope is invalid or not allowed")
+	ErrUnauthorizedClient   = errors.New("client is not allowed to use this grant type")
+	ErrUnsupportedGrantType = errors.New("unsupported grant type")
+	ErrInvalidRedirectURI   = errors.New("redirect_uri is not registered for the client")
+	ErrPKCERequired         = errors.New("a code_challenge with method S256 is required")
+	ErrInvalidOAuthClient   = errors.New("oauth client needs a name, valid grant types and redirect uris for the code grant")
+	ErrInvalidAccessToken   = errors.New("access token is invalid or expired")
+	ErrInsufficientScope    = errors.New("access token lacks the required scope")
+	ErrLoginRequired        = errors.New("the user is not signed in")
+	ErrConsentRequired      = errors.New("the user has not allowed the client these scopes")
+
+	ErrIdentityProviderNotFound = errors.New("identity provider not found")
+	ErrIdentityNotFound         = errors.New("identity is not linked to a user")
+	ErrIdentityTaken            = errors.New("identity is already linked to another user")
+	ErrFederationState          = errors.New("sign-in attempt is invalid or has expired, please start again")
+	ErrNoLinkedAccount          = errors.New("no account is linked to this identity and sign-up is not allowed")
+	ErrUnverifiedEmail          = errors.New("the identity provider has not verified the email address")
+	ErrNoMappedRole             = errors.New("no role is mapped for the external identity")
+
+	ErrUnauthenticated    = errors.New("missing or invalid credentials")
+	ErrAPIKeyNotFound     = errors.New("api key not found")
+	ErrInvalidAPIKey      = errors.New("api key is invalid, expired or revoked")
+	ErrAPIKeyIPNotAllowed = errors.New("api key may not be used from this address")
+	ErrInvalidAPIKeyName  = errors.New("api key name is required")
+	ErrInvalidAllowedIP   = errors.New("allowed ips must be addresses or CIDR prefixes")
+	ErrInvalidExpiry      = errors.New("expiry must be in the future")
+	ErrRoleEscalation     = errors.New("cannot grant a role with rights the caller does not have")
+	ErrKeyCreatorNotUser  = errors.New("keys and credentials can only be created by a signed-in user acting as themselves")
+
+	ErrServiceAccountNotFound  = errors.New("service account not found")
+	ErrServiceAccountNameTaken = errors.New("service account name is already in use")
+	ErrInvalidServiceAccount   = errors.New("service account name is required")
+	ErrServiceAccountLogin     = errors.New("service accounts cannot log in")
+	ErrCredentialNotFound      = errors.New("credential not found")
+	ErrInvalidCredentialType   = errors.New("credential type must be secret or public_key")
+	ErrInvalidPublicKey        = errors.New("public key must be a PEM encoded RSA key of at least 2048 bits")
+	ErrInvalidAssertion        = errors.New("service account assertion is invalid or expired")
+
+	ErrImpersonationNotAllowed     = errors.New("this user cannot be impersonated by the caller")
+	ErrImpersonationEscalation     = errors.New("cannot impersonate a user whose role has rights the caller does not have")
+	ErrImpersonationReasonRequired = errors.New("a reason is required to impersonate a user")
+
+	ErrMagicLinkDisabled    = errors.New("magic link login is not enabled")
+	ErrMagicLinkRateLimited = errors.New("too many magic links requested for this email, try again later")
+	ErrMagicLinkWrongClient = errors.New("magic link must be opened from the client that requested it")
+
+	ErrSecondFactorRequired          = errors.New("confirm the login with your passkey")
+	ErrWebAuthnCeremony              = errors.New("passkey request is invalid or has expired, please start again")
+	ErrWebAuthnVerification          = errors.New("passkey response could not be verified")
+	ErrWebAuthnSignCount             = errors.New("passkey signature counter went backwards, the authenticator may have been cloned")
+	ErrWebAuthnCredentialNotFound    = errors.New("passkey not found")
+	ErrWebAuthnCredentialExists      = errors.New("passkey is already registered")
+	ErrInvalidWebAuthnCredentialName = errors.New("passkey name is required")
+
+	ErrStepUpRequired    = errors.New("re-authenticate to continue")
+	ErrStepUpUnavailable = errors.New("this action needs a user who authenticated recently, which the caller cannot do")
+)
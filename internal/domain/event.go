@@ -0,0 +1,203 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventUserCreated EventType = "UserCreated"
+	EventUserUpdated EventType = "UserUpdated"
+	EventUserDeleted EventType = "UserDeleted"
+	EventRoleChanged EventType = "RoleChanged"
+
+	EventUserLoggedIn     EventType = "UserLoggedIn"
+	EventUserLoggedOut    EventType = "UserLoggedOut"
+	EventUserImpersonated EventType = "UserImpersonated"
+	// EventImpersonatedCall records each call made while impersonating,
+	// reads included.
+	EventImpersonatedCall EventType = "ImpersonatedCall"
+
+	// EventRoleRightChanged is written by a database trigger on role_rights.
+	EventRoleRightChanged EventType = "RoleRightChanged"
+)
+
+func (t EventType) Valid() bool {
+	switch t {
+	case EventUserCreated, EventUserUpdated, EventUserDeleted, EventRoleChanged,
+		EventUserLoggedIn, EventUserLoggedOut, EventUserImpersonated, EventImpersonatedCall, EventRoleRightChanged:
+		return true
+	}
+	return false
+}
+
+// AffectsAccess tells whether the event changes user or permission data, as
+// opposed to recording activity such as logins.
+func (t EventType) AffectsAccess() bool {
+	switch t {
+	case EventUserLoggedIn, EventUserLoggedOut, EventUserImpersonated, EventImpersonatedCall:
+		return false
+	}
+	return true
+}
+
+const (
+	AggregateUser      = "user"
+	AggregateRoleRight = "role_right"
+)
+
+// Event is a domain event as stored in the outbox and handed to publishers.
+type Event struct {
+	ID int64 `db:"id" json:"id"`
+	// Revision orders events by commit and is what watchers resume from. It
+	// is zero until the relay has sequenced the event.
+	Revision      int64           `db:"revision" json:"revision"`
+	AggregateType string          `db:"aggregate_type" json:"aggregate_type"`
+	AggregateID   int             `db:"aggregate_id" json:"aggregate_id"`
+	Type          EventType       `db:"event_type" json:"event_type"`
+	Payload       json.RawMessage `db:"payload" json:"payload"`
+	// Actor is who made the change, when it was made through an
+	// authenticated call.
+	Actor     *Actor    `db:"actor" json:"actor,omitempty"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// Actor identifies the principal behind an event in the audit trail.
+type Actor struct {
+	Kind     PrincipalKind `json:"kind"`
+	UserID   int           `json:"user_id,omitempty"`
+	APIKeyID int           `json:"api_key_id,omitempty"`
+	// ImpersonatorID is the user who really acted when UserID was being
+	// impersonated.
+	ImpersonatorID int `json:"impersonator_id,omitempty"`
+}
+
+// UserSnapshot is the public view of a user carried by events. It never
+// includes the password hash.
+type UserSnapshot struct {
+	ID            int        `json:"id"`
+	Name          string     `json:"name"`
+	Email         string     `json:"email"`
+	RoleID        int        `json:"role_id"`
+	Status        UserStatus `json:"status"`
+	EmailVerified bool       `json:"email_verified"`
+	Version       int        `json:"version"`
+	Kind          UserKind   `json:"kind"`
+}
+
+type UserEventPayload struct {
+	User           UserSnapshot `json:"user"`
+	PreviousRoleID int          `json:"previous_role_id,omitempty"`
+	Purged         bool         `json:"purged,omitempty"`
+}
+
+func NewUserSnapshot(u *User) UserSnapshot {
+	return UserSnapshot{
+		ID:            u.ID,
+		Name:          u.Name,
+		Email:         u.Email,
+		RoleID:        u.RoleID,
+		Status:        u.Status,
+		EmailVerified: u.EmailVerifiedAt != nil,
+		Version:       u.Version,
+		Kind:          u.Kind,
+	}
+}
+
+func NewUserEvent(eventType EventType, u *User) *Event {
+	return newUserEvent(eventType, u, UserEventPayload{User: NewUserSnapshot(u)})
+}
+
+func NewRoleChangedEvent(u *User, previousRoleID int) *Event {
+	return newUserEvent(EventRoleChanged, u, UserEventPayload{User: NewUserSnapshot(u), PreviousRoleID: previousRoleID})
+}
+
+func NewUserPurgedEvent(u *User) *Event {
+	return newUserEvent(EventUserDeleted, u, UserEventPayload{User: NewUserSnapshot(u), Purged: true})
+}
+
+// RoleRightSnapshot mirrors the role_rights row in RoleRightChanged events.
+type RoleRightSnapshot struct {
+	ID      int    `json:"id"`
+	RoleID  int    `json:"role_id"`
+	Section string `json:"section"`
+	Route   string `json:"route"`
+	Create  bool   `json:"create"`
+	Read    bool   `json:"read"`
+	Update  bool   `json:"update"`
+	Delete  bool   `json:"delete"`
+}
+
+type RoleRightEventPayload struct {
+	RoleRight RoleRightSnapshot `json:"role_right"`
+	Deleted   bool              `json:"deleted"`
+}
+
+// AuthEventPayload describes a login, logout or impersonation. It comes from
+// the session, so it does not carry the full user.
+type AuthEventPayload struct {
+	UserID         int    `json:"user_id"`
+	Email          string `json:"email"`
+	RoleID         int    `json:"role_id"`
+	ImpersonatorID int    `json:"impersonator_id,omitempty"`
+	Reason         string `json:"reason,omitempty"`
+	// Method is the RPC an impersonated call was made to.
+	Method string `json:"method,omitempty"`
+}
+
+func NewAuthEvent(eventType EventType, s *Session) *Event {
+	return newAuthEvent(eventType, s, "")
+}
+
+// NewImpersonationEvent records the start of an impersonation session and
+// why it was started.
+func NewImpersonationEvent(s *Session, reason string) *Event {
+	return newAuthEvent(EventUserImpersonated, s, reason)
+}
+
+// NewImpersonatedCallEvent records that p, an impersonation principal,
+// called method.
+func NewImpersonatedCallEvent(p *Principal, method string) *Event {
+	raw, _ := json.Marshal(AuthEventPayload{
+		UserID:         p.UserID,
+		Email:          p.Email,
+		RoleID:         p.RoleID,
+		ImpersonatorID: p.ImpersonatorID,
+		Method:         method,
+	})
+	return &Event{
+		AggregateType: AggregateUser,
+		AggregateID:   p.UserID,
+		Type:          EventImpersonatedCall,
+		Payload:       raw,
+	}
+}
+
+func newAuthEvent(eventType EventType, s *Session, reason string) *Event {
+	raw, _ := json.Marshal(AuthEventPayload{
+		UserID:         s.UserID,
+		Email:          s.Email,
+		RoleID:         s.RoleID,
+		ImpersonatorID: s.ImpersonatorID,
+		Reason:         reason,
+	})
+	return &Event{
+		AggregateType: AggregateUser,
+		AggregateID:   s.UserID,
+		Type:          eventType,
+		Payload:       raw,
+	}
+}
+
+func newUserEvent(eventType EventType, u *User, payload UserEventPayload) *Event {
+	// UserEventPayload only holds plain values, so marshalling cannot fail.
+	raw, _ := json.Marshal(payload)
+	return &Event{
+		AggregateType: AggregateUser,
+		AggregateID:   u.ID,
+		Type:          eventType,
+		Payload:       raw,
+	}
+}
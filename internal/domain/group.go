@@ -0,0 +1,8 @@
+package domain
+
+// Group is a role together with the users that have it, which is how roles
+// are provisioned over SCIM.
+type Group struct {
+	Role    *Role
+	Members []*User
+}
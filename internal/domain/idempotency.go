@@ -0,0 +1,13 @@
+package domain
+
+// IdempotencyRecord is what is remembered about a request made with an
+// idempotency key. Response is empty while the first request is in flight.
+type IdempotencyRecord struct {
+	RequestHash  string `json:"request_hash"`
+	ResponseType string `json:"response_type,omitempty"`
+	Response     []byte `json:"response,omitempty"`
+}
+
+func (r *IdempotencyRecord) Completed() bool {
+	return r.ResponseType != ""
+}
@@ -0,0 +1,132 @@
+package domain
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// UserIdentity links an account at an external identity provider to a
+// local user.
+type UserIdentity struct {
+	ID          int        `db:"id"`
+	UserID      int        `db:"user_id"`
+	Provider    string     `db:"provider"`
+	Subject     string     `db:"subject"`
+	Email       *string    `db:"email"`
+	CreatedAt   time.Time  `db:"created_at"`
+	LastLoginAt *time.Time `db:"last_login_at"`
+}
+
+// IdentityProvider is an external OpenID Connect provider users can sign
+// in with.
+type IdentityProvider struct {
+	ID           string       `json:"id"`
+	Name         string       `json:"name"`
+	Issuer       string       `json:"issuer"`
+	ClientID     string       `json:"client_id"`
+	ClientSecret string       `json:"client_secret"`
+	Scopes       []string     `json:"scopes"`
+	Claims       ClaimMapping `json:"claims"`
+
+	// LinkByEmail links a first login to the existing user with the same
+	// email address.
+	LinkByEmail bool `json:"link_by_email"`
+	// AutoProvision creates a user on the first login of an identity that
+	// matches no user. AllowedDomains, when set, limits it to those email
+	// domains.
+	AutoProvision  bool     `json:"auto_provision"`
+	AllowedDomains []string `json:"allowed_domains"`
+	// TrustUnverifiedEmail accepts emails the provider does not mark as
+	// verified. Without it such logins can neither link nor provision.
+	TrustUnverifiedEmail bool `json:"trust_unverified_email"`
+
+	// RoleRules pick the role of provisioned users; the first matching rule
+	// wins, then DefaultRoleID. With SyncRoles the role is reassigned on
+	// every login.
+	RoleRules     []RoleRule `json:"role_rules"`
+	DefaultRoleID int        `json:"default_role_id"`
+	SyncRoles     bool       `json:"sync_roles"`
+}
+
+// ClaimMapping names the ID token claims user fields are read from. Empty
+// names fall back to the standard claims.
+type ClaimMapping struct {
+	Subject       string `json:"subject"`
+	Email         string `json:"email"`
+	EmailVerified string `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+// RoleRule assigns RoleID when claim Claim equals Value or, for list
+// claims such as groups, contains it.
+type RoleRule struct {
+	Claim  string `json:"claim"`
+	Value  string `json:"value"`
+	RoleID int    `json:"role_id"`
+}
+
+func (p *IdentityProvider) Validate() error {
+	if p.ID == "" || p.Issuer == "" || p.ClientID == "" {
+		return fmt.Errorf("identity provider needs an id, issuer and client_id: %q", p.ID)
+	}
+	if p.AutoProvision && p.DefaultRoleID == 0 && len(p.RoleRules) == 0 {
+		return fmt.Errorf("identity provider %q provisions users but assigns no role", p.ID)
+	}
+	return nil
+}
+
+// AllowsDomain reports whether users with email may be provisioned.
+func (p *IdentityProvider) AllowsDomain(email string) bool {
+	if len(p.AllowedDomains) == 0 {
+		return true
+	}
+	i := strings.LastIndex(email, "@")
+	if i < 0 {
+		return false
+	}
+	return slices.ContainsFunc(p.AllowedDomains, func(d string) bool {
+		return strings.EqualFold(d, email[i+1:])
+	})
+}
+
+// RoleFor applies the role rules to the claims of an identity, returning 0
+// when no rule matches and there is no default.
+func (p *IdentityProvider) RoleFor(claims map[string]any) int {
+	for _, rule := range p.RoleRules {
+		switch v := claims[rule.Claim].(type) {
+		case string:
+			if v == rule.Value {
+				return rule.RoleID
+			}
+		case []any:
+			for _, item := range v {
+				if s, ok := item.(string); ok && s == rule.Value {
+					return rule.RoleID
+				}
+			}
+		}
+	}
+	return p.DefaultRoleID
+}
+
+// ExternalIdentity is who an identity provider says signed in, read from
+// its ID token through the provider's claim mapping.
+type ExternalIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Claims        map[string]any
+}
+
+// FederationState is what a federated login remembers between sending the
+// user to the provider and the provider sending them back.
+type FederationState struct {
+	Provider     string `json:"provider"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+	ReturnTo     string `json:"return_to"`
+}
@@ -0,0 +1,9 @@
+package domain
+
+// Impersonation is granted by the create right on this section and route,
+// rather than on whatever route a call names, so only roles given it on
+// purpose can act as other users.
+const (
+	ImpersonationSection = "users"
+	ImpersonationRoute   = "impersonate"
+)
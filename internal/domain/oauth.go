@@ -0,0 +1,148 @@
+package domain
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantClientCredentials = "client_credentials"
+	GrantRefreshToken      = "refresh_token"
+)
+
+// OAuthClient is a registered third-party application. Public clients,
+// such as single-page and mobile apps, have no secret and can only use the
+// authorization code grant with PKCE. RoleID is the role the client acts
+// with under the client credentials grant.
+type OAuthClient struct {
+	ID                     string    `db:"id"`
+	Name                   string    `db:"name"`
+	SecretHash             *string   `db:"secret_hash"`
+	RedirectURIs           []string  `db:"redirect_uris"`
+	PostLogoutRedirectURIs []string  `db:"post_logout_redirect_uris"`
+	GrantTypes             []string  `db:"grant_types"`
+	Scopes                 []string  `db:"scopes"`
+	RoleID                 *int      `db:"role_id"`
+	CreatedAt              time.Time `db:"created_at"`
+}
+
+func (c *OAuthClient) Public() bool {
+	return c.SecretHash == nil
+}
+
+func (c *OAuthClient) AllowsGrant(grantType string) bool {
+	return slices.Contains(c.GrantTypes, grantType)
+}
+
+// AllowsRedirect compares redirect uris exactly, as RFC 6749 section 3.1.2
+// recommends.
+func (c *OAuthClient) AllowsRedirect(uri string) bool {
+	return slices.Contains(c.RedirectURIs, uri)
+}
+
+func (c *OAuthClient) AllowsPostLogoutRedirect(uri string) bool {
+	return slices.Contains(c.PostLogoutRedirectURIs, uri)
+}
+
+// OAuthCode is an authorization code waiting to be exchanged for tokens.
+// Only the SHA-256 hash of the code is stored. Nonce and AuthTime are
+// copied into the ID token when the openid scope was granted.
+type OAuthCode struct {
+	CodeHash      string     `db:"code_hash"`
+	GrantID       string     `db:"grant_id"`
+	ClientID      string     `db:"client_id"`
+	UserID        int        `db:"user_id"`
+	RedirectURI   string     `db:"redirect_uri"`
+	Scopes        []string   `db:"scopes"`
+	CodeChallenge string     `db:"code_challenge"`
+	Nonce         string     `db:"nonce"`
+	AuthTime      *time.Time `db:"auth_time"`
+	ExpiresAt     time.Time  `db:"expires_at"`
+	UsedAt        *time.Time `db:"used_at"`
+}
+
+type OAuthTokenKind string
+
+const (
+	OAuthAccessToken  OAuthTokenKind = "access_token"
+	OAuthRefreshToken OAuthTokenKind = "refresh_token"
+)
+
+// OAuthToken is an issued access or refresh token. Tokens issued from the
+// same authorization share a GrantID, so the whole grant can be revoked at
+// once. UserID is nil for client credentials tokens.
+type OAuthToken struct {
+	TokenHash string         `db:"token_hash"`
+	Kind      OAuthTokenKind `db:"kind"`
+	GrantID   string         `db:"grant_id"`
+	ClientID  string         `db:"client_id"`
+	UserID    *int           `db:"user_id"`
+	Scopes    []string       `db:"scopes"`
+	ExpiresAt time.Time      `db:"expires_at"`
+	RevokedAt *time.Time     `db:"revoked_at"`
+	CreatedAt time.Time      `db:"created_at"`
+}
+
+func (t *OAuthToken) Active(now time.Time) bool {
+	return t.RevokedAt == nil && now.Before(t.ExpiresAt)
+}
+
+// AuthorizationRequest is the part of an authorization code request that
+// is checked before the user is asked to log in.
+type AuthorizationRequest struct {
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+}
+
+// TokenSet is the result of a token request. IDToken is only set when the
+// openid scope was granted.
+type TokenSet struct {
+	AccessToken  string
+	RefreshToken string
+	IDToken      string
+	ExpiresIn    time.Duration
+	Scopes       []string
+}
+
+// TokenInfo describes an active token, as returned by introspection.
+type TokenInfo struct {
+	ClientID  string
+	UserID    *int
+	RoleID    int
+	Scopes    []string
+	ExpiresAt time.Time
+	IssuedAt  time.Time
+}
+
+// IsOIDCScope reports whether scope is one of the OpenID Connect scopes,
+// which select claims about the user rather than rights.
+func IsOIDCScope(scope string) bool {
+	switch scope {
+	case ScopeOpenID, ScopeProfile, ScopeEmail:
+		return true
+	}
+	return false
+}
+
+// ParseScope splits a scope of the form "section:action", where action is
+// one of create, read, update or delete, into its parts. Scopes map onto
+// role_rights: a token with scope "users:read" may read the users section
+// if its role may.
+func ParseScope(scope string) (section, action string, ok bool) {
+	section, action, found := strings.Cut(scope, ":")
+	if !found || section == "" {
+		return "", "", false
+	}
+	switch action {
+	case "create", "read", "update", "delete":
+		return section, action, true
+	}
+	return "", "", false
+}
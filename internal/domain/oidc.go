@@ -0,0 +1,50 @@
+package domain
+
+import (
+	"slices"
+	"strconv"
+)
+
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+// UserClaims are the OpenID Connect standard claims about a user, as
+// returned by the userinfo endpoint and embedded in ID tokens.
+type UserClaims struct {
+	Subject           string `json:"sub"`
+	Name              string `json:"name,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     *bool  `json:"email_verified,omitempty"`
+}
+
+// NewUserClaims builds the claims the granted scopes allow: profile adds
+// the name and email adds the address and whether it is verified.
+func NewUserClaims(user *User, scopes []string) *UserClaims {
+	claims := &UserClaims{Subject: strconv.Itoa(user.ID)}
+	if slices.Contains(scopes, ScopeProfile) {
+		claims.Name = user.Name
+		claims.PreferredUsername = user.Email
+	}
+	if slices.Contains(scopes, ScopeEmail) {
+		verified := user.EmailVerifiedAt != nil
+		claims.Email = user.Email
+		claims.EmailVerified = &verified
+	}
+	return claims
+}
+
+// IDTokenClaims is the payload of an ID token.
+type IDTokenClaims struct {
+	Issuer          string `json:"iss"`
+	Audience        string `json:"aud"`
+	ExpiresAt       int64  `json:"exp"`
+	IssuedAt        int64  `json:"iat"`
+	AuthTime        int64  `json:"auth_time,omitempty"`
+	Nonce           string `json:"nonce,omitempty"`
+	AccessTokenHash string `json:"at_hash,omitempty"`
+	*UserClaims
+}
@@ -0,0 +1,50 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+type PrincipalKind string
+
+const (
+	PrincipalUser           PrincipalKind = "user"
+	PrincipalAPIKey         PrincipalKind = "api_key"
+	PrincipalServiceAccount PrincipalKind = "service_account"
+)
+
+// Principal is who makes a call: a signed-in user, an API key or a service
+// account. Its RoleID is what the call is authorized with.
+type Principal struct {
+	Kind PrincipalKind
+	// UserID is set for users and service accounts.
+	UserID int
+	Email  string
+	// APIKeyID is set for API keys.
+	APIKeyID int
+	RoleID   int
+	// ImpersonatorID is set when a user acts as UserID through an
+	// impersonation session.
+	ImpersonatorID int
+	// AuthTime and AuthMethod come from the session of a user.
+	AuthTime   time.Time
+	AuthMethod AuthMethod
+}
+
+// Actor is how the principal is recorded in events.
+func (p *Principal) Actor() *Actor {
+	return &Actor{Kind: p.Kind, UserID: p.UserID, APIKeyID: p.APIKeyID, ImpersonatorID: p.ImpersonatorID}
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the principal the authentication
+// interceptor found for the call, or nil.
+func PrincipalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
@@ -0,0 +1,13 @@
+package domain
+
+// The sections and routes the rights of each RPC are checked on. Like
+// ImpersonationSection and ImpersonationRoute they are fixed by the server
+// rather than named by the call, so a caller cannot pick a route whose
+// rights are wider or need no step up.
+const (
+	UsersSection         = "users"
+	UsersRoute           = "users"
+	WebhooksRoute        = "webhooks"
+	APIKeysRoute         = "api_keys"
+	ServiceAccountsRoute = "service_accounts"
+)
@@ -1,12 +1,48 @@
 package domain
 
+import "time"
+
 type RoleRight struct {
 	Id      int    `db:"id"`
 	RoleId  int    `db:"role_id"`
 	Section string `db:"section"`
-	route   string `db:"route"`
-	RCreate bool   `db:"r_create"`
+	Route   string `db:"route"`
+	RCreate bool   `db:"r_created"`
 	RRead   bool   `db:"r_read"`
 	RUpdate bool   `db:"r_update"`
 	RDelete bool   `db:"r_delete"`
+	// StepUpActions are the actions that need a strong authentication
+	// within the last StepUpMinutes, such as "delete" on the users route.
+	StepUpActions []string `db:"step_up_actions"`
+	StepUpMinutes int      `db:"step_up_minutes"`
+}
+
+// Allows reports whether the right grants action ("create", "read",
+// "update" or "delete").
+func (r *RoleRight) Allows(action string) bool {
+	switch action {
+	case "create":
+		return r.RCreate
+	case "read":
+		return r.RRead
+	case "update":
+		return r.RUpdate
+	case "delete":
+		return r.RDelete
+	}
+	return false
+}
+
+// StepUpWithin returns how recently the user must have authenticated to
+// perform action, or zero when any session will do.
+func (r *RoleRight) StepUpWithin(action string) time.Duration {
+	if r.StepUpMinutes <= 0 {
+		return 0
+	}
+	for _, a := range r.StepUpActions {
+		if a == action {
+			return time.Duration(r.StepUpMinutes) * time.Minute
+		}
+	}
+	return 0
 }
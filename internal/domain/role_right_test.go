@@ -0,0 +1,28 @@
+package domain
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRoleRightStepUpWithin(t *testing.T) {
+	tests := []struct {
+		name   string
+		right  RoleRight
+		action string
+		want   time.Duration
+	}{
+		{name: "marked action", right: RoleRight{StepUpActions: []string{"delete"}, StepUpMinutes: 5}, action: "delete", want: 5 * time.Minute},
+		{name: "unmarked action", right: RoleRight{StepUpActions: []string{"delete"}, StepUpMinutes: 5}, action: "read"},
+		{name: "marked action without minutes", right: RoleRight{StepUpActions: []string{"delete"}}, action: "delete"},
+		{name: "negative minutes", right: RoleRight{StepUpActions: []string{"delete"}, StepUpMinutes: -1}, action: "delete"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.right.StepUpWithin(tt.action); got != tt.want {
+				t.Errorf("StepUpWithin(%s) = %s, want %s", tt.action, got, tt.want)
+			}
+		})
+	}
+}
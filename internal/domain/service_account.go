@@ -0,0 +1,62 @@
+package domain
+
+import "time"
+
+// UserKind tells people apart from service accounts, the non-human
+// principals programs run as. Service accounts live in the users table
+// with their own role, but never log in with a password and are left out
+// of user listings unless asked for.
+type UserKind string
+
+const (
+	UserKindHuman   UserKind = "human"
+	UserKindService UserKind = "service"
+)
+
+// ServiceAccountSecretPrefix starts every service account secret, like
+// APIKeyPrefix does for API keys.
+const ServiceAccountSecretPrefix = "tlsa_"
+
+type CredentialType string
+
+const (
+	// CredentialSecret is a generated secret, presented as is.
+	CredentialSecret CredentialType = "secret"
+	// CredentialPublicKey is an RSA public key. The account signs short
+	// lived JWT assertions with the private key, which never leaves it.
+	CredentialPublicKey CredentialType = "public_key"
+)
+
+func (t CredentialType) Valid() bool {
+	return t == CredentialSecret || t == CredentialPublicKey
+}
+
+// ServiceAccountCredential is a credential of a service account. KeyID is
+// the lookup prefix of a secret or the kid of a public key.
+type ServiceAccountCredential struct {
+	ID         int            `db:"id"`
+	UserID     int            `db:"user_id"`
+	Type       CredentialType `db:"type"`
+	KeyID      string         `db:"key_id"`
+	SecretHash *string        `db:"secret_hash"`
+	PublicKey  *string        `db:"public_key"`
+	ExpiresAt  *time.Time     `db:"expires_at"`
+	CreatedAt  time.Time      `db:"created_at"`
+	LastUsedAt *time.Time     `db:"last_used_at"`
+	RevokedAt  *time.Time     `db:"revoked_at"`
+}
+
+// Active reports whether the credential is neither revoked nor expired at
+// now.
+func (c *ServiceAccountCredential) Active(now time.Time) bool {
+	return c.RevokedAt == nil && (c.ExpiresAt == nil || now.Before(*c.ExpiresAt))
+}
+
+// ServiceAccountAssertion is the claims of the JWT a service account signs
+// with its private key to authenticate. Sub is the account's user ID.
+type ServiceAccountAssertion struct {
+	Subject   string `json:"sub"`
+	Audience  any    `json:"aud"`
+	ExpiresAt int64  `json:"exp"`
+	IssuedAt  int64  `json:"iat"`
+}
@@ -0,0 +1,43 @@
+package domain
+
+import "time"
+
+// AuthMethod is how the user behind a session last proved who they are.
+type AuthMethod string
+
+const (
+	AuthMethodPassword  AuthMethod = "password"
+	AuthMethodMagicLink AuthMethod = "magic_link"
+	AuthMethodFederated AuthMethod = "federated"
+	AuthMethodPasskey   AuthMethod = "passkey"
+	// AuthMethodMFA is a password or magic link confirmed with a passkey.
+	AuthMethodMFA AuthMethod = "mfa"
+	// AuthMethodImpersonation marks sessions from Impersonate, which the
+	// user never authenticated.
+	AuthMethodImpersonation AuthMethod = "impersonation"
+)
+
+// Strong reports whether the method proves the user is at the keyboard
+// well enough to step up to sensitive operations. Magic links and other
+// providers only prove control of a mailbox or an outside account.
+func (m AuthMethod) Strong() bool {
+	switch m {
+	case AuthMethodPassword, AuthMethodPasskey, AuthMethodMFA:
+		return true
+	}
+	return false
+}
+
+type Session struct {
+	UserID     int       `json:"user_id"`
+	Email      string    `json:"email"`
+	RoleID     int       `json:"role_id"`
+	LastAccess time.Time `json:"last_access"`
+	// ImpersonatorID is set on sessions from Impersonate: the user who acts
+	// as UserID.
+	ImpersonatorID int `json:"impersonator_id,omitempty"`
+	// AuthTime and AuthMethod tell when and how the user last
+	// authenticated, at login or with Reauthenticate.
+	AuthTime   time.Time  `json:"auth_time"`
+	AuthMethod AuthMethod `json:"auth_method"`
+}
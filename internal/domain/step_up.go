@@ -0,0 +1,21 @@
+package domain
+
+import (
+	"fmt"
+	"time"
+)
+
+// StepUpRequiredError is returned when a right needs the user to have
+// authenticated strongly within MaxAge and the session is older or was
+// opened another way. Reauthenticate and retrying clears it.
+type StepUpRequiredError struct {
+	MaxAge time.Duration
+}
+
+func (e *StepUpRequiredError) Error() string {
+	return fmt.Sprintf("%s: this action needs a password or passkey login within the last %s", ErrStepUpRequired, e.MaxAge)
+}
+
+func (e *StepUpRequiredError) Is(target error) bool {
+	return target == ErrStepUpRequired
+}
@@ -9,4 +9,18 @@ type User struct {
 	Password   string     `db:"password"`
 	RoleID     int        `db:"role_id"`
 	LastAccess *time.Time `db:"last_access"`
+	Status     UserStatus `db:"status"`
+	Version    int        `db:"version"`
+	DeletedAt  *time.Time `db:"deleted_at"`
+
+	EmailVerifiedAt *time.Time `db:"email_verified_at"`
+	PendingEmail    *string    `db:"pending_email"`
+
+	// DirectoryDN is set for users managed by the directory sync.
+	DirectoryDN *string `db:"directory_dn"`
+	// ExternalID is the identity provider's id of a user provisioned over
+	// SCIM.
+	ExternalID *string `db:"external_id"`
+
+	Kind UserKind `db:"kind"`
 }
@@ -0,0 +1,27 @@
+package domain
+
+// UserBatchUpdate is one item of a batch update. Zero values leave the
+// corresponding field unchanged.
+type UserBatchUpdate struct {
+	ID      int
+	Version int
+	Status  UserStatus
+	RoleID  int
+	Reason  string
+
+	// FromStatus and FromRoleID are what the user had when the batch was
+	// validated.
+	FromStatus UserStatus
+	FromRoleID int
+}
+
+type UserBatchDelete struct {
+	ID      int
+	Version int
+}
+
+type BatchResult struct {
+	ID   int
+	User *User
+	Err  error
+}
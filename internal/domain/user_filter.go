@@ -0,0 +1,23 @@
+package domain
+
+// UserFilter narrows down user listings and exports. Zero values match
+// everything, except that service accounts are only included on request.
+type UserFilter struct {
+	RoleID int
+	Status UserStatus
+	Query  string
+
+	// Email and ExternalID match whole values, EmailPrefix and
+	// ExternalIDPrefix the start of them, all case insensitively.
+	Email            string
+	EmailPrefix      string
+	ExternalID       string
+	ExternalIDPrefix string
+
+	IncludeServiceAccounts bool
+
+	// Offset and Limit page through the matching users in id order. A zero
+	// Limit returns all of them.
+	Offset int
+	Limit  int
+}
@@ -0,0 +1,40 @@
+package domain
+
+type ImportFormat string
+
+const (
+	ImportFormatCSV   ImportFormat = "csv"
+	ImportFormatJSONL ImportFormat = "jsonl"
+)
+
+type ImportRow struct {
+	Row      int    `json:"-"`
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	RoleID   int    `json:"role_id"`
+
+	// Error is set when the row could not be parsed at all.
+	Error string `json:"-"`
+}
+
+// ImportResult is the outcome of one row. Warning is set when the row was
+// imported but its invitation could not be sent.
+type ImportResult struct {
+	Row     int
+	Email   string
+	Error   string
+	Warning string
+}
+
+func (r *ImportResult) OK() bool {
+	return r.Error == ""
+}
+
+type ImportReport struct {
+	DryRun   bool
+	Total    int
+	Imported int
+	Failed   int
+	Results  []*ImportResult
+}
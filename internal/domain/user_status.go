@@ -0,0 +1,54 @@
+package domain
+
+type UserStatus string
+
+const (
+	UserStatusInvited   UserStatus = "invited"
+	UserStatusActive    UserStatus = "active"
+	UserStatusSuspended UserStatus = "suspended"
+	UserStatusDisabled  UserStatus = "disabled"
+)
+
+var userStatusTransitions = map[UserStatus][]UserStatus{
+	UserStatusInvited:   {UserStatusActive},
+	UserStatusActive:    {UserStatusSuspended, UserStatusDisabled},
+	UserStatusSuspended: {UserStatusActive, UserStatusDisabled},
+}
+
+func (s UserStatus) Valid() bool {
+	switch s {
+	case UserStatusInvited, UserStatusActive, UserStatusSuspended, UserStatusDisabled:
+		return true
+	}
+	return false
+}
+
+// CanTransitionTo reports whether the account lifecycle allows moving from s
+// to next. Disabled is terminal.
+func (s UserStatus) CanTransitionTo(next UserStatus) bool {
+	for _, allowed := range userStatusTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// CanLogin reports whether a user in this status may start new sessions.
+func (s UserStatus) CanLogin() bool {
+	return s == UserStatusActive
+}
+
+// RevokesSessions reports whether entering this status must end the user's
+// existing sessions.
+func (s UserStatus) RevokesSessions() bool {
+	return s == UserStatusSuspended || s == UserStatusDisabled
+}
+
+type UserStatusChange struct {
+	ID         int        `db:"id"`
+	UserID     int        `db:"user_id"`
+	FromStatus UserStatus `db:"from_status"`
+	ToStatus   UserStatus `db:"to_status"`
+	Reason     string     `db:"reason"`
+}
@@ -0,0 +1,55 @@
+package domain
+
+import "testing"
+
+func TestUserStatusCanTransitionTo(t *testing.T) {
+	tests := []struct {
+		from, to UserStatus
+		want     bool
+	}{
+		{UserStatusInvited, UserStatusActive, true},
+		{UserStatusInvited, UserStatusSuspended, false},
+		{UserStatusInvited, UserStatusDisabled, false},
+		{UserStatusActive, UserStatusSuspended, true},
+		{UserStatusActive, UserStatusDisabled, true},
+		{UserStatusActive, UserStatusInvited, false},
+		{UserStatusActive, UserStatusActive, false},
+		{UserStatusSuspended, UserStatusActive, true},
+		{UserStatusSuspended, UserStatusDisabled, true},
+		{UserStatusDisabled, UserStatusActive, false},
+		{UserStatusDisabled, UserStatusSuspended, false},
+		{UserStatus("unknown"), UserStatusActive, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.from)+" to "+string(tt.to), func(t *testing.T) {
+			if got := tt.from.CanTransitionTo(tt.to); got != tt.want {
+				t.Errorf("CanTransitionTo() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUserStatusSessions(t *testing.T) {
+	tests := []struct {
+		status          UserStatus
+		wantLogin       bool
+		wantRevocations bool
+	}{
+		{status: UserStatusInvited},
+		{status: UserStatusActive, wantLogin: true},
+		{status: UserStatusSuspended, wantRevocations: true},
+		{status: UserStatusDisabled, wantRevocations: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.status), func(t *testing.T) {
+			if got := tt.status.CanLogin(); got != tt.wantLogin {
+				t.Errorf("CanLogin() = %t, want %t", got, tt.wantLogin)
+			}
+			if got := tt.status.RevokesSessions(); got != tt.wantRevocations {
+				t.Errorf("RevokesSessions() = %t, want %t", got, tt.wantRevocations)
+			}
+		})
+	}
+}
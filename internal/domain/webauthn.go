@@ -0,0 +1,60 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// WebAuthnCredential is a passkey or security key registered by a user.
+// CredentialID is the id the authenticator gave it and SignCount the
+// highest signature counter seen, which must keep growing unless the
+// authenticator does not count at all.
+type WebAuthnCredential struct {
+	ID              int        `db:"id"`
+	UserID          int        `db:"user_id"`
+	Name            string     `db:"name"`
+	CredentialID    []byte     `db:"credential_id"`
+	PublicKey       []byte     `db:"public_key"`
+	AttestationType string     `db:"attestation_type"`
+	Transports      []string   `db:"transports"`
+	AAGUID          []byte     `db:"aaguid"`
+	SignCount       int64      `db:"sign_count"`
+	BackupEligible  bool       `db:"backup_eligible"`
+	BackupState     bool       `db:"backup_state"`
+	CreatedAt       time.Time  `db:"created_at"`
+	LastUsedAt      *time.Time `db:"last_used_at"`
+}
+
+type WebAuthnCeremonyKind string
+
+const (
+	WebAuthnRegistration WebAuthnCeremonyKind = "registration"
+	WebAuthnLogin        WebAuthnCeremonyKind = "login"
+)
+
+// WebAuthnCeremony is what a registration or login remembers between
+// handing the challenge to the client and checking its response. UserID is
+// zero for a login that lets the authenticator pick the account, and
+// SecondFactor is set for a login that confirms a password or magic link.
+type WebAuthnCeremony struct {
+	Kind         WebAuthnCeremonyKind `json:"kind"`
+	UserID       int                  `json:"user_id,omitempty"`
+	Name         string               `json:"name,omitempty"`
+	SecondFactor bool                 `json:"second_factor,omitempty"`
+	Session      json.RawMessage      `json:"session"`
+}
+
+// SecondFactorRequiredError is returned instead of a session when a user
+// who has registered a passkey logs in with a password. Token starts the
+// WebAuthn login that finishes it.
+type SecondFactorRequiredError struct {
+	Token string
+}
+
+func (e *SecondFactorRequiredError) Error() string {
+	return ErrSecondFactorRequired.Error()
+}
+
+func (e *SecondFactorRequiredError) Is(target error) bool {
+	return target == ErrSecondFactorRequired
+}
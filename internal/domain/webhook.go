@@ -0,0 +1,75 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// WebhookAllEvents subscribes a webhook to every event type.
+const WebhookAllEvents = "*"
+
+type WebhookSubscription struct {
+	ID         int       `db:"id"`
+	URL        string    `db:"url"`
+	EventTypes []string  `db:"event_types"`
+	Secret     string    `db:"secret"`
+	Active     bool      `db:"active"`
+	CreatedAt  time.Time `db:"created_at"`
+	UpdatedAt  time.Time `db:"updated_at"`
+}
+
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryPending   WebhookDeliveryStatus = "pending"
+	WebhookDeliverySucceeded WebhookDeliveryStatus = "succeeded"
+	// WebhookDeliveryDead is the dead-letter state of deliveries that ran
+	// out of attempts. They stay there until redelivered by hand.
+	WebhookDeliveryDead WebhookDeliveryStatus = "dead"
+)
+
+func (s WebhookDeliveryStatus) Valid() bool {
+	switch s {
+	case WebhookDeliveryPending, WebhookDeliverySucceeded, WebhookDeliveryDead:
+		return true
+	}
+	return false
+}
+
+type WebhookDelivery struct {
+	ID             int64                 `db:"id"`
+	SubscriptionID int                   `db:"subscription_id"`
+	EventID        int64                 `db:"event_id"`
+	EventType      EventType             `db:"event_type"`
+	Payload        json.RawMessage       `db:"payload"`
+	Status         WebhookDeliveryStatus `db:"status"`
+	Attempts       int                   `db:"attempts"`
+	NextAttemptAt  time.Time             `db:"next_attempt_at"`
+	LastStatusCode *int                  `db:"last_status_code"`
+	LastError      *string               `db:"last_error"`
+	CreatedAt      time.Time             `db:"created_at"`
+	DeliveredAt    *time.Time            `db:"delivered_at"`
+
+	// URL and Secret are copied from the subscription when the delivery is
+	// claimed for sending.
+	URL    string `db:"url"`
+	Secret string `db:"secret"`
+
+	AttemptLog []*WebhookAttempt `db:"-"`
+}
+
+// WebhookAttempt is one entry of the delivery log.
+type WebhookAttempt struct {
+	DeliveryID  int64     `db:"delivery_id"`
+	Attempt     int       `db:"attempt"`
+	StatusCode  *int      `db:"status_code"`
+	Error       *string   `db:"error"`
+	DurationMs  int       `db:"duration_ms"`
+	AttemptedAt time.Time `db:"attempted_at"`
+}
+
+type WebhookDeliveryFilter struct {
+	SubscriptionID int
+	Status         WebhookDeliveryStatus
+	Limit          int
+}
@@ -0,0 +1,40 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"tablelink/internal/domain"
+)
+
+var csvHeader = []string{"id", "name", "email", "role_id", "status", "email_verified", "last_access"}
+
+type csvEncoder struct {
+	w *csv.Writer
+}
+
+func newCSVEncoder(w io.Writer) (Encoder, error) {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return nil, err
+	}
+	return &csvEncoder{w: cw}, nil
+}
+
+func (e *csvEncoder) Encode(u *domain.User) error {
+	r := NewRecord(u)
+	return e.w.Write([]string{
+		strconv.Itoa(int(r.ID)),
+		r.Name,
+		r.Email,
+		strconv.Itoa(int(r.RoleID)),
+		r.Status,
+		strconv.FormatBool(r.EmailVerified),
+		r.LastAccess,
+	})
+}
+
+func (e *csvEncoder) Close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
@@ -0,0 +1,66 @@
+package export
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"tablelink/internal/domain"
+	"time"
+)
+
+type Format string
+
+const (
+	FormatCSV     Format = "csv"
+	FormatJSONL   Format = "jsonl"
+	FormatParquet Format = "parquet"
+)
+
+var ErrUnsupportedFormat = errors.New("unsupported export format")
+
+// Record is the exported shape of a user. It deliberately has no password
+// field.
+type Record struct {
+	ID            int32  `json:"id" parquet:"name=id, type=INT32"`
+	Name          string `json:"name" parquet:"name=name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Email         string `json:"email" parquet:"name=email, type=BYTE_ARRAY, convertedtype=UTF8"`
+	RoleID        int32  `json:"role_id" parquet:"name=role_id, type=INT32"`
+	Status        string `json:"status" parquet:"name=status, type=BYTE_ARRAY, convertedtype=UTF8"`
+	EmailVerified bool   `json:"email_verified" parquet:"name=email_verified, type=BOOLEAN"`
+	LastAccess    string `json:"last_access" parquet:"name=last_access, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+func NewRecord(u *domain.User) *Record {
+	record := &Record{
+		ID:            int32(u.ID),
+		Name:          u.Name,
+		Email:         u.Email,
+		RoleID:        int32(u.RoleID),
+		Status:        string(u.Status),
+		EmailVerified: u.EmailVerifiedAt != nil,
+	}
+	if u.LastAccess != nil {
+		record.LastAccess = u.LastAccess.UTC().Format(time.RFC3339)
+	}
+	return record
+}
+
+// Encoder writes user records one at a time. Close flushes whatever the
+// format buffers and must be called once all users are written.
+type Encoder interface {
+	Encode(u *domain.User) error
+	Close() error
+}
+
+func NewEncoder(format Format, w io.Writer) (Encoder, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVEncoder(w)
+	case FormatJSONL:
+		return newJSONLEncoder(w), nil
+	case FormatParquet:
+		return newParquetEncoder(w)
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedFormat, format)
+	}
+}
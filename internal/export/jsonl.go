@@ -0,0 +1,23 @@
+package export
+
+import (
+	"encoding/json"
+	"io"
+	"tablelink/internal/domain"
+)
+
+type jsonlEncoder struct {
+	enc *json.Encoder
+}
+
+func newJSONLEncoder(w io.Writer) Encoder {
+	return &jsonlEncoder{enc: json.NewEncoder(w)}
+}
+
+func (e *jsonlEncoder) Encode(u *domain.User) error {
+	return e.enc.Encode(NewRecord(u))
+}
+
+func (e *jsonlEncoder) Close() error {
+	return nil
+}
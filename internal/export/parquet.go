@@ -0,0 +1,35 @@
+package export
+
+import (
+	"io"
+	"tablelink/internal/domain"
+
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// parquetRowGroupSize bounds how much the writer buffers before flushing a
+// row group to w.
+const parquetRowGroupSize = 8 * 1024 * 1024
+
+type parquetEncoder struct {
+	pw *writer.ParquetWriter
+}
+
+func newParquetEncoder(w io.Writer) (Encoder, error) {
+	pw, err := writer.NewParquetWriterFromWriter(w, new(Record), 1)
+	if err != nil {
+		return nil, err
+	}
+	pw.RowGroupSize = parquetRowGroupSize
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+	return &parquetEncoder{pw: pw}, nil
+}
+
+func (e *parquetEncoder) Encode(u *domain.User) error {
+	return e.pw.Write(NewRecord(u))
+}
+
+func (e *parquetEncoder) Close() error {
+	return e.pw.WriteStop()
+}
@@ -0,0 +1,296 @@
+package federation
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"tablelink/internal/domain"
+	"tablelink/internal/token"
+	"time"
+)
+
+// clockSkew is how far the clocks of an identity provider and ours may
+// drift apart before its ID tokens are refused.
+const clockSkew = time.Minute
+
+// jwksRefreshInterval limits how often an unknown key id makes the client
+// fetch the provider's keys again.
+const jwksRefreshInterval = time.Minute
+
+// Client is an OpenID Connect relying party for one identity provider. It
+// discovers the provider's endpoints and keys on first use.
+type Client struct {
+	provider *domain.IdentityProvider
+	http     *http.Client
+
+	mu          sync.Mutex
+	metadata    *providerMetadata
+	keys        map[string]*rsa.PublicKey
+	keysFetched time.Time
+}
+
+type providerMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+func NewClient(provider *domain.IdentityProvider, httpClient *http.Client) *Client {
+	return &Client{
+		provider: provider,
+		http:     httpClient,
+	}
+}
+
+func (c *Client) Provider() *domain.IdentityProvider {
+	return c.provider
+}
+
+// AuthCodeURL is where to send the user to sign in, using PKCE with the
+// S256 challenge of the verifier kept in the login state.
+func (c *Client) AuthCodeURL(ctx context.Context, redirectURI, state, nonce, codeChallenge string) (string, error) {
+	meta, err := c.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	target, err := url.Parse(meta.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("Failed to parse authorization endpoint with err %v", err)
+	}
+	query := target.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", c.provider.ClientID)
+	query.Set("redirect_uri", redirectURI)
+	query.Set("scope", strings.Join(c.provider.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge)
+	query.Set("code_challenge_method", "S256")
+	target.RawQuery = query.Encode()
+	return target.String(), nil
+}
+
+// Exchange redeems an authorization code and returns the identity in the
+// verified ID token.
+func (c *Client) Exchange(ctx context.Context, code, codeVerifier, redirectURI, nonce string) (*domain.ExternalIdentity, error) {
+	meta, err := c.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	// RFC 6749 section 2.3.1 form-encodes the credentials before Basic.
+	req.SetBasicAuth(url.QueryEscape(c.provider.ClientID), url.QueryEscape(c.provider.ClientSecret))
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	status, err := c.doJSON(req, &body)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to redeem code at %s with err %v", c.provider.ID, err)
+	}
+	if status != http.StatusOK || body.IDToken == "" {
+		return nil, fmt.Errorf("Failed to redeem code at %s: %d %s %s", c.provider.ID, status, body.Error, body.ErrorDescription)
+	}
+
+	claims, err := c.verifyIDToken(ctx, meta, body.IDToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return c.identity(claims)
+}
+
+func (c *Client) verifyIDToken(ctx context.Context, meta *providerMetadata, idToken, nonce string) (map[string]any, error) {
+	claims := make(map[string]any)
+	err := token.VerifyJWT(idToken, func(kid string) (*rsa.PublicKey, error) {
+		return c.key(ctx, meta, kid)
+	}, &claims)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to verify ID token from %s with err %v", c.provider.ID, err)
+	}
+
+	if iss, _ := claims["iss"].(string); iss != meta.Issuer {
+		return nil, fmt.Errorf("ID token from %s has issuer %q", c.provider.ID, iss)
+	}
+	if !hasAudience(claims["aud"], c.provider.ClientID) {
+		return nil, fmt.Errorf("ID token from %s is not for client %s", c.provider.ID, c.provider.ClientID)
+	}
+	if azp, ok := claims["azp"].(string); ok && azp != c.provider.ClientID {
+		return nil, fmt.Errorf("ID token from %s was issued to %s", c.provider.ID, azp)
+	}
+	exp, _ := claims["exp"].(float64)
+	if time.Now().Add(-clockSkew).Unix() >= int64(exp) {
+		return nil, fmt.Errorf("ID token from %s has expired", c.provider.ID)
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, fmt.Errorf("ID token from %s has the wrong nonce", c.provider.ID)
+	}
+	return claims, nil
+}
+
+func hasAudience(aud any, clientID string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == clientID
+	case []any:
+		for _, item := range v {
+			if item == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// identity reads the identity out of the claims through the claim mapping.
+func (c *Client) identity(claims map[string]any) (*domain.ExternalIdentity, error) {
+	mapping := c.provider.Claims
+	identity := &domain.ExternalIdentity{
+		Provider: c.provider.ID,
+		Subject:  claimString(claims, mapping.Subject, "sub"),
+		Email:    strings.TrimSpace(claimString(claims, mapping.Email, "email")),
+		Name:     strings.TrimSpace(claimString(claims, mapping.Name, "name")),
+		Claims:   claims,
+	}
+	switch v := claims[orDefault(mapping.EmailVerified, "email_verified")].(type) {
+	case bool:
+		identity.EmailVerified = v
+	case string:
+		identity.EmailVerified = v == "true"
+	}
+
+	if identity.Subject == "" {
+		return nil, fmt.Errorf("ID token from %s has no subject", c.provider.ID)
+	}
+	return identity, nil
+}
+
+func claimString(claims map[string]any, name, fallback string) string {
+	s, _ := claims[orDefault(name, fallback)].(string)
+	return s
+}
+
+func orDefault(s, fallback string) string {
+	if s == "" {
+		return fallback
+	}
+	return s
+}
+
+func (c *Client) discover(ctx context.Context) (*providerMetadata, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.metadata != nil {
+		return c.metadata, nil
+	}
+
+	issuer := strings.TrimSuffix(c.provider.Issuer, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	meta := new(providerMetadata)
+	status, err := c.doJSON(req, meta)
+	if err != nil || status != http.StatusOK {
+		return nil, fmt.Errorf("Failed to discover identity provider %s: status %d, err %v", c.provider.ID, status, err)
+	}
+	// OpenID Connect Discovery section 4.3: the document must be for the
+	// issuer it was fetched from.
+	if strings.TrimSuffix(meta.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("Identity provider %s reports issuer %q", c.provider.ID, meta.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, fmt.Errorf("Identity provider %s is missing endpoints in its discovery document", c.provider.ID)
+	}
+
+	c.metadata = meta
+	return meta, nil
+}
+
+// key returns the provider's signing key kid, fetching the key set again
+// when kid is unknown, since providers rotate their keys.
+func (c *Client) key(ctx context.Context, meta *providerMetadata, kid string) (*rsa.PublicKey, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if key, ok := c.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(c.keysFetched) < jwksRefreshInterval {
+		return nil, token.ErrInvalidToken
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, meta.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	status, err := c.doJSON(req, &set)
+	if err != nil || status != http.StatusOK {
+		return nil, fmt.Errorf("Failed to fetch keys of %s: status %d, err %v", c.provider.ID, status, err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	c.keys, c.keysFetched = keys, time.Now()
+
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, token.ErrInvalidToken
+}
+
+func (c *Client) doJSON(req *http.Request, v any) (int, error) {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return resp.StatusCode, err
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,179 @@
+package federation
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sync"
+	"tablelink/internal/token"
+	"time"
+)
+
+// FakeIdP is a minimal OpenID Connect provider for development and tests.
+// It signs in the user described by Claims without asking for credentials,
+// and supports only the authorization code flow with S256 PKCE.
+type FakeIdP struct {
+	// Issuer is the URL the provider is served at. It may be set after
+	// construction, once the listener address is known.
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// Claims describe the signed-in user and must include "sub".
+	Claims map[string]any
+
+	signer *token.Signer
+	mux    *http.ServeMux
+
+	mu    sync.Mutex
+	codes map[string]*fakeCode
+}
+
+type fakeCode struct {
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+	expiresAt     time.Time
+}
+
+func NewFakeIdP(issuer, clientID, clientSecret string, claims map[string]any) (*FakeIdP, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &FakeIdP{
+		Issuer:       issuer,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Claims:       claims,
+		signer:       token.NewSigner(key),
+		mux:          http.NewServeMux(),
+		codes:        make(map[string]*fakeCode),
+	}
+	f.mux.HandleFunc("GET /.well-known/openid-configuration", f.discovery)
+	f.mux.HandleFunc("GET /authorize", f.authorize)
+	f.mux.HandleFunc("POST /token", f.token)
+	f.mux.HandleFunc("GET /jwks", f.jwks)
+	return f, nil
+}
+
+func (f *FakeIdP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mux.ServeHTTP(w, r)
+}
+
+func (f *FakeIdP) discovery(w http.ResponseWriter, r *http.Request) {
+	writeFakeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                f.Issuer,
+		"authorization_endpoint":                f.Issuer + "/authorize",
+		"token_endpoint":                        f.Issuer + "/token",
+		"jwks_uri":                              f.Issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (f *FakeIdP) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != f.ClientID || q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || !redirect.IsAbs() {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	code := randomString()
+	f.mu.Lock()
+	f.codes[code] = &fakeCode{
+		redirectURI:   q.Get("redirect_uri"),
+		nonce:         q.Get("nonce"),
+		codeChallenge: q.Get("code_challenge"),
+		expiresAt:     time.Now().Add(time.Minute),
+	}
+	f.mu.Unlock()
+
+	params := redirect.Query()
+	params.Set("code", code)
+	params.Set("state", q.Get("state"))
+	redirect.RawQuery = params.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (f *FakeIdP) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	if ok {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	}
+	if !ok || id != f.ClientID || subtle.ConstantTimeCompare([]byte(secret), []byte(f.ClientSecret)) != 1 {
+		writeFakeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	if r.PostFormValue("grant_type") != "authorization_code" {
+		writeFakeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	f.mu.Lock()
+	code, ok := f.codes[r.PostFormValue("code")]
+	delete(f.codes, r.PostFormValue("code"))
+	f.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+	if !ok || time.Now().After(code.expiresAt) || code.redirectURI != r.PostFormValue("redirect_uri") || challenge != code.codeChallenge {
+		writeFakeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims := make(map[string]any, len(f.Claims)+5)
+	for k, v := range f.Claims {
+		claims[k] = v
+	}
+	claims["iss"] = f.Issuer
+	claims["aud"] = f.ClientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(time.Hour).Unix()
+	if code.nonce != "" {
+		claims["nonce"] = code.nonce
+	}
+	idToken, err := f.signer.Sign(claims)
+	if err != nil {
+		writeFakeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeFakeJSON(w, http.StatusOK, map[string]any{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func (f *FakeIdP) jwks(w http.ResponseWriter, r *http.Request) {
+	writeFakeJSON(w, http.StatusOK, f.signer.JWKS())
+}
+
+func writeFakeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func randomString() string {
+	buf := make([]byte, 24)
+	rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
@@ -0,0 +1,54 @@
+package federation
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"tablelink/internal/domain"
+)
+
+var defaultScopes = []string{domain.ScopeOpenID, domain.ScopeEmail, domain.ScopeProfile}
+
+// LoadProviders reads identity providers from a JSON array, for example
+//
+//	[{"id": "corp", "name": "Corporate SSO", "issuer": "https://sso.corp.example",
+//	  "client_id": "tablelink", "client_secret": "env:CORP_SSO_SECRET",
+//	  "link_by_email": true, "auto_provision": true, "allowed_domains": ["corp.example"],
+//	  "role_rules": [{"claim": "groups", "value": "admins", "role_id": 1}],
+//	  "default_role_id": 2}]
+//
+// A client_secret of the form "env:NAME" is read from that environment
+// variable, so the file need not hold secrets.
+func LoadProviders(path string) ([]*domain.IdentityProvider, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var providers []*domain.IdentityProvider
+	if err := json.Unmarshal(raw, &providers); err != nil {
+		return nil, fmt.Errorf("Failed to parse identity providers %s with err %v", path, err)
+	}
+
+	seen := make(map[string]bool, len(providers))
+	for _, p := range providers {
+		if err := p.Validate(); err != nil {
+			return nil, err
+		}
+		if seen[p.ID] {
+			return nil, fmt.Errorf("identity provider %q is configured twice", p.ID)
+		}
+		seen[p.ID] = true
+
+		if name, ok := strings.CutPrefix(p.ClientSecret, "env:"); ok {
+			p.ClientSecret = os.Getenv(name)
+		}
+		if len(p.Scopes) == 0 {
+			p.Scopes = defaultScopes
+		}
+		if p.Name == "" {
+			p.Name = p.ID
+		}
+	}
+	return providers, nil
+}
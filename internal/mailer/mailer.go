@@ -0,0 +1,25 @@
+package mailer
+
+import "context"
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers transactional emails such as invitations.
+type Mailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// New picks the mailer implementation by driver name, falling back to
+// LogMailer.
+func New(driver, smtpAddr, from, username, password string) Mailer {
+	switch driver {
+	case "smtp":
+		return NewSMTPMailer(smtpAddr, from, username, password)
+	default:
+		return NewLogMailer()
+	}
+}
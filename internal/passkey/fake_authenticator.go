@@ -0,0 +1,237 @@
+// Package passkey holds a software WebAuthn authenticator for development
+// and tests, standing in for the browser and a platform authenticator.
+package passkey
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+)
+
+// Authenticator data flags, see §6.1 of the WebAuthn specification.
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+)
+
+var ErrNoCredential = errors.New("the authenticator has no matching passkey")
+
+// FakeAuthenticator answers navigator.credentials.create() and get() the
+// way a browser would, with "none" attestation and ES256 keys it keeps in
+// Credentials. Every passkey is discoverable and every gesture counts as
+// user verification. It is JSON so a command can keep it in a file.
+type FakeAuthenticator struct {
+	// Origin is the web origin the browser would report, which must be
+	// one the relying party accepts.
+	Origin      string            `json:"origin"`
+	Credentials []*FakeCredential `json:"credentials"`
+}
+
+type FakeCredential struct {
+	ID         []byte `json:"id"`
+	RPID       string `json:"rp_id"`
+	UserHandle []byte `json:"user_handle"`
+	// PrivateKey is the PKCS #8 DER of the credential's P-256 key.
+	PrivateKey []byte `json:"private_key"`
+	SignCount  uint32 `json:"sign_count"`
+}
+
+func NewFakeAuthenticator(origin string) *FakeAuthenticator {
+	return &FakeAuthenticator{Origin: origin}
+}
+
+type fakeDescriptor struct {
+	ID string `json:"id"`
+}
+
+type fakeCreationOptions struct {
+	PublicKey struct {
+		Challenge string `json:"challenge"`
+		RP        struct {
+			ID string `json:"id"`
+		} `json:"rp"`
+		User struct {
+			ID string `json:"id"`
+		} `json:"user"`
+		ExcludeCredentials []fakeDescriptor `json:"excludeCredentials"`
+	} `json:"publicKey"`
+}
+
+type fakeRequestOptions struct {
+	PublicKey struct {
+		Challenge        string           `json:"challenge"`
+		RPID             string           `json:"rpId"`
+		AllowCredentials []fakeDescriptor `json:"allowCredentials"`
+	} `json:"publicKey"`
+}
+
+// Register creates a passkey from creation options JSON and returns the
+// PublicKeyCredential JSON to finish the registration with.
+func (f *FakeAuthenticator) Register(options []byte) ([]byte, error) {
+	var opts fakeCreationOptions
+	if err := json.Unmarshal(options, &opts); err != nil {
+		return nil, fmt.Errorf("Failed to parse creation options with err %v", err)
+	}
+	rpID := opts.PublicKey.RP.ID
+	userHandle, err := base64.RawURLEncoding.DecodeString(opts.PublicKey.User.ID)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode user handle with err %v", err)
+	}
+	for _, excluded := range opts.PublicKey.ExcludeCredentials {
+		if f.credential(rpID, excluded.ID) != nil {
+			return nil, errors.New("the authenticator already holds a passkey for this account")
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	coseKey, err := webauthncbor.Marshal(&webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  1, // P-256
+		XCoord: key.PublicKey.X.FillBytes(make([]byte, 32)),
+		YCoord: key.PublicKey.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	credential := &FakeCredential{ID: id, RPID: rpID, UserHandle: userHandle, PrivateKey: der}
+	authData := authenticatorData(rpID, flagUserPresent|flagUserVerified|flagAttestedData, 0)
+	authData = append(authData, make([]byte, 16)...) // zero AAGUID
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(id)))
+	authData = append(authData, id...)
+	authData = append(authData, coseKey...)
+
+	attestation, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": authData,
+	})
+	if err != nil {
+		return nil, err
+	}
+	clientData, err := f.clientData("webauthn.create", opts.PublicKey.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	f.Credentials = append(f.Credentials, credential)
+	return json.Marshal(map[string]any{
+		"id":    base64.RawURLEncoding.EncodeToString(id),
+		"rawId": base64.RawURLEncoding.EncodeToString(id),
+		"type":  "public-key",
+		"response": map[string]any{
+			"clientDataJSON":    base64.RawURLEncoding.EncodeToString(clientData),
+			"attestationObject": base64.RawURLEncoding.EncodeToString(attestation),
+			"transports":        []string{"internal"},
+		},
+	})
+}
+
+// Login signs the challenge of request options JSON with the first passkey
+// allowed, or any passkey for the relying party when the options allow
+// all, and returns the PublicKeyCredential JSON to finish the login with.
+func (f *FakeAuthenticator) Login(options []byte) ([]byte, error) {
+	var opts fakeRequestOptions
+	if err := json.Unmarshal(options, &opts); err != nil {
+		return nil, fmt.Errorf("Failed to parse request options with err %v", err)
+	}
+	rpID := opts.PublicKey.RPID
+
+	var credential *FakeCredential
+	if len(opts.PublicKey.AllowCredentials) == 0 {
+		credential = f.credential(rpID, "")
+	}
+	for _, allowed := range opts.PublicKey.AllowCredentials {
+		if credential = f.credential(rpID, allowed.ID); credential != nil {
+			break
+		}
+	}
+	if credential == nil {
+		return nil, ErrNoCredential
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(credential.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse passkey private key with err %v", err)
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("passkey private key is not an ECDSA key")
+	}
+
+	credential.SignCount++
+	authData := authenticatorData(rpID, flagUserPresent|flagUserVerified, credential.SignCount)
+	clientData, err := f.clientData("webauthn.get", opts.PublicKey.Challenge)
+	if err != nil {
+		return nil, err
+	}
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(bytes.Clone(authData), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(map[string]any{
+		"id":    base64.RawURLEncoding.EncodeToString(credential.ID),
+		"rawId": base64.RawURLEncoding.EncodeToString(credential.ID),
+		"type":  "public-key",
+		"response": map[string]any{
+			"clientDataJSON":    base64.RawURLEncoding.EncodeToString(clientData),
+			"authenticatorData": base64.RawURLEncoding.EncodeToString(authData),
+			"signature":         base64.RawURLEncoding.EncodeToString(signature),
+			"userHandle":        base64.RawURLEncoding.EncodeToString(credential.UserHandle),
+		},
+	})
+}
+
+// credential returns the passkey for rpID with the base64url id, or the
+// first passkey for rpID when id is empty.
+func (f *FakeAuthenticator) credential(rpID, id string) *FakeCredential {
+	for _, c := range f.Credentials {
+		if c.RPID == rpID && (id == "" || base64.RawURLEncoding.EncodeToString(c.ID) == id) {
+			return c
+		}
+	}
+	return nil
+}
+
+func (f *FakeAuthenticator) clientData(ceremony, challenge string) ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"type":      ceremony,
+		"challenge": challenge,
+		"origin":    f.Origin,
+	})
+}
+
+func authenticatorData(rpID string, flags byte, signCount uint32) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append(rpIDHash[:], flags)
+	return binary.BigEndian.AppendUint32(data, signCount)
+}
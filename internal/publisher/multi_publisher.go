@@ -0,0 +1,28 @@
+package publisher
+
+import (
+	"context"
+	"tablelink/internal/domain"
+)
+
+// MultiPublisher publishes every event to all of its publishers. An event
+// that fails on one of them is retried on all, so each publisher must
+// tolerate duplicates.
+type MultiPublisher struct {
+	publishers []EventPublisher
+}
+
+func NewMultiPublisher(publishers ...EventPublisher) *MultiPublisher {
+	return &MultiPublisher{
+		publishers: publishers,
+	}
+}
+
+func (m *MultiPublisher) Publish(ctx context.Context, event *domain.Event) error {
+	for _, p := range m.publishers {
+		if err := p.Publish(ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
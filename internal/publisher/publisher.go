@@ -0,0 +1,13 @@
+package publisher
+
+import (
+	"context"
+	"tablelink/internal/domain"
+)
+
+// EventPublisher hands domain events to the systems that consume them.
+// Publish may be called more than once for the same event, so consumers
+// should dedupe on Event.ID.
+type EventPublisher interface {
+	Publish(ctx context.Context, event *domain.Event) error
+}
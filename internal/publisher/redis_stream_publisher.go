@@ -0,0 +1,49 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"tablelink/internal/domain"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStreamPublisher appends events to a Redis stream, one entry per event.
+type RedisStreamPublisher struct {
+	rdb    *redis.Client
+	stream string
+}
+
+func NewRedisStreamPublisher(rdb *redis.Client, stream string) *RedisStreamPublisher {
+	return &RedisStreamPublisher{
+		rdb:    rdb,
+		stream: stream,
+	}
+}
+
+func (p *RedisStreamPublisher) Publish(ctx context.Context, event *domain.Event) error {
+	values := map[string]interface{}{
+		"event_id":       strconv.FormatInt(event.ID, 10),
+		"revision":       strconv.FormatInt(event.Revision, 10),
+		"event_type":     string(event.Type),
+		"aggregate_type": event.AggregateType,
+		"aggregate_id":   strconv.Itoa(event.AggregateID),
+		"payload":        string(event.Payload),
+		"created_at":     event.CreatedAt.UTC().Format(time.RFC3339Nano),
+	}
+	if event.Actor != nil {
+		actor, err := json.Marshal(event.Actor)
+		if err != nil {
+			return fmt.Errorf("Failed to marshal event actor with err %v", err)
+		}
+		values["actor"] = string(actor)
+	}
+
+	return p.rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: p.stream,
+		Values: values,
+	}).Err()
+}
@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"context"
+	"tablelink/internal/domain"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const apiKeyColumns = `id, name, prefix, key_hash, role_id, expires_at, allowed_ips, created_by, created_at, last_used_at,
+	last_used_ip, revoked_at`
+
+type APIKeyRepository interface {
+	Create(ctx context.Context, key *domain.APIKey) (*domain.APIKey, error)
+	// GetByPrefix returns the key with the prefix, revoked or not.
+	GetByPrefix(ctx context.Context, prefix string) (*domain.APIKey, error)
+	List(ctx context.Context) ([]*domain.APIKey, error)
+	Revoke(ctx context.Context, id int) error
+	// Touch records that the key was used from ip.
+	Touch(ctx context.Context, id int, ip string) error
+}
+
+type apiKeyRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewAPIKeyRepository(pool *pgxpool.Pool) APIKeyRepository {
+	return &apiKeyRepository{
+		pool: pool,
+	}
+}
+
+func (a *apiKeyRepository) Create(ctx context.Context, key *domain.APIKey) (*domain.APIKey, error) {
+	created := new(domain.APIKey)
+	query := `
+	INSERT INTO api_keys (name, prefix, key_hash, role_id, expires_at, allowed_ips, created_by)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING ` + apiKeyColumns
+	err := pgxscan.Get(ctx, a.pool, created, query, key.Name, key.Prefix, key.KeyHash, key.RoleID, key.ExpiresAt,
+		nonNil(key.AllowedIPs), key.CreatedBy)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return nil, domain.ErrRoleNotFound
+		}
+		return nil, err
+	}
+	return created, nil
+}
+
+func (a *apiKeyRepository) GetByPrefix(ctx context.Context, prefix string) (*domain.APIKey, error) {
+	key := new(domain.APIKey)
+	query := "SELECT " + apiKeyColumns + " FROM api_keys WHERE prefix = $1"
+	if err := pgxscan.Get(ctx, a.pool, key, query, prefix); err != nil {
+		if pgxscan.NotFound(err) {
+			return nil, domain.ErrAPIKeyNotFound
+		}
+		return nil, err
+	}
+	return key, nil
+}
+
+func (a *apiKeyRepository) List(ctx context.Context) ([]*domain.APIKey, error) {
+	var keys []*domain.APIKey
+	query := "SELECT " + apiKeyColumns + " FROM api_keys ORDER BY id"
+	if err := pgxscan.Select(ctx, a.pool, &keys, query); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (a *apiKeyRepository) Revoke(ctx context.Context, id int) error {
+	tag, err := a.pool.Exec(ctx, `UPDATE api_keys SET revoked_at = COALESCE(revoked_at, NOW()) WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func (a *apiKeyRepository) Touch(ctx context.Context, id int, ip string) error {
+	_, err := a.pool.Exec(ctx, `UPDATE api_keys SET last_used_at = NOW(), last_used_ip = $1 WHERE id = $2`, ip, id)
+	return err
+}
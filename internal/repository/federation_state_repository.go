@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"tablelink/internal/domain"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// FederationStateRepository keeps federated logins in flight, keyed by the
+// state parameter sent to the identity provider.
+type FederationStateRepository interface {
+	Save(ctx context.Context, state string, value *domain.FederationState, ttl time.Duration) error
+	// Consume returns and removes the state, failing with
+	// domain.ErrFederationState when it is unknown or expired.
+	Consume(ctx context.Context, state string) (*domain.FederationState, error)
+}
+
+type federationStateRepository struct {
+	redis *redis.Client
+}
+
+func NewFederationStateRepository(redis *redis.Client) FederationStateRepository {
+	return &federationStateRepository{
+		redis: redis,
+	}
+}
+
+func federationStateKey(state string) string {
+	return "federation_state:" + state
+}
+
+func (f *federationStateRepository) Save(ctx context.Context, state string, value *domain.FederationState, ttl time.Duration) error {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return f.redis.Set(ctx, federationStateKey(state), payload, ttl).Err()
+}
+
+func (f *federationStateRepository) Consume(ctx context.Context, state string) (*domain.FederationState, error) {
+	payload, err := f.redis.GetDel(ctx, federationStateKey(state)).Bytes()
+	if err == redis.Nil {
+		return nil, domain.ErrFederationState
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	value := new(domain.FederationState)
+	if err := json.Unmarshal(payload, value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
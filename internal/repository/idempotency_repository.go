@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"tablelink/internal/domain"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type IdempotencyRepository interface {
+	// Reserve claims key for a new request. When the key is already taken it
+	// returns the existing record and false.
+	Reserve(ctx context.Context, key string, record *domain.IdempotencyRecord, ttl time.Duration) (*domain.IdempotencyRecord, bool, error)
+	Complete(ctx context.Context, key string, record *domain.IdempotencyRecord, ttl time.Duration) error
+	Release(ctx context.Context, key string) error
+}
+
+type idempotencyRepository struct {
+	redis *redis.Client
+}
+
+func NewIdempotencyRepository(redis *redis.Client) IdempotencyRepository {
+	return &idempotencyRepository{
+		redis: redis,
+	}
+}
+
+func idempotencyKey(key string) string {
+	return "idempotency:" + key
+}
+
+func (i *idempotencyRepository) Reserve(ctx context.Context, key string, record *domain.IdempotencyRecord, ttl time.Duration) (*domain.IdempotencyRecord, bool, error) {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return nil, false, err
+	}
+
+	ok, err := i.redis.SetNX(ctx, idempotencyKey(key), payload, ttl).Result()
+	if err != nil {
+		return nil, false, err
+	}
+	if ok {
+		return record, true, nil
+	}
+
+	payload, err = i.redis.Get(ctx, idempotencyKey(key)).Bytes()
+	if err == redis.Nil {
+		// The key expired in between, let the caller try again.
+		return i.Reserve(ctx, key, record, ttl)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	existing := new(domain.IdempotencyRecord)
+	if err := json.Unmarshal(payload, existing); err != nil {
+		return nil, false, err
+	}
+	return existing, false, nil
+}
+
+func (i *idempotencyRepository) Complete(ctx context.Context, key string, record *domain.IdempotencyRecord, ttl time.Duration) error {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return i.redis.Set(ctx, idempotencyKey(key), payload, ttl).Err()
+}
+
+func (i *idempotencyRepository) Release(ctx context.Context, key string) error {
+	return i.redis.Del(ctx, idempotencyKey(key)).Err()
+}
@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+	"tablelink/internal/domain"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const identityColumns = "id, user_id, provider, subject, email, created_at, last_login_at"
+
+type IdentityRepository interface {
+	Get(ctx context.Context, provider, subject string) (*domain.UserIdentity, error)
+	ListByUser(ctx context.Context, userID int) ([]*domain.UserIdentity, error)
+	// Link attaches the identity to its user. It fails with
+	// domain.ErrIdentityTaken when the identity is already linked.
+	Link(ctx context.Context, identity *domain.UserIdentity) (*domain.UserIdentity, error)
+	// Touch records a login with the identity and the email it came with.
+	Touch(ctx context.Context, id int, email string) error
+}
+
+type identityRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewIdentityRepository(pool *pgxpool.Pool) IdentityRepository {
+	return &identityRepository{
+		pool: pool,
+	}
+}
+
+func (i *identityRepository) Get(ctx context.Context, provider, subject string) (*domain.UserIdentity, error) {
+	identity := new(domain.UserIdentity)
+	query := "SELECT " + identityColumns + " FROM user_identities WHERE provider = $1 AND subject = $2"
+	if err := pgxscan.Get(ctx, i.pool, identity, query, provider, subject); err != nil {
+		if pgxscan.NotFound(err) {
+			return nil, domain.ErrIdentityNotFound
+		}
+		return nil, err
+	}
+	return identity, nil
+}
+
+func (i *identityRepository) ListByUser(ctx context.Context, userID int) ([]*domain.UserIdentity, error) {
+	var identities []*domain.UserIdentity
+	query := "SELECT " + identityColumns + " FROM user_identities WHERE user_id = $1 ORDER BY id"
+	if err := pgxscan.Select(ctx, i.pool, &identities, query, userID); err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+func (i *identityRepository) Link(ctx context.Context, identity *domain.UserIdentity) (*domain.UserIdentity, error) {
+	linked := new(domain.UserIdentity)
+	query := `
+	INSERT INTO user_identities (user_id, provider, subject, email, last_login_at)
+	VALUES ($1, $2, $3, $4, NOW())
+	RETURNING ` + identityColumns
+	err := pgxscan.Get(ctx, i.pool, linked, query, identity.UserID, identity.Provider, identity.Subject, identity.Email)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, domain.ErrIdentityTaken
+		}
+		if isForeignKeyViolation(err) {
+			return nil, domain.ErrUserNotFound
+		}
+		return nil, err
+	}
+	return linked, nil
+}
+
+func (i *identityRepository) Touch(ctx context.Context, id int, email string) error {
+	_, err := i.pool.Exec(ctx, `UPDATE user_identities SET last_login_at = NOW(), email = $1 WHERE id = $2`, email, id)
+	return err
+}
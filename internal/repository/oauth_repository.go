@@ -0,0 +1,236 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"tablelink/internal/domain"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const oauthClientColumns = `id, name, secret_hash, redirect_uris, post_logout_redirect_uris, grant_types, scopes,
+	role_id, created_at`
+
+const oauthCodeColumns = `code_hash, grant_id, client_id, user_id, redirect_uri, scopes, code_challenge, nonce,
+	auth_time, expires_at, used_at`
+
+const oauthTokenColumns = "token_hash, kind, grant_id, client_id, user_id, scopes, expires_at, revoked_at, created_at"
+
+type OAuthRepository interface {
+	CreateClient(ctx context.Context, client *domain.OAuthClient) (*domain.OAuthClient, error)
+	GetClient(ctx context.Context, id string) (*domain.OAuthClient, error)
+	ListClients(ctx context.Context) ([]*domain.OAuthClient, error)
+	DeleteClient(ctx context.Context, id string) error
+
+	// SaveConsent remembers that the user allowed the client scopes, on top
+	// of what they allowed it before.
+	SaveConsent(ctx context.Context, userID int, clientID string, scopes []string) error
+	// GetConsent returns the scopes the user allowed the client, none when
+	// they never did.
+	GetConsent(ctx context.Context, userID int, clientID string) ([]string, error)
+
+	CreateCode(ctx context.Context, code *domain.OAuthCode) error
+	// ConsumeCode marks the code as used and returns it as it was before,
+	// so a code with UsedAt set is one that is being replayed.
+	ConsumeCode(ctx context.Context, codeHash string) (*domain.OAuthCode, error)
+
+	CreateTokens(ctx context.Context, tokens ...*domain.OAuthToken) error
+	GetToken(ctx context.Context, tokenHash string) (*domain.OAuthToken, error)
+	// RotateToken revokes the refresh token and stores its replacements in
+	// one transaction. It fails with domain.ErrInvalidGrant when the token
+	// was already revoked, so a refresh token can be used only once.
+	RotateToken(ctx context.Context, tokenHash string, replacements ...*domain.OAuthToken) error
+	RevokeToken(ctx context.Context, tokenHash string) error
+	RevokeGrant(ctx context.Context, grantID string) error
+}
+
+type oauthRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewOAuthRepository(pool *pgxpool.Pool) OAuthRepository {
+	return &oauthRepository{
+		pool: pool,
+	}
+}
+
+func (o *oauthRepository) CreateClient(ctx context.Context, client *domain.OAuthClient) (*domain.OAuthClient, error) {
+	created := new(domain.OAuthClient)
+	query := `
+	INSERT INTO oauth_clients (id, name, secret_hash, redirect_uris, post_logout_redirect_uris, grant_types, scopes, role_id)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	RETURNING ` + oauthClientColumns
+	err := pgxscan.Get(ctx, o.pool, created, query, client.ID, client.Name, client.SecretHash, nonNil(client.RedirectURIs),
+		nonNil(client.PostLogoutRedirectURIs), client.GrantTypes, nonNil(client.Scopes), client.RoleID)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return nil, domain.ErrRoleNotFound
+		}
+		return nil, err
+	}
+	return created, nil
+}
+
+// nonNil turns a nil slice into an empty one, which pgx sends as '{}'
+// rather than NULL.
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+func (o *oauthRepository) GetClient(ctx context.Context, id string) (*domain.OAuthClient, error) {
+	client := new(domain.OAuthClient)
+	query := "SELECT " + oauthClientColumns + " FROM oauth_clients WHERE id = $1"
+	if err := pgxscan.Get(ctx, o.pool, client, query, id); err != nil {
+		if pgxscan.NotFound(err) {
+			return nil, domain.ErrOAuthClientNotFound
+		}
+		return nil, err
+	}
+	return client, nil
+}
+
+func (o *oauthRepository) ListClients(ctx context.Context) ([]*domain.OAuthClient, error) {
+	var clients []*domain.OAuthClient
+	query := "SELECT " + oauthClientColumns + " FROM oauth_clients ORDER BY created_at"
+	if err := pgxscan.Select(ctx, o.pool, &clients, query); err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+func (o *oauthRepository) DeleteClient(ctx context.Context, id string) error {
+	tag, err := o.pool.Exec(ctx, `DELETE FROM oauth_clients WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrOAuthClientNotFound
+	}
+	return nil
+}
+
+func (o *oauthRepository) SaveConsent(ctx context.Context, userID int, clientID string, scopes []string) error {
+	query := `
+	INSERT INTO oauth_consents (user_id, client_id, scopes)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (user_id, client_id) DO UPDATE
+	SET scopes = ARRAY(SELECT DISTINCT UNNEST(oauth_consents.scopes || EXCLUDED.scopes)), granted_at = NOW()`
+	_, err := o.pool.Exec(ctx, query, userID, clientID, nonNil(scopes))
+	return err
+}
+
+func (o *oauthRepository) GetConsent(ctx context.Context, userID int, clientID string) ([]string, error) {
+	var scopes []string
+	query := "SELECT scopes FROM oauth_consents WHERE user_id = $1 AND client_id = $2"
+	if err := o.pool.QueryRow(ctx, query, userID, clientID).Scan(&scopes); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return scopes, nil
+}
+
+func (o *oauthRepository) CreateCode(ctx context.Context, code *domain.OAuthCode) error {
+	query := `
+	INSERT INTO oauth_codes (code_hash, grant_id, client_id, user_id, redirect_uri, scopes, code_challenge, nonce,
+		auth_time, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+	_, err := o.pool.Exec(ctx, query, code.CodeHash, code.GrantID, code.ClientID, code.UserID, code.RedirectURI,
+		code.Scopes, code.CodeChallenge, code.Nonce, code.AuthTime, code.ExpiresAt)
+	return err
+}
+
+func (o *oauthRepository) ConsumeCode(ctx context.Context, codeHash string) (*domain.OAuthCode, error) {
+	tx, err := o.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	code := new(domain.OAuthCode)
+	query := "SELECT " + oauthCodeColumns + " FROM oauth_codes WHERE code_hash = $1 FOR UPDATE"
+	if err := pgxscan.Get(ctx, tx, code, query, codeHash); err != nil {
+		if pgxscan.NotFound(err) {
+			return nil, domain.ErrInvalidGrant
+		}
+		return nil, err
+	}
+
+	if code.UsedAt == nil {
+		if _, err := tx.Exec(ctx, `UPDATE oauth_codes SET used_at = NOW() WHERE code_hash = $1`, codeHash); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return code, nil
+}
+
+func (o *oauthRepository) CreateTokens(ctx context.Context, tokens ...*domain.OAuthToken) error {
+	batch := &pgx.Batch{}
+	for _, t := range tokens {
+		queueInsertToken(batch, t)
+	}
+	return o.pool.SendBatch(ctx, batch).Close()
+}
+
+func queueInsertToken(batch *pgx.Batch, t *domain.OAuthToken) {
+	batch.Queue(`
+	INSERT INTO oauth_tokens (token_hash, kind, grant_id, client_id, user_id, scopes, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		t.TokenHash, t.Kind, t.GrantID, t.ClientID, t.UserID, t.Scopes, t.ExpiresAt)
+}
+
+func (o *oauthRepository) GetToken(ctx context.Context, tokenHash string) (*domain.OAuthToken, error) {
+	t := new(domain.OAuthToken)
+	query := "SELECT " + oauthTokenColumns + " FROM oauth_tokens WHERE token_hash = $1"
+	if err := pgxscan.Get(ctx, o.pool, t, query, tokenHash); err != nil {
+		if pgxscan.NotFound(err) {
+			return nil, domain.ErrInvalidGrant
+		}
+		return nil, err
+	}
+	return t, nil
+}
+
+func (o *oauthRepository) RotateToken(ctx context.Context, tokenHash string, replacements ...*domain.OAuthToken) error {
+	tx, err := o.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `UPDATE oauth_tokens SET revoked_at = NOW() WHERE token_hash = $1 AND revoked_at IS NULL`, tokenHash)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrInvalidGrant
+	}
+
+	batch := &pgx.Batch{}
+	for _, t := range replacements {
+		queueInsertToken(batch, t)
+	}
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (o *oauthRepository) RevokeToken(ctx context.Context, tokenHash string) error {
+	_, err := o.pool.Exec(ctx, `UPDATE oauth_tokens SET revoked_at = NOW() WHERE token_hash = $1 AND revoked_at IS NULL`, tokenHash)
+	return err
+}
+
+func (o *oauthRepository) RevokeGrant(ctx context.Context, grantID string) error {
+	_, err := o.pool.Exec(ctx, `UPDATE oauth_tokens SET revoked_at = NOW() WHERE grant_id = $1 AND revoked_at IS NULL`, grantID)
+	return err
+}
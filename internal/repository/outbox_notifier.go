@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// OutboxNotifier listens on OutboxChannel and wakes every subscriber when new
+// revisions are available. A wake-up carries no data; subscribers read the
+// outbox themselves, so a missed or merged notification is harmless.
+type OutboxNotifier interface {
+	// Run holds a dedicated LISTEN connection until ctx is done,
+	// reconnecting after failures.
+	Run(ctx context.Context)
+	// Subscribe returns a channel that receives a value after new
+	// revisions, and a function that releases it.
+	Subscribe() (<-chan struct{}, func())
+}
+
+type outboxNotifier struct {
+	pool *pgxpool.Pool
+
+	mu   sync.Mutex
+	subs map[chan struct{}]struct{}
+}
+
+func NewOutboxNotifier(pool *pgxpool.Pool) OutboxNotifier {
+	return &outboxNotifier{
+		pool: pool,
+		subs: make(map[chan struct{}]struct{}),
+	}
+}
+
+func (n *outboxNotifier) Run(ctx context.Context) {
+	for {
+		if err := n.listen(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to listen for outbox notifications with err %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (n *outboxNotifier) listen(ctx context.Context) error {
+	conn, err := pgx.ConnectConfig(ctx, n.pool.Config().ConnConfig.Copy())
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.WithoutCancel(ctx))
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{OutboxChannel}.Sanitize()); err != nil {
+		return err
+	}
+	// Notifications may have been missed while reconnecting.
+	n.broadcast()
+
+	for {
+		if _, err := conn.WaitForNotification(ctx); err != nil {
+			return err
+		}
+		n.broadcast()
+	}
+}
+
+func (n *outboxNotifier) Subscribe() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	n.mu.Lock()
+	n.subs[ch] = struct{}{}
+	n.mu.Unlock()
+
+	return ch, func() {
+		n.mu.Lock()
+		delete(n.subs, ch)
+		n.mu.Unlock()
+	}
+}
+
+func (n *outboxNotifier) broadcast() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for ch := range n.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package repository
+
+import (
+	"context"
+	"tablelink/internal/domain"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// outboxRelayLockID is the advisory lock key that makes a single relay
+// process the outbox at a time, which is what keeps events of a user in
+// order.
+const outboxRelayLockID = 7_300_036
+
+// OutboxChannel is notified with the latest revision whenever the relay
+// sequences new events.
+const OutboxChannel = "outbox_revision"
+
+// sequenceBatchSize bounds how many events one relay pass sequences.
+const sequenceBatchSize = 1000
+
+type OutboxRepository interface {
+	// Append writes events that are not tied to a user write, such as
+	// logins.
+	Append(ctx context.Context, events ...*domain.Event) error
+	// Relay hands up to limit unpublished events to publish in revision
+	// order and marks the IDs it returns as published. It returns the number
+	// of events published, or zero when another relay holds the outbox.
+	Relay(ctx context.Context, limit int, publish func([]*domain.Event) []int64) (int, error)
+	// ListSince returns up to limit sequenced events with a revision greater
+	// than revision, in revision order.
+	ListSince(ctx context.Context, revision int64, limit int) ([]*domain.Event, error)
+	LatestRevision(ctx context.Context) (int64, error)
+}
+
+type outboxRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewOutboxRepository(pool *pgxpool.Pool) OutboxRepository {
+	return &outboxRepository{
+		pool: pool,
+	}
+}
+
+func (o *outboxRepository) Append(ctx context.Context, events ...*domain.Event) error {
+	tx, err := o.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	if err = insertEvents(ctx, tx, events...); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (o *outboxRepository) Relay(ctx context.Context, limit int, publish func([]*domain.Event) []int64) (int, error) {
+	tx, err := o.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = tx.Rollback(context.WithoutCancel(ctx))
+	}()
+
+	var locked bool
+	if err := tx.QueryRow(ctx, `SELECT pg_try_advisory_xact_lock($1)`, outboxRelayLockID).Scan(&locked); err != nil {
+		return 0, err
+	}
+	if !locked {
+		return 0, nil
+	}
+
+	if err := o.sequence(ctx, tx); err != nil {
+		return 0, err
+	}
+
+	// Only sequenced events are published, so they go out in the order they
+	// committed. Ids are handed out before commit and can run ahead of it.
+	var events []*domain.Event
+	query := `
+	SELECT id, revision, aggregate_type, aggregate_id, event_type, payload, actor, created_at
+	FROM outbox WHERE published_at IS NULL AND revision IS NOT NULL
+	ORDER BY revision LIMIT $1`
+	if err := pgxscan.Select(ctx, tx, &events, query, limit); err != nil {
+		return 0, err
+	}
+	if len(events) == 0 {
+		return 0, tx.Commit(ctx)
+	}
+
+	published := publish(events)
+	if len(published) > 0 {
+		query = `UPDATE outbox SET published_at = NOW() WHERE id = ANY($1)`
+		if _, err := tx.Exec(ctx, query, published); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return len(published), nil
+}
+
+// sequence gives committed events without a revision the next revisions and
+// notifies watchers. It relies on the relay lock: only one transaction
+// sequences at a time, so revisions become visible in order.
+func (o *outboxRepository) sequence(ctx context.Context, tx pgx.Tx) error {
+	query := `
+	WITH base AS (
+		SELECT COALESCE(MAX(revision), 0) AS revision FROM outbox
+	), pending AS (
+		SELECT id, ROW_NUMBER() OVER (ORDER BY id) AS n
+		FROM outbox WHERE revision IS NULL
+		ORDER BY id LIMIT $1
+	)
+	UPDATE outbox SET revision = base.revision + pending.n
+	FROM base, pending WHERE outbox.id = pending.id
+	RETURNING outbox.revision`
+	rows, err := tx.Query(ctx, query, sequenceBatchSize)
+	if err != nil {
+		return err
+	}
+	revisions, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil || len(revisions) == 0 {
+		return err
+	}
+
+	var latest int64
+	for _, r := range revisions {
+		latest = max(latest, r)
+	}
+	_, err = tx.Exec(ctx, `SELECT pg_notify($1, $2::TEXT)`, OutboxChannel, latest)
+	return err
+}
+
+func (o *outboxRepository) ListSince(ctx context.Context, revision int64, limit int) ([]*domain.Event, error) {
+	var events []*domain.Event
+	query := `
+	SELECT id, revision, aggregate_type, aggregate_id, event_type, payload, actor, created_at
+	FROM outbox WHERE revision > $1
+	ORDER BY revision LIMIT $2`
+	if err := pgxscan.Select(ctx, o.pool, &events, query, revision, limit); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (o *outboxRepository) LatestRevision(ctx context.Context) (int64, error) {
+	var revision int64
+	err := o.pool.QueryRow(ctx, `SELECT COALESCE(MAX(revision), 0) FROM outbox`).Scan(&revision)
+	return revision, err
+}
+
+// insertEvents writes events to the outbox as part of tx, so they are only
+// visible to the relay if the change they describe commits.
+func insertEvents(ctx context.Context, tx pgx.Tx, events ...*domain.Event) error {
+	// Events of an authenticated call are attributed to its principal.
+	var callActor *domain.Actor
+	if p := domain.PrincipalFromContext(ctx); p != nil {
+		callActor = p.Actor()
+	}
+
+	query := `
+	INSERT INTO outbox (aggregate_type, aggregate_id, event_type, payload, actor)
+	VALUES ($1, $2, $3, $4, $5)`
+	for _, event := range events {
+		actor := event.Actor
+		if actor == nil {
+			actor = callActor
+		}
+		if _, err := tx.Exec(ctx, query, event.AggregateType, event.AggregateID, event.Type, event.Payload, actor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
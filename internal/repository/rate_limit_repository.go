@@ -0,0 +1,43 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RateLimitRepository counts attempts per key in fixed windows.
+type RateLimitRepository interface {
+	// Allow records an attempt on key and reports whether it is within
+	// limit attempts for the current window.
+	Allow(ctx context.Context, key string, limit int, window time.Duration) (bool, error)
+}
+
+type rateLimitRepository struct {
+	redis *redis.Client
+}
+
+func NewRateLimitRepository(redis *redis.Client) RateLimitRepository {
+	return &rateLimitRepository{
+		redis: redis,
+	}
+}
+
+func rateLimitKey(key string) string {
+	return "rate_limit:" + key
+}
+
+func (r *rateLimitRepository) Allow(ctx context.Context, key string, limit int, window time.Duration) (bool, error) {
+	var count *redis.IntCmd
+	_, err := r.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		count = pipe.Incr(ctx, rateLimitKey(key))
+		// The window starts with the first attempt in it.
+		pipe.ExpireNX(ctx, rateLimitKey(key), window)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return count.Val() <= int64(limit), nil
+}
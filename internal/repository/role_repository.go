@@ -10,6 +10,12 @@ import (
 
 type RoleRepository interface {
 	GetByID(ctx context.Context, id int) (*domain.Role, error)
+	ExistingIDs(ctx context.Context, ids []int) (map[int]bool, error)
+	List(ctx context.Context) ([]*domain.Role, error)
+	Create(ctx context.Context, name string) (*domain.Role, error)
+	Rename(ctx context.Context, id int, name string) (*domain.Role, error)
+	// Delete fails with domain.ErrRoleInUse while users still have the role.
+	Delete(ctx context.Context, id int) error
 }
 
 type roleRepository struct {
@@ -27,9 +33,76 @@ func (r *roleRepository) GetByID(ctx context.Context, id int) (*domain.Role, err
 	query := `SELECT id, name FROM roles WHERE id = $1`
 
 	if err := pgxscan.Get(ctx, r.pool, role, query, id); err != nil {
+		if pgxscan.NotFound(err) {
+			return nil, domain.ErrRoleNotFound
+		}
 		return nil, err
 	}
 
 	return role, nil
 
 }
+
+func (r *roleRepository) ExistingIDs(ctx context.Context, ids []int) (map[int]bool, error) {
+	var found []int
+	query := `SELECT id FROM roles WHERE id = ANY($1)`
+	if err := pgxscan.Select(ctx, r.pool, &found, query, ids); err != nil {
+		return nil, err
+	}
+
+	existing := make(map[int]bool, len(found))
+	for _, id := range found {
+		existing[id] = true
+	}
+	return existing, nil
+}
+
+func (r *roleRepository) List(ctx context.Context) ([]*domain.Role, error) {
+	var roles []*domain.Role
+	query := `SELECT id, name FROM roles ORDER BY id`
+	if err := pgxscan.Select(ctx, r.pool, &roles, query); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func (r *roleRepository) Create(ctx context.Context, name string) (*domain.Role, error) {
+	role := new(domain.Role)
+	query := `INSERT INTO roles (name) VALUES ($1) RETURNING id, name`
+	if err := pgxscan.Get(ctx, r.pool, role, query, name); err != nil {
+		if isUniqueViolation(err) {
+			return nil, domain.ErrRoleNameTaken
+		}
+		return nil, err
+	}
+	return role, nil
+}
+
+func (r *roleRepository) Rename(ctx context.Context, id int, name string) (*domain.Role, error) {
+	role := new(domain.Role)
+	query := `UPDATE roles SET name = $1 WHERE id = $2 RETURNING id, name`
+	if err := pgxscan.Get(ctx, r.pool, role, query, name, id); err != nil {
+		if pgxscan.NotFound(err) {
+			return nil, domain.ErrRoleNotFound
+		}
+		if isUniqueViolation(err) {
+			return nil, domain.ErrRoleNameTaken
+		}
+		return nil, err
+	}
+	return role, nil
+}
+
+func (r *roleRepository) Delete(ctx context.Context, id int) error {
+	tag, err := r.pool.Exec(ctx, `DELETE FROM roles WHERE id = $1`, id)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return domain.ErrRoleInUse
+		}
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrRoleNotFound
+	}
+	return nil
+}
@@ -8,8 +8,13 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+const roleRightColumns = "id, role_id, section, route, r_created, r_read, r_update, r_delete, step_up_actions, step_up_minutes"
+
 type RoleRightRepository interface {
+	// CheckPermission returns the rights of roleID on the route of section.
+	// A route without a row grants nothing.
 	CheckPermission(ctx context.Context, roleID int, section, route string) (*domain.RoleRight, error)
+	ListByRole(ctx context.Context, roleID int) ([]*domain.RoleRight, error)
 }
 
 type roleRightRepository struct {
@@ -24,13 +29,24 @@ func NewRoleRightRepository(pool *pgxpool.Pool) RoleRightRepository {
 
 func (r *roleRightRepository) CheckPermission(ctx context.Context, roleID int, section, route string) (*domain.RoleRight, error) {
 	rr := new(domain.RoleRight)
-	query := `SELECT id, role_id, section, route, r_create, r_create, r_create, r_create
-	 name FROM roles WHERE id = $1
-	 WHERE  role_id = $1 AND section=$2 AND route = $3`
+	query := `SELECT ` + roleRightColumns + `
+	FROM role_rights
+	WHERE role_id = $1 AND section = $2 AND route = $3`
 	if err := pgxscan.Get(ctx, r.pool, rr, query, roleID, section, route); err != nil {
+		if pgxscan.NotFound(err) {
+			return &domain.RoleRight{RoleId: roleID, Section: section, Route: route}, nil
+		}
 		return nil, err
 	}
 
 	return rr, nil
+}
 
+func (r *roleRightRepository) ListByRole(ctx context.Context, roleID int) ([]*domain.RoleRight, error) {
+	var rights []*domain.RoleRight
+	query := `SELECT ` + roleRightColumns + ` FROM role_rights WHERE role_id = $1 ORDER BY section, route`
+	if err := pgxscan.Select(ctx, r.pool, &rights, query, roleID); err != nil {
+		return nil, err
+	}
+	return rights, nil
 }
@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"context"
+	"tablelink/internal/domain"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const credentialColumns = "id, user_id, type, key_id, secret_hash, public_key, expires_at, created_at, last_used_at, revoked_at"
+
+// ServiceAccountRepository reads service accounts, which are rows of users
+// written through UserRepository, and stores their credentials.
+type ServiceAccountRepository interface {
+	List(ctx context.Context) ([]*domain.User, error)
+	CreateCredential(ctx context.Context, credential *domain.ServiceAccountCredential) (*domain.ServiceAccountCredential, error)
+	// GetCredential returns the credential with keyID, revoked or not.
+	GetCredential(ctx context.Context, keyID string) (*domain.ServiceAccountCredential, error)
+	ListCredentials(ctx context.Context, userID int) ([]*domain.ServiceAccountCredential, error)
+	RevokeCredential(ctx context.Context, userID, id int) error
+	TouchCredential(ctx context.Context, id int) error
+}
+
+type serviceAccountRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewServiceAccountRepository(pool *pgxpool.Pool) ServiceAccountRepository {
+	return &serviceAccountRepository{
+		pool: pool,
+	}
+}
+
+func (s *serviceAccountRepository) List(ctx context.Context) ([]*domain.User, error) {
+	users := make([]*domain.User, 0)
+	query := "SELECT " + userColumns + " FROM users WHERE kind = 'service' AND deleted_at IS NULL ORDER BY id"
+	if err := pgxscan.Select(ctx, s.pool, &users, query); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (s *serviceAccountRepository) CreateCredential(ctx context.Context, credential *domain.ServiceAccountCredential) (*domain.ServiceAccountCredential, error) {
+	created := new(domain.ServiceAccountCredential)
+	query := `
+	INSERT INTO service_account_credentials (user_id, type, key_id, secret_hash, public_key, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING ` + credentialColumns
+	err := pgxscan.Get(ctx, s.pool, created, query, credential.UserID, credential.Type, credential.KeyID, credential.SecretHash,
+		credential.PublicKey, credential.ExpiresAt)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return nil, domain.ErrServiceAccountNotFound
+		}
+		return nil, err
+	}
+	return created, nil
+}
+
+func (s *serviceAccountRepository) GetCredential(ctx context.Context, keyID string) (*domain.ServiceAccountCredential, error) {
+	credential := new(domain.ServiceAccountCredential)
+	query := "SELECT " + credentialColumns + " FROM service_account_credentials WHERE key_id = $1"
+	if err := pgxscan.Get(ctx, s.pool, credential, query, keyID); err != nil {
+		if pgxscan.NotFound(err) {
+			return nil, domain.ErrCredentialNotFound
+		}
+		return nil, err
+	}
+	return credential, nil
+}
+
+func (s *serviceAccountRepository) ListCredentials(ctx context.Context, userID int) ([]*domain.ServiceAccountCredential, error) {
+	var credentials []*domain.ServiceAccountCredential
+	query := "SELECT " + credentialColumns + " FROM service_account_credentials WHERE user_id = $1 ORDER BY id"
+	if err := pgxscan.Select(ctx, s.pool, &credentials, query, userID); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+func (s *serviceAccountRepository) RevokeCredential(ctx context.Context, userID, id int) error {
+	query := `UPDATE service_account_credentials SET revoked_at = COALESCE(revoked_at, NOW()) WHERE id = $1 AND user_id = $2`
+	tag, err := s.pool.Exec(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrCredentialNotFound
+	}
+	return nil
+}
+
+func (s *serviceAccountRepository) TouchCredential(ctx context.Context, id int) error {
+	_, err := s.pool.Exec(ctx, `UPDATE service_account_credentials SET last_used_at = NOW() WHERE id = $1`, id)
+	return err
+}
@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"tablelink/internal/domain"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type SessionRepository interface {
+	Create(ctx context.Context, token string, session *domain.Session, ttl time.Duration) error
+	Get(ctx context.Context, token string) (*domain.Session, error)
+	// Update replaces a session, keeping its expiry. It fails with
+	// domain.ErrInvalidSession when the session has ended.
+	Update(ctx context.Context, token string, session *domain.Session) error
+	Delete(ctx context.Context, token string) error
+	DeleteByUserID(ctx context.Context, userID int) error
+}
+
+type sessionRepository struct {
+	redis *redis.Client
+}
+
+func NewSessionRepository(redis *redis.Client) SessionRepository {
+	return &sessionRepository{
+		redis: redis,
+	}
+}
+
+func sessionKey(token string) string {
+	return "session:" + token
+}
+
+// userSessionsKey indexes the tokens of a user so they can be revoked
+// together.
+func userSessionsKey(userID int) string {
+	return "user_sessions:" + strconv.Itoa(userID)
+}
+
+func (s *sessionRepository) Create(ctx context.Context, token string, session *domain.Session, ttl time.Duration) error {
+	payload, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, sessionKey(token), payload, ttl)
+		// An impersonation session is indexed under the impersonator too,
+		// so revoking either user's sessions ends it.
+		for _, userID := range sessionUserIDs(session) {
+			indexKey := userSessionsKey(userID)
+			pipe.SAdd(ctx, indexKey, token)
+			// Only ever extend the index, a short session must not cut
+			// the longer ones in it loose.
+			pipe.ExpireNX(ctx, indexKey, ttl)
+			pipe.ExpireGT(ctx, indexKey, ttl)
+		}
+		return nil
+	})
+	return err
+}
+
+func sessionUserIDs(session *domain.Session) []int {
+	if session.ImpersonatorID != 0 {
+		return []int{session.UserID, session.ImpersonatorID}
+	}
+	return []int{session.UserID}
+}
+
+func (s *sessionRepository) Get(ctx context.Context, token string) (*domain.Session, error) {
+	payload, err := s.redis.Get(ctx, sessionKey(token)).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	session := new(domain.Session)
+	if err := json.Unmarshal(payload, session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (s *sessionRepository) Update(ctx context.Context, token string, session *domain.Session) error {
+	payload, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	// XX keeps a session that ended meanwhile from coming back.
+	err = s.redis.SetArgs(ctx, sessionKey(token), payload, redis.SetArgs{Mode: "XX", KeepTTL: true}).Err()
+	if err == redis.Nil {
+		return domain.ErrInvalidSession
+	}
+	return err
+}
+
+func (s *sessionRepository) Delete(ctx context.Context, token string) error {
+	session, err := s.Get(ctx, token)
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = s.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, sessionKey(token))
+		for _, userID := range sessionUserIDs(session) {
+			pipe.SRem(ctx, userSessionsKey(userID), token)
+		}
+		return nil
+	})
+	return err
+}
+
+func (s *sessionRepository) DeleteByUserID(ctx context.Context, userID int) error {
+	indexKey := userSessionsKey(userID)
+	tokens, err := s.redis.SMembers(ctx, indexKey).Result()
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(tokens)+1)
+	for _, token := range tokens {
+		keys = append(keys, sessionKey(token))
+	}
+	keys = append(keys, indexKey)
+
+	return s.redis.Del(ctx, keys...).Err()
+}
@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// TokenRepository tracks outstanding single-use tokens by their ID.
+type TokenRepository interface {
+	Save(ctx context.Context, id string, ttl time.Duration) error
+	Consume(ctx context.Context, id string) (bool, error)
+}
+
+type tokenRepository struct {
+	redis *redis.Client
+}
+
+func NewTokenRepository(redis *redis.Client) TokenRepository {
+	return &tokenRepository{
+		redis: redis,
+	}
+}
+
+func tokenKey(id string) string {
+	return "token:" + id
+}
+
+func (t *tokenRepository) Save(ctx context.Context, id string, ttl time.Duration) error {
+	return t.redis.Set(ctx, tokenKey(id), 1, ttl).Err()
+}
+
+// Consume atomically removes the token and reports whether it was still
+// outstanding.
+func (t *tokenRepository) Consume(ctx context.Context, id string) (bool, error) {
+	err := t.redis.GetDel(ctx, tokenKey(id)).Err()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
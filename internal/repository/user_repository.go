@@ -2,20 +2,51 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"tablelink/internal/domain"
+	"time"
 
 	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type UserRepository interface {
+	GetByID(ctx context.Context, id int) (*domain.User, error)
 	GetByEmail(ctx context.Context, email string) (*domain.User, error)
 	Create(ctx context.Context, user *domain.User) (*domain.User, error)
 	Update(ctx context.Context, user *domain.User) (*domain.User, error)
-	Delete(ctx context.Context, id int) error
-	ListAll(ctx context.Context) ([]*domain.User, error)
+	Delete(ctx context.Context, id, version int) error
+	Restore(ctx context.Context, id, version int) error
+	Purge(ctx context.Context, id, version int) error
+	PurgeDeletedBefore(ctx context.Context, before time.Time) (int64, error)
+	ChangeStatus(ctx context.Context, id, version int, from, to domain.UserStatus, reason string) (*domain.User, error)
+	// LastStatusReasons returns the reason of the latest status change of
+	// each of the users ids that has one.
+	LastStatusReasons(ctx context.Context, ids []int) (map[int]string, error)
+	AcceptInvite(ctx context.Context, id int, password string) (*domain.User, error)
+	MarkEmailVerified(ctx context.Context, id int, email string) error
+	SetPendingEmail(ctx context.Context, id int, email string) error
+	ConfirmEmailChange(ctx context.Context, id int, email string) (*domain.User, error)
+	ExistingEmails(ctx context.Context, emails []string) (map[string]bool, error)
+	CopyUsers(ctx context.Context, users []*domain.User, batchSize int) ([]*domain.User, error)
+	ListAll(ctx context.Context, filter *domain.UserFilter) ([]*domain.User, error)
+	Count(ctx context.Context, filter *domain.UserFilter) (int, error)
+	IterateUsers(ctx context.Context, filter *domain.UserFilter, batchSize int, fn func([]*domain.User) error) error
+	GetByIDs(ctx context.Context, ids []int) (map[int]*domain.User, error)
+	BatchUpdate(ctx context.Context, updates []*domain.UserBatchUpdate, atomic bool) ([]*domain.BatchResult, error)
+	BatchDelete(ctx context.Context, deletes []*domain.UserBatchDelete, atomic bool) ([]*domain.BatchResult, error)
+	// LinkDirectory marks the user as managed by the directory entry dn.
+	LinkDirectory(ctx context.Context, id int, dn string) error
 }
 
+// userColumns is the column list shared by queries returning a user without
+// its password hash.
+const userColumns = `id, name, email, role_id, last_access, status, version, email_verified_at, pending_email, directory_dn, external_id, kind`
+
 type userRepository struct {
 	pool *pgxpool.Pool
 }
@@ -26,13 +57,32 @@ func NewUserRepository(pool *pgxpool.Pool) UserRepository {
 	}
 }
 
+func (u *userRepository) GetByID(ctx context.Context, id int) (*domain.User, error) {
+	user := new(domain.User)
+	query := `
+	SELECT ` + userColumns + `
+	FROM users WHERE id = $1 AND deleted_at IS NULL
+	`
+	if err := pgxscan.Get(ctx, u.pool, user, query, id); err != nil {
+		if pgxscan.NotFound(err) {
+			return nil, domain.ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	return user, nil
+}
+
 func (u *userRepository) GetByEmail(ctx context.Context, email string) (*domain.User, error) {
 	user := new(domain.User)
 	query := `
-	SELECT id, name, email, password, role_id, last_access
-	FROM users WHERE email = $1
+	SELECT password, ` + userColumns + `
+	FROM users WHERE email = $1 AND deleted_at IS NULL
 	`
 	if err := pgxscan.Get(ctx, u.pool, user, query, email); err != nil {
+		if pgxscan.NotFound(err) {
+			return nil, domain.ErrUserNotFound
+		}
 		return nil, err
 	}
 
@@ -49,11 +99,27 @@ func (u *userRepository) Create(ctx context.Context, user *domain.User) (*domain
 		}
 	}()
 
+	if user.Status == "" {
+		user.Status = domain.UserStatusActive
+	}
+	if user.Kind == "" {
+		user.Kind = domain.UserKindHuman
+	}
+
 	query := `
-	INSERT INTO users (id, name, email, password, role_id, last_access)
-	VALUES ($1, $2,$ 3, $4, $5, $6)`
-	_, err = tx.Exec(ctx, query, user.ID, user.Name, user.Email, user.Password, user.RoleID, user.LastAccess)
+	INSERT INTO users (name, email, password, role_id, last_access, status, directory_dn, external_id, kind)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	RETURNING id, version`
+	err = tx.QueryRow(ctx, query, user.Name, user.Email, user.Password, user.RoleID, user.LastAccess, user.Status, user.DirectoryDN,
+		user.ExternalID, user.Kind).Scan(&user.ID, &user.Version)
 	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, domain.ErrEmailTaken
+		}
+		return nil, err
+	}
+
+	if err = insertEvents(ctx, tx, domain.NewUserEvent(domain.EventUserCreated, user)); err != nil {
 		return nil, err
 	}
 
@@ -74,52 +140,670 @@ func (u *userRepository) Update(ctx context.Context, user *domain.User) (*domain
 			_ = tx.Rollback(ctx)
 		}
 	}()
+	var previousRoleID int
+	query := `SELECT role_id FROM users WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`
+	err = tx.QueryRow(ctx, query, user.ID).Scan(&previousRoleID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+
+	updated := new(domain.User)
+	query = `
+	UPDATE users SET name = $1, email = $2, password = COALESCE(NULLIF($3, ''), password), role_id = $4,
+	last_access = COALESCE($5, last_access), external_id = COALESCE($6, external_id), version = version + 1
+	WHERE id = $7 AND version = $8 AND deleted_at IS NULL
+	RETURNING ` + userColumns
+	err = pgxscan.Get(ctx, tx, updated, query, user.Name, user.Email, user.Password, user.RoleID, user.LastAccess, user.ExternalID,
+		user.ID, user.Version)
+	if pgxscan.NotFound(err) {
+		err = u.conflictOrNotFound(ctx, tx, user.ID, false)
+	} else if isUniqueViolation(err) {
+		err = domain.ErrEmailTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = insertEvents(ctx, tx, userUpdatedEvents(updated, previousRoleID)...); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+
+}
+
+func (u *userRepository) Delete(ctx context.Context, id, version int) error {
+	query := `
+	UPDATE users SET deleted_at = NOW(), version = version + 1
+	WHERE id = $1 AND version = $2 AND deleted_at IS NULL
+	RETURNING ` + userColumns
+	return u.execVersioned(ctx, query, id, version, false, func(user *domain.User) *domain.Event {
+		return domain.NewUserEvent(domain.EventUserDeleted, user)
+	})
+}
+
+func (u *userRepository) Restore(ctx context.Context, id, version int) error {
+	query := `
+	UPDATE users SET deleted_at = NULL, version = version + 1
+	WHERE id = $1 AND version = $2 AND deleted_at IS NOT NULL
+	RETURNING ` + userColumns
+	return u.execVersioned(ctx, query, id, version, true, func(user *domain.User) *domain.Event {
+		return domain.NewUserEvent(domain.EventUserUpdated, user)
+	})
+}
+
+func (u *userRepository) Purge(ctx context.Context, id, version int) error {
+	query := `
+	DELETE FROM users WHERE id = $1 AND version = $2 AND deleted_at IS NOT NULL
+	RETURNING ` + userColumns
+	return u.execVersioned(ctx, query, id, version, true, domain.NewUserPurgedEvent)
+}
+
+func (u *userRepository) PurgeDeletedBefore(ctx context.Context, before time.Time) (int64, error) {
+	tx, err := u.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	var purged []*domain.User
+	query := `
+	DELETE FROM users WHERE deleted_at IS NOT NULL AND deleted_at < $1
+	RETURNING ` + userColumns
+	if err = pgxscan.Select(ctx, tx, &purged, query, before); err != nil {
+		return 0, err
+	}
+
+	events := make([]*domain.Event, 0, len(purged))
+	for _, user := range purged {
+		events = append(events, domain.NewUserPurgedEvent(user))
+	}
+	if err = insertEvents(ctx, tx, events...); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+
+	return int64(len(purged)), nil
+}
+
+func (u *userRepository) ChangeStatus(ctx context.Context, id, version int, from, to domain.UserStatus, reason string) (*domain.User, error) {
+	tx, err := u.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	user := new(domain.User)
 	query := `
-	UPDATE users SET name = $1, email = $2, password = $3, role_id = $4, last_access= $5 
-	WHERE id = $6`
-	_, err = tx.Exec(ctx, query, user.Name, user.Email, user.Password, user.RoleID, user.LastAccess)
+	UPDATE users SET status = $1, version = version + 1
+	WHERE id = $2 AND version = $3 AND status = $4 AND deleted_at IS NULL
+	RETURNING ` + userColumns
+	err = pgxscan.Get(ctx, tx, user, query, to, id, version, from)
+	if pgxscan.NotFound(err) {
+		err = u.conflictOrNotFound(ctx, tx, id, false)
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	query = `
+	INSERT INTO user_status_changes (user_id, from_status, to_status, reason)
+	VALUES ($1, $2, $3, $4)`
+	if _, err = tx.Exec(ctx, query, id, from, to, reason); err != nil {
+		return nil, err
+	}
+
+	if err = insertEvents(ctx, tx, domain.NewUserEvent(domain.EventUserUpdated, user)); err != nil {
+		return nil, err
+	}
+
 	if err := tx.Commit(ctx); err != nil {
 		return nil, err
 	}
 
 	return user, nil
+}
+
+func (u *userRepository) LastStatusReasons(ctx context.Context, ids []int) (map[int]string, error) {
+	var rows []struct {
+		UserID int    `db:"user_id"`
+		Reason string `db:"reason"`
+	}
+	query := `
+	SELECT DISTINCT ON (user_id) user_id, reason FROM user_status_changes
+	WHERE user_id = ANY($1)
+	ORDER BY user_id, id DESC`
+	if err := pgxscan.Select(ctx, u.pool, &rows, query, ids); err != nil {
+		return nil, err
+	}
 
+	reasons := make(map[int]string, len(rows))
+	for _, row := range rows {
+		reasons[row.UserID] = row.Reason
+	}
+	return reasons, nil
 }
 
-func (u *userRepository) Delete(ctx context.Context, id int) error {
+// AcceptInvite sets the password of an invited user and activates it in a
+// single step, recording the transition like ChangeStatus does.
+func (u *userRepository) AcceptInvite(ctx context.Context, id int, password string) (*domain.User, error) {
 	tx, err := u.pool.Begin(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer func() {
 		if err != nil {
 			_ = tx.Rollback(ctx)
 		}
 	}()
+
+	user := new(domain.User)
 	query := `
-	DELETE FROM users WHERE id=$1`
-	_, err = tx.Exec(ctx, query, id)
+	UPDATE users SET password = $1, status = $2, email_verified_at = NOW(), version = version + 1
+	WHERE id = $3 AND status = $4 AND deleted_at IS NULL
+	RETURNING ` + userColumns
+	err = pgxscan.Get(ctx, tx, user, query, password, domain.UserStatusActive, id, domain.UserStatusInvited)
+	if pgxscan.NotFound(err) {
+		err = domain.ErrInviteNotPending
+	}
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	query = `
+	INSERT INTO user_status_changes (user_id, from_status, to_status, reason)
+	VALUES ($1, $2, $3, $4)`
+	if _, err = tx.Exec(ctx, query, id, domain.UserStatusInvited, domain.UserStatusActive, "invite accepted"); err != nil {
+		return nil, err
+	}
+
+	if err = insertEvents(ctx, tx, domain.NewUserEvent(domain.EventUserUpdated, user)); err != nil {
+		return nil, err
 	}
 
 	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (u *userRepository) MarkEmailVerified(ctx context.Context, id int, email string) error {
+	query := `
+	UPDATE users SET email_verified_at = NOW()
+	WHERE id = $1 AND email = $2 AND deleted_at IS NULL`
+	tag, err := u.pool.Exec(ctx, query, id, email)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrUserNotFound
+	}
+
+	return nil
+}
+
+func (u *userRepository) SetPendingEmail(ctx context.Context, id int, email string) error {
+	query := `
+	UPDATE users SET pending_email = $1
+	WHERE id = $2 AND deleted_at IS NULL`
+	tag, err := u.pool.Exec(ctx, query, email, id)
+	if err != nil {
 		return err
 	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrUserNotFound
+	}
 
 	return nil
+}
+
+// ConfirmEmailChange swaps the pending email in as the verified primary
+// address, provided it is still the one the confirmation was issued for.
+func (u *userRepository) ConfirmEmailChange(ctx context.Context, id int, email string) (*domain.User, error) {
+	tx, err := u.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
 
+	user := new(domain.User)
+	query := `
+	UPDATE users SET email = pending_email, pending_email = NULL, email_verified_at = NOW(), version = version + 1
+	WHERE id = $1 AND pending_email = $2 AND deleted_at IS NULL
+	RETURNING ` + userColumns
+	err = pgxscan.Get(ctx, tx, user, query, id, email)
+	if pgxscan.NotFound(err) {
+		err = domain.ErrEmailChangeNotPending
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = insertEvents(ctx, tx, domain.NewUserEvent(domain.EventUserUpdated, user)); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return user, nil
 }
 
-func (u *userRepository) ListAll(ctx context.Context) ([]*domain.User, error) {
+// ExistingEmails returns the lower-cased emails that already belong to a
+// user that is not soft-deleted.
+func (u *userRepository) ExistingEmails(ctx context.Context, emails []string) (map[string]bool, error) {
+	var found []string
+	query := `SELECT lower(email) FROM users WHERE lower(email) = ANY($1) AND deleted_at IS NULL`
+	if err := pgxscan.Select(ctx, u.pool, &found, query, emails); err != nil {
+		return nil, err
+	}
+
+	existing := make(map[string]bool, len(found))
+	for _, email := range found {
+		existing[email] = true
+	}
+	return existing, nil
+}
+
+// CopyUsers bulk inserts users with COPY in batches of batchSize, all inside
+// one transaction so a failing batch leaves nothing behind. It returns the
+// created users.
+func (u *userRepository) CopyUsers(ctx context.Context, users []*domain.User, batchSize int) ([]*domain.User, error) {
+	tx, err := u.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	columns := []string{"name", "email", "password", "role_id", "status"}
+	for start := 0; start < len(users); start += batchSize {
+		batch := users[start:min(start+batchSize, len(users))]
+		_, err = tx.CopyFrom(ctx, pgx.Identifier{"users"}, columns, pgx.CopyFromSlice(len(batch), func(i int) ([]any, error) {
+			user := batch[i]
+			return []any{user.Name, user.Email, user.Password, user.RoleID, string(user.Status)}, nil
+		}))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	emails := make([]string, 0, len(users))
+	for _, user := range users {
+		emails = append(emails, user.Email)
+	}
+	var created []*domain.User
+	query := "SELECT " + userColumns + " FROM users WHERE email = ANY($1) AND deleted_at IS NULL ORDER BY id"
+	if err = pgxscan.Select(ctx, tx, &created, query, emails); err != nil {
+		return nil, err
+	}
+	events := make([]*domain.Event, 0, len(created))
+	for _, user := range created {
+		events = append(events, domain.NewUserEvent(domain.EventUserCreated, user))
+	}
+	if err = insertEvents(ctx, tx, events...); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return created, nil
+}
+
+func (u *userRepository) ListAll(ctx context.Context, filter *domain.UserFilter) ([]*domain.User, error) {
 	users := make([]*domain.User, 0)
-	query := "SELECT id, name, email, role_id, last_access FROM users"
-	if err := pgxscan.Select(ctx, u.pool, users, query); err != nil {
+	where, args := userFilterClause(filter)
+	query := "SELECT " + userColumns + " FROM users WHERE " + where + " ORDER BY id"
+	if filter != nil && filter.Limit > 0 {
+		args = append(args, filter.Limit, filter.Offset)
+		query += fmt.Sprintf(" LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+	}
+	if err := pgxscan.Select(ctx, u.pool, &users, query, args...); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// Count returns how many users match filter, ignoring its Offset and Limit.
+func (u *userRepository) Count(ctx context.Context, filter *domain.UserFilter) (int, error) {
+	where, args := userFilterClause(filter)
+	var count int
+	if err := u.pool.QueryRow(ctx, "SELECT COUNT(*) FROM users WHERE "+where, args...).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// IterateUsers walks the users matching filter through a server-side cursor,
+// handing them to fn batchSize at a time so the whole table is never held in
+// memory. Returning an error from fn or cancelling ctx stops the walk.
+func (u *userRepository) IterateUsers(ctx context.Context, filter *domain.UserFilter, batchSize int, fn func([]*domain.User) error) error {
+	tx, err := u.pool.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback(context.WithoutCancel(ctx))
+	}()
+
+	where, args := userFilterClause(filter)
+	query := "DECLARE users_cursor NO SCROLL CURSOR FOR SELECT " + userColumns + " FROM users WHERE " + where + " ORDER BY id"
+	if _, err := tx.Exec(ctx, query, args...); err != nil {
+		return err
+	}
+
+	fetch := fmt.Sprintf("FETCH %d FROM users_cursor", batchSize)
+	for {
+		batch := make([]*domain.User, 0, batchSize)
+		if err := pgxscan.Select(ctx, tx, &batch, fetch); err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := fn(batch); err != nil {
+			return err
+		}
+	}
+}
+
+func (u *userRepository) GetByIDs(ctx context.Context, ids []int) (map[int]*domain.User, error) {
+	var found []*domain.User
+	query := "SELECT " + userColumns + " FROM users WHERE id = ANY($1) AND deleted_at IS NULL"
+	if err := pgxscan.Select(ctx, u.pool, &found, query, ids); err != nil {
 		return nil, err
 	}
+
+	users := make(map[int]*domain.User, len(found))
+	for _, user := range found {
+		users[user.ID] = user
+	}
 	return users, nil
 }
+
+func (u *userRepository) BatchUpdate(ctx context.Context, updates []*domain.UserBatchUpdate, atomic bool) ([]*domain.BatchResult, error) {
+	results := make([]*domain.BatchResult, len(updates))
+	for i, upd := range updates {
+		results[i] = &domain.BatchResult{ID: upd.ID}
+	}
+	err := u.runBatch(ctx, len(updates), atomic, func(tx pgx.Tx, i int) error {
+		upd := updates[i]
+
+		user := new(domain.User)
+		query := `
+		UPDATE users SET status = COALESCE(NULLIF($1, ''), status), role_id = COALESCE(NULLIF($2, 0), role_id),
+		version = version + 1
+		WHERE id = $3 AND version = $4 AND deleted_at IS NULL
+		RETURNING ` + userColumns
+		err := pgxscan.Get(ctx, tx, user, query, upd.Status, upd.RoleID, upd.ID, upd.Version)
+		if pgxscan.NotFound(err) {
+			err = u.conflictOrNotFound(ctx, tx, upd.ID, false)
+		}
+		if err != nil {
+			return err
+		}
+
+		if upd.Status != "" && upd.Status != upd.FromStatus {
+			query = `
+			INSERT INTO user_status_changes (user_id, from_status, to_status, reason)
+			VALUES ($1, $2, $3, $4)`
+			if _, err := tx.Exec(ctx, query, upd.ID, upd.FromStatus, upd.Status, upd.Reason); err != nil {
+				return err
+			}
+		}
+
+		if err := insertEvents(ctx, tx, userUpdatedEvents(user, upd.FromRoleID)...); err != nil {
+			return err
+		}
+
+		results[i].User = user
+		return nil
+	}, func(i int, err error) {
+		results[i].User = nil
+		results[i].Err = err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (u *userRepository) BatchDelete(ctx context.Context, deletes []*domain.UserBatchDelete, atomic bool) ([]*domain.BatchResult, error) {
+	results := make([]*domain.BatchResult, len(deletes))
+	for i, del := range deletes {
+		results[i] = &domain.BatchResult{ID: del.ID}
+	}
+	err := u.runBatch(ctx, len(deletes), atomic, func(tx pgx.Tx, i int) error {
+		del := deletes[i]
+
+		user := new(domain.User)
+		query := `
+		UPDATE users SET deleted_at = NOW(), version = version + 1
+		WHERE id = $1 AND version = $2 AND deleted_at IS NULL
+		RETURNING ` + userColumns
+		err := pgxscan.Get(ctx, tx, user, query, del.ID, del.Version)
+		if pgxscan.NotFound(err) {
+			err = u.conflictOrNotFound(ctx, tx, del.ID, false)
+		}
+		if err != nil {
+			return err
+		}
+
+		return insertEvents(ctx, tx, domain.NewUserEvent(domain.EventUserDeleted, user))
+	}, func(i int, err error) {
+		results[i].User = nil
+		results[i].Err = err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// runBatch applies n items in one transaction through applySavepoints and
+// commits unless an atomic batch failed. fail records the error of item i;
+// a non-nil return means the batch itself could not run.
+func (u *userRepository) runBatch(ctx context.Context, n int, atomic bool, apply func(tx pgx.Tx, i int) error, fail func(i int, err error)) error {
+	tx, err := u.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback(context.WithoutCancel(ctx))
+	}()
+
+	commit, err := applySavepoints(ctx, tx, n, atomic, apply, fail)
+	if err != nil || !commit {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// applySavepoints applies each of n items inside its own savepoint of tx.
+// In best-effort mode a failing item is rolled back to its savepoint and the
+// rest still apply. In atomic mode the first failure stops the batch, every
+// other item is reported as aborted, and false tells the caller to roll the
+// whole transaction back.
+func applySavepoints(ctx context.Context, tx pgx.Tx, n int, atomic bool, apply func(tx pgx.Tx, i int) error, fail func(i int, err error)) (bool, error) {
+	for i := 0; i < n; i++ {
+		savepoint, err := tx.Begin(ctx)
+		if err != nil {
+			return false, err
+		}
+
+		if err := apply(savepoint, i); err != nil {
+			_ = savepoint.Rollback(ctx)
+			fail(i, err)
+			if atomic {
+				for j := 0; j < n; j++ {
+					if j != i {
+						fail(j, domain.ErrBatchAborted)
+					}
+				}
+				return false, nil
+			}
+			continue
+		}
+
+		if err := savepoint.Commit(ctx); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+func isForeignKeyViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23503"
+}
+
+func userFilterClause(filter *domain.UserFilter) (string, []any) {
+	clauses := []string{"deleted_at IS NULL"}
+	var args []any
+	if filter == nil || !filter.IncludeServiceAccounts {
+		clauses = append(clauses, "kind = 'human'")
+	}
+	if filter == nil {
+		return strings.Join(clauses, " AND "), args
+	}
+
+	if filter.RoleID > 0 {
+		args = append(args, filter.RoleID)
+		clauses = append(clauses, fmt.Sprintf("role_id = $%d", len(args)))
+	}
+	if filter.Status != "" {
+		args = append(args, filter.Status)
+		clauses = append(clauses, fmt.Sprintf("status = $%d", len(args)))
+	}
+	if filter.Query != "" {
+		args = append(args, "%"+likeEscaper.Replace(filter.Query)+"%")
+		clauses = append(clauses, fmt.Sprintf("(name ILIKE $%d OR email ILIKE $%d)", len(args), len(args)))
+	}
+	for _, match := range []struct {
+		column, value string
+		prefix        bool
+	}{
+		{"email", filter.Email, false},
+		{"email", filter.EmailPrefix, true},
+		{"external_id", filter.ExternalID, false},
+		{"external_id", filter.ExternalIDPrefix, true},
+	} {
+		if match.value == "" {
+			continue
+		}
+		if match.prefix {
+			args = append(args, likeEscaper.Replace(strings.ToLower(match.value))+"%")
+			clauses = append(clauses, fmt.Sprintf("LOWER(%s) LIKE $%d", match.column, len(args)))
+		} else {
+			args = append(args, strings.ToLower(match.value))
+			clauses = append(clauses, fmt.Sprintf("LOWER(%s) = $%d", match.column, len(args)))
+		}
+	}
+	return strings.Join(clauses, " AND "), args
+}
+
+// likeEscaper escapes the LIKE wildcards so a value matches literally.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// userUpdatedEvents describes an update of user, adding RoleChanged when
+// the role differs from previousRoleID.
+func userUpdatedEvents(user *domain.User, previousRoleID int) []*domain.Event {
+	events := []*domain.Event{domain.NewUserEvent(domain.EventUserUpdated, user)}
+	if previousRoleID != 0 && previousRoleID != user.RoleID {
+		events = append(events, domain.NewRoleChangedEvent(user, previousRoleID))
+	}
+	return events
+}
+
+// execVersioned runs a single-row write guarded by id and version inside a
+// transaction, together with the outbox event describing it. The query must
+// return the user columns. deleted tells whether the target row is expected
+// to be soft-deleted.
+func (u *userRepository) execVersioned(ctx context.Context, query string, id, version int, deleted bool, event func(*domain.User) *domain.Event) error {
+	tx, err := u.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	user := new(domain.User)
+	err = pgxscan.Get(ctx, tx, user, query, id, version)
+	if pgxscan.NotFound(err) {
+		err = u.conflictOrNotFound(ctx, tx, id, deleted)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = insertEvents(ctx, tx, event(user)); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// conflictOrNotFound tells apart a missing user from a stale version after a
+// versioned write matched no rows.
+func (u *userRepository) conflictOrNotFound(ctx context.Context, tx pgx.Tx, id int, deleted bool) error {
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND (deleted_at IS NOT NULL) = $2)`
+	if err := tx.QueryRow(ctx, query, id, deleted).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return domain.ErrUserNotFound
+	}
+	return domain.ErrVersionConflict
+}
+
+func (u *userRepository) LinkDirectory(ctx context.Context, id int, dn string) error {
+	query := `UPDATE users SET directory_dn = $1 WHERE id = $2 AND deleted_at IS NULL`
+	tag, err := u.pool.Exec(ctx, query, dn, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrUserNotFound
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"tablelink/internal/domain"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestUserFilterClause(t *testing.T) {
+	tests := []struct {
+		name      string
+		filter    *domain.UserFilter
+		wantWhere string
+		wantArgs  []any
+	}{
+		{
+			name:      "excludes service accounts by default",
+			wantWhere: "deleted_at IS NULL AND kind = 'human'",
+		},
+		{
+			name:      "matches email and external id case insensitively",
+			filter:    &domain.UserFilter{Email: "Ada@Example.org", ExternalID: "OKTA-1"},
+			wantWhere: "deleted_at IS NULL AND kind = 'human' AND LOWER(email) = $1 AND LOWER(external_id) = $2",
+			wantArgs:  []any{"ada@example.org", "okta-1"},
+		},
+		{
+			name:      "escapes wildcards in prefixes",
+			filter:    &domain.UserFilter{RoleID: 2, EmailPrefix: `a_b%c\`, IncludeServiceAccounts: true},
+			wantWhere: "deleted_at IS NULL AND role_id = $1 AND LOWER(email) LIKE $2",
+			wantArgs:  []any{2, `a\_b\%c\\%`},
+		},
+		{
+			name:      "escapes wildcards in the search query",
+			filter:    &domain.UserFilter{Query: "100%_done"},
+			wantWhere: "deleted_at IS NULL AND kind = 'human' AND (name ILIKE $1 OR email ILIKE $1)",
+			wantArgs:  []any{`%100\%\_done%`},
+		},
+		{
+			name:      "matches an external id prefix",
+			filter:    &domain.UserFilter{ExternalIDPrefix: "okta"},
+			wantWhere: "deleted_at IS NULL AND kind = 'human' AND LOWER(external_id) LIKE $1",
+			wantArgs:  []any{"okta%"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			where, args := userFilterClause(tt.filter)
+			if where != tt.wantWhere {
+				t.Errorf("where = %q, want %q", where, tt.wantWhere)
+			}
+			if !slices.Equal(args, tt.wantArgs) {
+				t.Errorf("args = %q, want %q", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+// fakeTx records what happens to the savepoints begun on it.
+type fakeTx struct {
+	pgx.Tx
+	name string
+	log  *[]string
+	next *int
+}
+
+func (f *fakeTx) Begin(ctx context.Context) (pgx.Tx, error) {
+	*f.next++
+	return &fakeTx{name: fmt.Sprintf("sp%d", *f.next), log: f.log, next: f.next}, nil
+}
+
+func (f *fakeTx) Commit(ctx context.Context) error {
+	*f.log = append(*f.log, "release "+f.name)
+	return nil
+}
+
+func (f *fakeTx) Rollback(ctx context.Context) error {
+	*f.log = append(*f.log, "rollback "+f.name)
+	return nil
+}
+
+func TestApplySavepoints(t *testing.T) {
+	errFailed := errors.New("failed")
+	tests := []struct {
+		name       string
+		atomic     bool
+		failing    int
+		wantCommit bool
+		wantLog    []string
+		wantErrs   []error
+	}{
+		{
+			name:       "applies every item",
+			failing:    -1,
+			wantCommit: true,
+			wantLog:    []string{"release sp1", "release sp2", "release sp3"},
+			wantErrs:   []error{nil, nil, nil},
+		},
+		{
+			name:       "rolls back only the failing item",
+			failing:    1,
+			wantCommit: true,
+			wantLog:    []string{"release sp1", "rollback sp2", "release sp3"},
+			wantErrs:   []error{nil, errFailed, nil},
+		},
+		{
+			name:     "aborts every item of an atomic batch",
+			atomic:   true,
+			failing:  1,
+			wantLog:  []string{"release sp1", "rollback sp2"},
+			wantErrs: []error{domain.ErrBatchAborted, errFailed, domain.ErrBatchAborted},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var log []string
+			tx := &fakeTx{name: "tx", log: &log, next: new(int)}
+			errs := make([]error, 3)
+			commit, err := applySavepoints(context.Background(), tx, 3, tt.atomic, func(tx pgx.Tx, i int) error {
+				if i == tt.failing {
+					return errFailed
+				}
+				return nil
+			}, func(i int, err error) {
+				errs[i] = err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if commit != tt.wantCommit {
+				t.Errorf("commit = %t, want %t", commit, tt.wantCommit)
+			}
+			if !slices.Equal(log, tt.wantLog) {
+				t.Errorf("savepoints = %q, want %q", log, tt.wantLog)
+			}
+			if !slices.Equal(errs, tt.wantErrs) {
+				t.Errorf("errs = %v, want %v", errs, tt.wantErrs)
+			}
+		})
+	}
+}
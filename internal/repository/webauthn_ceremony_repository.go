@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"tablelink/internal/domain"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// WebAuthnCeremonyRepository keeps WebAuthn registrations and logins in
+// flight, keyed by an id handed to the client with the challenge.
+type WebAuthnCeremonyRepository interface {
+	Save(ctx context.Context, id string, ceremony *domain.WebAuthnCeremony, ttl time.Duration) error
+	// Consume returns and removes the ceremony, failing with
+	// domain.ErrWebAuthnCeremony when it is unknown or expired.
+	Consume(ctx context.Context, id string) (*domain.WebAuthnCeremony, error)
+}
+
+type webAuthnCeremonyRepository struct {
+	redis *redis.Client
+}
+
+func NewWebAuthnCeremonyRepository(redis *redis.Client) WebAuthnCeremonyRepository {
+	return &webAuthnCeremonyRepository{
+		redis: redis,
+	}
+}
+
+func webAuthnCeremonyKey(id string) string {
+	return "webauthn_ceremony:" + id
+}
+
+func (w *webAuthnCeremonyRepository) Save(ctx context.Context, id string, ceremony *domain.WebAuthnCeremony, ttl time.Duration) error {
+	payload, err := json.Marshal(ceremony)
+	if err != nil {
+		return err
+	}
+	return w.redis.Set(ctx, webAuthnCeremonyKey(id), payload, ttl).Err()
+}
+
+func (w *webAuthnCeremonyRepository) Consume(ctx context.Context, id string) (*domain.WebAuthnCeremony, error) {
+	payload, err := w.redis.GetDel(ctx, webAuthnCeremonyKey(id)).Bytes()
+	if err == redis.Nil {
+		return nil, domain.ErrWebAuthnCeremony
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ceremony := new(domain.WebAuthnCeremony)
+	if err := json.Unmarshal(payload, ceremony); err != nil {
+		return nil, err
+	}
+	return ceremony, nil
+}
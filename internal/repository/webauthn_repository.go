@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"context"
+	"tablelink/internal/domain"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const webAuthnColumns = `id, user_id, name, credential_id, public_key, attestation_type, transports, aaguid, sign_count,
+	backup_eligible, backup_state, created_at, last_used_at`
+
+type WebAuthnRepository interface {
+	Create(ctx context.Context, credential *domain.WebAuthnCredential) (*domain.WebAuthnCredential, error)
+	ListByUser(ctx context.Context, userID int) ([]*domain.WebAuthnCredential, error)
+	Delete(ctx context.Context, userID, id int) error
+	// RecordUse stores the counter and backup state of a successful
+	// login. It fails with domain.ErrWebAuthnSignCount when the counter did
+	// not grow, which also stops two logins racing with the same value.
+	RecordUse(ctx context.Context, id int, signCount int64, backupState bool) error
+}
+
+type webAuthnRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewWebAuthnRepository(pool *pgxpool.Pool) WebAuthnRepository {
+	return &webAuthnRepository{
+		pool: pool,
+	}
+}
+
+func (w *webAuthnRepository) Create(ctx context.Context, credential *domain.WebAuthnCredential) (*domain.WebAuthnCredential, error) {
+	created := new(domain.WebAuthnCredential)
+	query := `
+	INSERT INTO webauthn_credentials (user_id, name, credential_id, public_key, attestation_type, transports, aaguid,
+		sign_count, backup_eligible, backup_state)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	RETURNING ` + webAuthnColumns
+	err := pgxscan.Get(ctx, w.pool, created, query, credential.UserID, credential.Name, credential.CredentialID,
+		credential.PublicKey, credential.AttestationType, nonNil(credential.Transports), credential.AAGUID,
+		credential.SignCount, credential.BackupEligible, credential.BackupState)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, domain.ErrWebAuthnCredentialExists
+		}
+		if isForeignKeyViolation(err) {
+			return nil, domain.ErrUserNotFound
+		}
+		return nil, err
+	}
+	return created, nil
+}
+
+func (w *webAuthnRepository) ListByUser(ctx context.Context, userID int) ([]*domain.WebAuthnCredential, error) {
+	var credentials []*domain.WebAuthnCredential
+	query := "SELECT " + webAuthnColumns + " FROM webauthn_credentials WHERE user_id = $1 ORDER BY id"
+	if err := pgxscan.Select(ctx, w.pool, &credentials, query, userID); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+func (w *webAuthnRepository) Delete(ctx context.Context, userID, id int) error {
+	tag, err := w.pool.Exec(ctx, `DELETE FROM webauthn_credentials WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrWebAuthnCredentialNotFound
+	}
+	return nil
+}
+
+func (w *webAuthnRepository) RecordUse(ctx context.Context, id int, signCount int64, backupState bool) error {
+	query := `
+	UPDATE webauthn_credentials SET sign_count = $2, backup_state = $3, last_used_at = NOW()
+	WHERE id = $1 AND (sign_count < $2 OR (sign_count = 0 AND $2 = 0))`
+	tag, err := w.pool.Exec(ctx, query, id, signCount, backupState)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrWebAuthnSignCount
+	}
+	return nil
+}
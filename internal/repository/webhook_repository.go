@@ -0,0 +1,217 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"tablelink/internal/domain"
+	"time"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const webhookColumns = "id, url, event_types, secret, active, created_at, updated_at"
+
+const webhookDeliveryColumns = `id, subscription_id, event_id, event_type, payload, status, attempts,
+	next_attempt_at, last_status_code, last_error, created_at, delivered_at`
+
+type WebhookRepository interface {
+	Create(ctx context.Context, sub *domain.WebhookSubscription) (*domain.WebhookSubscription, error)
+	GetByID(ctx context.Context, id int) (*domain.WebhookSubscription, error)
+	List(ctx context.Context) ([]*domain.WebhookSubscription, error)
+	Update(ctx context.Context, sub *domain.WebhookSubscription) (*domain.WebhookSubscription, error)
+	Delete(ctx context.Context, id int) error
+
+	// Enqueue creates a pending delivery of event for every active
+	// subscription that wants it. Enqueuing the same event twice is a no-op.
+	Enqueue(ctx context.Context, event *domain.Event) error
+	// ClaimDue returns up to limit pending deliveries that are due and
+	// pushes their next attempt lease into the future, so other workers
+	// skip them while they are being sent.
+	ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]*domain.WebhookDelivery, error)
+	// RecordAttempt appends attempt to the delivery log and moves the
+	// delivery to status, retrying at nextAttemptAt when still pending.
+	RecordAttempt(ctx context.Context, attempt *domain.WebhookAttempt, status domain.WebhookDeliveryStatus, nextAttemptAt time.Time) error
+	ListDeliveries(ctx context.Context, filter *domain.WebhookDeliveryFilter) ([]*domain.WebhookDelivery, error)
+	// Redeliver moves a dead delivery back to pending with a fresh set of
+	// attempts.
+	Redeliver(ctx context.Context, subscriptionID int, deliveryID int64) error
+}
+
+type webhookRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewWebhookRepository(pool *pgxpool.Pool) WebhookRepository {
+	return &webhookRepository{
+		pool: pool,
+	}
+}
+
+func (w *webhookRepository) Create(ctx context.Context, sub *domain.WebhookSubscription) (*domain.WebhookSubscription, error) {
+	created := new(domain.WebhookSubscription)
+	query := `
+	INSERT INTO webhook_subscriptions (url, event_types, secret, active)
+	VALUES ($1, $2, $3, $4)
+	RETURNING ` + webhookColumns
+	if err := pgxscan.Get(ctx, w.pool, created, query, sub.URL, sub.EventTypes, sub.Secret, sub.Active); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func (w *webhookRepository) GetByID(ctx context.Context, id int) (*domain.WebhookSubscription, error) {
+	sub := new(domain.WebhookSubscription)
+	query := "SELECT " + webhookColumns + " FROM webhook_subscriptions WHERE id = $1"
+	if err := pgxscan.Get(ctx, w.pool, sub, query, id); err != nil {
+		if pgxscan.NotFound(err) {
+			return nil, domain.ErrWebhookNotFound
+		}
+		return nil, err
+	}
+	return sub, nil
+}
+
+func (w *webhookRepository) List(ctx context.Context) ([]*domain.WebhookSubscription, error) {
+	var subs []*domain.WebhookSubscription
+	query := "SELECT " + webhookColumns + " FROM webhook_subscriptions ORDER BY id"
+	if err := pgxscan.Select(ctx, w.pool, &subs, query); err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
+
+func (w *webhookRepository) Update(ctx context.Context, sub *domain.WebhookSubscription) (*domain.WebhookSubscription, error) {
+	updated := new(domain.WebhookSubscription)
+	query := `
+	UPDATE webhook_subscriptions SET url = $1, event_types = $2, secret = $3, active = $4, updated_at = NOW()
+	WHERE id = $5
+	RETURNING ` + webhookColumns
+	if err := pgxscan.Get(ctx, w.pool, updated, query, sub.URL, sub.EventTypes, sub.Secret, sub.Active, sub.ID); err != nil {
+		if pgxscan.NotFound(err) {
+			return nil, domain.ErrWebhookNotFound
+		}
+		return nil, err
+	}
+	return updated, nil
+}
+
+func (w *webhookRepository) Delete(ctx context.Context, id int) error {
+	tag, err := w.pool.Exec(ctx, `DELETE FROM webhook_subscriptions WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrWebhookNotFound
+	}
+	return nil
+}
+
+func (w *webhookRepository) Enqueue(ctx context.Context, event *domain.Event) error {
+	query := `
+	INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, payload)
+	SELECT id, $1, $2, $3 FROM webhook_subscriptions
+	WHERE active AND ($2 = ANY(event_types) OR $4 = ANY(event_types))
+	ON CONFLICT (subscription_id, event_id) DO NOTHING`
+	_, err := w.pool.Exec(ctx, query, event.ID, string(event.Type), event.Payload, domain.WebhookAllEvents)
+	return err
+}
+
+func (w *webhookRepository) ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]*domain.WebhookDelivery, error) {
+	var deliveries []*domain.WebhookDelivery
+	query := `
+	WITH due AS (
+		SELECT id FROM webhook_deliveries
+		WHERE status = 'pending' AND next_attempt_at <= NOW()
+		ORDER BY next_attempt_at, id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	), claimed AS (
+		UPDATE webhook_deliveries d SET next_attempt_at = NOW() + $2 * INTERVAL '1 millisecond'
+		FROM due WHERE d.id = due.id
+		RETURNING d.*
+	)
+	SELECT claimed.*, s.url, s.secret
+	FROM claimed JOIN webhook_subscriptions s ON s.id = claimed.subscription_id
+	ORDER BY claimed.id`
+	if err := pgxscan.Select(ctx, w.pool, &deliveries, query, limit, lease.Milliseconds()); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+func (w *webhookRepository) RecordAttempt(ctx context.Context, attempt *domain.WebhookAttempt, status domain.WebhookDeliveryStatus, nextAttemptAt time.Time) error {
+	tx, err := w.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	query := `
+	INSERT INTO webhook_delivery_attempts (delivery_id, attempt, status_code, error, duration_ms)
+	VALUES ($1, $2, $3, $4, $5)`
+	_, err = tx.Exec(ctx, query, attempt.DeliveryID, attempt.Attempt, attempt.StatusCode, attempt.Error, attempt.DurationMs)
+	if err != nil {
+		return err
+	}
+
+	query = `
+	UPDATE webhook_deliveries SET status = $1, attempts = $2, last_status_code = $3, last_error = $4,
+	next_attempt_at = $5, delivered_at = CASE WHEN $1 = 'succeeded' THEN NOW() END
+	WHERE id = $6`
+	_, err = tx.Exec(ctx, query, status, attempt.Attempt, attempt.StatusCode, attempt.Error, nextAttemptAt, attempt.DeliveryID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (w *webhookRepository) ListDeliveries(ctx context.Context, filter *domain.WebhookDeliveryFilter) ([]*domain.WebhookDelivery, error) {
+	var deliveries []*domain.WebhookDelivery
+	query := "SELECT " + webhookDeliveryColumns + ` FROM webhook_deliveries
+	WHERE subscription_id = $1 AND ($2 = '' OR status = $2)
+	ORDER BY id DESC LIMIT $3`
+	err := pgxscan.Select(ctx, w.pool, &deliveries, query, filter.SubscriptionID, string(filter.Status), filter.Limit)
+	if err != nil || len(deliveries) == 0 {
+		return deliveries, err
+	}
+
+	ids := make([]int64, 0, len(deliveries))
+	byID := make(map[int64]*domain.WebhookDelivery, len(deliveries))
+	for _, d := range deliveries {
+		ids = append(ids, d.ID)
+		byID[d.ID] = d
+	}
+
+	var attempts []*domain.WebhookAttempt
+	query = `
+	SELECT delivery_id, attempt, status_code, error, duration_ms, attempted_at
+	FROM webhook_delivery_attempts WHERE delivery_id = ANY($1)
+	ORDER BY delivery_id, id`
+	if err := pgxscan.Select(ctx, w.pool, &attempts, query, ids); err != nil {
+		return nil, err
+	}
+	for _, a := range attempts {
+		byID[a.DeliveryID].AttemptLog = append(byID[a.DeliveryID].AttemptLog, a)
+	}
+
+	return deliveries, nil
+}
+
+func (w *webhookRepository) Redeliver(ctx context.Context, subscriptionID int, deliveryID int64) error {
+	query := `
+	UPDATE webhook_deliveries SET status = 'pending', attempts = 0, next_attempt_at = NOW()
+	WHERE id = $1 AND subscription_id = $2 AND status = 'dead'
+	RETURNING id`
+	err := w.pool.QueryRow(ctx, query, deliveryID, subscriptionID).Scan(&deliveryID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return domain.ErrWebhookNotDead
+	}
+	return err
+}
@@ -0,0 +1,161 @@
+package token
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+)
+
+// Signer signs and verifies RS256 JSON Web Tokens, such as OpenID Connect
+// ID tokens, and publishes its public key as a JWK set.
+type Signer struct {
+	key *rsa.PrivateKey
+	kid string
+}
+
+func NewSigner(key *rsa.PrivateKey) *Signer {
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	sum := sha256.Sum256(der)
+	return &Signer{
+		key: key,
+		kid: base64.RawURLEncoding.EncodeToString(sum[:12]),
+	}
+}
+
+// LoadSigner reads a PEM encoded RSA private key, in PKCS#1 or PKCS#8 form.
+// Without a path it generates a key, which only lasts until the process
+// exits, so tokens it signed stop verifying after a restart.
+func LoadSigner(path string) (*Signer, error) {
+	if path == "" {
+		log.Printf("No signing key configured, generating a temporary one")
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to generate signing key with err %v", err)
+		}
+		return NewSigner(key), nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, fmt.Errorf("Failed to decode signing key %s: no PEM block", path)
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return NewSigner(key), nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse signing key %s with err %v", path, err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("Failed to parse signing key %s: not an RSA key", path)
+	}
+	return NewSigner(key), nil
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+func (s *Signer) Sign(claims any) (string, error) {
+	header, err := json.Marshal(&jwtHeader{Alg: "RS256", Kid: s.kid, Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("Failed to sign token with err %v", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Verify checks the signature of a token signed by s and decodes its claims.
+// Expiry and audience are left to the caller.
+func (s *Signer) Verify(token string, claims any) error {
+	return VerifyJWT(token, func(kid string) (*rsa.PublicKey, error) {
+		if kid != s.kid {
+			return nil, ErrInvalidToken
+		}
+		return &s.key.PublicKey, nil
+	}, claims)
+}
+
+// VerifyJWT checks the RS256 signature of token with the key keyFor returns
+// for its kid and decodes its claims. Other algorithms are rejected.
+func VerifyJWT(token string, keyFor func(kid string) (*rsa.PublicKey, error), claims any) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ErrInvalidToken
+	}
+
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return ErrInvalidToken
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(rawHeader, &header); err != nil || header.Alg != "RS256" {
+		return ErrInvalidToken
+	}
+	key, err := keyFor(header.Kid)
+	if err != nil {
+		return err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return ErrInvalidToken
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ErrInvalidToken
+	}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return errors.Join(ErrInvalidToken, err)
+	}
+	return nil
+}
+
+// JWKS returns the public key as a JSON Web Key Set (RFC 7517).
+func (s *Signer) JWKS() map[string]any {
+	pub := s.key.PublicKey
+	return map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": s.kid,
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	}
+}
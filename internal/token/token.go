@@ -0,0 +1,146 @@
+package token
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"tablelink/internal/repository"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Purpose string
+
+const (
+	PurposeInvite      Purpose = "invite"
+	PurposeVerifyEmail Purpose = "verify_email"
+	PurposeChangeEmail Purpose = "change_email"
+	PurposeMagicLink   Purpose = "magic_link"
+	// PurposeSecondFactor is held between a password login and the passkey
+	// that completes it.
+	PurposeSecondFactor Purpose = "second_factor"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid or expired token")
+	ErrTokenUsed    = errors.New("token has already been used")
+)
+
+type Claims struct {
+	ID        string  `json:"jti"`
+	Purpose   Purpose `json:"purpose"`
+	UserID    int     `json:"sub"`
+	Email     string  `json:"email,omitempty"`
+	ExpiresAt int64   `json:"exp"`
+	// Binding is set on tokens only the client holding the matching
+	// secret may use, see IssueBound.
+	Binding string `json:"bnd,omitempty"`
+}
+
+// Issuer signs single-use tokens with HMAC-SHA256 and tracks them in the
+// token repository so each one can be consumed only once.
+type Issuer struct {
+	secret    []byte
+	tokenRepo repository.TokenRepository
+}
+
+func NewIssuer(secret string, tokenRepo repository.TokenRepository) *Issuer {
+	return &Issuer{
+		secret:    []byte(secret),
+		tokenRepo: tokenRepo,
+	}
+}
+
+func (i *Issuer) Issue(ctx context.Context, purpose Purpose, userID int, email string, ttl time.Duration) (string, error) {
+	return i.issue(ctx, purpose, userID, email, "", ttl)
+}
+
+// IssueBound issues a token bound to a client by binding, a digest of a
+// secret that client keeps. Whoever consumes the token must check it with
+// Claims.BoundTo, so a token that leaks on its own is of no use.
+func (i *Issuer) IssueBound(ctx context.Context, purpose Purpose, userID int, email, binding string, ttl time.Duration) (string, error) {
+	return i.issue(ctx, purpose, userID, email, binding, ttl)
+}
+
+func (i *Issuer) issue(ctx context.Context, purpose Purpose, userID int, email, binding string, ttl time.Duration) (string, error) {
+	claims := &Claims{
+		ID:        uuid.NewString(),
+		Purpose:   purpose,
+		UserID:    userID,
+		Email:     email,
+		Binding:   binding,
+		ExpiresAt: time.Now().Add(ttl).Unix(),
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	if err := i.tokenRepo.Save(ctx, claims.ID, ttl); err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + i.sign(encoded), nil
+}
+
+// Consume verifies the signature, purpose and expiry of the token and marks
+// it as used.
+func (i *Issuer) Consume(ctx context.Context, token string, purposes ...Purpose) (*Claims, error) {
+	claims, err := i.Verify(token, purposes...)
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := i.tokenRepo.Consume(ctx, claims.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrTokenUsed
+	}
+
+	return claims, nil
+}
+
+// Verify checks the token without consuming it. The token must have been
+// issued for one of the given purposes.
+func (i *Issuer) Verify(token string, purposes ...Purpose) (*Claims, error) {
+	encoded, signature, found := strings.Cut(token, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(i.sign(encoded))) {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	claims := new(Claims)
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if !slices.Contains(purposes, claims.Purpose) || time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+// BoundTo reports whether the token was bound to binding.
+func (c *Claims) BoundTo(binding string) bool {
+	return c.Binding != "" && hmac.Equal([]byte(c.Binding), []byte(binding))
+}
+
+func (i *Issuer) sign(encoded string) string {
+	mac := hmac.New(sha256.New, i.secret)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,161 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"tablelink/internal/domain"
+	"tablelink/internal/repository"
+	"time"
+)
+
+// apiKeyPrefixBytes and apiKeySecretBytes size the two random parts of an
+// API key: tlk_<prefix>_<secret>.
+const (
+	apiKeyPrefixBytes = 6
+	apiKeySecretBytes = 32
+)
+
+type APIKeyUseCase interface {
+	// CreateAPIKey also returns the key itself, which is not stored and so
+	// cannot be shown again.
+	CreateAPIKey(ctx context.Context, roleID int, section, route string, key *domain.APIKey) (*domain.APIKey, string, error)
+	ListAPIKeys(ctx context.Context, roleID int, section, route string) ([]*domain.APIKey, error)
+	RevokeAPIKey(ctx context.Context, roleID int, section, route string, id int) error
+}
+
+type apiKeyUseCase struct {
+	apiKeyRepo repository.APIKeyRepository
+	rightRepo  repository.RoleRightRepository
+}
+
+func NewAPIKeyUseCase(apiKeyRepo repository.APIKeyRepository, rightRepo repository.RoleRightRepository) APIKeyUseCase {
+	return &apiKeyUseCase{
+		apiKeyRepo: apiKeyRepo,
+		rightRepo:  rightRepo,
+	}
+}
+
+func (u *apiKeyUseCase) CreateAPIKey(ctx context.Context, roleID int, section, route string, key *domain.APIKey) (*domain.APIKey, string, error) {
+	if err := authorize(ctx, u.rightRepo, roleID, section, route, "create"); err != nil {
+		return nil, "", err
+	}
+	creator, err := keyCreator(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	key.Name = strings.TrimSpace(key.Name)
+	if key.Name == "" {
+		return nil, "", domain.ErrInvalidAPIKeyName
+	}
+	if key.ExpiresAt != nil && !key.ExpiresAt.After(time.Now()) {
+		return nil, "", domain.ErrInvalidExpiry
+	}
+	if err := domain.ValidateAllowedIPs(key.AllowedIPs); err != nil {
+		return nil, "", err
+	}
+	// A key is a way to act with its role, so nobody may mint one for a
+	// role that could do more than they can.
+	if err := roleWithin(ctx, u.rightRepo, key.RoleID, roleID); err != nil {
+		return nil, "", err
+	}
+
+	prefix, secret, err := newAPIKey()
+	if err != nil {
+		return nil, "", err
+	}
+	raw := domain.APIKeyPrefix + prefix + "_" + secret
+	key.Prefix = prefix
+	key.KeyHash = hashOpaqueToken(raw)
+	key.CreatedBy = &creator.UserID
+
+	created, err := u.apiKeyRepo.Create(ctx, key)
+	if err != nil {
+		return nil, "", err
+	}
+	return created, raw, nil
+}
+
+func (u *apiKeyUseCase) ListAPIKeys(ctx context.Context, roleID int, section, route string) ([]*domain.APIKey, error) {
+	if err := authorize(ctx, u.rightRepo, roleID, section, route, "read"); err != nil {
+		return nil, err
+	}
+
+	return u.apiKeyRepo.List(ctx)
+}
+
+func (u *apiKeyUseCase) RevokeAPIKey(ctx context.Context, roleID int, section, route string, id int) error {
+	if err := authorize(ctx, u.rightRepo, roleID, section, route, "delete"); err != nil {
+		return err
+	}
+
+	return u.apiKeyRepo.Revoke(ctx, id)
+}
+
+// keyCreator returns the user creating a key or credential. Keys and
+// credentials cannot mint others: a new one would not inherit the
+// caller's IP allowlist or expiry, so those limits could be shed. Nor can
+// an impersonator create one that outlives the impersonation.
+func keyCreator(ctx context.Context) (*domain.Principal, error) {
+	p := domain.PrincipalFromContext(ctx)
+	if p == nil || p.Kind != domain.PrincipalUser || p.ImpersonatorID != 0 {
+		return nil, domain.ErrKeyCreatorNotUser
+	}
+	return p, nil
+}
+
+func newAPIKey() (string, string, error) {
+	prefix := make([]byte, apiKeyPrefixBytes)
+	if _, err := rand.Read(prefix); err != nil {
+		return "", "", fmt.Errorf("Failed to generate api key with err %v", err)
+	}
+	secret, err := randomOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(prefix), secret, nil
+}
+
+// splitKey returns the lookup prefix of a key in the form
+// <kind>_<prefix>_<secret>, where kindPrefix is "<kind>_".
+func splitKey(raw, kindPrefix string) (string, bool) {
+	rest, ok := strings.CutPrefix(raw, kindPrefix)
+	if !ok || len(rest) < 2*apiKeyPrefixBytes+1 || rest[2*apiKeyPrefixBytes] != '_' {
+		return "", false
+	}
+	return rest[:2*apiKeyPrefixBytes], true
+}
+
+// roleWithin fails with domain.ErrRoleEscalation unless callerRoleID holds
+// every right roleID has.
+func roleWithin(ctx context.Context, rightRepo repository.RoleRightRepository, roleID, callerRoleID int) error {
+	if roleID == callerRoleID {
+		return nil
+	}
+
+	granted, err := rightRepo.ListByRole(ctx, roleID)
+	if err != nil {
+		return fmt.Errorf("Failed to list role rights with err %v", err)
+	}
+	held, err := rightRepo.ListByRole(ctx, callerRoleID)
+	if err != nil {
+		return fmt.Errorf("Failed to list role rights with err %v", err)
+	}
+
+	byRoute := make(map[string]*domain.RoleRight, len(held))
+	for _, r := range held {
+		byRoute[r.Section+"\x00"+r.Route] = r
+	}
+	for _, r := range granted {
+		h := byRoute[r.Section+"\x00"+r.Route]
+		for _, action := range []string{"create", "read", "update", "delete"} {
+			if r.Allows(action) && (h == nil || !h.Allows(action)) {
+				return domain.ErrRoleEscalation
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"tablelink/internal/domain"
+	"tablelink/internal/token"
+)
+
+// RequestMagicLink emails a login link to email and returns the client
+// token that must accompany the link when it is used. The answer is the
+// same whether or not the address belongs to a user who can log in, so it
+// cannot be used to find out who has an account.
+func (u *authUseCase) RequestMagicLink(ctx context.Context, email string) (string, error) {
+	if !u.cfg.MagicLinkEnabled {
+		return "", domain.ErrMagicLinkDisabled
+	}
+
+	email = strings.TrimSpace(email)
+	if _, err := mail.ParseAddress(email); err != nil {
+		return "", domain.ErrInvalidEmail
+	}
+	allowed, err := u.rateLimitRepository.Allow(ctx, "magic_link:"+strings.ToLower(email), u.cfg.MagicLinkRateLimit, u.cfg.MagicLinkRateWindow)
+	if err != nil {
+		return "", fmt.Errorf("Failed to check magic link rate limit with err %v", err)
+	}
+	if !allowed {
+		return "", domain.ErrMagicLinkRateLimited
+	}
+
+	clientToken, err := randomOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	user, err := u.userRepository.GetByEmail(ctx, email)
+	if errors.Is(err, domain.ErrUserNotFound) {
+		return clientToken, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if user.Kind == domain.UserKindService || !user.Status.CanLogin() {
+		return clientToken, nil
+	}
+
+	linkToken, err := u.issuer.IssueBound(ctx, token.PurposeMagicLink, user.ID, user.Email, hashOpaqueToken(clientToken), u.cfg.MagicLinkTTL)
+	if err != nil {
+		return "", fmt.Errorf("Failed to issue magic link token with err %v", err)
+	}
+	link := tokenLink(u.cfg.MagicLinkURL, linkToken)
+	if err := sendMagicLinkEmail(ctx, u.mailer, user.Email, user.Name, link, u.cfg.MagicLinkTTL); err != nil {
+		return "", fmt.Errorf("Failed to send magic link with err %v", err)
+	}
+	return clientToken, nil
+}
+
+// ConsumeMagicLink logs in with the token from a magic link and the client
+// token returned when it was requested.
+func (u *authUseCase) ConsumeMagicLink(ctx context.Context, magicLinkToken, clientToken string) (string, error) {
+	if !u.cfg.MagicLinkEnabled {
+		return "", domain.ErrMagicLinkDisabled
+	}
+
+	// The binding is checked before the token is used up, so someone who
+	// only got hold of the link cannot spend it for the user.
+	claims, err := u.issuer.Verify(magicLinkToken, token.PurposeMagicLink)
+	if err != nil {
+		return "", err
+	}
+	if !claims.BoundTo(hashOpaqueToken(clientToken)) {
+		return "", domain.ErrMagicLinkWrongClient
+	}
+	if claims, err = u.issuer.Consume(ctx, magicLinkToken, token.PurposeMagicLink); err != nil {
+		return "", err
+	}
+
+	user, err := u.userRepository.GetByID(ctx, claims.UserID)
+	if err != nil {
+		return "", err
+	}
+	if user.Email != claims.Email {
+		return "", token.ErrInvalidToken
+	}
+	if !user.Status.CanLogin() {
+		return "", domain.ErrUserNotActive
+	}
+
+	// Opening the link proves the address is the user's.
+	if user.EmailVerifiedAt == nil {
+		if err := u.userRepository.MarkEmailVerified(ctx, user.ID, user.Email); err != nil {
+			return "", err
+		}
+	}
+
+	return u.firstFactorSession(ctx, user, domain.AuthMethodMagicLink)
+}
@@ -0,0 +1,54 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"tablelink/internal/domain"
+	"tablelink/internal/repository"
+	"time"
+)
+
+func (u *authUseCase) Reauthenticate(ctx context.Context, accessToken, password string) error {
+	session, err := u.sessionRepository.Get(ctx, accessToken)
+	if err != nil {
+		return domain.ErrInvalidSession
+	}
+	// An impersonator cannot vouch for the user they act as.
+	if session.ImpersonatorID != 0 {
+		return domain.ErrPermissionDenied
+	}
+
+	user, err := u.authenticator.Authenticate(ctx, session.Email, password)
+	if err != nil {
+		return err
+	}
+	if user.ID != session.UserID {
+		return domain.ErrInvalidCredentials
+	}
+	if !user.Status.CanLogin() {
+		return domain.ErrUserNotActive
+	}
+
+	if err := u.requireSecondFactor(ctx, user); err != nil {
+		return err
+	}
+	return reauthenticateSession(ctx, u.sessionRepository, accessToken, session, domain.AuthMethodPassword)
+}
+
+// reauthenticateSession records that the user of session has just proven
+// who they are with method, so it can step up to sensitive operations.
+func reauthenticateSession(ctx context.Context, sessionRepo repository.SessionRepository, token string, session *domain.Session,
+	method domain.AuthMethod) error {
+	now := time.Now().UTC()
+	session.AuthTime = now
+	session.AuthMethod = method
+	session.LastAccess = now
+	if err := sessionRepo.Update(ctx, token, session); err != nil {
+		if errors.Is(err, domain.ErrInvalidSession) {
+			return err
+		}
+		return fmt.Errorf("Failed to update session with err %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"tablelink/internal/config"
+	"tablelink/internal/domain"
+	"tablelink/internal/token"
+	"testing"
+	"time"
+)
+
+func TestReauthenticate(t *testing.T) {
+	tests := []struct {
+		name           string
+		status         domain.UserStatus
+		impersonatorID int
+		hasPasskey     bool
+		wantErr        error
+	}{
+		{name: "renews the session of an active user", status: domain.UserStatusActive},
+		{name: "refuses an impersonated session", status: domain.UserStatusActive, impersonatorID: 2, wantErr: domain.ErrPermissionDenied},
+		{name: "refuses a suspended user", status: domain.UserStatusSuspended, wantErr: domain.ErrUserNotActive},
+		{name: "asks a user with a passkey for it", status: domain.UserStatusActive, hasPasskey: true, wantErr: domain.ErrSecondFactorRequired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			users := newFakeUserRepo(&domain.User{ID: 1, Name: "Ada", Email: "ada@example.org", Status: tt.status})
+			sessions := newFakeSessionRepo()
+			credentials := &fakeWebAuthnRepo{}
+			if tt.hasPasskey {
+				credentials.Create(ctx, &domain.WebAuthnCredential{UserID: 1, Name: "Laptop"})
+			}
+			uc := NewAuthUseCase(fakeAuthenticator{users}, users, sessions, &fakeOutboxRepo{}, nil, credentials,
+				token.NewIssuer("secret", newFakeTokenRepo()), nil, &config.Config{SecondFactorTTL: time.Minute})
+			sessions.Create(ctx, "token", &domain.Session{
+				UserID:         1,
+				Email:          "ada@example.org",
+				ImpersonatorID: tt.impersonatorID,
+				AuthTime:       time.Now().Add(-time.Hour),
+				AuthMethod:     domain.AuthMethodMagicLink,
+			}, time.Hour)
+
+			err := uc.Reauthenticate(ctx, "token", "password")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Reauthenticate() err = %v, want %v", err, tt.wantErr)
+			}
+			session := sessions.sessions["token"]
+			if renewed := session.AuthMethod == domain.AuthMethodPassword && time.Since(session.AuthTime) < time.Minute; renewed != (tt.wantErr == nil) {
+				t.Errorf("session = %+v, renewed %t, want %t", session, renewed, tt.wantErr == nil)
+			}
+		})
+	}
+}
@@ -3,50 +3,234 @@ package usecase
 import (
 	"context"
 	"fmt"
+	"log"
+	"tablelink/internal/config"
+	"tablelink/internal/domain"
+	"tablelink/internal/mailer"
 	"tablelink/internal/repository"
+	"tablelink/internal/token"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/redis/go-redis/v9"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type AuthUseCase interface {
 	Login(ctx context.Context, email, password string) (string, error)
 	Logout(ctx context.Context, token string) error
+	AcceptInvite(ctx context.Context, inviteToken, password string) (string, error)
+	SendVerificationEmail(ctx context.Context, accessToken string) error
+	VerifyEmail(ctx context.Context, verificationToken string) error
+	RequestMagicLink(ctx context.Context, email string) (string, error)
+	ConsumeMagicLink(ctx context.Context, magicLinkToken, clientToken string) (string, error)
+	// Reauthenticate checks the password of the session's user and renews
+	// the session's authentication time, or returns a
+	// domain.SecondFactorRequiredError when the user has a passkey.
+	Reauthenticate(ctx context.Context, accessToken, password string) error
 }
 
 type authUseCase struct {
-	userRepository repository.UserRepository
-	redis          redis.Client
+	authenticator       Authenticator
+	userRepository      repository.UserRepository
+	sessionRepository   repository.SessionRepository
+	outboxRepository    repository.OutboxRepository
+	rateLimitRepository repository.RateLimitRepository
+	webAuthnRepository  repository.WebAuthnRepository
+	issuer              *token.Issuer
+	mailer              mailer.Mailer
+	cfg                 *config.Config
 }
 
-func NewAuthUseCase(userRepo repository.UserRepository, redis redis.Client) AuthUseCase {
+func NewAuthUseCase(authenticator Authenticator, userRepo repository.UserRepository, sessionRepo repository.SessionRepository,
+	outboxRepo repository.OutboxRepository, rateLimitRepo repository.RateLimitRepository, webAuthnRepo repository.WebAuthnRepository,
+	issuer *token.Issuer, mailer mailer.Mailer, cfg *config.Config) AuthUseCase {
 	return &authUseCase{
-		userRepository: userRepo,
-		redis:          redis,
+		authenticator:       authenticator,
+		userRepository:      userRepo,
+		sessionRepository:   sessionRepo,
+		outboxRepository:    outboxRepo,
+		rateLimitRepository: rateLimitRepo,
+		webAuthnRepository:  webAuthnRepo,
+		issuer:              issuer,
+		mailer:              mailer,
+		cfg:                 cfg,
 	}
 }
 
 func (u *authUseCase) Login(ctx context.Context, email, password string) (string, error) {
-	user, err := u.userRepository.GetByEmail(ctx, email)
+	user, err := u.authenticator.Authenticate(ctx, email, password)
 	if err != nil {
-		return "", fmt.Errorf("Make sure you have provide valid email or password")
+		return "", err
 	}
 
-	if err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-		return "", fmt.Errorf("Make sure you have provide valid email or password")
+	if !user.Status.CanLogin() {
+		return "", domain.ErrUserNotActive
+	}
+
+	return u.firstFactorSession(ctx, user, domain.AuthMethodPassword)
+}
+
+func (u *authUseCase) AcceptInvite(ctx context.Context, inviteToken, password string) (string, error) {
+	if len(password) < 8 {
+		return "", domain.ErrWeakPassword
+	}
+
+	claims, err := u.issuer.Consume(ctx, inviteToken, token.PurposeInvite)
+	if err != nil {
+		return "", err
+	}
+
+	invited, err := u.userRepository.GetByID(ctx, claims.UserID)
+	if err != nil {
+		return "", err
+	}
+	if invited.Email != claims.Email {
+		return "", token.ErrInvalidToken
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("Failed to hash password with err %v", err)
+	}
+
+	user, err := u.userRepository.AcceptInvite(ctx, invited.ID, string(hashed))
+	if err != nil {
+		return "", err
+	}
+
+	return u.createSession(ctx, user, domain.AuthMethodPassword)
+}
+
+func (u *authUseCase) SendVerificationEmail(ctx context.Context, accessToken string) error {
+	session, err := u.sessionRepository.Get(ctx, accessToken)
+	if err != nil {
+		return domain.ErrInvalidSession
+	}
+	if session.ImpersonatorID != 0 {
+		return domain.ErrPermissionDenied
+	}
+
+	user, err := u.userRepository.GetByID(ctx, session.UserID)
+	if err != nil {
+		return err
+	}
+	if user.EmailVerifiedAt != nil {
+		return domain.ErrEmailAlreadyVerified
+	}
+
+	verifyToken, err := u.issuer.Issue(ctx, token.PurposeVerifyEmail, user.ID, user.Email, u.cfg.VerificationTTL)
+	if err != nil {
+		return fmt.Errorf("Failed to issue verification token with err %v", err)
+	}
+
+	link := tokenLink(u.cfg.VerifyEmailURL, verifyToken)
+	if err := sendVerificationEmail(ctx, u.mailer, user.Email, user.Name, link, u.cfg.VerificationTTL); err != nil {
+		return fmt.Errorf("Failed to send verification email with err %v", err)
+	}
+	return nil
+}
+
+// VerifyEmail accepts both plain verification tokens and email change
+// confirmations, which also swap the pending address in.
+func (u *authUseCase) VerifyEmail(ctx context.Context, verificationToken string) error {
+	claims, err := u.issuer.Consume(ctx, verificationToken, token.PurposeVerifyEmail, token.PurposeChangeEmail)
+	if err != nil {
+		return err
+	}
+
+	if claims.Purpose == token.PurposeVerifyEmail {
+		return u.userRepository.MarkEmailVerified(ctx, claims.UserID, claims.Email)
+	}
+
+	if _, err := u.userRepository.ConfirmEmailChange(ctx, claims.UserID, claims.Email); err != nil {
+		return err
+	}
+
+	// Sessions carry the email, so make the user log in with the new one.
+	return u.sessionRepository.DeleteByUserID(ctx, claims.UserID)
+}
+
+func (u *authUseCase) createSession(ctx context.Context, user *domain.User, method domain.AuthMethod) (string, error) {
+	return startSession(ctx, u.sessionRepository, u.outboxRepository, user, method)
+}
+
+// firstFactorSession creates a session after a password or magic link, or,
+// when the user has registered a passkey, returns a
+// domain.SecondFactorRequiredError so the login is finished with it.
+func (u *authUseCase) firstFactorSession(ctx context.Context, user *domain.User, method domain.AuthMethod) (string, error) {
+	if err := u.requireSecondFactor(ctx, user); err != nil {
+		return "", err
+	}
+	return u.createSession(ctx, user, method)
+}
+
+// requireSecondFactor returns a domain.SecondFactorRequiredError when the
+// user has registered a passkey, and nil when a first factor is enough.
+func (u *authUseCase) requireSecondFactor(ctx context.Context, user *domain.User) error {
+	credentials, err := u.webAuthnRepository.ListByUser(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("Failed to list passkeys with err %v", err)
+	}
+	if len(credentials) == 0 {
+		return nil
+	}
+
+	secondFactorToken, err := u.issuer.Issue(ctx, token.PurposeSecondFactor, user.ID, user.Email, u.cfg.SecondFactorTTL)
+	if err != nil {
+		return fmt.Errorf("Failed to issue second factor token with err %v", err)
+	}
+	return &domain.SecondFactorRequiredError{Token: secondFactorToken}
+}
+
+// startSession creates a session for a user who has already proven who they
+// are with method, and records the login.
+func startSession(ctx context.Context, sessionRepo repository.SessionRepository, outboxRepo repository.OutboxRepository,
+	user *domain.User, method domain.AuthMethod) (string, error) {
+	// Whatever way a login reached here, service accounts only ever
+	// authenticate call by call with their own credentials.
+	if user.Kind == domain.UserKindService {
+		return "", domain.ErrServiceAccountLogin
 	}
 
 	token := uuid.NewString()
 	now := time.Now().UTC()
-	user.LastAccess = &now
-	if err := u.redis.Set(ctx, token, user, 24*time.Hour).Err(); err != nil {
+	session := &domain.Session{
+		UserID:     user.ID,
+		Email:      user.Email,
+		RoleID:     user.RoleID,
+		LastAccess: now,
+		AuthTime:   now,
+		AuthMethod: method,
+	}
+	if err := sessionRepo.Create(ctx, token, session, 24*time.Hour); err != nil {
 		return "", fmt.Errorf("Failed to save token to cache")
 	}
+
+	recordAuthEvent(ctx, outboxRepo, domain.EventUserLoggedIn, session)
 	return token, nil
 }
 
 func (u *authUseCase) Logout(ctx context.Context, token string) error {
-	return u.redis.Del(ctx, token).Err()
+	session, err := u.sessionRepository.Get(ctx, token)
+	if err != nil {
+		// Nothing to record for an unknown token, but still make sure it
+		// is gone.
+		return u.sessionRepository.Delete(ctx, token)
+	}
+
+	if err := u.sessionRepository.Delete(ctx, token); err != nil {
+		return err
+	}
+
+	recordAuthEvent(ctx, u.outboxRepository, domain.EventUserLoggedOut, session)
+	return nil
+}
+
+// recordAuthEvent writes a login or logout to the outbox. Sessions live in
+// Redis, so this cannot share a transaction with them; a failure is logged
+// rather than failing an otherwise successful login.
+func recordAuthEvent(ctx context.Context, outboxRepo repository.OutboxRepository, eventType domain.EventType, session *domain.Session) {
+	if err := outboxRepo.Append(ctx, domain.NewAuthEvent(eventType, session)); err != nil {
+		log.Printf("Failed to record %s event for user %d with err %v", eventType, session.UserID, err)
+	}
 }
@@ -0,0 +1,148 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"tablelink/internal/config"
+	"tablelink/internal/directory"
+	"tablelink/internal/domain"
+	"tablelink/internal/repository"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Authenticator checks the credentials of a login and returns the user they
+// belong to. Wrong credentials fail with domain.ErrInvalidCredentials.
+type Authenticator interface {
+	Authenticate(ctx context.Context, email, password string) (*domain.User, error)
+}
+
+type passwordAuthenticator struct {
+	userRepo repository.UserRepository
+}
+
+// NewPasswordAuthenticator checks passwords against the bcrypt hashes in
+// the users table.
+func NewPasswordAuthenticator(userRepo repository.UserRepository) Authenticator {
+	return &passwordAuthenticator{userRepo: userRepo}
+}
+
+func (a *passwordAuthenticator) Authenticate(ctx context.Context, email, password string) (*domain.User, error) {
+	user, err := a.userRepo.GetByEmail(ctx, email)
+	if err != nil || user.Kind == domain.UserKindService {
+		return nil, domain.ErrInvalidCredentials
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		return nil, domain.ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+type ldapAuthenticator struct {
+	directory directory.Directory
+	userRepo  repository.UserRepository
+	cfg       *config.Config
+}
+
+// NewLDAPAuthenticator checks passwords by binding to the directory as the
+// user. A user who has no account yet gets one on their first successful
+// login, with the role their directory groups map to.
+func NewLDAPAuthenticator(dir directory.Directory, userRepo repository.UserRepository, cfg *config.Config) Authenticator {
+	return &ldapAuthenticator{
+		directory: dir,
+		userRepo:  userRepo,
+		cfg:       cfg,
+	}
+}
+
+func (a *ldapAuthenticator) Authenticate(ctx context.Context, email, password string) (*domain.User, error) {
+	entry, err := a.directory.Bind(ctx, email, password)
+	if err != nil {
+		if errors.Is(err, domain.ErrInvalidCredentials) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("Failed to authenticate against directory with err %v", err)
+	}
+
+	user, err := a.userRepo.GetByEmail(ctx, email)
+	if errors.Is(err, domain.ErrUserNotFound) {
+		user, err = a.provision(ctx, email, entry)
+		if errors.Is(err, domain.ErrEmailTaken) {
+			// A concurrent first login created the user.
+			user, err = a.userRepo.GetByEmail(ctx, email)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if user.DirectoryDN == nil || !strings.EqualFold(*user.DirectoryDN, entry.DN) {
+		// As in the sync, taking over a local account by email is opt-in,
+		// and a user linked to another entry is never handed over.
+		if user.DirectoryDN != nil || !a.cfg.LDAPAdoptByEmail {
+			return nil, domain.ErrDirectoryEmail
+		}
+		if err := a.userRepo.LinkDirectory(ctx, user.ID, entry.DN); err != nil {
+			return nil, err
+		}
+		user.DirectoryDN = &entry.DN
+	}
+	return user, nil
+}
+
+func (a *ldapAuthenticator) provision(ctx context.Context, email string, entry *domain.DirectoryUser) (*domain.User, error) {
+	groupRoles, err := parseGroupRoles(a.cfg.LDAPGroupRoles)
+	if err != nil {
+		return nil, err
+	}
+	roleID := roleForGroups(entry.Groups, groupRoles, a.cfg.LDAPDefaultRoleID)
+	if roleID == 0 {
+		return nil, domain.ErrNoDirectoryRole
+	}
+
+	hashed, err := unusablePasswordHash()
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSpace(entry.Name)
+	if name == "" {
+		name = email
+	}
+	dn := entry.DN
+	return a.userRepo.Create(ctx, &domain.User{
+		Name:        name,
+		Email:       email,
+		Password:    string(hashed),
+		RoleID:      roleID,
+		Status:      domain.UserStatusActive,
+		DirectoryDN: &dn,
+	})
+}
+
+type domainAuthenticator struct {
+	fallback Authenticator
+	byDomain map[string]Authenticator
+}
+
+// NewDomainAuthenticator picks an authenticator by the domain of the email,
+// using fallback for domains not in byDomain.
+func NewDomainAuthenticator(fallback Authenticator, byDomain map[string]Authenticator) Authenticator {
+	normalized := make(map[string]Authenticator, len(byDomain))
+	for d, a := range byDomain {
+		normalized[strings.ToLower(strings.TrimSpace(d))] = a
+	}
+	return &domainAuthenticator{fallback: fallback, byDomain: normalized}
+}
+
+func (a *domainAuthenticator) Authenticate(ctx context.Context, email, password string) (*domain.User, error) {
+	if i := strings.LastIndex(email, "@"); i >= 0 {
+		if authenticator, ok := a.byDomain[strings.ToLower(email[i+1:])]; ok {
+			return authenticator.Authenticate(ctx, email, password)
+		}
+	}
+	return a.fallback.Authenticate(ctx, email, password)
+}
@@ -0,0 +1,90 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"tablelink/internal/config"
+	"tablelink/internal/directory"
+	"tablelink/internal/domain"
+	"testing"
+)
+
+func TestLDAPAuthenticator(t *testing.T) {
+	adaDN := "uid=ada,ou=people,dc=example,dc=org"
+	otherDN := "uid=ada2,ou=people,dc=example,dc=org"
+
+	tests := []struct {
+		name     string
+		user     *domain.User
+		password string
+		adopt    bool
+		wantErr  error
+		// wantDN is the entry the user must be linked to afterwards, nil
+		// when they must stay as they were.
+		wantDN *string
+	}{
+		{name: "creates a user on their first login", password: "secret", wantDN: &adaDN},
+		{
+			name:     "logs in a linked user",
+			user:     &domain.User{ID: 1, Email: "ada@example.org", RoleID: 2, DirectoryDN: &adaDN},
+			password: "secret",
+			wantDN:   &adaDN,
+		},
+		{
+			name:     "refuses a local user with the entry's email",
+			user:     &domain.User{ID: 1, Email: "ada@example.org", RoleID: 1},
+			password: "secret",
+			wantErr:  domain.ErrDirectoryEmail,
+		},
+		{
+			name:     "adopts a local user by email when allowed",
+			user:     &domain.User{ID: 1, Email: "ada@example.org", RoleID: 1},
+			password: "secret",
+			adopt:    true,
+			wantDN:   &adaDN,
+		},
+		{
+			name:     "refuses a user linked to another entry",
+			user:     &domain.User{ID: 1, Email: "ada@example.org", RoleID: 1, DirectoryDN: &otherDN},
+			password: "secret",
+			adopt:    true,
+			wantErr:  domain.ErrDirectoryEmail,
+			wantDN:   &otherDN,
+		},
+		{
+			name:     "refuses a wrong password",
+			user:     &domain.User{ID: 1, Email: "ada@example.org", RoleID: 1},
+			password: "wrong",
+			adopt:    true,
+			wantErr:  domain.ErrInvalidCredentials,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := directory.NewFakeDirectory(&domain.DirectoryUser{DN: adaDN, Email: "ada@example.org", Name: "Ada"})
+			dir.SetPassword("ada@example.org", "secret")
+			users := newFakeUserRepo()
+			if tt.user != nil {
+				users.put(tt.user)
+			}
+			authenticator := NewLDAPAuthenticator(dir, users, &config.Config{LDAPDefaultRoleID: 2, LDAPAdoptByEmail: tt.adopt})
+
+			user, err := authenticator.Authenticate(context.Background(), "ada@example.org", tt.password)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Authenticate() err = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && user.Email != "ada@example.org" {
+				t.Errorf("Authenticate() = %+v, want Ada", user)
+			}
+
+			stored, err := users.GetByEmail(context.Background(), "ada@example.org")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := stored.DirectoryDN; (got == nil) != (tt.wantDN == nil) || (got != nil && *got != *tt.wantDN) {
+				t.Errorf("directory dn = %v, want %v", got, tt.wantDN)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"tablelink/internal/config"
+	"tablelink/internal/domain"
+	"tablelink/internal/repository"
+	"tablelink/internal/token"
+	"time"
+)
+
+const (
+	// credentialTouchInterval limits how often using a key is written
+	// back, so a busy job does not update its row on every call.
+	credentialTouchInterval = time.Minute
+	// maxAssertionLifetime bounds how long a signed service account
+	// assertion may be used, as nothing stops it from being replayed
+	// until it expires.
+	maxAssertionLifetime = 5 * time.Minute
+	assertionClockSkew   = 30 * time.Second
+)
+
+// CredentialUseCase resolves the credentials calls are made with to the
+// principal making them.
+type CredentialUseCase interface {
+	// Authenticate accepts a session token, an API key, a service account
+	// secret or a service account assertion presented from ip.
+	Authenticate(ctx context.Context, credential, ip string) (*domain.Principal, error)
+}
+
+type credentialUseCase struct {
+	sessionRepo        repository.SessionRepository
+	apiKeyRepo         repository.APIKeyRepository
+	serviceAccountRepo repository.ServiceAccountRepository
+	userRepo           repository.UserRepository
+	cfg                *config.Config
+}
+
+func NewCredentialUseCase(sessionRepo repository.SessionRepository, apiKeyRepo repository.APIKeyRepository,
+	serviceAccountRepo repository.ServiceAccountRepository, userRepo repository.UserRepository, cfg *config.Config) CredentialUseCase {
+	return &credentialUseCase{
+		sessionRepo:        sessionRepo,
+		apiKeyRepo:         apiKeyRepo,
+		serviceAccountRepo: serviceAccountRepo,
+		userRepo:           userRepo,
+		cfg:                cfg,
+	}
+}
+
+func (u *credentialUseCase) Authenticate(ctx context.Context, credential, ip string) (*domain.Principal, error) {
+	if credential == "" {
+		return nil, domain.ErrUnauthenticated
+	}
+	if prefix, ok := splitKey(credential, domain.APIKeyPrefix); ok {
+		return u.authenticateAPIKey(ctx, prefix, credential, ip)
+	}
+	if keyID, ok := splitKey(credential, domain.ServiceAccountSecretPrefix); ok {
+		return u.authenticateSecret(ctx, keyID, credential)
+	}
+	if strings.Count(credential, ".") == 2 {
+		return u.authenticateAssertion(ctx, credential)
+	}
+
+	session, err := u.sessionRepo.Get(ctx, credential)
+	if err != nil {
+		return nil, domain.ErrUnauthenticated
+	}
+	return u.sessionPrincipal(ctx, session)
+}
+
+// sessionPrincipal checks the user of a session. Like service accounts,
+// the user is read on every call, so deleting, suspending or demoting them
+// takes effect at once rather than when the session expires.
+func (u *credentialUseCase) sessionPrincipal(ctx context.Context, session *domain.Session) (*domain.Principal, error) {
+	user, err := u.loginUser(ctx, session.UserID)
+	if err != nil {
+		return nil, err
+	}
+	// An impersonation ends as soon as the impersonator could no longer
+	// log in themselves.
+	if session.ImpersonatorID != 0 {
+		if _, err := u.loginUser(ctx, session.ImpersonatorID); err != nil {
+			return nil, err
+		}
+	}
+
+	return &domain.Principal{
+		Kind:           domain.PrincipalUser,
+		UserID:         user.ID,
+		Email:          user.Email,
+		RoleID:         user.RoleID,
+		ImpersonatorID: session.ImpersonatorID,
+		AuthTime:       session.AuthTime,
+		AuthMethod:     session.AuthMethod,
+	}, nil
+}
+
+// loginUser returns the human user userID if they exist and may log in.
+func (u *credentialUseCase) loginUser(ctx context.Context, userID int) (*domain.User, error) {
+	user, err := u.userRepo.GetByID(ctx, userID)
+	if errors.Is(err, domain.ErrUserNotFound) {
+		return nil, domain.ErrUnauthenticated
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.Kind == domain.UserKindService || !user.Status.CanLogin() {
+		return nil, domain.ErrUnauthenticated
+	}
+	return user, nil
+}
+
+func (u *credentialUseCase) authenticateAPIKey(ctx context.Context, prefix, raw, ip string) (*domain.Principal, error) {
+	key, err := u.apiKeyRepo.GetByPrefix(ctx, prefix)
+	if errors.Is(err, domain.ErrAPIKeyNotFound) {
+		return nil, domain.ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if subtle.ConstantTimeCompare([]byte(hashOpaqueToken(raw)), []byte(key.KeyHash)) != 1 || !key.Active(now) {
+		return nil, domain.ErrInvalidAPIKey
+	}
+	if !key.AllowsIP(ip) {
+		return nil, domain.ErrAPIKeyIPNotAllowed
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= credentialTouchInterval {
+		if err := u.apiKeyRepo.Touch(ctx, key.ID, ip); err != nil {
+			log.Printf("Failed to record use of api key %d with err %v", key.ID, err)
+		}
+	}
+	return &domain.Principal{
+		Kind:     domain.PrincipalAPIKey,
+		APIKeyID: key.ID,
+		RoleID:   key.RoleID,
+	}, nil
+}
+
+func (u *credentialUseCase) authenticateSecret(ctx context.Context, keyID, raw string) (*domain.Principal, error) {
+	credential, err := u.credential(ctx, keyID, domain.CredentialSecret)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashOpaqueToken(raw)), []byte(*credential.SecretHash)) != 1 {
+		return nil, domain.ErrUnauthenticated
+	}
+	return u.serviceAccountPrincipal(ctx, credential)
+}
+
+// authenticateAssertion accepts a JWT a service account signed with the
+// private key of one of its public key credentials, named by the kid.
+func (u *credentialUseCase) authenticateAssertion(ctx context.Context, raw string) (*domain.Principal, error) {
+	var credential *domain.ServiceAccountCredential
+	var claims domain.ServiceAccountAssertion
+	err := token.VerifyJWT(raw, func(kid string) (*rsa.PublicKey, error) {
+		var err error
+		credential, err = u.credential(ctx, kid, domain.CredentialPublicKey)
+		if err != nil {
+			return nil, err
+		}
+		return parsePublicKey(*credential.PublicKey)
+	}, &claims)
+	if errors.Is(err, token.ErrInvalidToken) || errors.Is(err, domain.ErrInvalidPublicKey) {
+		return nil, domain.ErrInvalidAssertion
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	exp := time.Unix(claims.ExpiresAt, 0)
+	if claims.Subject != strconv.Itoa(credential.UserID) || !audienceIncludes(claims.Audience, u.cfg.ServiceAccountAudience) ||
+		now.After(exp.Add(assertionClockSkew)) || exp.Sub(now) > maxAssertionLifetime+assertionClockSkew {
+		return nil, domain.ErrInvalidAssertion
+	}
+	return u.serviceAccountPrincipal(ctx, credential)
+}
+
+// credential returns the active credential keyID of type credentialType.
+func (u *credentialUseCase) credential(ctx context.Context, keyID string, credentialType domain.CredentialType) (*domain.ServiceAccountCredential, error) {
+	credential, err := u.serviceAccountRepo.GetCredential(ctx, keyID)
+	if errors.Is(err, domain.ErrCredentialNotFound) {
+		return nil, domain.ErrUnauthenticated
+	}
+	if err != nil {
+		return nil, err
+	}
+	if credential.Type != credentialType || !credential.Active(time.Now()) {
+		return nil, domain.ErrUnauthenticated
+	}
+	return credential, nil
+}
+
+// serviceAccountPrincipal checks the account a credential belongs to. The
+// account is read on every call, so suspending it or changing its role
+// takes effect at once.
+func (u *credentialUseCase) serviceAccountPrincipal(ctx context.Context, credential *domain.ServiceAccountCredential) (*domain.Principal, error) {
+	account, err := u.userRepo.GetByID(ctx, credential.UserID)
+	if errors.Is(err, domain.ErrUserNotFound) {
+		return nil, domain.ErrUnauthenticated
+	}
+	if err != nil {
+		return nil, err
+	}
+	if account.Kind != domain.UserKindService || !account.Status.CanLogin() {
+		return nil, domain.ErrUnauthenticated
+	}
+
+	if credential.LastUsedAt == nil || time.Since(*credential.LastUsedAt) >= credentialTouchInterval {
+		if err := u.serviceAccountRepo.TouchCredential(ctx, credential.ID); err != nil {
+			log.Printf("Failed to record use of credential %d with err %v", credential.ID, err)
+		}
+	}
+	return &domain.Principal{
+		Kind:   domain.PrincipalServiceAccount,
+		UserID: account.ID,
+		Email:  account.Email,
+		RoleID: account.RoleID,
+	}, nil
+}
+
+func audienceIncludes(aud any, audience string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == audience
+	case []any:
+		for _, item := range v {
+			if item == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
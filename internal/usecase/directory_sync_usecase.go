@@ -0,0 +1,298 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"strconv"
+	"strings"
+	"tablelink/internal/config"
+	"tablelink/internal/directory"
+	"tablelink/internal/domain"
+	"tablelink/internal/repository"
+)
+
+const directorySyncReason = "directory sync"
+
+type DirectorySyncUseCase interface {
+	// Sync makes users match the directory: entries without a user are
+	// created, linked users are updated, and directory-managed users that
+	// are gone from the directory are suspended. Users come back only from
+	// a suspension made by the sync itself. With dryRun nothing is written
+	// and the report is the diff that would be applied.
+	Sync(ctx context.Context, dryRun bool) (*domain.SyncReport, error)
+}
+
+type directorySyncUseCase struct {
+	directory   directory.Directory
+	userRepo    repository.UserRepository
+	roleRepo    repository.RoleRepository
+	sessionRepo repository.SessionRepository
+	cfg         *config.Config
+}
+
+func NewDirectorySyncUseCase(dir directory.Directory, userRepo repository.UserRepository, roleRepo repository.RoleRepository,
+	sessionRepo repository.SessionRepository, cfg *config.Config) DirectorySyncUseCase {
+	return &directorySyncUseCase{
+		directory:   dir,
+		userRepo:    userRepo,
+		roleRepo:    roleRepo,
+		sessionRepo: sessionRepo,
+		cfg:         cfg,
+	}
+}
+
+// syncStep is a planned action together with what applying it takes.
+type syncStep struct {
+	action  *domain.SyncAction
+	current *domain.User
+	target  *domain.User
+	link    bool
+	status  domain.UserStatus
+}
+
+func (u *directorySyncUseCase) Sync(ctx context.Context, dryRun bool) (*domain.SyncReport, error) {
+	groupRoles, err := parseGroupRoles(u.cfg.LDAPGroupRoles)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := u.directory.Users(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, domain.ErrEmptyDirectory
+	}
+
+	users, err := u.userRepo.ListAll(ctx, &domain.UserFilter{})
+	if err != nil {
+		return nil, err
+	}
+	roles, err := u.roleRepo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var suspended []int
+	for _, user := range users {
+		if user.Status == domain.UserStatusSuspended {
+			suspended = append(suspended, user.ID)
+		}
+	}
+	reasons, err := u.userRepo.LastStatusReasons(ctx, suspended)
+	if err != nil {
+		return nil, err
+	}
+
+	steps, unchanged := u.plan(entries, users, reasons, groupRoles, roles)
+	report := &domain.SyncReport{DryRun: dryRun, Unchanged: unchanged}
+	for _, step := range steps {
+		report.Actions = append(report.Actions, step.action)
+		if !dryRun && step.action.Err == nil && step.action.Kind != domain.SyncSkip {
+			step.action.Err = u.apply(ctx, step)
+		}
+	}
+	return report, nil
+}
+
+// plan diffs the directory against users. reasons holds the reason of the
+// latest status change of suspended users.
+func (u *directorySyncUseCase) plan(entries []*domain.DirectoryUser, users []*domain.User, reasons map[int]string,
+	groupRoles []groupRole, roles []*domain.Role) ([]*syncStep, int) {
+	knownRoles := make(map[int]bool, len(roles))
+	for _, r := range roles {
+		knownRoles[r.ID] = true
+	}
+
+	byDN := make(map[string]*domain.User)
+	byEmail := make(map[string]*domain.User)
+	for _, user := range users {
+		if user.DirectoryDN != nil {
+			byDN[strings.ToLower(*user.DirectoryDN)] = user
+		}
+		byEmail[strings.ToLower(user.Email)] = user
+	}
+
+	var steps []*syncStep
+	var unchanged int
+	seen := make(map[int]bool)
+	for _, entry := range entries {
+		action := &domain.SyncAction{DN: entry.DN, Email: strings.TrimSpace(entry.Email)}
+		step := &syncStep{action: action}
+
+		if _, err := mail.ParseAddress(action.Email); err != nil {
+			action.Kind, action.Err = domain.SyncSkip, domain.ErrInvalidEmail
+			steps = append(steps, step)
+			continue
+		}
+		name := strings.TrimSpace(entry.Name)
+		if name == "" {
+			name = action.Email
+		}
+		roleID := roleForGroups(entry.Groups, groupRoles, u.cfg.LDAPDefaultRoleID)
+		if !knownRoles[roleID] {
+			action.Kind, action.Err = domain.SyncSkip, fmt.Errorf("%w: %d", domain.ErrRoleNotFound, roleID)
+			steps = append(steps, step)
+			continue
+		}
+
+		current := byDN[strings.ToLower(entry.DN)]
+		if current == nil {
+			current = byEmail[strings.ToLower(action.Email)]
+			// Taking over a local account by email hands it to whoever
+			// controls the directory entry, so it is opt-in.
+			if current != nil && !u.cfg.LDAPAdoptByEmail {
+				action.Kind, action.Err, action.UserID = domain.SyncSkip, domain.ErrDirectoryEmail, current.ID
+				steps = append(steps, step)
+				continue
+			}
+		}
+		if current == nil {
+			dn := entry.DN
+			action.Kind = domain.SyncCreate
+			action.Changes = []string{"role: " + strconv.Itoa(roleID)}
+			step.target = &domain.User{Name: name, Email: action.Email, RoleID: roleID, DirectoryDN: &dn}
+			steps = append(steps, step)
+			continue
+		}
+
+		seen[current.ID] = true
+		action.Kind, action.UserID = domain.SyncUpdate, current.ID
+		step.current = current
+		step.target = &domain.User{ID: current.ID, Name: name, Email: action.Email, RoleID: roleID}
+		if current.DirectoryDN == nil || !strings.EqualFold(*current.DirectoryDN, entry.DN) {
+			step.link = true
+			action.Changes = append(action.Changes, "dn: "+deref(current.DirectoryDN)+" -> "+entry.DN)
+		}
+		if current.Name != name {
+			action.Changes = append(action.Changes, "name: "+current.Name+" -> "+name)
+		}
+		if current.Email != action.Email {
+			action.Changes = append(action.Changes, "email: "+current.Email+" -> "+action.Email)
+		}
+		if current.RoleID != roleID {
+			action.Changes = append(action.Changes, fmt.Sprintf("role: %d -> %d", current.RoleID, roleID))
+		}
+		// Only undo the sync's own suspensions: an admin's suspension or
+		// disabling stands even while the entry is in the directory.
+		if current.Status == domain.UserStatusSuspended && reasons[current.ID] == directorySyncReason {
+			step.status = domain.UserStatusActive
+			action.Changes = append(action.Changes, "status: "+string(current.Status)+" -> active")
+		}
+		if len(action.Changes) == 0 {
+			unchanged++
+			continue
+		}
+		steps = append(steps, step)
+	}
+
+	for _, user := range users {
+		if user.DirectoryDN == nil || seen[user.ID] || !user.Status.CanLogin() {
+			continue
+		}
+		steps = append(steps, &syncStep{
+			action: &domain.SyncAction{
+				Kind:    domain.SyncDeactivate,
+				DN:      *user.DirectoryDN,
+				Email:   user.Email,
+				UserID:  user.ID,
+				Changes: []string{"status: " + string(user.Status) + " -> suspended"},
+			},
+			current: user,
+			status:  domain.UserStatusSuspended,
+		})
+	}
+	return steps, unchanged
+}
+
+func (u *directorySyncUseCase) apply(ctx context.Context, step *syncStep) error {
+	if step.action.Kind == domain.SyncCreate {
+		hashed, err := unusablePasswordHash()
+		if err != nil {
+			return err
+		}
+		step.target.Password = hashed
+		step.target.Status = domain.UserStatusActive
+
+		created, err := u.userRepo.Create(ctx, step.target)
+		if err != nil {
+			return err
+		}
+		step.action.UserID = created.ID
+		return nil
+	}
+
+	user := step.current
+	if step.link {
+		if err := u.userRepo.LinkDirectory(ctx, user.ID, step.action.DN); err != nil {
+			return err
+		}
+	}
+
+	target := step.target
+	if target != nil && (target.Name != user.Name || target.Email != user.Email || target.RoleID != user.RoleID) {
+		target.Version = user.Version
+		updated, err := u.userRepo.Update(ctx, target)
+		if err != nil {
+			return err
+		}
+		user = updated
+	}
+
+	if step.status != "" {
+		if _, err := u.userRepo.ChangeStatus(ctx, user.ID, user.Version, user.Status, step.status, directorySyncReason); err != nil {
+			return err
+		}
+		if step.status.RevokesSessions() {
+			if err := u.sessionRepo.DeleteByUserID(ctx, user.ID); err != nil {
+				return fmt.Errorf("Failed to revoke sessions with err %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+type groupRole struct {
+	group  string
+	roleID int
+}
+
+// parseGroupRoles parses "admins=1,staff=2". Order matters: the first group
+// a user belongs to wins.
+func parseGroupRoles(raw string) ([]groupRole, error) {
+	var mapping []groupRole
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		i := strings.LastIndex(pair, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid group role mapping %q", pair)
+		}
+		roleID, err := strconv.Atoi(strings.TrimSpace(pair[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid role id in group role mapping %q", pair)
+		}
+		mapping = append(mapping, groupRole{group: strings.TrimSpace(pair[:i]), roleID: roleID})
+	}
+	return mapping, nil
+}
+
+func roleForGroups(groups []string, mapping []groupRole, defaultRoleID int) int {
+	for _, m := range mapping {
+		for _, g := range groups {
+			if strings.EqualFold(g, m.group) {
+				return m.roleID
+			}
+		}
+	}
+	return defaultRoleID
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return "-"
+	}
+	return *s
+}
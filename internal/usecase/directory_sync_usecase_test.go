@@ -0,0 +1,181 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"tablelink/internal/config"
+	"tablelink/internal/directory"
+	"tablelink/internal/domain"
+	"testing"
+	"time"
+)
+
+type fakeDirectory struct {
+	directory.Directory
+	entries []*domain.DirectoryUser
+}
+
+func (f *fakeDirectory) Users(ctx context.Context) ([]*domain.DirectoryUser, error) {
+	return f.entries, nil
+}
+
+func TestDirectorySync(t *testing.T) {
+	adaDN := "uid=ada,ou=people,dc=example,dc=org"
+	ada := &domain.DirectoryUser{DN: adaDN, Email: "ada@example.org", Name: "Ada", Groups: []string{"staff"}}
+	// Grace keeps the directory from looking empty when Ada is gone.
+	grace := &domain.DirectoryUser{DN: "uid=grace,ou=people,dc=example,dc=org", Email: "grace@example.org", Name: "Grace"}
+	linkedAda := func(status domain.UserStatus) *domain.User {
+		return &domain.User{ID: 1, Name: "Ada", Email: "ada@example.org", RoleID: 2, Status: status, Version: 1, DirectoryDN: &adaDN}
+	}
+
+	tests := []struct {
+		name    string
+		user    *domain.User
+		reason  string
+		entries []*domain.DirectoryUser
+		dryRun  bool
+		adopt   bool
+		// wantKind, wantChanges and wantErr describe the action for Ada,
+		// none when wantKind is empty.
+		wantKind    domain.SyncActionKind
+		wantChanges []string
+		wantErr     error
+		wantStatus  domain.UserStatus
+		// wantLocal is set when Ada must stay unlinked from the directory.
+		wantLocal bool
+	}{
+		{
+			name:       "creates a new entry",
+			entries:    []*domain.DirectoryUser{ada},
+			wantKind:   domain.SyncCreate,
+			wantStatus: domain.UserStatusActive,
+		},
+		{
+			name:       "skips a local user with the entry's email",
+			user:       &domain.User{ID: 1, Name: "Ada", Email: "ada@example.org", RoleID: 2, Status: domain.UserStatusActive, Version: 1},
+			entries:    []*domain.DirectoryUser{ada},
+			wantKind:   domain.SyncSkip,
+			wantErr:    domain.ErrDirectoryEmail,
+			wantStatus: domain.UserStatusActive,
+			wantLocal:  true,
+		},
+		{
+			name:        "adopts a local user by email when allowed",
+			user:        &domain.User{ID: 1, Name: "Ada", Email: "ada@example.org", RoleID: 2, Status: domain.UserStatusActive, Version: 1},
+			entries:     []*domain.DirectoryUser{ada},
+			adopt:       true,
+			wantKind:    domain.SyncUpdate,
+			wantChanges: []string{"dn: - -> " + adaDN},
+			wantStatus:  domain.UserStatusActive,
+		},
+		{
+			name:        "updates the role of a linked user",
+			user:        &domain.User{ID: 1, Name: "Ada", Email: "ada@example.org", RoleID: 3, Status: domain.UserStatusActive, Version: 1, DirectoryDN: &adaDN},
+			entries:     []*domain.DirectoryUser{ada, grace},
+			wantKind:    domain.SyncUpdate,
+			wantChanges: []string{"role: 3 -> 2"},
+			wantStatus:  domain.UserStatusActive,
+		},
+		{
+			name:        "suspends a linked user gone from the directory",
+			user:        linkedAda(domain.UserStatusActive),
+			entries:     []*domain.DirectoryUser{grace},
+			wantKind:    domain.SyncDeactivate,
+			wantChanges: []string{"status: active -> suspended"},
+			wantStatus:  domain.UserStatusSuspended,
+		},
+		{
+			name:        "reactivates a user the sync suspended",
+			user:        linkedAda(domain.UserStatusSuspended),
+			reason:      directorySyncReason,
+			entries:     []*domain.DirectoryUser{ada},
+			wantKind:    domain.SyncUpdate,
+			wantChanges: []string{"status: suspended -> active"},
+			wantStatus:  domain.UserStatusActive,
+		},
+		{
+			name:       "keeps a suspension made by an admin",
+			user:       linkedAda(domain.UserStatusSuspended),
+			reason:     "left the company",
+			entries:    []*domain.DirectoryUser{ada},
+			wantStatus: domain.UserStatusSuspended,
+		},
+		{
+			name:       "keeps a disabled user disabled",
+			user:       linkedAda(domain.UserStatusDisabled),
+			reason:     directorySyncReason,
+			entries:    []*domain.DirectoryUser{ada},
+			wantStatus: domain.UserStatusDisabled,
+		},
+		{
+			name:        "writes nothing in a dry run",
+			user:        linkedAda(domain.UserStatusActive),
+			entries:     []*domain.DirectoryUser{grace},
+			dryRun:      true,
+			wantKind:    domain.SyncDeactivate,
+			wantChanges: []string{"status: active -> suspended"},
+			wantStatus:  domain.UserStatusActive,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			users := newFakeUserRepo()
+			sessions := newFakeSessionRepo()
+			if tt.user != nil {
+				users.put(tt.user)
+				if tt.reason != "" {
+					users.reasons[tt.user.ID] = tt.reason
+				}
+				sessions.Create(ctx, "token", &domain.Session{UserID: tt.user.ID}, time.Hour)
+			}
+			cfg := &config.Config{LDAPGroupRoles: "staff=2", LDAPDefaultRoleID: 3, LDAPAdoptByEmail: tt.adopt}
+			uc := NewDirectorySyncUseCase(&fakeDirectory{entries: tt.entries}, users,
+				&fakeRoleRepo{roles: []*domain.Role{{ID: 2}, {ID: 3}}}, sessions, cfg)
+
+			report, err := uc.Sync(ctx, tt.dryRun)
+			if err != nil {
+				t.Fatalf("Sync() err = %v", err)
+			}
+			if report.Failed() != 0 && tt.wantErr == nil {
+				t.Fatalf("Sync() failed %d actions", report.Failed())
+			}
+
+			var action *domain.SyncAction
+			for _, a := range report.Actions {
+				if a.Email == "ada@example.org" {
+					action = a
+				}
+			}
+			switch {
+			case tt.wantKind == "" && action != nil:
+				t.Errorf("action = %+v, want none", action)
+			case tt.wantKind != "" && (action == nil || action.Kind != tt.wantKind):
+				t.Errorf("action = %+v, want %s", action, tt.wantKind)
+			case tt.wantChanges != nil && !slices.Equal(action.Changes, tt.wantChanges):
+				t.Errorf("changes = %q, want %q", action.Changes, tt.wantChanges)
+			case tt.wantErr != nil && !errors.Is(action.Err, tt.wantErr):
+				t.Errorf("action err = %v, want %v", action.Err, tt.wantErr)
+			}
+
+			stored, err := users.GetByEmail(ctx, "ada@example.org")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", stored.Status, tt.wantStatus)
+			}
+			if linked := stored.DirectoryDN != nil; linked == tt.wantLocal && !tt.dryRun {
+				t.Errorf("linked to the directory = %t, want %t", linked, !tt.wantLocal)
+			}
+			if tt.user != nil {
+				wantRevoked := stored.Status != tt.user.Status && stored.Status.RevokesSessions()
+				if revoked := len(sessions.sessions) == 0; revoked != wantRevoked {
+					t.Errorf("sessions revoked = %t, want %t", revoked, wantRevoked)
+				}
+			}
+		})
+	}
+}
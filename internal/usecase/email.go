@@ -0,0 +1,52 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"tablelink/internal/mailer"
+	"time"
+)
+
+func tokenLink(base, token string) string {
+	return base + "?token=" + url.QueryEscape(token)
+}
+
+func sendVerificationEmail(ctx context.Context, m mailer.Mailer, to, name, link string, ttl time.Duration) error {
+	return m.Send(ctx, &mailer.Message{
+		To:      to,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm that %s is your email address:\n\n%s\n\nThis link expires in %s.\n",
+			name, to, link, ttl),
+	})
+}
+
+func sendInvitationEmail(ctx context.Context, m mailer.Mailer, to, name, link string, ttl time.Duration) error {
+	return m.Send(ctx, &mailer.Message{
+		To:      to,
+		Subject: "You have been invited to Tablelink",
+		Body: fmt.Sprintf("Hi %s,\n\nYou have been invited to Tablelink. Set your password to get started:\n\n%s\n\nThis link expires in %s.\n",
+			name, link, ttl),
+	})
+}
+
+func sendEmailChangeNotice(ctx context.Context, m mailer.Mailer, to, name, newEmail string) error {
+	return m.Send(ctx, &mailer.Message{
+		To:      to,
+		Subject: "Your email address is being changed",
+		Body: fmt.Sprintf("Hi %s,\n\nA request was made to change the email address of your account to %s. "+
+			"Your current address stays active until the new one is confirmed.\n\n"+
+			"If you did not request this change, please contact your administrator.\n",
+			name, newEmail),
+	})
+}
+
+func sendMagicLinkEmail(ctx context.Context, m mailer.Mailer, to, name, link string, ttl time.Duration) error {
+	return m.Send(ctx, &mailer.Message{
+		To:      to,
+		Subject: "Your Tablelink login link",
+		Body: fmt.Sprintf("Hi %s,\n\nUse this link to log in to Tablelink. It works once, from the device you requested it on:\n\n%s\n\n"+
+			"This link expires in %s. If you did not ask to log in, you can ignore this email.\n",
+			name, link, ttl),
+	})
+}
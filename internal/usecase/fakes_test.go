@@ -0,0 +1,436 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"tablelink/internal/domain"
+	"tablelink/internal/mailer"
+	"tablelink/internal/repository"
+	"time"
+)
+
+// The fakes below keep their rows in memory. They embed the repository
+// interface they stand in for, so a test calling a method the fake does not
+// implement panics instead of passing by accident.
+
+type fakeUserRepo struct {
+	repository.UserRepository
+	mu     sync.Mutex
+	nextID int
+	users  map[int]*domain.User
+	// reasons holds the reason of each user's latest status change.
+	reasons map[int]string
+}
+
+func newFakeUserRepo(users ...*domain.User) *fakeUserRepo {
+	f := &fakeUserRepo{users: make(map[int]*domain.User), reasons: make(map[int]string)}
+	for _, u := range users {
+		f.put(u)
+	}
+	return f
+}
+
+func (f *fakeUserRepo) put(u *domain.User) *domain.User {
+	if u.ID == 0 {
+		f.nextID++
+		u.ID = f.nextID
+	}
+	f.nextID = max(f.nextID, u.ID)
+	if u.Kind == "" {
+		u.Kind = domain.UserKindHuman
+	}
+	if u.Status == "" {
+		u.Status = domain.UserStatusActive
+	}
+	stored := *u
+	f.users[u.ID] = &stored
+	return u
+}
+
+func (f *fakeUserRepo) get(id int) *domain.User {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if u, ok := f.users[id]; ok {
+		copied := *u
+		return &copied
+	}
+	return nil
+}
+
+func (f *fakeUserRepo) Create(ctx context.Context, user *domain.User) (*domain.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, u := range f.users {
+		if u.Email == user.Email {
+			return nil, domain.ErrEmailTaken
+		}
+	}
+	created := *user
+	created.ID = 0
+	created.Version = 1
+	return f.put(&created), nil
+}
+
+func (f *fakeUserRepo) GetByID(ctx context.Context, id int) (*domain.User, error) {
+	if u := f.get(id); u != nil && u.DeletedAt == nil {
+		return u, nil
+	}
+	return nil, domain.ErrUserNotFound
+}
+
+func (f *fakeUserRepo) GetByEmail(ctx context.Context, email string) (*domain.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, u := range f.users {
+		if u.Email == email && u.DeletedAt == nil {
+			copied := *u
+			return &copied, nil
+		}
+	}
+	return nil, domain.ErrUserNotFound
+}
+
+func (f *fakeUserRepo) Update(ctx context.Context, user *domain.User) (*domain.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stored, ok := f.users[user.ID]
+	if !ok {
+		return nil, domain.ErrUserNotFound
+	}
+	stored.Name, stored.Email, stored.RoleID = user.Name, user.Email, user.RoleID
+	if user.Password != "" {
+		stored.Password = user.Password
+	}
+	if user.ExternalID != nil {
+		stored.ExternalID = user.ExternalID
+	}
+	stored.Version++
+	copied := *stored
+	return &copied, nil
+}
+
+func (f *fakeUserRepo) MarkEmailVerified(ctx context.Context, id int, email string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if u, ok := f.users[id]; ok && u.Email == email {
+		now := time.Now()
+		u.EmailVerifiedAt = &now
+	}
+	return nil
+}
+
+// ListAll honours only the RoleID of filter.
+func (f *fakeUserRepo) ListAll(ctx context.Context, filter *domain.UserFilter) ([]*domain.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	users := make([]*domain.User, 0, len(f.users))
+	for id := 1; id <= f.nextID; id++ {
+		if u, ok := f.users[id]; ok && u.DeletedAt == nil && (filter == nil || filter.RoleID == 0 || filter.RoleID == u.RoleID) {
+			copied := *u
+			users = append(users, &copied)
+		}
+	}
+	return users, nil
+}
+
+func (f *fakeUserRepo) GetByIDs(ctx context.Context, ids []int) (map[int]*domain.User, error) {
+	users := make(map[int]*domain.User, len(ids))
+	for _, id := range ids {
+		if u := f.get(id); u != nil && u.DeletedAt == nil {
+			users[id] = u
+		}
+	}
+	return users, nil
+}
+
+// BatchUpdate applies role and status changes, all or nothing when atomic.
+func (f *fakeUserRepo) BatchUpdate(ctx context.Context, updates []*domain.UserBatchUpdate, atomic bool) ([]*domain.BatchResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	results := make([]*domain.BatchResult, len(updates))
+	failed := false
+	for i, update := range updates {
+		results[i] = &domain.BatchResult{ID: update.ID}
+		u, ok := f.users[update.ID]
+		switch {
+		case !ok:
+			results[i].Err = domain.ErrUserNotFound
+		case u.Version != update.Version || u.Status != update.FromStatus || u.RoleID != update.FromRoleID:
+			results[i].Err = domain.ErrVersionConflict
+		}
+		failed = failed || results[i].Err != nil
+	}
+	for i, update := range updates {
+		if results[i].Err != nil {
+			continue
+		}
+		if failed && atomic {
+			results[i].Err = domain.ErrBatchAborted
+			continue
+		}
+		u := f.users[update.ID]
+		if update.RoleID != 0 {
+			u.RoleID = update.RoleID
+		}
+		if update.Status != "" {
+			u.Status = update.Status
+		}
+		u.Version++
+		copied := *u
+		results[i].User = &copied
+	}
+	return results, nil
+}
+
+func (f *fakeUserRepo) ChangeStatus(ctx context.Context, id, version int, from, to domain.UserStatus, reason string) (*domain.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u, ok := f.users[id]
+	if !ok {
+		return nil, domain.ErrUserNotFound
+	}
+	if u.Version != version || u.Status != from {
+		return nil, domain.ErrVersionConflict
+	}
+	u.Status = to
+	u.Version++
+	f.reasons[id] = reason
+	copied := *u
+	return &copied, nil
+}
+
+func (f *fakeUserRepo) LastStatusReasons(ctx context.Context, ids []int) (map[int]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	reasons := make(map[int]string)
+	for _, id := range ids {
+		if reason, ok := f.reasons[id]; ok {
+			reasons[id] = reason
+		}
+	}
+	return reasons, nil
+}
+
+func (f *fakeUserRepo) ExistingEmails(ctx context.Context, emails []string) (map[string]bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	existing := make(map[string]bool)
+	for _, u := range f.users {
+		existing[strings.ToLower(u.Email)] = true
+	}
+	return existing, nil
+}
+
+func (f *fakeUserRepo) CopyUsers(ctx context.Context, users []*domain.User, batchSize int) ([]*domain.User, error) {
+	created := make([]*domain.User, 0, len(users))
+	for _, u := range users {
+		user, err := f.Create(ctx, u)
+		if err != nil {
+			return nil, err
+		}
+		created = append(created, user)
+	}
+	return created, nil
+}
+
+func (f *fakeUserRepo) LinkDirectory(ctx context.Context, id int, dn string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if u, ok := f.users[id]; ok {
+		u.DirectoryDN = &dn
+		return nil
+	}
+	return domain.ErrUserNotFound
+}
+
+type fakeSessionRepo struct {
+	repository.SessionRepository
+	mu       sync.Mutex
+	sessions map[string]*domain.Session
+}
+
+func newFakeSessionRepo() *fakeSessionRepo {
+	return &fakeSessionRepo{sessions: make(map[string]*domain.Session)}
+}
+
+func (f *fakeSessionRepo) Create(ctx context.Context, token string, session *domain.Session, ttl time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sessions[token] = session
+	return nil
+}
+
+func (f *fakeSessionRepo) Get(ctx context.Context, token string) (*domain.Session, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s, ok := f.sessions[token]; ok {
+		copied := *s
+		return &copied, nil
+	}
+	return nil, domain.ErrInvalidSession
+}
+
+func (f *fakeSessionRepo) Update(ctx context.Context, token string, session *domain.Session) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.sessions[token]; !ok {
+		return domain.ErrInvalidSession
+	}
+	f.sessions[token] = session
+	return nil
+}
+
+func (f *fakeSessionRepo) Delete(ctx context.Context, token string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.sessions, token)
+	return nil
+}
+
+func (f *fakeSessionRepo) DeleteByUserID(ctx context.Context, userID int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for token, s := range f.sessions {
+		if s.UserID == userID {
+			delete(f.sessions, token)
+		}
+	}
+	return nil
+}
+
+type fakeOutboxRepo struct {
+	repository.OutboxRepository
+	mu     sync.Mutex
+	events []*domain.Event
+}
+
+func (f *fakeOutboxRepo) Append(ctx context.Context, events ...*domain.Event) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.events = append(f.events, events...)
+	return nil
+}
+
+func (f *fakeOutboxRepo) types() []domain.EventType {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	types := make([]domain.EventType, 0, len(f.events))
+	for _, e := range f.events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+type fakeTokenRepo struct {
+	mu     sync.Mutex
+	tokens map[string]bool
+}
+
+func newFakeTokenRepo() *fakeTokenRepo {
+	return &fakeTokenRepo{tokens: make(map[string]bool)}
+}
+
+func (f *fakeTokenRepo) Save(ctx context.Context, id string, ttl time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tokens[id] = true
+	return nil
+}
+
+func (f *fakeTokenRepo) Consume(ctx context.Context, id string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ok := f.tokens[id]
+	delete(f.tokens, id)
+	return ok, nil
+}
+
+type fakeRoleRepo struct {
+	repository.RoleRepository
+	roles []*domain.Role
+}
+
+func (f *fakeRoleRepo) GetByID(ctx context.Context, id int) (*domain.Role, error) {
+	for _, r := range f.roles {
+		if r.ID == id {
+			return r, nil
+		}
+	}
+	return nil, domain.ErrRoleNotFound
+}
+
+func (f *fakeRoleRepo) Create(ctx context.Context, name string) (*domain.Role, error) {
+	role := &domain.Role{ID: 1, Name: name}
+	for _, r := range f.roles {
+		role.ID = max(role.ID, r.ID+1)
+	}
+	f.roles = append(f.roles, role)
+	return role, nil
+}
+
+func (f *fakeRoleRepo) Delete(ctx context.Context, id int) error {
+	for i, r := range f.roles {
+		if r.ID == id {
+			f.roles = append(f.roles[:i], f.roles[i+1:]...)
+			return nil
+		}
+	}
+	return domain.ErrRoleNotFound
+}
+
+func (f *fakeRoleRepo) List(ctx context.Context) ([]*domain.Role, error) {
+	return f.roles, nil
+}
+
+func (f *fakeRoleRepo) ExistingIDs(ctx context.Context, ids []int) (map[int]bool, error) {
+	existing := make(map[int]bool, len(f.roles))
+	for _, r := range f.roles {
+		existing[r.ID] = true
+	}
+	return existing, nil
+}
+
+// fakeRightRepo grants rights to every role on every route.
+type fakeRightRepo struct {
+	repository.RoleRightRepository
+	rights domain.RoleRight
+}
+
+func (f *fakeRightRepo) CheckPermission(ctx context.Context, roleID int, section, route string) (*domain.RoleRight, error) {
+	rights := f.rights
+	return &rights, nil
+}
+
+func (f *fakeRightRepo) ListByRole(ctx context.Context, roleID int) ([]*domain.RoleRight, error) {
+	rights := f.rights
+	return []*domain.RoleRight{&rights}, nil
+}
+
+type fakeMailer struct {
+	mu   sync.Mutex
+	sent []*mailer.Message
+	// fail makes every Send fail.
+	fail bool
+}
+
+func (f *fakeMailer) Send(ctx context.Context, msg *mailer.Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fail {
+		return errors.New("smtp unavailable")
+	}
+	f.sent = append(f.sent, msg)
+	return nil
+}
+
+func (f *fakeMailer) recipients() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	to := make([]string, 0, len(f.sent))
+	for _, msg := range f.sent {
+		to = append(to, msg.To)
+	}
+	return to
+}
@@ -0,0 +1,257 @@
+package usecase
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strings"
+	"tablelink/internal/config"
+	"tablelink/internal/domain"
+	"tablelink/internal/federation"
+	"tablelink/internal/repository"
+	"time"
+)
+
+type FederationUseCase interface {
+	// Providers lists the identity providers users can sign in with.
+	Providers() []*domain.IdentityProvider
+	// Begin starts a login at the provider and returns the URL to send the
+	// user to and the state, which the caller keeps in the browser that
+	// started the login. returnTo is handed back by Complete.
+	Begin(ctx context.Context, providerID, returnTo string) (string, string, error)
+	// Complete finishes a login when the provider redirects back with code,
+	// and returns a session token for the local user the identity maps to.
+	// browserState is the state kept by the browser the callback came to,
+	// which must be the one the login was started with.
+	Complete(ctx context.Context, providerID, state, browserState, code string) (string, string, error)
+}
+
+type federationUseCase struct {
+	clients      map[string]*federation.Client
+	providers    []*domain.IdentityProvider
+	stateRepo    repository.FederationStateRepository
+	identityRepo repository.IdentityRepository
+	userRepo     repository.UserRepository
+	sessionRepo  repository.SessionRepository
+	outboxRepo   repository.OutboxRepository
+	cfg          *config.Config
+}
+
+func NewFederationUseCase(providers []*domain.IdentityProvider, stateRepo repository.FederationStateRepository,
+	identityRepo repository.IdentityRepository, userRepo repository.UserRepository, sessionRepo repository.SessionRepository,
+	outboxRepo repository.OutboxRepository, cfg *config.Config) FederationUseCase {
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+	clients := make(map[string]*federation.Client, len(providers))
+	for _, p := range providers {
+		clients[p.ID] = federation.NewClient(p, httpClient)
+	}
+	return &federationUseCase{
+		clients:      clients,
+		providers:    providers,
+		stateRepo:    stateRepo,
+		identityRepo: identityRepo,
+		userRepo:     userRepo,
+		sessionRepo:  sessionRepo,
+		outboxRepo:   outboxRepo,
+		cfg:          cfg,
+	}
+}
+
+func (u *federationUseCase) Providers() []*domain.IdentityProvider {
+	return u.providers
+}
+
+func (u *federationUseCase) Begin(ctx context.Context, providerID, returnTo string) (string, string, error) {
+	client, ok := u.clients[providerID]
+	if !ok {
+		return "", "", domain.ErrIdentityProviderNotFound
+	}
+
+	state, err := randomOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := randomOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+	target, err := client.AuthCodeURL(ctx, u.callbackURI(providerID), state, nonce, challenge)
+	if err != nil {
+		return "", "", err
+	}
+
+	value := &domain.FederationState{Provider: providerID, Nonce: nonce, CodeVerifier: verifier, ReturnTo: returnTo}
+	if err := u.stateRepo.Save(ctx, state, value, u.cfg.FederationStateTTL); err != nil {
+		return "", "", fmt.Errorf("Failed to save federation state with err %v", err)
+	}
+	return target, state, nil
+}
+
+func (u *federationUseCase) Complete(ctx context.Context, providerID, state, browserState, code string) (string, string, error) {
+	client, ok := u.clients[providerID]
+	if !ok {
+		return "", "", domain.ErrIdentityProviderNotFound
+	}
+	// Without this, an attacker could start a login, stop at the callback
+	// and get a victim's browser to finish it, signing the victim in as the
+	// attacker.
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(browserState)) != 1 {
+		return "", "", domain.ErrFederationState
+	}
+	saved, err := u.stateRepo.Consume(ctx, state)
+	if err != nil {
+		return "", "", err
+	}
+	// The state must come back to the provider it was created for, or a
+	// login started at one provider could be finished with another's code.
+	if saved.Provider != providerID || code == "" {
+		return "", "", domain.ErrFederationState
+	}
+
+	identity, err := client.Exchange(ctx, code, saved.CodeVerifier, u.callbackURI(providerID), saved.Nonce)
+	if err != nil {
+		return "", "", err
+	}
+
+	user, err := u.resolveUser(ctx, client.Provider(), identity)
+	if err != nil {
+		return "", "", err
+	}
+	if !user.Status.CanLogin() {
+		return "", "", domain.ErrUserNotActive
+	}
+
+	sessionToken, err := startSession(ctx, u.sessionRepo, u.outboxRepo, user, domain.AuthMethodFederated)
+	if err != nil {
+		return "", "", err
+	}
+	return sessionToken, saved.ReturnTo, nil
+}
+
+// resolveUser finds the local user of an external identity: the user it is
+// linked to, else the user with its email when the provider links by
+// email, else a new user when the provider provisions.
+func (u *federationUseCase) resolveUser(ctx context.Context, provider *domain.IdentityProvider,
+	identity *domain.ExternalIdentity) (*domain.User, error) {
+	linked, err := u.identityRepo.Get(ctx, provider.ID, identity.Subject)
+	if err == nil {
+		user, err := u.userRepo.GetByID(ctx, linked.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if err := u.identityRepo.Touch(ctx, linked.ID, identity.Email); err != nil {
+			return nil, fmt.Errorf("Failed to record identity login with err %v", err)
+		}
+		return u.syncRole(ctx, provider, identity, user)
+	}
+	if !errors.Is(err, domain.ErrIdentityNotFound) {
+		return nil, err
+	}
+
+	if !provider.LinkByEmail && !provider.AutoProvision {
+		return nil, domain.ErrNoLinkedAccount
+	}
+	if _, err := mail.ParseAddress(identity.Email); err != nil {
+		return nil, domain.ErrNoLinkedAccount
+	}
+	// An unverified email may belong to someone else, so it must not grant
+	// access to the account that owns it.
+	if !identity.EmailVerified && !provider.TrustUnverifiedEmail {
+		return nil, domain.ErrUnverifiedEmail
+	}
+
+	user, err := u.userRepo.GetByEmail(ctx, identity.Email)
+	switch {
+	case err == nil && provider.LinkByEmail:
+		user, err = u.syncRole(ctx, provider, identity, user)
+	case err == nil, errors.Is(err, domain.ErrUserNotFound) && !provider.AutoProvision:
+		return nil, domain.ErrNoLinkedAccount
+	case errors.Is(err, domain.ErrUserNotFound):
+		user, err = u.provision(ctx, provider, identity)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	email := identity.Email
+	if _, err := u.identityRepo.Link(ctx, &domain.UserIdentity{
+		UserID:   user.ID,
+		Provider: provider.ID,
+		Subject:  identity.Subject,
+		Email:    &email,
+	}); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (u *federationUseCase) provision(ctx context.Context, provider *domain.IdentityProvider,
+	identity *domain.ExternalIdentity) (*domain.User, error) {
+	if !provider.AllowsDomain(identity.Email) {
+		return nil, domain.ErrNoLinkedAccount
+	}
+	roleID := provider.RoleFor(identity.Claims)
+	if roleID == 0 {
+		return nil, domain.ErrNoMappedRole
+	}
+
+	hashed, err := unusablePasswordHash()
+	if err != nil {
+		return nil, err
+	}
+
+	name := identity.Name
+	if name == "" {
+		name = identity.Email
+	}
+	user, err := u.userRepo.Create(ctx, &domain.User{
+		Name:     name,
+		Email:    identity.Email,
+		Password: string(hashed),
+		RoleID:   roleID,
+		Status:   domain.UserStatusActive,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if identity.EmailVerified {
+		if err := u.userRepo.MarkEmailVerified(ctx, user.ID, user.Email); err != nil {
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
+// syncRole moves the user to the role the provider's rules give them, for
+// providers that manage roles.
+func (u *federationUseCase) syncRole(ctx context.Context, provider *domain.IdentityProvider,
+	identity *domain.ExternalIdentity, user *domain.User) (*domain.User, error) {
+	if !provider.SyncRoles {
+		return user, nil
+	}
+	roleID := provider.RoleFor(identity.Claims)
+	if roleID == 0 || roleID == user.RoleID {
+		return user, nil
+	}
+
+	updated := *user
+	updated.RoleID = roleID
+	updated.Password = ""
+	return u.userRepo.Update(ctx, &updated)
+}
+
+func (u *federationUseCase) callbackURI(providerID string) string {
+	return strings.TrimSuffix(u.cfg.OAuthIssuer, "/") + "/federation/" + providerID + "/callback"
+}
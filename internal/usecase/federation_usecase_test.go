@@ -0,0 +1,311 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"tablelink/internal/config"
+	"tablelink/internal/domain"
+	"tablelink/internal/federation"
+	"testing"
+	"time"
+)
+
+type fakeFederationStateRepo struct {
+	mu     sync.Mutex
+	states map[string]*domain.FederationState
+}
+
+func (f *fakeFederationStateRepo) Save(ctx context.Context, state string, value *domain.FederationState, ttl time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.states[state] = value
+	return nil
+}
+
+func (f *fakeFederationStateRepo) Consume(ctx context.Context, state string) (*domain.FederationState, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	value, ok := f.states[state]
+	if !ok {
+		return nil, domain.ErrFederationState
+	}
+	delete(f.states, state)
+	return value, nil
+}
+
+type fakeIdentityRepo struct {
+	mu         sync.Mutex
+	identities []*domain.UserIdentity
+}
+
+func (f *fakeIdentityRepo) Get(ctx context.Context, provider, subject string) (*domain.UserIdentity, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, i := range f.identities {
+		if i.Provider == provider && i.Subject == subject {
+			return i, nil
+		}
+	}
+	return nil, domain.ErrIdentityNotFound
+}
+
+func (f *fakeIdentityRepo) ListByUser(ctx context.Context, userID int) ([]*domain.UserIdentity, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var identities []*domain.UserIdentity
+	for _, i := range f.identities {
+		if i.UserID == userID {
+			identities = append(identities, i)
+		}
+	}
+	return identities, nil
+}
+
+func (f *fakeIdentityRepo) Link(ctx context.Context, identity *domain.UserIdentity) (*domain.UserIdentity, error) {
+	if _, err := f.Get(ctx, identity.Provider, identity.Subject); err == nil {
+		return nil, domain.ErrIdentityTaken
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	identity.ID = len(f.identities) + 1
+	f.identities = append(f.identities, identity)
+	return identity, nil
+}
+
+func (f *fakeIdentityRepo) Touch(ctx context.Context, id int, email string) error {
+	return nil
+}
+
+// federationFixture signs in through a FakeIdP the way a browser would.
+type federationFixture struct {
+	uc         FederationUseCase
+	idp        *federation.FakeIdP
+	users      *fakeUserRepo
+	identities *fakeIdentityRepo
+	sessions   *fakeSessionRepo
+}
+
+func newFederationFixture(t *testing.T, provider domain.IdentityProvider, users ...*domain.User) *federationFixture {
+	t.Helper()
+	idp, err := federation.NewFakeIdP("", "tablelink", "secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(idp)
+	t.Cleanup(server.Close)
+	idp.Issuer = server.URL
+
+	provider.ID, provider.Name = "fake", "Fake"
+	provider.Issuer, provider.ClientID, provider.ClientSecret = server.URL, "tablelink", "secret"
+	// A second provider at the same issuer, to finish logins at the wrong
+	// provider with.
+	other := provider
+	other.ID, other.Name = "other", "Other"
+
+	f := &federationFixture{
+		idp:        idp,
+		users:      newFakeUserRepo(users...),
+		identities: &fakeIdentityRepo{},
+		sessions:   newFakeSessionRepo(),
+	}
+	cfg := &config.Config{OAuthIssuer: "https://tablelink.test", FederationStateTTL: time.Minute}
+	f.uc = NewFederationUseCase([]*domain.IdentityProvider{&provider, &other},
+		&fakeFederationStateRepo{states: make(map[string]*domain.FederationState)},
+		f.identities, f.users, f.sessions, &fakeOutboxRepo{}, cfg)
+	return f
+}
+
+// signIn begins a login at begin, lets tamper change the authorization
+// request, follows it to the FakeIdP and completes the login at complete.
+func (f *federationFixture) signIn(t *testing.T, begin, complete string, tamper func(url.Values)) (string, error) {
+	t.Helper()
+	ctx := context.Background()
+	target, browserState, err := f.uc.Begin(ctx, begin, "/back")
+	if err != nil {
+		t.Fatalf("Begin() err = %v", err)
+	}
+	authorizeURL, err := url.Parse(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tamper != nil {
+		query := authorizeURL.Query()
+		tamper(query)
+		authorizeURL.RawQuery = query.Encode()
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authorizeURL.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	callback, err := resp.Location()
+	if err != nil {
+		t.Fatalf("FakeIdP did not redirect back: %v", err)
+	}
+
+	query := callback.Query()
+	token, returnTo, err := f.uc.Complete(ctx, complete, query.Get("state"), browserState, query.Get("code"))
+	if err == nil && returnTo != "/back" {
+		t.Errorf("Complete() returnTo = %q, want /back", returnTo)
+	}
+	return token, err
+}
+
+func TestFederationComplete(t *testing.T) {
+	ada := &domain.User{ID: 1, Name: "Ada", Email: "ada@example.org", RoleID: 3}
+	tests := []struct {
+		name     string
+		provider domain.IdentityProvider
+		claims   map[string]any
+		users    []*domain.User
+		complete string
+		tamper   func(url.Values)
+		wantErr  error
+		// failErr is set for failures without a sentinel error.
+		failErr bool
+		// wantUser and wantRole describe the user signed in.
+		wantUser int
+		wantRole int
+	}{
+		{
+			name:     "links an existing user by verified email",
+			provider: domain.IdentityProvider{LinkByEmail: true},
+			claims:   map[string]any{"sub": "s-1", "email": "ada@example.org", "email_verified": true},
+			users:    []*domain.User{ada},
+			wantUser: 1,
+			wantRole: 3,
+		},
+		{
+			name:     "provisions a user with the default role",
+			provider: domain.IdentityProvider{AutoProvision: true, DefaultRoleID: 2},
+			claims:   map[string]any{"sub": "s-1", "email": "grace@example.org", "email_verified": true, "name": "Grace"},
+			users:    []*domain.User{ada},
+			wantUser: 2,
+			wantRole: 2,
+		},
+		{
+			name: "provisions with the role of the first matching rule",
+			provider: domain.IdentityProvider{AutoProvision: true, DefaultRoleID: 2, RoleRules: []domain.RoleRule{
+				{Claim: "groups", Value: "admins", RoleID: 1},
+				{Claim: "groups", Value: "staff", RoleID: 4},
+			}},
+			claims:   map[string]any{"sub": "s-1", "email": "grace@example.org", "email_verified": true, "groups": []string{"staff", "admins"}},
+			wantUser: 1,
+			wantRole: 1,
+		},
+		{
+			name:     "moves a linked user to the mapped role with SyncRoles",
+			provider: domain.IdentityProvider{LinkByEmail: true, SyncRoles: true, RoleRules: []domain.RoleRule{{Claim: "department", Value: "ops", RoleID: 5}}},
+			claims:   map[string]any{"sub": "s-1", "email": "ada@example.org", "email_verified": true, "department": "ops"},
+			users:    []*domain.User{ada},
+			wantUser: 1,
+			wantRole: 5,
+		},
+		{
+			name:     "refuses to provision when no rule maps a role",
+			provider: domain.IdentityProvider{AutoProvision: true, RoleRules: []domain.RoleRule{{Claim: "groups", Value: "admins", RoleID: 1}}},
+			claims:   map[string]any{"sub": "s-1", "email": "grace@example.org", "email_verified": true, "groups": []string{"staff"}},
+			wantErr:  domain.ErrNoMappedRole,
+		},
+		{
+			name:     "refuses an unverified email",
+			provider: domain.IdentityProvider{LinkByEmail: true, AutoProvision: true, DefaultRoleID: 2},
+			claims:   map[string]any{"sub": "s-1", "email": "ada@example.org", "email_verified": false},
+			users:    []*domain.User{ada},
+			wantErr:  domain.ErrUnverifiedEmail,
+		},
+		{
+			name:     "refuses an email match without LinkByEmail",
+			provider: domain.IdentityProvider{AutoProvision: true, DefaultRoleID: 2},
+			claims:   map[string]any{"sub": "s-1", "email": "ada@example.org", "email_verified": true},
+			users:    []*domain.User{ada},
+			wantErr:  domain.ErrNoLinkedAccount,
+		},
+		{
+			name:     "refuses an ID token with the wrong nonce",
+			provider: domain.IdentityProvider{LinkByEmail: true},
+			claims:   map[string]any{"sub": "s-1", "email": "ada@example.org", "email_verified": true},
+			users:    []*domain.User{ada},
+			tamper:   func(q url.Values) { q.Set("nonce", "replayed") },
+			failErr:  true,
+		},
+		{
+			name:     "refuses state made for another provider",
+			provider: domain.IdentityProvider{LinkByEmail: true},
+			claims:   map[string]any{"sub": "s-1", "email": "ada@example.org", "email_verified": true},
+			users:    []*domain.User{ada},
+			complete: "other",
+			wantErr:  domain.ErrFederationState,
+		},
+		{
+			name:     "refuses state the browser did not start",
+			provider: domain.IdentityProvider{LinkByEmail: true},
+			claims:   map[string]any{"sub": "s-1", "email": "ada@example.org", "email_verified": true},
+			users:    []*domain.User{ada},
+			tamper:   func(q url.Values) { q.Set("state", "forged") },
+			wantErr:  domain.ErrFederationState,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var users []*domain.User
+			for _, u := range tt.users {
+				copied := *u
+				users = append(users, &copied)
+			}
+			f := newFederationFixture(t, tt.provider, users...)
+			f.idp.Claims = tt.claims
+			complete := tt.complete
+			if complete == "" {
+				complete = "fake"
+			}
+
+			token, err := f.signIn(t, "fake", complete, tt.tamper)
+			if tt.wantErr != nil || tt.failErr {
+				if err == nil || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+					t.Fatalf("Complete() err = %v, want %v", err, tt.wantErr)
+				}
+				if len(f.sessions.sessions) != 0 || len(f.identities.identities) != 0 {
+					t.Errorf("failed login left %d sessions and %d identities", len(f.sessions.sessions), len(f.identities.identities))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Complete() err = %v", err)
+			}
+
+			session := f.sessions.sessions[token]
+			if session == nil || session.UserID != tt.wantUser || session.AuthMethod != domain.AuthMethodFederated {
+				t.Fatalf("session = %+v, want a federated session of user %d", session, tt.wantUser)
+			}
+			if user := f.users.get(tt.wantUser); user.RoleID != tt.wantRole {
+				t.Errorf("user role = %d, want %d", user.RoleID, tt.wantRole)
+			}
+			if identity, err := f.identities.Get(context.Background(), "fake", "s-1"); err != nil || identity.UserID != tt.wantUser {
+				t.Errorf("identity = %+v, %v, want linked to user %d", identity, err, tt.wantUser)
+			}
+
+			// The identity is linked now, so the next login finds it
+			// without looking at the email.
+			f.idp.Claims["email"] = "changed@example.org"
+			again, err := f.signIn(t, "fake", "fake", nil)
+			if err != nil || f.sessions.sessions[again].UserID != tt.wantUser {
+				t.Errorf("second login = %v, want user %d", err, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestFederationBeginUnknownProvider(t *testing.T) {
+	f := newFederationFixture(t, domain.IdentityProvider{LinkByEmail: true})
+	if _, _, err := f.uc.Begin(context.Background(), "missing", ""); !errors.Is(err, domain.ErrIdentityProviderNotFound) {
+		t.Fatalf("Begin() err = %v, want %v", err, domain.ErrIdentityProviderNotFound)
+	}
+}
@@ -0,0 +1,113 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"tablelink/internal/config"
+	"tablelink/internal/domain"
+	"tablelink/internal/repository"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ImpersonationUseCase interface {
+	// Impersonate starts a session in which the calling user acts as
+	// userID, and returns its token and when it expires. Every call made
+	// with the token, and every change, is recorded with both users.
+	Impersonate(ctx context.Context, roleID int, userID int, reason string) (string, time.Time, error)
+	// RecordCall audits a call to method by the impersonation principal in
+	// ctx, so reads leave a trace as well as changes.
+	RecordCall(ctx context.Context, method string) error
+}
+
+type impersonationUseCase struct {
+	userRepo    repository.UserRepository
+	rightRepo   repository.RoleRightRepository
+	sessionRepo repository.SessionRepository
+	outboxRepo  repository.OutboxRepository
+	cfg         *config.Config
+}
+
+func NewImpersonationUseCase(userRepo repository.UserRepository, rightRepo repository.RoleRightRepository,
+	sessionRepo repository.SessionRepository, outboxRepo repository.OutboxRepository, cfg *config.Config) ImpersonationUseCase {
+	return &impersonationUseCase{
+		userRepo:    userRepo,
+		rightRepo:   rightRepo,
+		sessionRepo: sessionRepo,
+		outboxRepo:  outboxRepo,
+		cfg:         cfg,
+	}
+}
+
+func (u *impersonationUseCase) Impersonate(ctx context.Context, roleID int, userID int, reason string) (string, time.Time, error) {
+	if err := authorize(ctx, u.rightRepo, roleID, domain.ImpersonationSection, domain.ImpersonationRoute, "create"); err != nil {
+		return "", time.Time{}, err
+	}
+
+	// Only a person can be held to account for what they do as someone
+	// else, and an impersonation session cannot start another one.
+	principal := domain.PrincipalFromContext(ctx)
+	if principal == nil || principal.Kind != domain.PrincipalUser || principal.ImpersonatorID != 0 {
+		return "", time.Time{}, domain.ErrImpersonationNotAllowed
+	}
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return "", time.Time{}, domain.ErrImpersonationReasonRequired
+	}
+
+	user, err := u.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if user.ID == principal.UserID || user.Kind == domain.UserKindService || !user.Status.CanLogin() {
+		return "", time.Time{}, domain.ErrImpersonationNotAllowed
+	}
+	err = roleWithin(ctx, u.rightRepo, user.RoleID, roleID)
+	if errors.Is(err, domain.ErrRoleEscalation) {
+		return "", time.Time{}, domain.ErrImpersonationEscalation
+	}
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	token := uuid.NewString()
+	now := time.Now().UTC()
+	expiresAt := now.Add(u.cfg.ImpersonationTTL)
+	session := &domain.Session{
+		UserID:         user.ID,
+		Email:          user.Email,
+		RoleID:         user.RoleID,
+		LastAccess:     now,
+		ImpersonatorID: principal.UserID,
+		AuthTime:       now,
+		AuthMethod:     domain.AuthMethodImpersonation,
+	}
+	if err := u.sessionRepo.Create(ctx, token, session, u.cfg.ImpersonationTTL); err != nil {
+		return "", time.Time{}, fmt.Errorf("Failed to save impersonation session with err %v", err)
+	}
+
+	// Unlike logins, an impersonation that cannot be audited must not
+	// happen at all.
+	if err := u.outboxRepo.Append(ctx, domain.NewImpersonationEvent(session, reason)); err != nil {
+		if err := u.sessionRepo.Delete(ctx, token); err != nil {
+			log.Printf("Failed to remove unaudited impersonation session of user %d with err %v", user.ID, err)
+		}
+		return "", time.Time{}, fmt.Errorf("Failed to record impersonation with err %v", err)
+	}
+	return token, expiresAt, nil
+}
+
+func (u *impersonationUseCase) RecordCall(ctx context.Context, method string) error {
+	principal := domain.PrincipalFromContext(ctx)
+	if principal == nil || principal.ImpersonatorID == 0 {
+		return nil
+	}
+	if err := u.outboxRepo.Append(ctx, domain.NewImpersonatedCallEvent(principal, method)); err != nil {
+		return fmt.Errorf("Failed to audit impersonated call with err %v", err)
+	}
+	return nil
+}
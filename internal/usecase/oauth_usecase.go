@@ -0,0 +1,738 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"tablelink/internal/config"
+	"tablelink/internal/domain"
+	"tablelink/internal/repository"
+	"tablelink/internal/token"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type OAuthUseCase interface {
+	// RegisterClient stores a new client. Confidential clients get a
+	// secret, which is returned once and only its hash is kept.
+	RegisterClient(ctx context.Context, client *domain.OAuthClient, confidential bool) (*domain.OAuthClient, string, error)
+	ListClients(ctx context.Context) ([]*domain.OAuthClient, error)
+	DeleteClient(ctx context.Context, id string) error
+
+	// ValidateAuthorization checks an authorization code request before the
+	// user logs in and returns the client and the scopes it asks for. When
+	// it fails with domain.ErrOAuthClientNotFound or
+	// domain.ErrInvalidRedirectURI the user must not be redirected back.
+	ValidateAuthorization(ctx context.Context, req *domain.AuthorizationRequest) (*domain.OAuthClient, []string, error)
+	// SessionUser returns the user signed in with a session from
+	// AuthUseCase.Login, failing with domain.ErrLoginRequired when the
+	// session is gone.
+	SessionUser(ctx context.Context, sessionToken string) (*domain.User, error)
+	// Authorize issues an authorization code to the user of the session for
+	// the scopes the user's role holds, and remembers that the user allowed
+	// the client those scopes.
+	Authorize(ctx context.Context, req *domain.AuthorizationRequest, sessionToken string) (string, error)
+	// AuthorizeWithoutPrompt is Authorize for prompt=none, where the user is
+	// not asked. It fails with domain.ErrConsentRequired unless the user
+	// allowed the client every scope the code would carry before.
+	AuthorizeWithoutPrompt(ctx context.Context, req *domain.AuthorizationRequest, sessionToken string) (string, error)
+
+	ExchangeCode(ctx context.Context, clientID, secret, code, redirectURI, verifier string) (*domain.TokenSet, error)
+	ClientCredentials(ctx context.Context, clientID, secret, scope string) (*domain.TokenSet, error)
+	Refresh(ctx context.Context, clientID, secret, refreshToken, scope string) (*domain.TokenSet, error)
+	// Revoke revokes an access token, or a refresh token together with the
+	// whole grant it belongs to. Unknown tokens are not an error (RFC 7009).
+	Revoke(ctx context.Context, clientID, secret, token string) error
+	// Introspect returns what an active token grants, or nil when the token
+	// is not active.
+	Introspect(ctx context.Context, clientID, secret, token string) (*domain.TokenInfo, error)
+
+	// UserInfo returns the claims an access token with the openid scope
+	// may see about its user.
+	UserInfo(ctx context.Context, accessToken string) (*domain.UserClaims, error)
+	// ValidateLogout checks an RP-initiated logout request. The ID token
+	// hint may be expired but must have been issued by us, and the
+	// post-logout redirect uri must be registered for its client.
+	ValidateLogout(ctx context.Context, idTokenHint, clientID, postLogoutRedirectURI string) error
+}
+
+type oauthUseCase struct {
+	oauthRepo   repository.OAuthRepository
+	userRepo    repository.UserRepository
+	rightRepo   repository.RoleRightRepository
+	sessionRepo repository.SessionRepository
+	signer      *token.Signer
+	cfg         *config.Config
+}
+
+func NewOAuthUseCase(oauthRepo repository.OAuthRepository, userRepo repository.UserRepository, rightRepo repository.RoleRightRepository,
+	sessionRepo repository.SessionRepository, signer *token.Signer, cfg *config.Config) OAuthUseCase {
+	return &oauthUseCase{
+		oauthRepo:   oauthRepo,
+		userRepo:    userRepo,
+		rightRepo:   rightRepo,
+		sessionRepo: sessionRepo,
+		signer:      signer,
+		cfg:         cfg,
+	}
+}
+
+func (u *oauthUseCase) RegisterClient(ctx context.Context, client *domain.OAuthClient, confidential bool) (*domain.OAuthClient, string, error) {
+	if err := validateOAuthClient(client, confidential); err != nil {
+		return nil, "", err
+	}
+
+	client.ID = uuid.NewString()
+	client.SecretHash = nil
+	var secret string
+	if confidential {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, "", fmt.Errorf("Failed to generate client secret with err %v", err)
+		}
+		secret = hex.EncodeToString(buf)
+		hash := hashOpaqueToken(secret)
+		client.SecretHash = &hash
+	}
+
+	created, err := u.oauthRepo.CreateClient(ctx, client)
+	if err != nil {
+		return nil, "", err
+	}
+	return created, secret, nil
+}
+
+func validateOAuthClient(client *domain.OAuthClient, confidential bool) error {
+	if strings.TrimSpace(client.Name) == "" || len(client.GrantTypes) == 0 {
+		return domain.ErrInvalidOAuthClient
+	}
+	for _, g := range client.GrantTypes {
+		switch g {
+		case domain.GrantAuthorizationCode:
+		case domain.GrantClientCredentials:
+			if !confidential || client.RoleID == nil {
+				return fmt.Errorf("%w: client credentials need a confidential client with a role", domain.ErrInvalidOAuthClient)
+			}
+		case domain.GrantRefreshToken:
+			if !client.AllowsGrant(domain.GrantAuthorizationCode) {
+				return fmt.Errorf("%w: refresh tokens need the authorization code grant", domain.ErrInvalidOAuthClient)
+			}
+		default:
+			return fmt.Errorf("%w: %s", domain.ErrUnsupportedGrantType, g)
+		}
+	}
+
+	if client.AllowsGrant(domain.GrantAuthorizationCode) && len(client.RedirectURIs) == 0 {
+		return domain.ErrInvalidOAuthClient
+	}
+	for _, raw := range slices.Concat(client.RedirectURIs, client.PostLogoutRedirectURIs) {
+		parsed, err := url.Parse(raw)
+		if err != nil || !parsed.IsAbs() || parsed.Fragment != "" {
+			return fmt.Errorf("%w: invalid redirect uri %q", domain.ErrInvalidOAuthClient, raw)
+		}
+	}
+	for _, scope := range client.Scopes {
+		if _, _, ok := domain.ParseScope(scope); !ok && !domain.IsOIDCScope(scope) {
+			return fmt.Errorf("%w: %s", domain.ErrInvalidScope, scope)
+		}
+	}
+	return nil
+}
+
+func (u *oauthUseCase) ListClients(ctx context.Context) ([]*domain.OAuthClient, error) {
+	return u.oauthRepo.ListClients(ctx)
+}
+
+func (u *oauthUseCase) DeleteClient(ctx context.Context, id string) error {
+	return u.oauthRepo.DeleteClient(ctx, id)
+}
+
+func (u *oauthUseCase) ValidateAuthorization(ctx context.Context, req *domain.AuthorizationRequest) (*domain.OAuthClient, []string, error) {
+	client, err := u.oauthRepo.GetClient(ctx, req.ClientID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !client.AllowsRedirect(req.RedirectURI) {
+		return nil, nil, domain.ErrInvalidRedirectURI
+	}
+
+	if !client.AllowsGrant(domain.GrantAuthorizationCode) {
+		return client, nil, domain.ErrUnauthorizedClient
+	}
+	// PKCE is required of every client, confidential ones included, and
+	// only with S256: the plain method gives no protection when the request
+	// leaks.
+	if req.CodeChallengeMethod != "S256" || len(req.CodeChallenge) != 43 {
+		return client, nil, domain.ErrPKCERequired
+	}
+	scopes, err := requestedScopes(req.Scope, client.Scopes)
+	if err != nil {
+		return client, nil, err
+	}
+	return client, scopes, nil
+}
+
+func (u *oauthUseCase) SessionUser(ctx context.Context, sessionToken string) (*domain.User, error) {
+	user, _, err := u.sessionUser(ctx, sessionToken)
+	return user, err
+}
+
+func (u *oauthUseCase) sessionUser(ctx context.Context, sessionToken string) (*domain.User, *domain.Session, error) {
+	if sessionToken == "" {
+		return nil, nil, domain.ErrLoginRequired
+	}
+	session, err := u.sessionRepo.Get(ctx, sessionToken)
+	if err != nil {
+		return nil, nil, domain.ErrLoginRequired
+	}
+	// Tokens issued from an impersonation would outlive it and carry no
+	// trace of the impersonator, so clients only ever get the user's own
+	// login.
+	if session.ImpersonatorID != 0 {
+		return nil, nil, domain.ErrLoginRequired
+	}
+
+	user, err := u.userRepo.GetByID(ctx, session.UserID)
+	if errors.Is(err, domain.ErrUserNotFound) {
+		return nil, nil, domain.ErrLoginRequired
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if !user.Status.CanLogin() {
+		return nil, nil, domain.ErrUserNotActive
+	}
+	return user, session, nil
+}
+
+func (u *oauthUseCase) Authorize(ctx context.Context, req *domain.AuthorizationRequest, sessionToken string) (string, error) {
+	return u.authorize(ctx, req, sessionToken, false)
+}
+
+func (u *oauthUseCase) AuthorizeWithoutPrompt(ctx context.Context, req *domain.AuthorizationRequest, sessionToken string) (string, error) {
+	return u.authorize(ctx, req, sessionToken, true)
+}
+
+func (u *oauthUseCase) authorize(ctx context.Context, req *domain.AuthorizationRequest, sessionToken string, silent bool) (string, error) {
+	client, scopes, err := u.ValidateAuthorization(ctx, req)
+	if err != nil {
+		return "", err
+	}
+
+	user, session, err := u.sessionUser(ctx, sessionToken)
+	if err != nil {
+		return "", err
+	}
+
+	scopes, err = u.grantableScopes(ctx, user.RoleID, scopes)
+	if err != nil {
+		return "", err
+	}
+
+	if silent {
+		consented, err := u.oauthRepo.GetConsent(ctx, user.ID, client.ID)
+		if err != nil {
+			return "", fmt.Errorf("Failed to get consent with err %v", err)
+		}
+		for _, scope := range scopes {
+			if !slices.Contains(consented, scope) {
+				return "", domain.ErrConsentRequired
+			}
+		}
+	} else if err := u.oauthRepo.SaveConsent(ctx, user.ID, client.ID, scopes); err != nil {
+		return "", fmt.Errorf("Failed to save consent with err %v", err)
+	}
+
+	// Sessions from before auth_time was recorded were stamped at login.
+	authTime := session.AuthTime
+	if authTime.IsZero() {
+		authTime = session.LastAccess
+	}
+
+	code, err := randomOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	err = u.oauthRepo.CreateCode(ctx, &domain.OAuthCode{
+		CodeHash:      hashOpaqueToken(code),
+		GrantID:       uuid.NewString(),
+		ClientID:      client.ID,
+		UserID:        user.ID,
+		RedirectURI:   req.RedirectURI,
+		Scopes:        scopes,
+		CodeChallenge: req.CodeChallenge,
+		Nonce:         req.Nonce,
+		AuthTime:      &authTime,
+		ExpiresAt:     time.Now().Add(u.cfg.OAuthCodeTTL),
+	})
+	if err != nil {
+		return "", fmt.Errorf("Failed to save authorization code with err %v", err)
+	}
+	return code, nil
+}
+
+func (u *oauthUseCase) ExchangeCode(ctx context.Context, clientID, secret, code, redirectURI, verifier string) (*domain.TokenSet, error) {
+	client, err := u.authenticateClient(ctx, clientID, secret)
+	if err != nil {
+		return nil, err
+	}
+	if !client.AllowsGrant(domain.GrantAuthorizationCode) {
+		return nil, domain.ErrUnauthorizedClient
+	}
+
+	grant, err := u.oauthRepo.ConsumeCode(ctx, hashOpaqueToken(code))
+	if err != nil {
+		return nil, err
+	}
+	if grant.UsedAt != nil {
+		// A replayed code may have been stolen, so revoke what the first
+		// exchange issued (RFC 6749 section 4.1.2).
+		if err := u.oauthRepo.RevokeGrant(ctx, grant.GrantID); err != nil {
+			return nil, fmt.Errorf("Failed to revoke grant with err %v", err)
+		}
+		return nil, domain.ErrInvalidGrant
+	}
+	if grant.ClientID != client.ID || grant.RedirectURI != redirectURI || time.Now().After(grant.ExpiresAt) {
+		return nil, domain.ErrInvalidGrant
+	}
+	if !verifyCodeChallenge(grant.CodeChallenge, verifier) {
+		return nil, domain.ErrInvalidGrant
+	}
+
+	user, err := u.userRepo.GetByID(ctx, grant.UserID)
+	if err != nil || !user.Status.CanLogin() {
+		return nil, domain.ErrInvalidGrant
+	}
+
+	access, set, err := u.newAccessToken(client.ID, grant.GrantID, &user.ID, grant.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	if err := u.signIDToken(set, client.ID, user, grant.Nonce, grant.AuthTime); err != nil {
+		return nil, err
+	}
+	tokens := []*domain.OAuthToken{access}
+	if client.AllowsGrant(domain.GrantRefreshToken) {
+		refresh, err := u.newRefreshToken(client.ID, grant.GrantID, &user.ID, grant.Scopes, set)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, refresh)
+	}
+
+	if err := u.oauthRepo.CreateTokens(ctx, tokens...); err != nil {
+		return nil, fmt.Errorf("Failed to save tokens with err %v", err)
+	}
+	return set, nil
+}
+
+func (u *oauthUseCase) ClientCredentials(ctx context.Context, clientID, secret, scope string) (*domain.TokenSet, error) {
+	client, err := u.authenticateClient(ctx, clientID, secret)
+	if err != nil {
+		return nil, err
+	}
+	if client.Public() || client.RoleID == nil || !client.AllowsGrant(domain.GrantClientCredentials) {
+		return nil, domain.ErrUnauthorizedClient
+	}
+
+	scopes, err := requestedScopes(scope, client.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	scopes, err = u.grantableScopes(ctx, *client.RoleID, scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	access, set, err := u.newAccessToken(client.ID, uuid.NewString(), nil, scopes)
+	if err != nil {
+		return nil, err
+	}
+	if err := u.oauthRepo.CreateTokens(ctx, access); err != nil {
+		return nil, fmt.Errorf("Failed to save tokens with err %v", err)
+	}
+	return set, nil
+}
+
+func (u *oauthUseCase) Refresh(ctx context.Context, clientID, secret, refreshToken, scope string) (*domain.TokenSet, error) {
+	client, err := u.authenticateClient(ctx, clientID, secret)
+	if err != nil {
+		return nil, err
+	}
+	if !client.AllowsGrant(domain.GrantRefreshToken) {
+		return nil, domain.ErrUnauthorizedClient
+	}
+
+	hash := hashOpaqueToken(refreshToken)
+	current, err := u.oauthRepo.GetToken(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	if current.Kind != domain.OAuthRefreshToken || current.ClientID != client.ID || current.UserID == nil {
+		return nil, domain.ErrInvalidGrant
+	}
+	if current.RevokedAt != nil {
+		// Refresh tokens are rotated, so a revoked one coming back means
+		// two parties hold it. Cut both off.
+		return nil, u.revokeReplayedGrant(ctx, current.GrantID)
+	}
+	if time.Now().After(current.ExpiresAt) {
+		return nil, domain.ErrInvalidGrant
+	}
+
+	scopes := current.Scopes
+	if strings.TrimSpace(scope) != "" {
+		if scopes, err = requestedScopes(scope, current.Scopes); err != nil {
+			return nil, err
+		}
+	}
+
+	user, err := u.userRepo.GetByID(ctx, *current.UserID)
+	if err != nil || !user.Status.CanLogin() {
+		return nil, domain.ErrInvalidGrant
+	}
+	// The role may have lost rights since the grant was issued.
+	if scopes, err = u.grantableScopes(ctx, user.RoleID, scopes); err != nil {
+		return nil, err
+	}
+
+	access, set, err := u.newAccessToken(client.ID, current.GrantID, &user.ID, scopes)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := u.newRefreshToken(client.ID, current.GrantID, &user.ID, scopes, set)
+	if err != nil {
+		return nil, err
+	}
+	if err := u.signIDToken(set, client.ID, user, "", nil); err != nil {
+		return nil, err
+	}
+	if err := u.oauthRepo.RotateToken(ctx, hash, access, refresh); err != nil {
+		if errors.Is(err, domain.ErrInvalidGrant) {
+			return nil, u.revokeReplayedGrant(ctx, current.GrantID)
+		}
+		return nil, fmt.Errorf("Failed to rotate refresh token with err %v", err)
+	}
+	return set, nil
+}
+
+func (u *oauthUseCase) revokeReplayedGrant(ctx context.Context, grantID string) error {
+	if err := u.oauthRepo.RevokeGrant(ctx, grantID); err != nil {
+		return fmt.Errorf("Failed to revoke grant with err %v", err)
+	}
+	return domain.ErrInvalidGrant
+}
+
+func (u *oauthUseCase) Revoke(ctx context.Context, clientID, secret, token string) error {
+	client, err := u.authenticateClient(ctx, clientID, secret)
+	if err != nil {
+		return err
+	}
+
+	hash := hashOpaqueToken(token)
+	current, err := u.oauthRepo.GetToken(ctx, hash)
+	if errors.Is(err, domain.ErrInvalidGrant) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// Clients may only revoke their own tokens. Anything else is ignored
+	// like an unknown token, so it does not reveal that the token exists.
+	if current.ClientID != client.ID {
+		return nil
+	}
+
+	if current.Kind == domain.OAuthRefreshToken {
+		return u.oauthRepo.RevokeGrant(ctx, current.GrantID)
+	}
+	return u.oauthRepo.RevokeToken(ctx, hash)
+}
+
+func (u *oauthUseCase) Introspect(ctx context.Context, clientID, secret, token string) (*domain.TokenInfo, error) {
+	if _, err := u.authenticateClient(ctx, clientID, secret); err != nil {
+		return nil, err
+	}
+
+	current, err := u.oauthRepo.GetToken(ctx, hashOpaqueToken(token))
+	if errors.Is(err, domain.ErrInvalidGrant) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if current.Kind != domain.OAuthAccessToken || !current.Active(time.Now()) {
+		return nil, nil
+	}
+
+	info := &domain.TokenInfo{
+		ClientID:  current.ClientID,
+		UserID:    current.UserID,
+		Scopes:    current.Scopes,
+		ExpiresAt: current.ExpiresAt,
+		IssuedAt:  current.CreatedAt,
+	}
+	if current.UserID != nil {
+		user, err := u.userRepo.GetByID(ctx, *current.UserID)
+		if errors.Is(err, domain.ErrUserNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !user.Status.CanLogin() {
+			return nil, nil
+		}
+		info.RoleID = user.RoleID
+		return info, nil
+	}
+
+	client, err := u.oauthRepo.GetClient(ctx, current.ClientID)
+	if errors.Is(err, domain.ErrOAuthClientNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if client.RoleID == nil {
+		return nil, nil
+	}
+	info.RoleID = *client.RoleID
+	return info, nil
+}
+
+func (u *oauthUseCase) UserInfo(ctx context.Context, accessToken string) (*domain.UserClaims, error) {
+	current, err := u.oauthRepo.GetToken(ctx, hashOpaqueToken(accessToken))
+	if errors.Is(err, domain.ErrInvalidGrant) {
+		return nil, domain.ErrInvalidAccessToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if current.Kind != domain.OAuthAccessToken || !current.Active(time.Now()) || current.UserID == nil {
+		return nil, domain.ErrInvalidAccessToken
+	}
+	if !slices.Contains(current.Scopes, domain.ScopeOpenID) {
+		return nil, domain.ErrInsufficientScope
+	}
+
+	user, err := u.userRepo.GetByID(ctx, *current.UserID)
+	if errors.Is(err, domain.ErrUserNotFound) {
+		return nil, domain.ErrInvalidAccessToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !user.Status.CanLogin() {
+		return nil, domain.ErrInvalidAccessToken
+	}
+	return domain.NewUserClaims(user, current.Scopes), nil
+}
+
+func (u *oauthUseCase) ValidateLogout(ctx context.Context, idTokenHint, clientID, postLogoutRedirectURI string) error {
+	if idTokenHint != "" {
+		var claims domain.IDTokenClaims
+		if err := u.signer.Verify(idTokenHint, &claims); err != nil || claims.Issuer != u.issuer() {
+			return token.ErrInvalidToken
+		}
+		if clientID != "" && clientID != claims.Audience {
+			return token.ErrInvalidToken
+		}
+		clientID = claims.Audience
+	}
+	if postLogoutRedirectURI == "" {
+		return nil
+	}
+	if clientID == "" {
+		return domain.ErrInvalidRedirectURI
+	}
+
+	client, err := u.oauthRepo.GetClient(ctx, clientID)
+	if err != nil {
+		return err
+	}
+	if !client.AllowsPostLogoutRedirect(postLogoutRedirectURI) {
+		return domain.ErrInvalidRedirectURI
+	}
+	return nil
+}
+
+// signIDToken adds an ID token to set when the openid scope was granted.
+func (u *oauthUseCase) signIDToken(set *domain.TokenSet, clientID string, user *domain.User, nonce string, authTime *time.Time) error {
+	if !slices.Contains(set.Scopes, domain.ScopeOpenID) {
+		return nil
+	}
+
+	// at_hash is the left half of the SHA-256 of the access token (OpenID
+	// Connect Core section 3.1.3.6).
+	sum := sha256.Sum256([]byte(set.AccessToken))
+	now := time.Now()
+	claims := &domain.IDTokenClaims{
+		Issuer:          u.issuer(),
+		Audience:        clientID,
+		ExpiresAt:       now.Add(u.cfg.OIDCIDTokenTTL).Unix(),
+		IssuedAt:        now.Unix(),
+		Nonce:           nonce,
+		AccessTokenHash: base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2]),
+		UserClaims:      domain.NewUserClaims(user, set.Scopes),
+	}
+	if authTime != nil {
+		claims.AuthTime = authTime.Unix()
+	}
+
+	idToken, err := u.signer.Sign(claims)
+	if err != nil {
+		return err
+	}
+	set.IDToken = idToken
+	return nil
+}
+
+func (u *oauthUseCase) issuer() string {
+	return strings.TrimSuffix(u.cfg.OAuthIssuer, "/")
+}
+
+// authenticateClient checks the client secret. Public clients have none and
+// must not send one.
+func (u *oauthUseCase) authenticateClient(ctx context.Context, clientID, secret string) (*domain.OAuthClient, error) {
+	client, err := u.oauthRepo.GetClient(ctx, clientID)
+	if errors.Is(err, domain.ErrOAuthClientNotFound) {
+		return nil, domain.ErrInvalidClient
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if client.Public() {
+		if secret != "" {
+			return nil, domain.ErrInvalidClient
+		}
+		return client, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(hashOpaqueToken(secret)), []byte(*client.SecretHash)) != 1 {
+		return nil, domain.ErrInvalidClient
+	}
+	return client, nil
+}
+
+// grantableScopes keeps the scopes whose section and action roleID holds on
+// at least one route in role_rights, and the OpenID Connect scopes, which
+// any user may grant. Asking only for scopes the role lacks is an error.
+func (u *oauthUseCase) grantableScopes(ctx context.Context, roleID int, scopes []string) ([]string, error) {
+	rights, err := u.rightRepo.ListByRole(ctx, roleID)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list role rights with err %v", err)
+	}
+
+	granted := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		if domain.IsOIDCScope(scope) {
+			granted = append(granted, scope)
+			continue
+		}
+		section, action, _ := domain.ParseScope(scope)
+		for _, right := range rights {
+			if right.Section == section && right.Allows(action) {
+				granted = append(granted, scope)
+				break
+			}
+		}
+	}
+	if len(granted) == 0 {
+		return nil, domain.ErrInvalidScope
+	}
+	return granted, nil
+}
+
+func (u *oauthUseCase) newAccessToken(clientID, grantID string, userID *int, scopes []string) (*domain.OAuthToken, *domain.TokenSet, error) {
+	raw, err := randomOpaqueToken()
+	if err != nil {
+		return nil, nil, err
+	}
+	ttl := u.cfg.OAuthAccessTokenTTL
+	token := &domain.OAuthToken{
+		TokenHash: hashOpaqueToken(raw),
+		Kind:      domain.OAuthAccessToken,
+		GrantID:   grantID,
+		ClientID:  clientID,
+		UserID:    userID,
+		Scopes:    scopes,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	return token, &domain.TokenSet{AccessToken: raw, ExpiresIn: ttl, Scopes: scopes}, nil
+}
+
+func (u *oauthUseCase) newRefreshToken(clientID, grantID string, userID *int, scopes []string, set *domain.TokenSet) (*domain.OAuthToken, error) {
+	raw, err := randomOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	set.RefreshToken = raw
+	return &domain.OAuthToken{
+		TokenHash: hashOpaqueToken(raw),
+		Kind:      domain.OAuthRefreshToken,
+		GrantID:   grantID,
+		ClientID:  clientID,
+		UserID:    userID,
+		Scopes:    scopes,
+		ExpiresAt: time.Now().Add(u.cfg.OAuthRefreshTokenTTL),
+	}, nil
+}
+
+// requestedScopes parses a space separated scope parameter, which must be a
+// subset of allowed. An empty parameter asks for everything allowed.
+func requestedScopes(scope string, allowed []string) ([]string, error) {
+	requested := strings.Fields(scope)
+	if len(requested) == 0 {
+		requested = allowed
+	}
+	if len(requested) == 0 {
+		return nil, domain.ErrInvalidScope
+	}
+
+	scopes := make([]string, 0, len(requested))
+	for _, s := range requested {
+		if !slices.Contains(allowed, s) {
+			return nil, fmt.Errorf("%w: %s", domain.ErrInvalidScope, s)
+		}
+		if !slices.Contains(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes, nil
+}
+
+// verifyCodeChallenge checks a PKCE verifier against an S256 challenge
+// (RFC 7636 section 4.6).
+func verifyCodeChallenge(challenge, verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+func randomOpaqueToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("Failed to generate token with err %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashOpaqueToken is how codes, tokens and client secrets are stored. They
+// are random and long, so a fast hash is enough.
+func hashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
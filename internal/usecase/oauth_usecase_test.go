@@ -0,0 +1,387 @@
+package usecase
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"tablelink/internal/config"
+	"tablelink/internal/domain"
+	"tablelink/internal/repository"
+	"testing"
+	"time"
+)
+
+// fakeOAuthRepo keeps codes and tokens by hash, the way the tables do.
+type fakeOAuthRepo struct {
+	repository.OAuthRepository
+	mu       sync.Mutex
+	clients  map[string]*domain.OAuthClient
+	consents map[string][]string
+	codes    map[string]*domain.OAuthCode
+	tokens   map[string]*domain.OAuthToken
+}
+
+func consentKey(userID int, clientID string) string {
+	return fmt.Sprintf("%d:%s", userID, clientID)
+}
+
+func (f *fakeOAuthRepo) SaveConsent(ctx context.Context, userID int, clientID string, scopes []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := consentKey(userID, clientID)
+	for _, scope := range scopes {
+		if !slices.Contains(f.consents[key], scope) {
+			f.consents[key] = append(f.consents[key], scope)
+		}
+	}
+	return nil
+}
+
+func (f *fakeOAuthRepo) GetConsent(ctx context.Context, userID int, clientID string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.consents[consentKey(userID, clientID)], nil
+}
+
+func (f *fakeOAuthRepo) GetClient(ctx context.Context, id string) (*domain.OAuthClient, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if c, ok := f.clients[id]; ok {
+		return c, nil
+	}
+	return nil, domain.ErrOAuthClientNotFound
+}
+
+func (f *fakeOAuthRepo) CreateCode(ctx context.Context, code *domain.OAuthCode) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.codes[code.CodeHash] = code
+	return nil
+}
+
+func (f *fakeOAuthRepo) ConsumeCode(ctx context.Context, codeHash string) (*domain.OAuthCode, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	code, ok := f.codes[codeHash]
+	if !ok {
+		return nil, domain.ErrInvalidGrant
+	}
+	before := *code
+	now := time.Now()
+	code.UsedAt = &now
+	return &before, nil
+}
+
+func (f *fakeOAuthRepo) CreateTokens(ctx context.Context, tokens ...*domain.OAuthToken) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, t := range tokens {
+		f.tokens[t.TokenHash] = t
+	}
+	return nil
+}
+
+func (f *fakeOAuthRepo) GetToken(ctx context.Context, tokenHash string) (*domain.OAuthToken, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if t, ok := f.tokens[tokenHash]; ok {
+		copied := *t
+		return &copied, nil
+	}
+	return nil, domain.ErrInvalidGrant
+}
+
+func (f *fakeOAuthRepo) RotateToken(ctx context.Context, tokenHash string, replacements ...*domain.OAuthToken) error {
+	f.mu.Lock()
+	t, ok := f.tokens[tokenHash]
+	if !ok || t.RevokedAt != nil {
+		f.mu.Unlock()
+		return domain.ErrInvalidGrant
+	}
+	now := time.Now()
+	t.RevokedAt = &now
+	f.mu.Unlock()
+	return f.CreateTokens(ctx, replacements...)
+}
+
+func (f *fakeOAuthRepo) RevokeGrant(ctx context.Context, grantID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	for _, t := range f.tokens {
+		if t.GrantID == grantID && t.RevokedAt == nil {
+			t.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+// active reports whether the raw token is stored and not revoked.
+func (f *fakeOAuthRepo) active(raw string) bool {
+	t, err := f.GetToken(context.Background(), hashOpaqueToken(raw))
+	return err == nil && t.Active(time.Now())
+}
+
+const oauthRedirectURI = "https://app.test/callback"
+
+type oauthFixture struct {
+	uc   OAuthUseCase
+	repo *fakeOAuthRepo
+}
+
+// newOAuthFixture registers the public clients "spa", which may read and
+// update users, and "other", and signs Ada, user 1, in with the session
+// "session".
+func newOAuthFixture() *oauthFixture {
+	repo := &fakeOAuthRepo{
+		clients: map[string]*domain.OAuthClient{
+			"spa": {
+				ID:           "spa",
+				RedirectURIs: []string{oauthRedirectURI},
+				GrantTypes:   []string{domain.GrantAuthorizationCode, domain.GrantRefreshToken},
+				Scopes:       []string{"users:read", "users:update"},
+			},
+			"other": {
+				ID:           "other",
+				RedirectURIs: []string{oauthRedirectURI},
+				GrantTypes:   []string{domain.GrantAuthorizationCode, domain.GrantRefreshToken},
+				Scopes:       []string{"users:read"},
+			},
+		},
+		consents: make(map[string][]string),
+		codes:    make(map[string]*domain.OAuthCode),
+		tokens:   make(map[string]*domain.OAuthToken),
+	}
+	users := newFakeUserRepo(&domain.User{ID: 1, Name: "Ada", Email: "ada@example.org", RoleID: 1, Status: domain.UserStatusActive})
+	sessions := newFakeSessionRepo()
+	sessions.Create(context.Background(), "session", &domain.Session{UserID: 1, AuthTime: time.Now()}, time.Hour)
+	rights := &fakeRightRepo{rights: domain.RoleRight{Section: "users", RRead: true, RUpdate: true}}
+	cfg := &config.Config{OAuthCodeTTL: time.Minute, OAuthAccessTokenTTL: time.Minute, OAuthRefreshTokenTTL: time.Hour}
+	return &oauthFixture{uc: NewOAuthUseCase(repo, users, rights, sessions, nil, cfg), repo: repo}
+}
+
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// authorize issues a code to "spa" for the challenge of verifier.
+func (f *oauthFixture) authorize(t *testing.T, verifier string) string {
+	t.Helper()
+	code, err := f.uc.Authorize(context.Background(), &domain.AuthorizationRequest{
+		ClientID:            "spa",
+		RedirectURI:         oauthRedirectURI,
+		Scope:               "users:read users:update",
+		CodeChallenge:       codeChallenge(verifier),
+		CodeChallengeMethod: "S256",
+	}, "session")
+	if err != nil {
+		t.Fatalf("Authorize() err = %v", err)
+	}
+	return code
+}
+
+// tokens exchanges a fresh code of "spa" for tokens.
+func (f *oauthFixture) tokens(t *testing.T) *domain.TokenSet {
+	t.Helper()
+	verifier := strings.Repeat("v", 43)
+	set, err := f.uc.ExchangeCode(context.Background(), "spa", "", f.authorize(t, verifier), oauthRedirectURI, verifier)
+	if err != nil {
+		t.Fatalf("ExchangeCode() err = %v", err)
+	}
+	return set
+}
+
+func TestValidateAuthorizationPKCE(t *testing.T) {
+	tests := []struct {
+		name      string
+		challenge string
+		method    string
+		wantErr   error
+	}{
+		{name: "accepts an S256 challenge", challenge: codeChallenge(strings.Repeat("v", 43)), method: "S256"},
+		{name: "requires a challenge", method: "S256", wantErr: domain.ErrPKCERequired},
+		{name: "refuses the plain method", challenge: strings.Repeat("v", 43), method: "plain", wantErr: domain.ErrPKCERequired},
+		{name: "refuses a challenge without a method", challenge: codeChallenge(strings.Repeat("v", 43)), wantErr: domain.ErrPKCERequired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newOAuthFixture()
+			_, _, err := f.uc.ValidateAuthorization(context.Background(), &domain.AuthorizationRequest{
+				ClientID:            "spa",
+				RedirectURI:         oauthRedirectURI,
+				CodeChallenge:       tt.challenge,
+				CodeChallengeMethod: tt.method,
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ValidateAuthorization() err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthorizeWithoutPrompt(t *testing.T) {
+	tests := []struct {
+		name string
+		// consented is what Ada allowed "spa" before, nil when the consent
+		// page was never shown.
+		consented []string
+		scope     string
+		wantErr   error
+	}{
+		{name: "refuses a client the user never allowed", scope: "users:read", wantErr: domain.ErrConsentRequired},
+		{name: "issues a code for allowed scopes", consented: []string{"users:read", "users:update"}, scope: "users:read"},
+		{name: "refuses scopes beyond the ones allowed", consented: []string{"users:read"}, scope: "users:read users:update", wantErr: domain.ErrConsentRequired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			f := newOAuthFixture()
+			if tt.consented != nil {
+				if err := f.repo.SaveConsent(ctx, 1, "spa", tt.consented); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			code, err := f.uc.AuthorizeWithoutPrompt(ctx, &domain.AuthorizationRequest{
+				ClientID:            "spa",
+				RedirectURI:         oauthRedirectURI,
+				Scope:               tt.scope,
+				CodeChallenge:       codeChallenge(strings.Repeat("v", 43)),
+				CodeChallengeMethod: "S256",
+			}, "session")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("AuthorizeWithoutPrompt() err = %v, want %v", err, tt.wantErr)
+			}
+			if (code != "") != (tt.wantErr == nil) || (tt.wantErr != nil && len(f.repo.codes) != 0) {
+				t.Errorf("code = %q with %d stored, want one only on success", code, len(f.repo.codes))
+			}
+		})
+	}
+}
+
+func TestAuthorizeRemembersConsent(t *testing.T) {
+	ctx := context.Background()
+	f := newOAuthFixture()
+	f.authorize(t, strings.Repeat("v", 43))
+
+	consented, err := f.repo.GetConsent(ctx, 1, "spa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"users:read", "users:update"}; !slices.Equal(consented, want) {
+		t.Errorf("consent = %q, want %q", consented, want)
+	}
+}
+
+func TestExchangeCode(t *testing.T) {
+	verifier := strings.Repeat("v", 43)
+	tests := []struct {
+		name        string
+		clientID    string
+		verifier    string
+		redirectURI string
+		// replay exchanges the code once before the call under test.
+		replay  bool
+		wantErr error
+	}{
+		{name: "issues tokens for the right verifier", clientID: "spa", verifier: verifier, redirectURI: oauthRedirectURI},
+		{name: "refuses a wrong verifier", clientID: "spa", verifier: strings.Repeat("w", 43), redirectURI: oauthRedirectURI, wantErr: domain.ErrInvalidGrant},
+		{name: "refuses a short verifier", clientID: "spa", verifier: "v", redirectURI: oauthRedirectURI, wantErr: domain.ErrInvalidGrant},
+		{name: "refuses another redirect uri", clientID: "spa", verifier: verifier, redirectURI: "https://app.test/other", wantErr: domain.ErrInvalidGrant},
+		{name: "refuses another client", clientID: "other", verifier: verifier, redirectURI: oauthRedirectURI, wantErr: domain.ErrInvalidGrant},
+		{name: "refuses a replayed code", clientID: "spa", verifier: verifier, redirectURI: oauthRedirectURI, replay: true, wantErr: domain.ErrInvalidGrant},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			f := newOAuthFixture()
+			code := f.authorize(t, verifier)
+			var first *domain.TokenSet
+			if tt.replay {
+				var err error
+				if first, err = f.uc.ExchangeCode(ctx, "spa", "", code, oauthRedirectURI, verifier); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			set, err := f.uc.ExchangeCode(ctx, tt.clientID, "", code, tt.redirectURI, tt.verifier)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ExchangeCode() err = %v, want %v", err, tt.wantErr)
+			}
+			if first != nil && (f.repo.active(first.AccessToken) || f.repo.active(first.RefreshToken)) {
+				t.Errorf("tokens of the first exchange are still active after a replay")
+			}
+			if tt.wantErr == nil && (!f.repo.active(set.AccessToken) || !f.repo.active(set.RefreshToken)) {
+				t.Errorf("token set = %+v, want an active access and refresh token", set)
+			}
+		})
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	tests := []struct {
+		name     string
+		clientID string
+		scope    string
+		// rotations refreshes this many times before the call under test,
+		// which then uses the original refresh token.
+		rotations  int
+		expired    bool
+		wantErr    error
+		wantScopes []string
+	}{
+		{name: "rotates the refresh token", clientID: "spa", wantScopes: []string{"users:read", "users:update"}},
+		{name: "narrows the scopes", clientID: "spa", scope: "users:read", wantScopes: []string{"users:read"}},
+		{name: "refuses a scope that was not granted", clientID: "spa", scope: "users:delete", wantErr: domain.ErrInvalidScope},
+		{name: "refuses another client", clientID: "other", wantErr: domain.ErrInvalidGrant},
+		{name: "refuses an expired token", clientID: "spa", expired: true, wantErr: domain.ErrInvalidGrant},
+		{name: "revokes the grant when a rotated token comes back", clientID: "spa", rotations: 1, wantErr: domain.ErrInvalidGrant},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			f := newOAuthFixture()
+			issued := f.tokens(t)
+			if tt.expired {
+				f.repo.tokens[hashOpaqueToken(issued.RefreshToken)].ExpiresAt = time.Now().Add(-time.Second)
+			}
+			latest := issued
+			for i := 0; i < tt.rotations; i++ {
+				var err error
+				if latest, err = f.uc.Refresh(ctx, "spa", "", latest.RefreshToken, ""); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			set, err := f.uc.Refresh(ctx, tt.clientID, "", issued.RefreshToken, tt.scope)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Refresh() err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.rotations > 0 && (f.repo.active(latest.AccessToken) || f.repo.active(latest.RefreshToken)) {
+				t.Errorf("tokens of the grant are still active after a replayed refresh token")
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if f.repo.active(issued.RefreshToken) {
+				t.Errorf("used refresh token is still active")
+			}
+			if !f.repo.active(set.AccessToken) || !f.repo.active(set.RefreshToken) || set.RefreshToken == issued.RefreshToken {
+				t.Errorf("token set = %+v, want a new active access and refresh token", set)
+			}
+			if !slices.Equal(set.Scopes, tt.wantScopes) {
+				t.Errorf("scopes = %q, want %q", set.Scopes, tt.wantScopes)
+			}
+		})
+	}
+}
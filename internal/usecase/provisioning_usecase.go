@@ -0,0 +1,386 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"tablelink/internal/config"
+	"tablelink/internal/domain"
+	"tablelink/internal/repository"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const provisioningReason = "provisioned by identity provider"
+
+// ProvisioningUseCase manages users and roles on behalf of an external
+// identity provider. The provider is trusted as a whole, so unlike
+// UserUseCase there is no per-role authorization.
+type ProvisioningUseCase interface {
+	// ListUsers returns the users matching filter, and CountUsers how many
+	// there are regardless of its Offset and Limit.
+	ListUsers(ctx context.Context, filter *domain.UserFilter) ([]*domain.User, error)
+	CountUsers(ctx context.Context, filter *domain.UserFilter) (int, error)
+	GetUser(ctx context.Context, id int) (*domain.User, error)
+	// CreateUser creates user with the default role. Without a password the
+	// user gets an unusable one and has to sign in some other way.
+	CreateUser(ctx context.Context, user *domain.User, active bool) (*domain.User, error)
+	// ReplaceUser sets the name, email, password and external id (the last
+	// two when given) and active state of the user. A non-zero user.Version
+	// must match the current one.
+	ReplaceUser(ctx context.Context, user *domain.User, active bool) (*domain.User, error)
+	DeleteUser(ctx context.Context, id, version int) error
+
+	ListGroups(ctx context.Context) ([]*domain.Group, error)
+	GetGroup(ctx context.Context, id int) (*domain.Group, error)
+	CreateGroup(ctx context.Context, name string, memberIDs []int) (*domain.Group, error)
+	// ReplaceGroup renames the role and gives it exactly memberIDs. Users
+	// that leave the role fall back to the default role.
+	ReplaceGroup(ctx context.Context, id int, name string, memberIDs []int) (*domain.Group, error)
+	DeleteGroup(ctx context.Context, id int) error
+}
+
+type provisioningUseCase struct {
+	userRepo    repository.UserRepository
+	roleRepo    repository.RoleRepository
+	sessionRepo repository.SessionRepository
+	cfg         *config.Config
+}
+
+func NewProvisioningUseCase(userRepo repository.UserRepository, roleRepo repository.RoleRepository,
+	sessionRepo repository.SessionRepository, cfg *config.Config) ProvisioningUseCase {
+	return &provisioningUseCase{
+		userRepo:    userRepo,
+		roleRepo:    roleRepo,
+		sessionRepo: sessionRepo,
+		cfg:         cfg,
+	}
+}
+
+func (u *provisioningUseCase) ListUsers(ctx context.Context, filter *domain.UserFilter) ([]*domain.User, error) {
+	// Service accounts are not the identity provider's to manage.
+	filter.IncludeServiceAccounts = false
+	return u.userRepo.ListAll(ctx, filter)
+}
+
+func (u *provisioningUseCase) CountUsers(ctx context.Context, filter *domain.UserFilter) (int, error) {
+	filter.IncludeServiceAccounts = false
+	return u.userRepo.Count(ctx, filter)
+}
+
+func (u *provisioningUseCase) GetUser(ctx context.Context, id int) (*domain.User, error) {
+	return u.user(ctx, id)
+}
+
+// user returns the user id. Service accounts are not the identity
+// provider's to manage, so they are not found.
+func (u *provisioningUseCase) user(ctx context.Context, id int) (*domain.User, error) {
+	user, err := u.userRepo.GetByID(ctx, id)
+	if err == nil && user.Kind == domain.UserKindService {
+		return nil, domain.ErrUserNotFound
+	}
+	return user, err
+}
+
+func (u *provisioningUseCase) CreateUser(ctx context.Context, user *domain.User, active bool) (*domain.User, error) {
+	if err := validateProvisionedUser(user); err != nil {
+		return nil, err
+	}
+
+	if u.cfg.SCIMDefaultRoleID == 0 {
+		return nil, domain.ErrNoDefaultRole
+	}
+	if _, err := u.roleRepo.GetByID(ctx, u.cfg.SCIMDefaultRoleID); err != nil {
+		return nil, err
+	}
+	user.RoleID = u.cfg.SCIMDefaultRoleID
+
+	if user.Password == "" {
+		hashed, err := unusablePasswordHash()
+		if err != nil {
+			return nil, err
+		}
+		user.Password = hashed
+	} else {
+		hashed, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to hash password with err %v", err)
+		}
+		user.Password = string(hashed)
+	}
+
+	user.Status = domain.UserStatusActive
+	if !active {
+		user.Status = domain.UserStatusSuspended
+	}
+
+	return u.userRepo.Create(ctx, user)
+}
+
+func (u *provisioningUseCase) ReplaceUser(ctx context.Context, user *domain.User, active bool) (*domain.User, error) {
+	if err := validateProvisionedUser(user); err != nil {
+		return nil, err
+	}
+
+	current, err := u.user(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Version != 0 && user.Version != current.Version {
+		return nil, domain.ErrVersionConflict
+	}
+
+	externalIDChanged := user.ExternalID != nil && (current.ExternalID == nil || *user.ExternalID != *current.ExternalID)
+	if user.Name != current.Name || user.Email != current.Email || user.Password != "" || externalIDChanged {
+		changed := &domain.User{
+			ID:         current.ID,
+			Name:       user.Name,
+			Email:      user.Email,
+			RoleID:     current.RoleID,
+			ExternalID: user.ExternalID,
+			Version:    current.Version,
+		}
+		if user.Password != "" {
+			hashed, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to hash password with err %v", err)
+			}
+			changed.Password = string(hashed)
+		}
+		if current, err = u.userRepo.Update(ctx, changed); err != nil {
+			return nil, err
+		}
+	}
+
+	to := current.Status
+	switch {
+	case active:
+		to = domain.UserStatusActive
+	case current.Status.CanLogin():
+		to = domain.UserStatusSuspended
+	}
+	if to == current.Status {
+		return current, nil
+	}
+	if !current.Status.CanTransitionTo(to) {
+		return nil, domain.ErrInvalidTransition
+	}
+
+	updated, err := u.userRepo.ChangeStatus(ctx, current.ID, current.Version, current.Status, to, provisioningReason)
+	if err != nil {
+		return nil, err
+	}
+	if to.RevokesSessions() {
+		if err := u.sessionRepo.DeleteByUserID(ctx, updated.ID); err != nil {
+			return nil, fmt.Errorf("Failed to revoke sessions with err %v", err)
+		}
+	}
+	return updated, nil
+}
+
+func (u *provisioningUseCase) DeleteUser(ctx context.Context, id, version int) error {
+	current, err := u.user(ctx, id)
+	if err != nil {
+		return err
+	}
+	if version == 0 {
+		version = current.Version
+	}
+
+	if err := u.userRepo.Delete(ctx, id, version); err != nil {
+		return err
+	}
+	if err := u.sessionRepo.DeleteByUserID(ctx, id); err != nil {
+		return fmt.Errorf("Failed to revoke sessions with err %v", err)
+	}
+	return nil
+}
+
+func (u *provisioningUseCase) ListGroups(ctx context.Context) ([]*domain.Group, error) {
+	roles, err := u.roleRepo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	users, err := u.userRepo.ListAll(ctx, &domain.UserFilter{})
+	if err != nil {
+		return nil, err
+	}
+
+	members := make(map[int][]*domain.User)
+	for _, user := range users {
+		members[user.RoleID] = append(members[user.RoleID], user)
+	}
+
+	groups := make([]*domain.Group, 0, len(roles))
+	for _, role := range roles {
+		groups = append(groups, &domain.Group{Role: role, Members: members[role.ID]})
+	}
+	return groups, nil
+}
+
+func (u *provisioningUseCase) GetGroup(ctx context.Context, id int) (*domain.Group, error) {
+	role, err := u.roleRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	members, err := u.userRepo.ListAll(ctx, &domain.UserFilter{RoleID: id})
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.Group{Role: role, Members: members}, nil
+}
+
+func (u *provisioningUseCase) CreateGroup(ctx context.Context, name string, memberIDs []int) (*domain.Group, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, domain.ErrInvalidRoleName
+	}
+
+	role, err := u.roleRepo.Create(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := u.setMembers(ctx, role.ID, nil, memberIDs); err != nil {
+		// The members are moved in one atomic batch, so nobody has the
+		// role yet and it can go again.
+		if deleteErr := u.roleRepo.Delete(context.WithoutCancel(ctx), role.ID); deleteErr != nil {
+			return nil, fmt.Errorf("%w (failed to remove role %d with err %v)", err, role.ID, deleteErr)
+		}
+		return nil, err
+	}
+
+	return u.GetGroup(ctx, role.ID)
+}
+
+func (u *provisioningUseCase) ReplaceGroup(ctx context.Context, id int, name string, memberIDs []int) (*domain.Group, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, domain.ErrInvalidRoleName
+	}
+
+	group, err := u.GetGroup(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if name != group.Role.Name {
+		if _, err := u.roleRepo.Rename(ctx, id, name); err != nil {
+			return nil, err
+		}
+	}
+	if err := u.setMembers(ctx, id, group.Members, memberIDs); err != nil {
+		return nil, err
+	}
+
+	return u.GetGroup(ctx, id)
+}
+
+func (u *provisioningUseCase) DeleteGroup(ctx context.Context, id int) error {
+	group, err := u.GetGroup(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := u.setMembers(ctx, id, group.Members, nil); err != nil {
+		return err
+	}
+
+	return u.roleRepo.Delete(ctx, id)
+}
+
+// setMembers moves the users in memberIDs into roleID and the current members
+// that are not listed back to the default role, all or nothing.
+func (u *provisioningUseCase) setMembers(ctx context.Context, roleID int, current []*domain.User, memberIDs []int) error {
+	wanted := make(map[int]bool, len(memberIDs))
+	for _, id := range memberIDs {
+		wanted[id] = true
+	}
+
+	var updates []*domain.UserBatchUpdate
+	for _, user := range current {
+		if wanted[user.ID] {
+			delete(wanted, user.ID)
+			continue
+		}
+		if u.cfg.SCIMDefaultRoleID == 0 {
+			return domain.ErrNoDefaultRole
+		}
+		if roleID == u.cfg.SCIMDefaultRoleID {
+			return domain.ErrDefaultRoleLocked
+		}
+		updates = append(updates, roleUpdate(user, u.cfg.SCIMDefaultRoleID))
+	}
+
+	if len(wanted) > 0 {
+		ids := make([]int, 0, len(wanted))
+		for id := range wanted {
+			ids = append(ids, id)
+		}
+		users, err := u.userRepo.GetByIDs(ctx, ids)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			user, ok := users[id]
+			if !ok || user.Kind == domain.UserKindService {
+				return fmt.Errorf("%w: %d", domain.ErrUserNotFound, id)
+			}
+			updates = append(updates, roleUpdate(user, roleID))
+		}
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+
+	results, err := u.userRepo.BatchUpdate(ctx, updates, true)
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		if result.Err != nil && !errors.Is(result.Err, domain.ErrBatchAborted) {
+			return result.Err
+		}
+	}
+	return nil
+}
+
+func roleUpdate(user *domain.User, roleID int) *domain.UserBatchUpdate {
+	return &domain.UserBatchUpdate{
+		ID:         user.ID,
+		Version:    user.Version,
+		RoleID:     roleID,
+		FromStatus: user.Status,
+		FromRoleID: user.RoleID,
+	}
+}
+
+func validateProvisionedUser(user *domain.User) error {
+	user.Name = strings.TrimSpace(user.Name)
+	user.Email = strings.TrimSpace(user.Email)
+	if _, err := mail.ParseAddress(user.Email); err != nil || user.Name == "" {
+		return domain.ErrInvalidEmail
+	}
+	if user.Password != "" && len(user.Password) < 8 {
+		return domain.ErrWeakPassword
+	}
+	return nil
+}
+
+// unusablePasswordHash returns the hash of a random password nobody knows.
+// It is stored for users who never sign in with a password of ours, such as
+// those of an identity provider, SCIM, the directory or service accounts,
+// so password login fails for them like for a wrong password.
+func unusablePasswordHash() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("Failed to generate password with err %v", err)
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(base64.RawURLEncoding.EncodeToString(buf)), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("Failed to hash password with err %v", err)
+	}
+	return string(hashed), nil
+}
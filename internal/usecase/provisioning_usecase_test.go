@@ -0,0 +1,141 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"tablelink/internal/config"
+	"tablelink/internal/domain"
+	"tablelink/internal/repository"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newTestProvisioning(users repository.UserRepository, roles *fakeRoleRepo) ProvisioningUseCase {
+	return NewProvisioningUseCase(users, roles, newFakeSessionRepo(), &config.Config{SCIMDefaultRoleID: 1})
+}
+
+func TestCreateGroup(t *testing.T) {
+	tests := []struct {
+		name    string
+		members []int
+		// stale makes Grace change after the members were looked up.
+		stale   bool
+		wantErr error
+	}{
+		{name: "creates the role and moves the members", members: []int{1, 2}},
+		{name: "creates an empty group"},
+		{name: "removes the role when a member is unknown", members: []int{1, 9}, wantErr: domain.ErrUserNotFound},
+		{name: "removes the role when a member changed meanwhile", members: []int{1, 2}, stale: true, wantErr: domain.ErrVersionConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			users := newFakeUserRepo(
+				&domain.User{ID: 1, Email: "ada@example.org", RoleID: 1, Version: 1},
+				&domain.User{ID: 2, Email: "grace@example.org", RoleID: 1, Version: 1},
+			)
+			roles := &fakeRoleRepo{roles: []*domain.Role{{ID: 1, Name: "member"}}}
+			uc := newTestProvisioning(users, roles)
+			if tt.stale {
+				uc = newTestProvisioning(&staleUserRepo{fakeUserRepo: users, stale: 2}, roles)
+			}
+
+			group, err := uc.CreateGroup(ctx, " admins ", tt.members)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CreateGroup() err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if len(roles.roles) != 1 {
+					t.Errorf("roles = %d, want the new role removed", len(roles.roles))
+				}
+				for _, id := range []int{1, 2} {
+					if u := users.get(id); u.RoleID != 1 {
+						t.Errorf("user %d role = %d, want 1", id, u.RoleID)
+					}
+				}
+				return
+			}
+			if group.Role.Name != "admins" || len(group.Members) != len(tt.members) {
+				t.Errorf("group = %s with %d members, want admins with %d", group.Role.Name, len(group.Members), len(tt.members))
+			}
+			for _, id := range tt.members {
+				if u := users.get(id); u.RoleID != group.Role.ID {
+					t.Errorf("user %d role = %d, want %d", id, u.RoleID, group.Role.ID)
+				}
+			}
+		})
+	}
+}
+
+// staleUserRepo returns user stale with an outdated version from GetByIDs,
+// as if it changed between the lookup and the batch.
+type staleUserRepo struct {
+	*fakeUserRepo
+	stale int
+}
+
+func (s *staleUserRepo) GetByIDs(ctx context.Context, ids []int) (map[int]*domain.User, error) {
+	users, err := s.fakeUserRepo.GetByIDs(ctx, ids)
+	if u, ok := users[s.stale]; ok {
+		u.Version--
+	}
+	return users, err
+}
+
+func TestReplaceUser(t *testing.T) {
+	externalID := func(s string) *string { return &s }
+	tests := []struct {
+		name           string
+		version        int
+		externalID     *string
+		active         bool
+		wantErr        error
+		wantStatus     domain.UserStatus
+		wantExternalID string
+	}{
+		{name: "keeps the external id when none is sent", active: true, wantStatus: domain.UserStatusActive, wantExternalID: "okta-1"},
+		{name: "sets a new external id", externalID: externalID("okta-2"), active: true, wantStatus: domain.UserStatusActive, wantExternalID: "okta-2"},
+		{name: "suspends an inactive user", active: false, wantStatus: domain.UserStatusSuspended, wantExternalID: "okta-1"},
+		{name: "refuses a stale version", version: 1, active: true, wantErr: domain.ErrVersionConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			users := newFakeUserRepo(&domain.User{ID: 1, Name: "Ada", Email: "ada@example.org", RoleID: 1, Version: 2, ExternalID: externalID("okta-1")})
+			uc := newTestProvisioning(users, &fakeRoleRepo{roles: []*domain.Role{{ID: 1}}})
+
+			user, err := uc.ReplaceUser(ctx, &domain.User{ID: 1, Name: "Ada", Email: "ada@example.org", Version: tt.version, ExternalID: tt.externalID}, tt.active)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReplaceUser() err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if user.Status != tt.wantStatus || user.ExternalID == nil || *user.ExternalID != tt.wantExternalID {
+				t.Errorf("user = %+v, want status %s and external id %s", user, tt.wantStatus, tt.wantExternalID)
+			}
+		})
+	}
+}
+
+func TestUnusablePasswordHash(t *testing.T) {
+	first, err := unusablePasswordHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := unusablePasswordHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Errorf("two hashes are equal, want a new random password each time")
+	}
+	for _, guess := range []string{"", "password"} {
+		if bcrypt.CompareHashAndPassword([]byte(first), []byte(guess)) == nil {
+			t.Errorf("hash matches %q", guess)
+		}
+	}
+}
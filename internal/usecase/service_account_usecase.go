@@ -0,0 +1,250 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"tablelink/internal/domain"
+	"tablelink/internal/repository"
+	"time"
+)
+
+// serviceAccountEmailDomain gives service accounts an address, which users
+// need, in a reserved domain (RFC 2606) that can never receive mail.
+const serviceAccountEmailDomain = "service-accounts.invalid"
+
+type ServiceAccountUseCase interface {
+	CreateServiceAccount(ctx context.Context, roleID int, section, route string, account *domain.User) (*domain.User, error)
+	ListServiceAccounts(ctx context.Context, roleID int, section, route string) ([]*domain.User, error)
+	DeleteServiceAccount(ctx context.Context, roleID int, section, route string, id, version int) error
+	// AddCredential adds a secret or, with publicKey, a public key to an
+	// account. It also returns what the account authenticates with: the
+	// secret, which is not stored and cannot be shown again, or the kid to
+	// sign assertions with.
+	AddCredential(ctx context.Context, roleID int, section, route string, accountID int, credentialType domain.CredentialType,
+		publicKey string, expiresAt *time.Time) (*domain.ServiceAccountCredential, string, error)
+	ListCredentials(ctx context.Context, roleID int, section, route string, accountID int) ([]*domain.ServiceAccountCredential, error)
+	RevokeCredential(ctx context.Context, roleID int, section, route string, accountID, credentialID int) error
+}
+
+type serviceAccountUseCase struct {
+	serviceAccountRepo repository.ServiceAccountRepository
+	userRepo           repository.UserRepository
+	rightRepo          repository.RoleRightRepository
+}
+
+func NewServiceAccountUseCase(serviceAccountRepo repository.ServiceAccountRepository, userRepo repository.UserRepository,
+	rightRepo repository.RoleRightRepository) ServiceAccountUseCase {
+	return &serviceAccountUseCase{
+		serviceAccountRepo: serviceAccountRepo,
+		userRepo:           userRepo,
+		rightRepo:          rightRepo,
+	}
+}
+
+func (u *serviceAccountUseCase) CreateServiceAccount(ctx context.Context, roleID int, section, route string, account *domain.User) (*domain.User, error) {
+	if err := authorize(ctx, u.rightRepo, roleID, section, route, "create"); err != nil {
+		return nil, err
+	}
+
+	account.Name = strings.TrimSpace(account.Name)
+	slug := serviceAccountSlug(account.Name)
+	if slug == "" {
+		return nil, domain.ErrInvalidServiceAccount
+	}
+	if err := roleWithin(ctx, u.rightRepo, account.RoleID, roleID); err != nil {
+		return nil, err
+	}
+
+	hashed, err := unusablePasswordHash()
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := u.userRepo.Create(ctx, &domain.User{
+		Name:     account.Name,
+		Email:    slug + "@" + serviceAccountEmailDomain,
+		Password: string(hashed),
+		RoleID:   account.RoleID,
+		Status:   domain.UserStatusActive,
+		Kind:     domain.UserKindService,
+	})
+	if errors.Is(err, domain.ErrEmailTaken) {
+		return nil, domain.ErrServiceAccountNameTaken
+	}
+	return created, err
+}
+
+// serviceAccountSlug turns a name into the local part of the account's
+// email, which is what keeps names unique.
+func serviceAccountSlug(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+			dash = false
+		case b.Len() > 0 && !dash:
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+func (u *serviceAccountUseCase) ListServiceAccounts(ctx context.Context, roleID int, section, route string) ([]*domain.User, error) {
+	if err := authorize(ctx, u.rightRepo, roleID, section, route, "read"); err != nil {
+		return nil, err
+	}
+
+	return u.serviceAccountRepo.List(ctx)
+}
+
+func (u *serviceAccountUseCase) DeleteServiceAccount(ctx context.Context, roleID int, section, route string, id, version int) error {
+	if err := authorize(ctx, u.rightRepo, roleID, section, route, "delete"); err != nil {
+		return err
+	}
+	if _, err := u.account(ctx, id); err != nil {
+		return err
+	}
+
+	return u.userRepo.Delete(ctx, id, version)
+}
+
+func (u *serviceAccountUseCase) AddCredential(ctx context.Context, roleID int, section, route string, accountID int,
+	credentialType domain.CredentialType, publicKey string, expiresAt *time.Time) (*domain.ServiceAccountCredential, string, error) {
+	if err := authorize(ctx, u.rightRepo, roleID, section, route, "create"); err != nil {
+		return nil, "", err
+	}
+	if _, err := keyCreator(ctx); err != nil {
+		return nil, "", err
+	}
+	if !credentialType.Valid() {
+		return nil, "", domain.ErrInvalidCredentialType
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, "", domain.ErrInvalidExpiry
+	}
+	account, err := u.account(ctx, accountID)
+	if err != nil {
+		return nil, "", err
+	}
+	// A credential acts with the account's role.
+	if err := roleWithin(ctx, u.rightRepo, account.RoleID, roleID); err != nil {
+		return nil, "", err
+	}
+
+	keyID, err := newCredentialKeyID()
+	if err != nil {
+		return nil, "", err
+	}
+	credential := &domain.ServiceAccountCredential{
+		UserID:    account.ID,
+		Type:      credentialType,
+		KeyID:     keyID,
+		ExpiresAt: expiresAt,
+	}
+
+	var returned string
+	if credentialType == domain.CredentialSecret {
+		secret, err := randomOpaqueToken()
+		if err != nil {
+			return nil, "", err
+		}
+		returned = domain.ServiceAccountSecretPrefix + keyID + "_" + secret
+		hash := hashOpaqueToken(returned)
+		credential.SecretHash = &hash
+	} else {
+		normalized, err := normalizePublicKey(publicKey)
+		if err != nil {
+			return nil, "", err
+		}
+		credential.PublicKey = &normalized
+		returned = keyID
+	}
+
+	created, err := u.serviceAccountRepo.CreateCredential(ctx, credential)
+	if err != nil {
+		return nil, "", err
+	}
+	return created, returned, nil
+}
+
+func (u *serviceAccountUseCase) ListCredentials(ctx context.Context, roleID int, section, route string, accountID int) ([]*domain.ServiceAccountCredential, error) {
+	if err := authorize(ctx, u.rightRepo, roleID, section, route, "read"); err != nil {
+		return nil, err
+	}
+	if _, err := u.account(ctx, accountID); err != nil {
+		return nil, err
+	}
+
+	return u.serviceAccountRepo.ListCredentials(ctx, accountID)
+}
+
+func (u *serviceAccountUseCase) RevokeCredential(ctx context.Context, roleID int, section, route string, accountID, credentialID int) error {
+	if err := authorize(ctx, u.rightRepo, roleID, section, route, "delete"); err != nil {
+		return err
+	}
+
+	return u.serviceAccountRepo.RevokeCredential(ctx, accountID, credentialID)
+}
+
+func (u *serviceAccountUseCase) account(ctx context.Context, id int) (*domain.User, error) {
+	account, err := u.userRepo.GetByID(ctx, id)
+	if errors.Is(err, domain.ErrUserNotFound) || (err == nil && account.Kind != domain.UserKindService) {
+		return nil, domain.ErrServiceAccountNotFound
+	}
+	return account, err
+}
+
+func newCredentialKeyID() (string, error) {
+	buf := make([]byte, apiKeyPrefixBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("Failed to generate credential id with err %v", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// normalizePublicKey accepts an RSA public key as a PEM "PUBLIC KEY" or
+// "RSA PUBLIC KEY" block and returns it as the former.
+func normalizePublicKey(raw string) (string, error) {
+	key, err := parsePublicKey(raw)
+	if err != nil {
+		return "", err
+	}
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", domain.ErrInvalidPublicKey
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+func parsePublicKey(raw string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(strings.TrimSpace(raw)))
+	if block == nil {
+		return nil, domain.ErrInvalidPublicKey
+	}
+
+	var key *rsa.PublicKey
+	switch block.Type {
+	case "PUBLIC KEY":
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, domain.ErrInvalidPublicKey
+		}
+		key, _ = parsed.(*rsa.PublicKey)
+	case "RSA PUBLIC KEY":
+		key, _ = x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	if key == nil || key.N.BitLen() < 2048 {
+		return nil, domain.ErrInvalidPublicKey
+	}
+	return key, nil
+}
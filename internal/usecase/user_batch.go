@@ -0,0 +1,179 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"tablelink/internal/domain"
+)
+
+const maxBatchSize = 500
+
+func (u *userUseCase) BatchUpdateUsers(ctx context.Context, roleID int, section, route string, updates []*domain.UserBatchUpdate, atomic bool) ([]*domain.BatchResult, error) {
+	if err := u.authorize(ctx, roleID, section, route, "update"); err != nil {
+		return nil, err
+	}
+	if err := checkBatchSize(len(updates)); err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, 0, len(updates))
+	roleIDs := make([]int, 0, len(updates))
+	for _, upd := range updates {
+		ids = append(ids, upd.ID)
+		if upd.RoleID > 0 {
+			roleIDs = append(roleIDs, upd.RoleID)
+		}
+	}
+	current, err := u.userRepo.GetByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	roles, err := u.roleRepo.ExistingIDs(ctx, roleIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	errs := make([]error, len(updates))
+	for i, upd := range updates {
+		errs[i] = validateBatchUpdate(upd, current[upd.ID], roles)
+	}
+
+	results, err := applyBatch(errs, atomic, func(valid []int) ([]*domain.BatchResult, error) {
+		items := make([]*domain.UserBatchUpdate, 0, len(valid))
+		for _, i := range valid {
+			items = append(items, updates[i])
+		}
+		return u.userRepo.BatchUpdate(ctx, items, atomic)
+	}, func(i int) int { return updates[i].ID })
+	if err != nil {
+		return nil, err
+	}
+
+	for i, result := range results {
+		upd := updates[i]
+		if result.Err != nil {
+			continue
+		}
+		if upd.Status.RevokesSessions() || upd.RoleID > 0 {
+			if err := u.sessionRepo.DeleteByUserID(ctx, upd.ID); err != nil {
+				return nil, fmt.Errorf("Failed to revoke user sessions with err %v", err)
+			}
+		}
+	}
+
+	return results, nil
+}
+
+func (u *userUseCase) BatchDeleteUsers(ctx context.Context, roleID int, section, route string, deletes []*domain.UserBatchDelete, atomic bool) ([]*domain.BatchResult, error) {
+	if err := u.authorize(ctx, roleID, section, route, "delete"); err != nil {
+		return nil, err
+	}
+	if err := checkBatchSize(len(deletes)); err != nil {
+		return nil, err
+	}
+
+	errs := make([]error, len(deletes))
+	for i, del := range deletes {
+		if del.Version <= 0 {
+			errs[i] = domain.ErrVersionRequired
+		}
+	}
+
+	results, err := applyBatch(errs, atomic, func(valid []int) ([]*domain.BatchResult, error) {
+		items := make([]*domain.UserBatchDelete, 0, len(valid))
+		for _, i := range valid {
+			items = append(items, deletes[i])
+		}
+		return u.userRepo.BatchDelete(ctx, items, atomic)
+	}, func(i int) int { return deletes[i].ID })
+	if err != nil {
+		return nil, err
+	}
+
+	for _, result := range results {
+		if result.Err != nil {
+			continue
+		}
+		if err := u.sessionRepo.DeleteByUserID(ctx, result.ID); err != nil {
+			return nil, fmt.Errorf("Failed to revoke user sessions with err %v", err)
+		}
+	}
+
+	return results, nil
+}
+
+func checkBatchSize(n int) error {
+	if n == 0 {
+		return domain.ErrBatchEmpty
+	}
+	if n > maxBatchSize {
+		return fmt.Errorf("%w, the limit is %d", domain.ErrBatchTooLarge, maxBatchSize)
+	}
+	return nil
+}
+
+func validateBatchUpdate(upd *domain.UserBatchUpdate, current *domain.User, roles map[int]bool) error {
+	upd.Reason = strings.TrimSpace(upd.Reason)
+	switch {
+	case upd.Version <= 0:
+		return domain.ErrVersionRequired
+	case current == nil:
+		return domain.ErrUserNotFound
+	case current.Version != upd.Version:
+		return domain.ErrVersionConflict
+	case upd.Status == "" && upd.RoleID <= 0:
+		return domain.ErrNothingToDo
+	case upd.RoleID > 0 && !roles[upd.RoleID]:
+		return domain.ErrRoleNotFound
+	}
+
+	upd.FromStatus = current.Status
+	upd.FromRoleID = current.RoleID
+	if upd.Status == "" || upd.Status == current.Status {
+		return nil
+	}
+	if !upd.Status.Valid() {
+		return domain.ErrInvalidUserStatus
+	}
+	if !current.Status.CanTransitionTo(upd.Status) {
+		return fmt.Errorf("%w: %s to %s", domain.ErrInvalidTransition, current.Status, upd.Status)
+	}
+	if upd.Reason == "" {
+		return domain.ErrStatusReasonRequired
+	}
+	return nil
+}
+
+// applyBatch runs the items that passed validation through apply and merges
+// the outcome with the validation errors, keeping the request order. In
+// atomic mode a single validation error means nothing is applied.
+func applyBatch(errs []error, atomic bool, apply func(valid []int) ([]*domain.BatchResult, error), idOf func(i int) int) ([]*domain.BatchResult, error) {
+	results := make([]*domain.BatchResult, len(errs))
+	valid := make([]int, 0, len(errs))
+	for i, err := range errs {
+		results[i] = &domain.BatchResult{ID: idOf(i), Err: err}
+		if err == nil {
+			valid = append(valid, i)
+		}
+	}
+
+	if len(valid) < len(errs) && atomic {
+		for _, i := range valid {
+			results[i].Err = domain.ErrBatchAborted
+		}
+		return results, nil
+	}
+	if len(valid) == 0 {
+		return results, nil
+	}
+
+	applied, err := apply(valid)
+	if err != nil {
+		return nil, err
+	}
+	for j, i := range valid {
+		results[i] = applied[j]
+	}
+	return results, nil
+}
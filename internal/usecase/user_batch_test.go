@@ -0,0 +1,106 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"tablelink/internal/domain"
+	"testing"
+)
+
+func TestBatchUpdateUsers(t *testing.T) {
+	tests := []struct {
+		name    string
+		atomic  bool
+		updates []*domain.UserBatchUpdate
+		wantErr []error
+		// wantStatus is the status of each user after the batch.
+		wantStatus map[int]domain.UserStatus
+	}{
+		{
+			name: "applies every valid item",
+			updates: []*domain.UserBatchUpdate{
+				{ID: 1, Version: 1, Status: domain.UserStatusSuspended, Reason: "left"},
+				{ID: 2, Version: 1, RoleID: 2},
+			},
+			wantErr:    []error{nil, nil},
+			wantStatus: map[int]domain.UserStatus{1: domain.UserStatusSuspended, 2: domain.UserStatusActive},
+		},
+		{
+			name: "reports each invalid item and applies the rest",
+			updates: []*domain.UserBatchUpdate{
+				{ID: 1, Version: 1, Status: domain.UserStatusSuspended, Reason: "left"},
+				{ID: 2, Version: 9, Status: domain.UserStatusSuspended, Reason: "left"},
+				{ID: 3, Version: 1, Status: domain.UserStatusActive, Reason: "back"},
+				{ID: 9, Version: 1, RoleID: 2},
+				{ID: 2, Status: domain.UserStatusSuspended, Reason: "left"},
+				{ID: 2, Version: 1, RoleID: 7},
+				{ID: 2, Version: 1},
+				{ID: 2, Version: 1, Status: domain.UserStatusDisabled},
+			},
+			wantErr: []error{nil, domain.ErrVersionConflict, domain.ErrInvalidTransition, domain.ErrUserNotFound,
+				domain.ErrVersionRequired, domain.ErrRoleNotFound, domain.ErrNothingToDo, domain.ErrStatusReasonRequired},
+			wantStatus: map[int]domain.UserStatus{1: domain.UserStatusSuspended, 2: domain.UserStatusActive, 3: domain.UserStatusDisabled},
+		},
+		{
+			name:   "aborts an atomic batch with an invalid item",
+			atomic: true,
+			updates: []*domain.UserBatchUpdate{
+				{ID: 1, Version: 1, Status: domain.UserStatusSuspended, Reason: "left"},
+				{ID: 2, Version: 9, Status: domain.UserStatusSuspended, Reason: "left"},
+			},
+			wantErr:    []error{domain.ErrBatchAborted, domain.ErrVersionConflict},
+			wantStatus: map[int]domain.UserStatus{1: domain.UserStatusActive, 2: domain.UserStatusActive},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			users := newFakeUserRepo(
+				&domain.User{ID: 1, Email: "ada@example.org", RoleID: 1, Status: domain.UserStatusActive, Version: 1},
+				&domain.User{ID: 2, Email: "grace@example.org", RoleID: 1, Status: domain.UserStatusActive, Version: 1},
+				&domain.User{ID: 3, Email: "linus@example.org", RoleID: 1, Status: domain.UserStatusDisabled, Version: 1},
+			)
+			uc := newTestUserUseCase(users, domain.RoleRight{RUpdate: true}, &fakeMailer{})
+
+			results, err := uc.BatchUpdateUsers(ctx, 1, domain.UsersSection, domain.UsersRoute, tt.updates, tt.atomic)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != len(tt.wantErr) {
+				t.Fatalf("%d results, want %d", len(results), len(tt.wantErr))
+			}
+			for i, result := range results {
+				if result.ID != tt.updates[i].ID || !errors.Is(result.Err, tt.wantErr[i]) || (result.Err == nil) != (result.User != nil) {
+					t.Errorf("result %d = %+v, want user %d with err %v", i, result, tt.updates[i].ID, tt.wantErr[i])
+				}
+			}
+			for id, want := range tt.wantStatus {
+				if got := users.get(id).Status; got != want {
+					t.Errorf("user %d status = %s, want %s", id, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestBatchUpdateUsersSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		n       int
+		wantErr error
+	}{
+		{name: "refuses an empty batch", wantErr: domain.ErrBatchEmpty},
+		{name: "refuses a batch over the limit", n: maxBatchSize + 1, wantErr: domain.ErrBatchTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updates := make([]*domain.UserBatchUpdate, tt.n)
+			uc := newTestUserUseCase(newFakeUserRepo(), domain.RoleRight{RUpdate: true}, &fakeMailer{})
+			if _, err := uc.BatchUpdateUsers(context.Background(), 1, domain.UsersSection, domain.UsersRoute, updates, false); !errors.Is(err, tt.wantErr) {
+				t.Errorf("BatchUpdateUsers() err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
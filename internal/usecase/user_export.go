@@ -0,0 +1,62 @@
+package usecase
+
+import (
+	"context"
+	"io"
+	"tablelink/internal/domain"
+	"tablelink/internal/export"
+)
+
+const (
+	exportBatchSize = 500
+
+	defaultStreamChunkSize = 100
+	maxStreamChunkSize     = 1000
+)
+
+func (u *userUseCase) ExportUsers(ctx context.Context, roleID int, section, route string, filter *domain.UserFilter, format export.Format, w io.Writer) error {
+	if err := u.authorize(ctx, roleID, section, route, "read"); err != nil {
+		return err
+	}
+	if err := validateUserFilter(filter); err != nil {
+		return err
+	}
+
+	enc, err := export.NewEncoder(format, w)
+	if err != nil {
+		return err
+	}
+
+	err = u.userRepo.IterateUsers(ctx, filter, exportBatchSize, func(users []*domain.User) error {
+		for _, user := range users {
+			if err := enc.Encode(user); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return enc.Close()
+}
+
+// StreamUsers hands users matching filter to send in chunks. send is
+// expected to block while the client is not keeping up, which in turn holds
+// the cursor, so memory stays bounded by a single chunk.
+func (u *userUseCase) StreamUsers(ctx context.Context, roleID int, section, route string, filter *domain.UserFilter, chunkSize int, send func([]*domain.User) error) error {
+	if err := u.authorize(ctx, roleID, section, route, "read"); err != nil {
+		return err
+	}
+	if err := validateUserFilter(filter); err != nil {
+		return err
+	}
+
+	if chunkSize <= 0 {
+		chunkSize = defaultStreamChunkSize
+	}
+	chunkSize = min(chunkSize, maxStreamChunkSize)
+
+	return u.userRepo.IterateUsers(ctx, filter, chunkSize, send)
+}
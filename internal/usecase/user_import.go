@@ -0,0 +1,258 @@
+package usecase
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"strconv"
+	"strings"
+	"tablelink/internal/domain"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	maxImportRows   = 10000
+	importBatchSize = 500
+)
+
+func (u *userUseCase) ImportUsers(ctx context.Context, roleID int, section, route string, format domain.ImportFormat, dryRun bool, r io.Reader) (*domain.ImportReport, error) {
+	if err := u.authorize(ctx, roleID, section, route, "create"); err != nil {
+		return nil, err
+	}
+
+	rows, err := parseImport(format, r)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &domain.ImportReport{
+		DryRun:  dryRun,
+		Total:   len(rows),
+		Results: make([]*domain.ImportResult, len(rows)),
+	}
+	if err := u.validateImport(ctx, rows, report); err != nil {
+		return nil, err
+	}
+
+	users := make([]*domain.User, 0, len(rows))
+	for i, row := range rows {
+		if !report.Results[i].OK() {
+			continue
+		}
+
+		user := &domain.User{
+			Name:   row.Name,
+			Email:  row.Email,
+			RoleID: row.RoleID,
+			Status: domain.UserStatusInvited,
+		}
+		if row.Password != "" && !dryRun {
+			hashed, err := bcrypt.GenerateFromPassword([]byte(row.Password), bcrypt.DefaultCost)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to hash password with err %v", err)
+			}
+			user.Password = string(hashed)
+			user.Status = domain.UserStatusActive
+		}
+		users = append(users, user)
+	}
+
+	report.Imported = len(users)
+	report.Failed = report.Total - report.Imported
+	if dryRun || len(users) == 0 {
+		return report, nil
+	}
+
+	created, err := u.userRepo.CopyUsers(ctx, users, importBatchSize)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to import users with err %v", err)
+	}
+
+	// Rows without a password are invited, as InviteUser does. The users
+	// exist by now, so a failed invitation is reported on its row instead
+	// of failing the import.
+	results := make(map[string]*domain.ImportResult, len(report.Results))
+	for _, result := range report.Results {
+		if result.OK() {
+			results[strings.ToLower(result.Email)] = result
+		}
+	}
+	for _, user := range created {
+		if user.Status != domain.UserStatusInvited {
+			continue
+		}
+		if err := u.sendInvite(ctx, user); err != nil {
+			if result := results[strings.ToLower(user.Email)]; result != nil {
+				result.Warning = err.Error()
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// validateImport fills one result per row. Rows are checked on their own
+// first, then against each other and the existing users and roles.
+func (u *userUseCase) validateImport(ctx context.Context, rows []*domain.ImportRow, report *domain.ImportReport) error {
+	seen := make(map[string]int, len(rows))
+	emails := make([]string, 0, len(rows))
+	roleIDs := make([]int, 0)
+	for i, row := range rows {
+		result := &domain.ImportResult{Row: row.Row, Email: row.Email}
+		report.Results[i] = result
+
+		key := strings.ToLower(row.Email)
+		switch {
+		case row.Error != "":
+			result.Error = row.Error
+		case row.Name == "":
+			result.Error = "name is required"
+		case row.RoleID <= 0:
+			result.Error = "role_id is required"
+		case row.Password != "" && len(row.Password) < 8:
+			result.Error = domain.ErrWeakPassword.Error()
+		default:
+			if _, err := mail.ParseAddress(row.Email); err != nil {
+				result.Error = domain.ErrInvalidEmail.Error()
+			} else if first, ok := seen[key]; ok {
+				result.Error = fmt.Sprintf("duplicate of row %d", first)
+			}
+		}
+		if result.OK() {
+			seen[key] = row.Row
+			emails = append(emails, key)
+			roleIDs = append(roleIDs, row.RoleID)
+		}
+	}
+
+	if len(emails) == 0 {
+		return nil
+	}
+
+	existingEmails, err := u.userRepo.ExistingEmails(ctx, emails)
+	if err != nil {
+		return err
+	}
+	existingRoles, err := u.roleRepo.ExistingIDs(ctx, roleIDs)
+	if err != nil {
+		return err
+	}
+
+	for i, row := range rows {
+		result := report.Results[i]
+		if !result.OK() {
+			continue
+		}
+		if existingEmails[strings.ToLower(row.Email)] {
+			result.Error = domain.ErrEmailTaken.Error()
+		} else if !existingRoles[row.RoleID] {
+			result.Error = fmt.Sprintf("role %d does not exist", row.RoleID)
+		}
+	}
+	return nil
+}
+
+func parseImport(format domain.ImportFormat, r io.Reader) ([]*domain.ImportRow, error) {
+	switch format {
+	case domain.ImportFormatCSV:
+		return parseImportCSV(r)
+	case domain.ImportFormatJSONL:
+		return parseImportJSONL(r)
+	default:
+		return nil, fmt.Errorf("unsupported import format %q", format)
+	}
+}
+
+// parseImportCSV expects a header row naming the name, email and role_id
+// columns, and optionally password, in any order.
+func parseImportCSV(r io.Reader) ([]*domain.ImportRow, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read CSV header with err %v", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"name", "email", "role_id"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV header is missing the %s column", required)
+		}
+	}
+
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var rows []*domain.ImportRow
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil && !errors.Is(err, csv.ErrFieldCount) {
+			return nil, fmt.Errorf("Failed to read CSV row %d with err %v", line, err)
+		}
+		if len(rows) == maxImportRows {
+			return nil, fmt.Errorf("import is limited to %d rows", maxImportRows)
+		}
+
+		row := &domain.ImportRow{
+			Row:      line,
+			Name:     field(record, "name"),
+			Email:    field(record, "email"),
+			Password: field(record, "password"),
+		}
+		if err != nil {
+			row.Error = "wrong number of fields"
+		} else if row.RoleID, err = strconv.Atoi(field(record, "role_id")); err != nil {
+			row.Error = "role_id must be a number"
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// parseImportJSONL reads one JSON object per line. A line that does not
+// decode still produces a row so it shows up in the report.
+func parseImportJSONL(r io.Reader) ([]*domain.ImportRow, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	var rows []*domain.ImportRow
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		if len(rows) == maxImportRows {
+			return nil, fmt.Errorf("import is limited to %d rows", maxImportRows)
+		}
+
+		row := new(domain.ImportRow)
+		if err := json.Unmarshal([]byte(text), row); err != nil {
+			row = &domain.ImportRow{Error: "invalid JSON"}
+		}
+		row.Row = line
+		row.Name = strings.TrimSpace(row.Name)
+		row.Email = strings.TrimSpace(row.Email)
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Failed to read JSON lines with err %v", err)
+	}
+	return rows, nil
+}
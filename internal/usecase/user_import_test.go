@@ -0,0 +1,95 @@
+package usecase
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"tablelink/internal/config"
+	"tablelink/internal/domain"
+	"tablelink/internal/token"
+	"testing"
+	"time"
+)
+
+func newTestUserUseCase(users *fakeUserRepo, rights domain.RoleRight, m *fakeMailer) UserUseCase {
+	cfg := &config.Config{InviteURL: "https://tablelink.test/invite", InviteTTL: time.Hour}
+	return NewUserUseCase(users, &fakeRightRepo{rights: rights}, &fakeRoleRepo{roles: []*domain.Role{{ID: 2}}},
+		newFakeSessionRepo(), token.NewIssuer("secret", newFakeTokenRepo()), m, cfg)
+}
+
+func TestImportUsers(t *testing.T) {
+	csv := "name,email,role_id,password\n" +
+		"Ada,ada@example.org,2,correct horse\n" +
+		"Grace,grace@example.org,2,\n" +
+		"Grace again,GRACE@example.org,2,\n" +
+		"Linus,linus@example.org,9,\n" +
+		"Taken,taken@example.org,2,\n"
+
+	tests := []struct {
+		name     string
+		dryRun   bool
+		failMail bool
+		// wantStatus is the status of each created user by email.
+		wantStatus  map[string]domain.UserStatus
+		wantMail    []string
+		wantWarning bool
+	}{
+		{
+			name:       "activates rows with a password and invites the rest",
+			wantStatus: map[string]domain.UserStatus{"ada@example.org": domain.UserStatusActive, "grace@example.org": domain.UserStatusInvited},
+			wantMail:   []string{"grace@example.org"},
+		},
+		{
+			name:        "keeps the invited user when the invitation fails",
+			failMail:    true,
+			wantStatus:  map[string]domain.UserStatus{"ada@example.org": domain.UserStatusActive, "grace@example.org": domain.UserStatusInvited},
+			wantMail:    []string{},
+			wantWarning: true,
+		},
+		{
+			name:       "writes and sends nothing in a dry run",
+			dryRun:     true,
+			wantStatus: map[string]domain.UserStatus{},
+			wantMail:   []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			users := newFakeUserRepo(&domain.User{Name: "Taken", Email: "taken@example.org", RoleID: 2})
+			m := &fakeMailer{fail: tt.failMail}
+			uc := newTestUserUseCase(users, domain.RoleRight{RCreate: true}, m)
+
+			report, err := uc.ImportUsers(ctx, 1, domain.UsersSection, domain.UsersRoute, domain.ImportFormatCSV, tt.dryRun, strings.NewReader(csv))
+			if err != nil {
+				t.Fatalf("ImportUsers() err = %v", err)
+			}
+			if report.Total != 5 || report.Imported != 2 || report.Failed != 3 {
+				t.Errorf("report = %d total, %d imported, %d failed, want 5, 2, 3", report.Total, report.Imported, report.Failed)
+			}
+			wantErrors := []string{"", "", "duplicate of row 3", "role 9 does not exist", domain.ErrEmailTaken.Error()}
+			for i, result := range report.Results {
+				if result.Error != wantErrors[i] {
+					t.Errorf("row %d error = %q, want %q", result.Row, result.Error, wantErrors[i])
+				}
+			}
+			if warned := report.Results[1].Warning != ""; warned != tt.wantWarning {
+				t.Errorf("invited row warning = %q, want set %t", report.Results[1].Warning, tt.wantWarning)
+			}
+
+			for email, want := range tt.wantStatus {
+				stored, err := users.GetByEmail(ctx, email)
+				if err != nil || stored.Status != want {
+					t.Errorf("%s = %+v, %v, want status %s", email, stored, err, want)
+				}
+			}
+			if len(users.users) != len(tt.wantStatus)+1 {
+				t.Errorf("%d users stored, want %d", len(users.users), len(tt.wantStatus)+1)
+			}
+			if got := m.recipients(); !slices.Equal(got, tt.wantMail) {
+				t.Errorf("mailed %q, want %q", got, tt.wantMail)
+			}
+		})
+	}
+}
@@ -4,59 +4,111 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"net/mail"
+	"strings"
+	"tablelink/internal/config"
 	"tablelink/internal/domain"
+	"tablelink/internal/export"
+	"tablelink/internal/mailer"
 	"tablelink/internal/repository"
+	"tablelink/internal/token"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 type UserUseCase interface {
-	ListUser(ctx context.Context, roleID int, section, route string) ([]*domain.User, error)
+	ListUser(ctx context.Context, roleID int, section, route string, filter *domain.UserFilter) ([]*domain.User, error)
 	CreateUser(ctx context.Context, roleID int, section, route string, user *domain.User) (*domain.User, error)
 	UpdateUser(ctx context.Context, roleID int, section, route string, user *domain.User) (*domain.User, error)
-	DeleteUser(ctx context.Context, roleID int, section, route string, userID int) error
+	DeleteUser(ctx context.Context, roleID int, section, route string, userID, version int) error
+	RestoreUser(ctx context.Context, roleID int, section, route string, userID, version int) error
+	PurgeUser(ctx context.Context, roleID int, section, route string, userID, version int) error
+	ChangeUserStatus(ctx context.Context, roleID int, section, route string, userID, version int, status domain.UserStatus, reason string) (*domain.User, error)
+	InviteUser(ctx context.Context, roleID int, section, route string, user *domain.User) (*domain.User, error)
+	ImportUsers(ctx context.Context, roleID int, section, route string, format domain.ImportFormat, dryRun bool, r io.Reader) (*domain.ImportReport, error)
+	ExportUsers(ctx context.Context, roleID int, section, route string, filter *domain.UserFilter, format export.Format, w io.Writer) error
+	StreamUsers(ctx context.Context, roleID int, section, route string, filter *domain.UserFilter, chunkSize int, send func([]*domain.User) error) error
+	BatchUpdateUsers(ctx context.Context, roleID int, section, route string, updates []*domain.UserBatchUpdate, atomic bool) ([]*domain.BatchResult, error)
+	BatchDeleteUsers(ctx context.Context, roleID int, section, route string, deletes []*domain.UserBatchDelete, atomic bool) ([]*domain.BatchResult, error)
 }
 
 type userUseCase struct {
-	userRepo  repository.UserRepository
-	rightRepo repository.RoleRightRepository
+	userRepo    repository.UserRepository
+	rightRepo   repository.RoleRightRepository
+	roleRepo    repository.RoleRepository
+	sessionRepo repository.SessionRepository
+	issuer      *token.Issuer
+	mailer      mailer.Mailer
+	cfg         *config.Config
 }
 
-func NewUserUseCase(userRepo repository.UserRepository, rightRepo repository.RoleRightRepository) UserUseCase {
+func NewUserUseCase(userRepo repository.UserRepository, rightRepo repository.RoleRightRepository, roleRepo repository.RoleRepository,
+	sessionRepo repository.SessionRepository, issuer *token.Issuer, mailer mailer.Mailer, cfg *config.Config) UserUseCase {
 	return &userUseCase{
-		userRepo:  userRepo,
-		rightRepo: rightRepo,
+		userRepo:    userRepo,
+		rightRepo:   rightRepo,
+		roleRepo:    roleRepo,
+		sessionRepo: sessionRepo,
+		issuer:      issuer,
+		mailer:      mailer,
+		cfg:         cfg,
 	}
 }
 
 func (u *userUseCase) authorize(ctx context.Context, roleID int, section, route, action string) error {
-	rights, err := u.rightRepo.CheckPermission(ctx, roleID, section, route)
+	return authorize(ctx, u.rightRepo, roleID, section, route, action)
+}
+
+// authorize checks that roleID may perform action ("create", "read",
+// "update" or "delete") on the route of section.
+func authorize(ctx context.Context, rightRepo repository.RoleRightRepository, roleID int, section, route, action string) error {
+	rights, err := rightRepo.CheckPermission(ctx, roleID, section, route)
 	if err != nil {
 		return fmt.Errorf("Failed to check permission with err %v", err)
 	}
 
-	var allowed bool
-	switch action {
-	case "create":
-		allowed = rights.RCreate
-	case "read":
-		allowed = rights.RRead
-	case "update":
-		allowed = rights.RUpdate
-	case "delete":
-		allowed = rights.RDelete
+	if !rights.Allows(action) {
+		return domain.ErrPermissionDenied
 	}
-	if !allowed {
-		return errors.New("permission denie")
+	if maxAge := rights.StepUpWithin(action); maxAge > 0 {
+		return requireStepUp(ctx, maxAge)
 	}
 	return nil
 
 }
 
-func (u *userUseCase) ListUser(ctx context.Context, roleID int, section, route string) ([]*domain.User, error) {
+// requireStepUp checks that the caller is a user who authenticated
+// strongly within maxAge. API keys, service accounts and impersonators
+// have no login of the user to show, so they cannot step up at all.
+func requireStepUp(ctx context.Context, maxAge time.Duration) error {
+	principal := domain.PrincipalFromContext(ctx)
+	if principal == nil || principal.Kind != domain.PrincipalUser || principal.ImpersonatorID != 0 {
+		return domain.ErrStepUpUnavailable
+	}
+	if !principal.AuthMethod.Strong() || time.Since(principal.AuthTime) > maxAge {
+		return &domain.StepUpRequiredError{MaxAge: maxAge}
+	}
+	return nil
+}
+
+func (u *userUseCase) ListUser(ctx context.Context, roleID int, section, route string, filter *domain.UserFilter) ([]*domain.User, error) {
 	if err := u.authorize(ctx, roleID, section, route, "read"); err != nil {
 		return nil, err
 	}
+	if err := validateUserFilter(filter); err != nil {
+		return nil, err
+	}
 
-	return u.userRepo.ListAll(ctx)
+	return u.userRepo.ListAll(ctx, filter)
+}
+
+func validateUserFilter(filter *domain.UserFilter) error {
+	if filter != nil && filter.Status != "" && !filter.Status.Valid() {
+		return domain.ErrInvalidUserStatus
+	}
+	return nil
 }
 
 func (u *userUseCase) CreateUser(ctx context.Context, roleID int, section, route string, user *domain.User) (*domain.User, error) {
@@ -64,22 +116,222 @@ func (u *userUseCase) CreateUser(ctx context.Context, roleID int, section, route
 		return nil, err
 	}
 
+	user.Name = strings.TrimSpace(user.Name)
+	user.Email = strings.TrimSpace(user.Email)
+	if _, err := mail.ParseAddress(user.Email); err != nil || user.Name == "" {
+		return nil, domain.ErrInvalidEmail
+	}
+	if len(user.Password) < 8 {
+		return nil, domain.ErrWeakPassword
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to hash password with err %v", err)
+	}
+	user.Password = string(hashed)
+
 	return u.userRepo.Create(ctx, user)
 
 }
 
 func (u *userUseCase) UpdateUser(ctx context.Context, roleID int, section, route string, user *domain.User) (*domain.User, error) {
-	if err := u.authorize(ctx, roleID, section, route, "updaet"); err != nil {
+	if err := u.authorize(ctx, roleID, section, route, "update"); err != nil {
+		return nil, err
+	}
+
+	if user.Version <= 0 {
+		return nil, domain.ErrVersionRequired
+	}
+
+	current, err := u.userRepo.GetByID(ctx, user.ID)
+	if err != nil {
 		return nil, err
 	}
+	if current.Version != user.Version {
+		return nil, domain.ErrVersionConflict
+	}
 
-	return u.userRepo.Update(ctx, user)
+	// A new email only takes over once it is confirmed, until then the
+	// current address stays the one used to log in.
+	newEmail := strings.TrimSpace(user.Email)
+	changingEmail := newEmail != "" && !strings.EqualFold(newEmail, current.Email)
+	if changingEmail {
+		if _, err := mail.ParseAddress(newEmail); err != nil {
+			return nil, domain.ErrInvalidEmail
+		}
+		if _, err := u.userRepo.GetByEmail(ctx, newEmail); err == nil {
+			return nil, domain.ErrEmailTaken
+		}
+	}
+	user.Email = current.Email
+
+	if user.Password != "" {
+		hashed, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to hash password with err %v", err)
+		}
+		user.Password = string(hashed)
+	}
+
+	user, err = u.userRepo.Update(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	if changingEmail {
+		if err := u.requestEmailChange(ctx, user, newEmail); err != nil {
+			return nil, err
+		}
+	}
+
+	return user, nil
 }
 
-func (u *userUseCase) DeleteUser(ctx context.Context, roleID int, section, route string, userID int) error {
+func (u *userUseCase) requestEmailChange(ctx context.Context, user *domain.User, newEmail string) error {
+	if err := u.userRepo.SetPendingEmail(ctx, user.ID, newEmail); err != nil {
+		return err
+	}
+	user.PendingEmail = &newEmail
+
+	changeToken, err := u.issuer.Issue(ctx, token.PurposeChangeEmail, user.ID, newEmail, u.cfg.VerificationTTL)
+	if err != nil {
+		return fmt.Errorf("Failed to issue email change token with err %v", err)
+	}
+
+	link := tokenLink(u.cfg.VerifyEmailURL, changeToken)
+	if err := sendVerificationEmail(ctx, u.mailer, newEmail, user.Name, link, u.cfg.VerificationTTL); err != nil {
+		return fmt.Errorf("Failed to send email change confirmation with err %v", err)
+	}
+	if err := sendEmailChangeNotice(ctx, u.mailer, user.Email, user.Name, newEmail); err != nil {
+		return fmt.Errorf("Failed to notify current email address with err %v", err)
+	}
+
+	return nil
+}
+
+func (u *userUseCase) DeleteUser(ctx context.Context, roleID int, section, route string, userID, version int) error {
 	if err := u.authorize(ctx, roleID, section, route, "delete"); err != nil {
 		return err
 	}
 
-	return u.userRepo.Delete(ctx, userID)
+	if version <= 0 {
+		return domain.ErrVersionRequired
+	}
+
+	return u.userRepo.Delete(ctx, userID, version)
+}
+
+func (u *userUseCase) RestoreUser(ctx context.Context, roleID int, section, route string, userID, version int) error {
+	if err := u.authorize(ctx, roleID, section, route, "update"); err != nil {
+		return err
+	}
+
+	if version <= 0 {
+		return domain.ErrVersionRequired
+	}
+
+	return u.userRepo.Restore(ctx, userID, version)
+}
+
+func (u *userUseCase) PurgeUser(ctx context.Context, roleID int, section, route string, userID, version int) error {
+	if err := u.authorize(ctx, roleID, section, route, "delete"); err != nil {
+		return err
+	}
+
+	if version <= 0 {
+		return domain.ErrVersionRequired
+	}
+
+	return u.userRepo.Purge(ctx, userID, version)
+}
+
+func (u *userUseCase) ChangeUserStatus(ctx context.Context, roleID int, section, route string, userID, version int, status domain.UserStatus, reason string) (*domain.User, error) {
+	if err := u.authorize(ctx, roleID, section, route, "update"); err != nil {
+		return nil, err
+	}
+
+	if version <= 0 {
+		return nil, domain.ErrVersionRequired
+	}
+	if !status.Valid() {
+		return nil, domain.ErrInvalidUserStatus
+	}
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, domain.ErrStatusReasonRequired
+	}
+
+	current, err := u.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if current.Version != version {
+		return nil, domain.ErrVersionConflict
+	}
+	if !current.Status.CanTransitionTo(status) {
+		return nil, fmt.Errorf("%w: %s to %s", domain.ErrInvalidTransition, current.Status, status)
+	}
+
+	user, err := u.userRepo.ChangeStatus(ctx, userID, version, current.Status, status, reason)
+	if err != nil {
+		return nil, err
+	}
+
+	if status.RevokesSessions() {
+		if err := u.sessionRepo.DeleteByUserID(ctx, userID); err != nil {
+			return nil, fmt.Errorf("Failed to revoke user sessions with err %v", err)
+		}
+	}
+
+	return user, nil
+}
+
+func (u *userUseCase) InviteUser(ctx context.Context, roleID int, section, route string, user *domain.User) (*domain.User, error) {
+	if err := u.authorize(ctx, roleID, section, route, "create"); err != nil {
+		return nil, err
+	}
+
+	user.Name = strings.TrimSpace(user.Name)
+	user.Email = strings.TrimSpace(user.Email)
+	if _, err := mail.ParseAddress(user.Email); err != nil || user.Name == "" {
+		return nil, domain.ErrInvalidEmail
+	}
+
+	user.Password = ""
+	user.Status = domain.UserStatusInvited
+	created, err := u.userRepo.Create(ctx, user)
+	if errors.Is(err, domain.ErrEmailTaken) {
+		// Inviting someone who has not accepted yet sends them a fresh
+		// invitation, e.g. when the first one never arrived.
+		existing, getErr := u.userRepo.GetByEmail(ctx, user.Email)
+		if getErr != nil || existing.Status != domain.UserStatusInvited {
+			return nil, err
+		}
+		existing.Password = ""
+		created, err = existing, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	user = created
+
+	if err := u.sendInvite(ctx, user); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// sendInvite mails user a link to set their first password.
+func (u *userUseCase) sendInvite(ctx context.Context, user *domain.User) error {
+	inviteToken, err := u.issuer.Issue(ctx, token.PurposeInvite, user.ID, user.Email, u.cfg.InviteTTL)
+	if err != nil {
+		return fmt.Errorf("Failed to issue invite token with err %v", err)
+	}
+	link := tokenLink(u.cfg.InviteURL, inviteToken)
+	if err := sendInvitationEmail(ctx, u.mailer, user.Email, user.Name, link, u.cfg.InviteTTL); err != nil {
+		return fmt.Errorf("Failed to send invitation with err %v", err)
+	}
+	return nil
 }
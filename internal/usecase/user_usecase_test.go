@@ -0,0 +1,256 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"tablelink/internal/config"
+	"tablelink/internal/domain"
+	"tablelink/internal/token"
+	"testing"
+	"time"
+)
+
+func TestInviteUser(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing *domain.User
+		failMail bool
+		wantErr  error
+		// failErr is set for failures without a sentinel error.
+		failErr  bool
+		wantID   int
+		wantMail []string
+	}{
+		{
+			name:     "creates and invites a new user",
+			wantID:   2,
+			wantMail: []string{"ada@example.org"},
+		},
+		{
+			name:     "invites a user who has not accepted again",
+			existing: &domain.User{ID: 1, Name: "Ada", Email: "ada@example.org", RoleID: 2, Status: domain.UserStatusInvited},
+			wantID:   1,
+			wantMail: []string{"ada@example.org"},
+		},
+		{
+			name:     "refuses the email of an active user",
+			existing: &domain.User{ID: 1, Name: "Ada", Email: "ada@example.org", RoleID: 2, Status: domain.UserStatusActive},
+			wantErr:  domain.ErrEmailTaken,
+		},
+		{
+			name:     "reports an invitation that was not sent",
+			failMail: true,
+			failErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			// The placeholder keeps new users from getting ID 1.
+			users := newFakeUserRepo(&domain.User{ID: 1, Email: "placeholder@example.org"})
+			if tt.existing != nil {
+				users = newFakeUserRepo(tt.existing)
+			}
+			m := &fakeMailer{fail: tt.failMail}
+			uc := newTestUserUseCase(users, domain.RoleRight{RCreate: true}, m)
+
+			user, err := uc.InviteUser(ctx, 1, domain.UsersSection, domain.UsersRoute,
+				&domain.User{Name: "Ada", Email: "ada@example.org", RoleID: 2})
+			if tt.wantErr != nil || tt.failErr {
+				if err == nil || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+					t.Fatalf("InviteUser() err = %v, want %v", err, tt.wantErr)
+				}
+				if len(m.sent) != 0 {
+					t.Errorf("failed invite mailed %q", m.recipients())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("InviteUser() err = %v", err)
+			}
+			if user.ID != tt.wantID || user.Status != domain.UserStatusInvited || user.Password != "" {
+				t.Errorf("user = %+v, want invited user %d without a password", user, tt.wantID)
+			}
+			if got := m.recipients(); !slices.Equal(got, tt.wantMail) {
+				t.Errorf("mailed %q, want %q", got, tt.wantMail)
+			}
+		})
+	}
+}
+
+func TestAuthorizeStepUp(t *testing.T) {
+	rights := &fakeRightRepo{rights: domain.RoleRight{RRead: true, RDelete: true, StepUpActions: []string{"delete"}, StepUpMinutes: 5}}
+	fresh, stale := time.Now(), time.Now().Add(-10*time.Minute)
+	tests := []struct {
+		name      string
+		action    string
+		principal *domain.Principal
+		wantErr   error
+		// wantMaxAge is set when the caller must step up and retry.
+		wantMaxAge time.Duration
+	}{
+		{name: "allows an action without step-up", action: "read"},
+		{name: "refuses an action the right does not grant", action: "update", wantErr: domain.ErrPermissionDenied},
+		{name: "refuses a call without a principal", action: "delete", wantErr: domain.ErrStepUpUnavailable},
+		{
+			name:      "allows a fresh password login",
+			action:    "delete",
+			principal: &domain.Principal{Kind: domain.PrincipalUser, UserID: 7, AuthTime: fresh, AuthMethod: domain.AuthMethodPassword},
+		},
+		{
+			name:      "allows a fresh passkey login",
+			action:    "delete",
+			principal: &domain.Principal{Kind: domain.PrincipalUser, UserID: 7, AuthTime: fresh, AuthMethod: domain.AuthMethodPasskey},
+		},
+		{
+			name:       "asks a stale login to step up",
+			action:     "delete",
+			principal:  &domain.Principal{Kind: domain.PrincipalUser, UserID: 7, AuthTime: stale, AuthMethod: domain.AuthMethodPassword},
+			wantErr:    domain.ErrStepUpRequired,
+			wantMaxAge: 5 * time.Minute,
+		},
+		{
+			name:       "asks a magic link login to step up",
+			action:     "delete",
+			principal:  &domain.Principal{Kind: domain.PrincipalUser, UserID: 7, AuthTime: fresh, AuthMethod: domain.AuthMethodMagicLink},
+			wantErr:    domain.ErrStepUpRequired,
+			wantMaxAge: 5 * time.Minute,
+		},
+		{
+			name:      "refuses an impersonator",
+			action:    "delete",
+			principal: &domain.Principal{Kind: domain.PrincipalUser, UserID: 7, ImpersonatorID: 3, AuthTime: fresh, AuthMethod: domain.AuthMethodPassword},
+			wantErr:   domain.ErrStepUpUnavailable,
+		},
+		{
+			name:      "refuses an API key",
+			action:    "delete",
+			principal: &domain.Principal{Kind: domain.PrincipalAPIKey, APIKeyID: 4},
+			wantErr:   domain.ErrStepUpUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.principal != nil {
+				ctx = domain.WithPrincipal(ctx, tt.principal)
+			}
+			err := authorize(ctx, rights, 1, domain.UsersSection, domain.UsersRoute, tt.action)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("authorize() err = %v, want %v", err, tt.wantErr)
+			}
+			var stepUp *domain.StepUpRequiredError
+			if errors.As(err, &stepUp) != (tt.wantMaxAge > 0) || (stepUp != nil && stepUp.MaxAge != tt.wantMaxAge) {
+				t.Errorf("authorize() err = %v, want a step-up within %s", err, tt.wantMaxAge)
+			}
+		})
+	}
+}
+
+func TestChangeUserStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		from    domain.UserStatus
+		to      domain.UserStatus
+		version int
+		reason  string
+		wantErr error
+		// wantRevoked is set when the user's sessions must be ended.
+		wantRevoked bool
+	}{
+		{name: "suspends an active user", from: domain.UserStatusActive, to: domain.UserStatusSuspended, version: 3, reason: "left", wantRevoked: true},
+		{name: "reactivates a suspended user", from: domain.UserStatusSuspended, to: domain.UserStatusActive, version: 3, reason: "back"},
+		{name: "disables a suspended user", from: domain.UserStatusSuspended, to: domain.UserStatusDisabled, version: 3, reason: "gone", wantRevoked: true},
+		{name: "refuses to leave disabled", from: domain.UserStatusDisabled, to: domain.UserStatusActive, version: 3, reason: "back", wantErr: domain.ErrInvalidTransition},
+		{name: "refuses to suspend an invited user", from: domain.UserStatusInvited, to: domain.UserStatusSuspended, version: 3, reason: "left", wantErr: domain.ErrInvalidTransition},
+		{name: "refuses an unknown status", from: domain.UserStatusActive, to: "frozen", version: 3, reason: "left", wantErr: domain.ErrInvalidUserStatus},
+		{name: "requires a reason", from: domain.UserStatusActive, to: domain.UserStatusSuspended, version: 3, reason: " ", wantErr: domain.ErrStatusReasonRequired},
+		{name: "requires a version", from: domain.UserStatusActive, to: domain.UserStatusSuspended, reason: "left", wantErr: domain.ErrVersionRequired},
+		{name: "refuses a stale version", from: domain.UserStatusActive, to: domain.UserStatusSuspended, version: 2, reason: "left", wantErr: domain.ErrVersionConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			users := newFakeUserRepo(&domain.User{ID: 1, Name: "Ada", Email: "ada@example.org", Status: tt.from, Version: 3})
+			sessions := newFakeSessionRepo()
+			sessions.Create(ctx, "token", &domain.Session{UserID: 1}, time.Hour)
+			uc := NewUserUseCase(users, &fakeRightRepo{rights: domain.RoleRight{RUpdate: true}}, &fakeRoleRepo{},
+				sessions, token.NewIssuer("secret", newFakeTokenRepo()), &fakeMailer{}, &config.Config{})
+
+			user, err := uc.ChangeUserStatus(ctx, 1, domain.UsersSection, domain.UsersRoute, 1, tt.version, tt.to, tt.reason)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ChangeUserStatus() err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && (user.Status != tt.to || user.Version != 4) {
+				t.Errorf("user = %+v, want status %s at version 4", user, tt.to)
+			}
+			if tt.wantErr != nil && users.get(1).Status != tt.from {
+				t.Errorf("status = %s, want it unchanged", users.get(1).Status)
+			}
+			if _, err := sessions.Get(ctx, "token"); (err != nil) != tt.wantRevoked {
+				t.Errorf("session revoked = %t, want %t", err != nil, tt.wantRevoked)
+			}
+		})
+	}
+}
+
+func TestUpdateUserVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		version int
+		wantErr error
+	}{
+		{name: "updates the current version", version: 3},
+		{name: "requires a version", wantErr: domain.ErrVersionRequired},
+		{name: "refuses a stale version", version: 2, wantErr: domain.ErrVersionConflict},
+		{name: "refuses a version from the future", version: 4, wantErr: domain.ErrVersionConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			users := newFakeUserRepo(&domain.User{ID: 1, Name: "Ada", Email: "ada@example.org", RoleID: 2, Version: 3})
+			uc := newTestUserUseCase(users, domain.RoleRight{RUpdate: true}, &fakeMailer{})
+
+			user, err := uc.UpdateUser(ctx, 1, domain.UsersSection, domain.UsersRoute,
+				&domain.User{ID: 1, Name: "Ada Lovelace", Email: "ada@example.org", RoleID: 2, Version: tt.version})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("UpdateUser() err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if stored := users.get(1); stored.Name != "Ada" || stored.Version != 3 {
+					t.Errorf("user = %+v, want it unchanged", stored)
+				}
+				return
+			}
+			if user.Name != "Ada Lovelace" || user.Version != 4 {
+				t.Errorf("user = %+v, want the new name at version 4", user)
+			}
+		})
+	}
+}
+
+func TestDeleteUserRequiresVersion(t *testing.T) {
+	ctx := context.Background()
+	uc := newTestUserUseCase(newFakeUserRepo(), domain.RoleRight{RUpdate: true, RDelete: true}, &fakeMailer{})
+	tests := []struct {
+		name string
+		call func(ctx context.Context, roleID int, section, route string, userID, version int) error
+	}{
+		{name: "DeleteUser", call: uc.DeleteUser},
+		{name: "RestoreUser", call: uc.RestoreUser},
+		{name: "PurgeUser", call: uc.PurgeUser},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call(ctx, 1, domain.UsersSection, domain.UsersRoute, 1, 0); !errors.Is(err, domain.ErrVersionRequired) {
+				t.Errorf("%s() err = %v, want %v", tt.name, err, domain.ErrVersionRequired)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package usecase
+
+import (
+	"context"
+	"tablelink/internal/domain"
+	"tablelink/internal/repository"
+	"time"
+)
+
+const (
+	watchBatchSize = 500
+	// watchPollInterval rereads the outbox even without a notification, in
+	// case the LISTEN connection is down.
+	watchPollInterval = 10 * time.Second
+)
+
+type WatchUseCase interface {
+	// WatchChanges first sends the revision the watch starts from, with no
+	// events, and then every user and role-right change after it until ctx
+	// is done. A fromRevision of zero starts at the latest change.
+	WatchChanges(ctx context.Context, roleID int, section, route string, fromRevision int64,
+		send func(revision int64, events []*domain.Event) error) error
+}
+
+type watchUseCase struct {
+	outboxRepo repository.OutboxRepository
+	notifier   repository.OutboxNotifier
+	rightRepo  repository.RoleRightRepository
+}
+
+func NewWatchUseCase(outboxRepo repository.OutboxRepository, notifier repository.OutboxNotifier,
+	rightRepo repository.RoleRightRepository) WatchUseCase {
+	return &watchUseCase{
+		outboxRepo: outboxRepo,
+		notifier:   notifier,
+		rightRepo:  rightRepo,
+	}
+}
+
+func (u *watchUseCase) WatchChanges(ctx context.Context, roleID int, section, route string, fromRevision int64,
+	send func(revision int64, events []*domain.Event) error) error {
+	if err := authorize(ctx, u.rightRepo, roleID, section, route, "read"); err != nil {
+		return err
+	}
+
+	// Subscribe before reading the latest revision so no wake-up in between
+	// is lost.
+	wake, unsubscribe := u.notifier.Subscribe()
+	defer unsubscribe()
+
+	latest, err := u.outboxRepo.LatestRevision(ctx)
+	if err != nil {
+		return err
+	}
+	revision := fromRevision
+	if revision <= 0 {
+		revision = latest
+	}
+	if revision > latest {
+		return domain.ErrRevisionAhead
+	}
+	if err := send(revision, nil); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if revision, err = u.catchUp(ctx, revision, send); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// catchUp sends everything after revision and returns the new revision.
+func (u *watchUseCase) catchUp(ctx context.Context, revision int64, send func(int64, []*domain.Event) error) (int64, error) {
+	for {
+		events, err := u.outboxRepo.ListSince(ctx, revision, watchBatchSize)
+		if err != nil {
+			return revision, err
+		}
+		if len(events) == 0 {
+			return revision, nil
+		}
+
+		changes := make([]*domain.Event, 0, len(events))
+		for _, event := range events {
+			if event.Type.AffectsAccess() {
+				changes = append(changes, event)
+			}
+		}
+		revision = events[len(events)-1].Revision
+		if len(changes) > 0 {
+			if err := send(revision, changes); err != nil {
+				return revision, err
+			}
+		}
+
+		if len(events) < watchBatchSize {
+			return revision, nil
+		}
+	}
+}
@@ -0,0 +1,473 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"tablelink/internal/config"
+	"tablelink/internal/domain"
+	"tablelink/internal/repository"
+	"tablelink/internal/token"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// WebAuthnUseCase registers passkeys and logs in with them, either on their
+// own or to confirm a password login. Options and responses are the JSON
+// passed to and returned by navigator.credentials.create() and get().
+type WebAuthnUseCase interface {
+	// BeginRegistration starts adding a passkey called name to the account
+	// of the session, returning the ceremony id and creation options. Like
+	// DeleteCredential it needs a strong login within WebAuthnStepUpMaxAge.
+	BeginRegistration(ctx context.Context, accessToken, name string) (string, []byte, error)
+	FinishRegistration(ctx context.Context, accessToken, ceremonyID string, response []byte) (*domain.WebAuthnCredential, error)
+	// BeginLogin returns the ceremony id and request options of a login.
+	// With secondFactorToken it completes that password login, with email
+	// it asks for one of the user's passkeys and with neither it lets the
+	// authenticator offer any passkey it holds for the site.
+	BeginLogin(ctx context.Context, email, secondFactorToken string) (string, []byte, error)
+	// FinishLogin checks the response and returns a session token.
+	FinishLogin(ctx context.Context, ceremonyID string, response []byte) (string, error)
+	// Reauthenticate checks the response to a login ceremony of the
+	// session's own user and renews the session's authentication time.
+	Reauthenticate(ctx context.Context, accessToken, ceremonyID string, response []byte) error
+	ListCredentials(ctx context.Context, accessToken string) ([]*domain.WebAuthnCredential, error)
+	DeleteCredential(ctx context.Context, accessToken string, id int) error
+}
+
+type webAuthnUseCase struct {
+	relyingParty *webauthn.WebAuthn
+	webAuthnRepo repository.WebAuthnRepository
+	ceremonyRepo repository.WebAuthnCeremonyRepository
+	userRepo     repository.UserRepository
+	sessionRepo  repository.SessionRepository
+	outboxRepo   repository.OutboxRepository
+	issuer       *token.Issuer
+	cfg          *config.Config
+}
+
+func NewWebAuthnUseCase(relyingParty *webauthn.WebAuthn, webAuthnRepo repository.WebAuthnRepository,
+	ceremonyRepo repository.WebAuthnCeremonyRepository, userRepo repository.UserRepository, sessionRepo repository.SessionRepository,
+	outboxRepo repository.OutboxRepository, issuer *token.Issuer, cfg *config.Config) WebAuthnUseCase {
+	return &webAuthnUseCase{
+		relyingParty: relyingParty,
+		webAuthnRepo: webAuthnRepo,
+		ceremonyRepo: ceremonyRepo,
+		userRepo:     userRepo,
+		sessionRepo:  sessionRepo,
+		outboxRepo:   outboxRepo,
+		issuer:       issuer,
+		cfg:          cfg,
+	}
+}
+
+// NewRelyingParty configures WebAuthn for the site passkeys belong to.
+func NewRelyingParty(cfg *config.Config) (*webauthn.WebAuthn, error) {
+	var origins []string
+	for _, origin := range strings.Split(cfg.WebAuthnRPOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return webauthn.New(&webauthn.Config{
+		RPID:          cfg.WebAuthnRPID,
+		RPDisplayName: cfg.WebAuthnRPDisplayName,
+		RPOrigins:     origins,
+	})
+}
+
+// webAuthnUser presents a user and their passkeys to the WebAuthn library.
+// The user handle is the user ID, which reveals nothing about the person.
+type webAuthnUser struct {
+	user        *domain.User
+	credentials []*domain.WebAuthnCredential
+}
+
+func webAuthnUserHandle(userID int) []byte {
+	return []byte(strconv.Itoa(userID))
+}
+
+func (w *webAuthnUser) WebAuthnID() []byte {
+	return webAuthnUserHandle(w.user.ID)
+}
+
+func (w *webAuthnUser) WebAuthnName() string {
+	return w.user.Email
+}
+
+func (w *webAuthnUser) WebAuthnDisplayName() string {
+	return w.user.Name
+}
+
+func (w *webAuthnUser) WebAuthnIcon() string {
+	return ""
+}
+
+func (w *webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, 0, len(w.credentials))
+	for _, c := range w.credentials {
+		transports := make([]protocol.AuthenticatorTransport, 0, len(c.Transports))
+		for _, t := range c.Transports {
+			transports = append(transports, protocol.AuthenticatorTransport(t))
+		}
+		credentials = append(credentials, webauthn.Credential{
+			ID:              c.CredentialID,
+			PublicKey:       c.PublicKey,
+			AttestationType: c.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: c.BackupEligible,
+				BackupState:    c.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    c.AAGUID,
+				SignCount: uint32(c.SignCount),
+			},
+		})
+	}
+	return credentials
+}
+
+func (w *webAuthnUser) credential(id []byte) *domain.WebAuthnCredential {
+	for _, c := range w.credentials {
+		if bytes.Equal(c.CredentialID, id) {
+			return c
+		}
+	}
+	return nil
+}
+
+func (u *webAuthnUseCase) loadUser(ctx context.Context, userID int) (*webAuthnUser, error) {
+	user, err := u.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := u.webAuthnRepo.ListByUser(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list passkeys with err %v", err)
+	}
+	return &webAuthnUser{user: user, credentials: credentials}, nil
+}
+
+// ownSession returns the session of accessToken. Passkeys are only managed
+// by the users themselves, never while impersonated.
+func (u *webAuthnUseCase) ownSession(ctx context.Context, accessToken string) (*domain.Session, error) {
+	session, err := u.sessionRepo.Get(ctx, accessToken)
+	if err != nil {
+		return nil, domain.ErrInvalidSession
+	}
+	if session.ImpersonatorID != 0 {
+		return nil, domain.ErrPermissionDenied
+	}
+	return session, nil
+}
+
+// sessionUser returns the user signed in with accessToken.
+func (u *webAuthnUseCase) sessionUser(ctx context.Context, accessToken string) (*webAuthnUser, error) {
+	session, err := u.ownSession(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	return u.loadUser(ctx, session.UserID)
+}
+
+// steppedUpSessionUser is sessionUser for changes to the user's passkeys,
+// which also need a strong login within WebAuthnStepUpMaxAge, checked as
+// requireStepUp checks marked rights. Otherwise a stolen session could
+// add a passkey of its own and keep the account after a password reset.
+func (u *webAuthnUseCase) steppedUpSessionUser(ctx context.Context, accessToken string) (*webAuthnUser, error) {
+	session, err := u.ownSession(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	principal := &domain.Principal{
+		Kind:       domain.PrincipalUser,
+		UserID:     session.UserID,
+		AuthTime:   session.AuthTime,
+		AuthMethod: session.AuthMethod,
+	}
+	if err := requireStepUp(domain.WithPrincipal(ctx, principal), u.cfg.WebAuthnStepUpMaxAge); err != nil {
+		return nil, err
+	}
+	return u.loadUser(ctx, session.UserID)
+}
+
+func (u *webAuthnUseCase) saveCeremony(ctx context.Context, ceremony *domain.WebAuthnCeremony, session *webauthn.SessionData,
+	options any) (string, []byte, error) {
+	var err error
+	if ceremony.Session, err = json.Marshal(session); err != nil {
+		return "", nil, err
+	}
+	rawOptions, err := json.Marshal(options)
+	if err != nil {
+		return "", nil, err
+	}
+
+	id, err := randomOpaqueToken()
+	if err != nil {
+		return "", nil, err
+	}
+	if err := u.ceremonyRepo.Save(ctx, id, ceremony, u.cfg.WebAuthnCeremonyTTL); err != nil {
+		return "", nil, fmt.Errorf("Failed to save passkey ceremony with err %v", err)
+	}
+	return id, rawOptions, nil
+}
+
+// consumeCeremony returns the ceremony id of kind and its session data.
+func (u *webAuthnUseCase) consumeCeremony(ctx context.Context, id string, kind domain.WebAuthnCeremonyKind) (*domain.WebAuthnCeremony,
+	*webauthn.SessionData, error) {
+	ceremony, err := u.ceremonyRepo.Consume(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ceremony.Kind != kind {
+		return nil, nil, domain.ErrWebAuthnCeremony
+	}
+	session := new(webauthn.SessionData)
+	if err := json.Unmarshal(ceremony.Session, session); err != nil {
+		return nil, nil, err
+	}
+	return ceremony, session, nil
+}
+
+func (u *webAuthnUseCase) BeginRegistration(ctx context.Context, accessToken, name string) (string, []byte, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", nil, domain.ErrInvalidWebAuthnCredentialName
+	}
+	user, err := u.steppedUpSessionUser(ctx, accessToken)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Excluding the user's passkeys stops an authenticator from being
+	// registered twice.
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(user.credentials))
+	for _, c := range user.WebAuthnCredentials() {
+		exclusions = append(exclusions, c.Descriptor())
+	}
+	creation, session, err := u.relyingParty.BeginRegistration(user,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+	)
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to begin passkey registration with err %v", err)
+	}
+
+	ceremony := &domain.WebAuthnCeremony{Kind: domain.WebAuthnRegistration, UserID: user.user.ID, Name: name}
+	return u.saveCeremony(ctx, ceremony, session, creation)
+}
+
+func (u *webAuthnUseCase) FinishRegistration(ctx context.Context, accessToken, ceremonyID string, response []byte) (*domain.WebAuthnCredential, error) {
+	user, err := u.sessionUser(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	ceremony, session, err := u.consumeCeremony(ctx, ceremonyID, domain.WebAuthnRegistration)
+	if err != nil {
+		return nil, err
+	}
+	if ceremony.UserID != user.user.ID {
+		return nil, domain.ErrWebAuthnCeremony
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(response))
+	if err != nil {
+		return nil, webAuthnError(err)
+	}
+	credential, err := u.relyingParty.CreateCredential(user, *session, parsed)
+	if err != nil {
+		return nil, webAuthnError(err)
+	}
+
+	transports := make([]string, 0, len(credential.Transport))
+	for _, t := range credential.Transport {
+		transports = append(transports, string(t))
+	}
+	return u.webAuthnRepo.Create(ctx, &domain.WebAuthnCredential{
+		UserID:          user.user.ID,
+		Name:            ceremony.Name,
+		CredentialID:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		Transports:      transports,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       int64(credential.Authenticator.SignCount),
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+	})
+}
+
+func (u *webAuthnUseCase) BeginLogin(ctx context.Context, email, secondFactorToken string) (string, []byte, error) {
+	var user *webAuthnUser
+	// A passkey on its own must prove the user is present and verified, as
+	// a PIN or biometric; after a password, presence is enough.
+	verification := protocol.VerificationRequired
+
+	switch {
+	case secondFactorToken != "":
+		claims, err := u.issuer.Consume(ctx, secondFactorToken, token.PurposeSecondFactor)
+		if err != nil {
+			return "", nil, err
+		}
+		if user, err = u.loadUser(ctx, claims.UserID); err != nil {
+			return "", nil, err
+		}
+		if len(user.credentials) == 0 {
+			return "", nil, domain.ErrWebAuthnCredentialNotFound
+		}
+		verification = protocol.VerificationPreferred
+	case email != "":
+		found, err := u.userRepo.GetByEmail(ctx, strings.TrimSpace(email))
+		if err != nil && !errors.Is(err, domain.ErrUserNotFound) {
+			return "", nil, err
+		}
+		// An unknown email, or one without passkeys, gets the same kind of
+		// answer as the discoverable login below, so the response does not
+		// tell who has an account.
+		if found != nil {
+			if user, err = u.loadUser(ctx, found.ID); err != nil {
+				return "", nil, err
+			}
+			if len(user.credentials) == 0 {
+				user = nil
+			}
+		}
+	}
+
+	var assertion *protocol.CredentialAssertion
+	var session *webauthn.SessionData
+	var err error
+	ceremony := &domain.WebAuthnCeremony{Kind: domain.WebAuthnLogin, SecondFactor: secondFactorToken != ""}
+	if user != nil {
+		ceremony.UserID = user.user.ID
+		assertion, session, err = u.relyingParty.BeginLogin(user, webauthn.WithUserVerification(verification))
+	} else {
+		assertion, session, err = u.relyingParty.BeginDiscoverableLogin(webauthn.WithUserVerification(verification))
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to begin passkey login with err %v", err)
+	}
+	return u.saveCeremony(ctx, ceremony, session, assertion)
+}
+
+func (u *webAuthnUseCase) FinishLogin(ctx context.Context, ceremonyID string, response []byte) (string, error) {
+	user, method, err := u.verifyLogin(ctx, ceremonyID, response)
+	if err != nil {
+		return "", err
+	}
+	if !user.Status.CanLogin() {
+		return "", domain.ErrUserNotActive
+	}
+	return startSession(ctx, u.sessionRepo, u.outboxRepo, user, method)
+}
+
+func (u *webAuthnUseCase) Reauthenticate(ctx context.Context, accessToken, ceremonyID string, response []byte) error {
+	session, err := u.ownSession(ctx, accessToken)
+	if err != nil {
+		return err
+	}
+
+	user, method, err := u.verifyLogin(ctx, ceremonyID, response)
+	if err != nil {
+		return err
+	}
+	if user.ID != session.UserID {
+		return domain.ErrWebAuthnVerification
+	}
+	if !user.Status.CanLogin() {
+		return domain.ErrUserNotActive
+	}
+	return reauthenticateSession(ctx, u.sessionRepo, accessToken, session, method)
+}
+
+// verifyLogin checks the response to a login ceremony and returns the user
+// it proves, with AuthMethodMFA when the passkey confirmed a first factor.
+func (u *webAuthnUseCase) verifyLogin(ctx context.Context, ceremonyID string, response []byte) (*domain.User, domain.AuthMethod, error) {
+	ceremony, session, err := u.consumeCeremony(ctx, ceremonyID, domain.WebAuthnLogin)
+	if err != nil {
+		return nil, "", err
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(response))
+	if err != nil {
+		return nil, "", webAuthnError(err)
+	}
+
+	var user *webAuthnUser
+	var credential *webauthn.Credential
+	if ceremony.UserID != 0 {
+		if user, err = u.loadUser(ctx, ceremony.UserID); err != nil {
+			return nil, "", err
+		}
+		credential, err = u.relyingParty.ValidateLogin(user, *session, parsed)
+	} else {
+		credential, err = u.relyingParty.ValidateDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+			userID, err := strconv.Atoi(string(userHandle))
+			if err != nil {
+				return nil, domain.ErrWebAuthnCredentialNotFound
+			}
+			if user, err = u.loadUser(ctx, userID); err != nil {
+				return nil, err
+			}
+			return user, nil
+		}, *session, parsed)
+	}
+	if err != nil {
+		return nil, "", webAuthnError(err)
+	}
+
+	stored := user.credential(credential.ID)
+	if stored == nil {
+		return nil, "", domain.ErrWebAuthnCredentialNotFound
+	}
+	if credential.Authenticator.CloneWarning {
+		log.Printf("Rejected passkey %d of user %d, its sign count did not grow past %d, it may be cloned",
+			stored.ID, stored.UserID, stored.SignCount)
+		return nil, "", domain.ErrWebAuthnSignCount
+	}
+	if err := u.webAuthnRepo.RecordUse(ctx, stored.ID, int64(credential.Authenticator.SignCount), credential.Flags.BackupState); err != nil {
+		return nil, "", err
+	}
+
+	if ceremony.SecondFactor {
+		return user.user, domain.AuthMethodMFA, nil
+	}
+	return user.user, domain.AuthMethodPasskey, nil
+}
+
+func (u *webAuthnUseCase) ListCredentials(ctx context.Context, accessToken string) ([]*domain.WebAuthnCredential, error) {
+	user, err := u.sessionUser(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	return user.credentials, nil
+}
+
+func (u *webAuthnUseCase) DeleteCredential(ctx context.Context, accessToken string, id int) error {
+	user, err := u.steppedUpSessionUser(ctx, accessToken)
+	if err != nil {
+		return err
+	}
+	return u.webAuthnRepo.Delete(ctx, user.user.ID, id)
+}
+
+// webAuthnError keeps the library's reasons for rejecting a response in
+// the log and gives callers domain.ErrWebAuthnVerification, unless the
+// failure was ours rather than the response's.
+func webAuthnError(err error) error {
+	var protocolErr *protocol.Error
+	if errors.As(err, &protocolErr) {
+		log.Printf("Rejected passkey response: %s %s", protocolErr.Details, protocolErr.DevInfo)
+		return domain.ErrWebAuthnVerification
+	}
+	if errors.Is(err, domain.ErrWebAuthnCredentialNotFound) || errors.Is(err, domain.ErrUserNotFound) {
+		return domain.ErrWebAuthnVerification
+	}
+	return err
+}
@@ -0,0 +1,417 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"tablelink/internal/config"
+	"tablelink/internal/domain"
+	"tablelink/internal/passkey"
+	"tablelink/internal/token"
+	"testing"
+	"time"
+)
+
+type fakeWebAuthnRepo struct {
+	mu          sync.Mutex
+	credentials []*domain.WebAuthnCredential
+}
+
+func (f *fakeWebAuthnRepo) Create(ctx context.Context, credential *domain.WebAuthnCredential) (*domain.WebAuthnCredential, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	credential.ID = len(f.credentials) + 1
+	credential.CreatedAt = time.Now()
+	stored := *credential
+	f.credentials = append(f.credentials, &stored)
+	return credential, nil
+}
+
+func (f *fakeWebAuthnRepo) ListByUser(ctx context.Context, userID int) ([]*domain.WebAuthnCredential, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var credentials []*domain.WebAuthnCredential
+	for _, c := range f.credentials {
+		if c.UserID == userID {
+			copied := *c
+			credentials = append(credentials, &copied)
+		}
+	}
+	return credentials, nil
+}
+
+func (f *fakeWebAuthnRepo) Delete(ctx context.Context, userID, id int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, c := range f.credentials {
+		if c.ID == id && c.UserID == userID {
+			f.credentials = slices.Delete(f.credentials, i, i+1)
+			return nil
+		}
+	}
+	return domain.ErrWebAuthnCredentialNotFound
+}
+
+// RecordUse refuses a counter that did not grow, as the SQL update does.
+func (f *fakeWebAuthnRepo) RecordUse(ctx context.Context, id int, signCount int64, backupState bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.credentials {
+		if c.ID == id {
+			if signCount <= c.SignCount && (signCount != 0 || c.SignCount != 0) {
+				return domain.ErrWebAuthnSignCount
+			}
+			c.SignCount, c.BackupState = signCount, backupState
+			return nil
+		}
+	}
+	return domain.ErrWebAuthnCredentialNotFound
+}
+
+type fakeCeremonyRepo struct {
+	mu         sync.Mutex
+	ceremonies map[string]*domain.WebAuthnCeremony
+}
+
+func (f *fakeCeremonyRepo) Save(ctx context.Context, id string, ceremony *domain.WebAuthnCeremony, ttl time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.ceremonies[id] = ceremony
+	return nil
+}
+
+func (f *fakeCeremonyRepo) Consume(ctx context.Context, id string) (*domain.WebAuthnCeremony, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ceremony, ok := f.ceremonies[id]
+	if !ok {
+		return nil, domain.ErrWebAuthnCeremony
+	}
+	delete(f.ceremonies, id)
+	return ceremony, nil
+}
+
+// fakeAuthenticator accepts any password of a known user.
+type fakeAuthenticator struct{ users *fakeUserRepo }
+
+func (a fakeAuthenticator) Authenticate(ctx context.Context, email, password string) (*domain.User, error) {
+	return a.users.GetByEmail(ctx, email)
+}
+
+const webAuthnOrigin = "http://localhost:3000"
+
+type webAuthnFixture struct {
+	uc          WebAuthnUseCase
+	auth        AuthUseCase
+	sessions    *fakeSessionRepo
+	credentials *fakeWebAuthnRepo
+	browser     *passkey.FakeAuthenticator
+}
+
+// newWebAuthnFixture serves Ada, user 1, whose sessions are the tokens of
+// the fixture's sessions.
+func newWebAuthnFixture(t *testing.T) *webAuthnFixture {
+	t.Helper()
+	cfg := &config.Config{
+		WebAuthnRPID:          "localhost",
+		WebAuthnRPDisplayName: "Tablelink",
+		WebAuthnRPOrigins:     webAuthnOrigin,
+		WebAuthnCeremonyTTL:   time.Minute,
+		WebAuthnStepUpMaxAge:  5 * time.Minute,
+		SecondFactorTTL:       time.Minute,
+	}
+	relyingParty, err := NewRelyingParty(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := newFakeUserRepo(&domain.User{ID: 1, Name: "Ada", Email: "ada@example.org"})
+	f := &webAuthnFixture{
+		sessions:    newFakeSessionRepo(),
+		credentials: &fakeWebAuthnRepo{},
+		browser:     passkey.NewFakeAuthenticator(webAuthnOrigin),
+	}
+	issuer := token.NewIssuer("secret", newFakeTokenRepo())
+	f.uc = NewWebAuthnUseCase(relyingParty, f.credentials, &fakeCeremonyRepo{ceremonies: make(map[string]*domain.WebAuthnCeremony)},
+		users, f.sessions, &fakeOutboxRepo{}, issuer, cfg)
+	f.auth = NewAuthUseCase(fakeAuthenticator{users}, users, f.sessions, &fakeOutboxRepo{}, nil, f.credentials, issuer, nil, cfg)
+	return f
+}
+
+// session saves a session of Ada authenticated with method age ago.
+func (f *webAuthnFixture) session(token string, method domain.AuthMethod, age time.Duration, impersonatorID int) string {
+	f.sessions.Create(context.Background(), token, &domain.Session{
+		UserID:         1,
+		ImpersonatorID: impersonatorID,
+		AuthTime:       time.Now().Add(-age),
+		AuthMethod:     method,
+	}, time.Hour)
+	return token
+}
+
+// register gives Ada a passkey held by the fixture's browser.
+func (f *webAuthnFixture) register(t *testing.T) *domain.WebAuthnCredential {
+	t.Helper()
+	ctx := context.Background()
+	accessToken := f.session("register", domain.AuthMethodPassword, 0, 0)
+	ceremonyID, options, err := f.uc.BeginRegistration(ctx, accessToken, "Laptop")
+	if err != nil {
+		t.Fatalf("BeginRegistration() err = %v", err)
+	}
+	response, err := f.browser.Register(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credential, err := f.uc.FinishRegistration(ctx, accessToken, ceremonyID, response)
+	if err != nil {
+		t.Fatalf("FinishRegistration() err = %v", err)
+	}
+	return credential
+}
+
+// login runs a login ceremony begun with email or secondFactorToken in
+// the fixture's browser.
+func (f *webAuthnFixture) login(t *testing.T, email, secondFactorToken string) (string, error) {
+	t.Helper()
+	ctx := context.Background()
+	ceremonyID, options, err := f.uc.BeginLogin(ctx, email, secondFactorToken)
+	if err != nil {
+		return "", err
+	}
+	response, err := f.browser.Login(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f.uc.FinishLogin(ctx, ceremonyID, response)
+}
+
+func TestWebAuthnBeginRegistration(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         domain.AuthMethod
+		age            time.Duration
+		impersonatorID int
+		passkeyName    string
+		wantErr        error
+		wantStepUp     bool
+	}{
+		{name: "recent password login", method: domain.AuthMethodPassword, passkeyName: "Laptop"},
+		{name: "recent passkey login", method: domain.AuthMethodPasskey, age: 4 * time.Minute, passkeyName: "Laptop"},
+		{name: "password login too long ago", method: domain.AuthMethodPassword, age: 10 * time.Minute, passkeyName: "Laptop", wantStepUp: true},
+		{name: "magic link login", method: domain.AuthMethodMagicLink, passkeyName: "Laptop", wantStepUp: true},
+		{name: "federated login", method: domain.AuthMethodFederated, passkeyName: "Laptop", wantStepUp: true},
+		{name: "impersonated session", method: domain.AuthMethodImpersonation, impersonatorID: 2, passkeyName: "Laptop", wantErr: domain.ErrPermissionDenied},
+		{name: "missing name", method: domain.AuthMethodPassword, passkeyName: " ", wantErr: domain.ErrInvalidWebAuthnCredentialName},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newWebAuthnFixture(t)
+			accessToken := f.session("token", tt.method, tt.age, tt.impersonatorID)
+
+			_, _, err := f.uc.BeginRegistration(context.Background(), accessToken, tt.passkeyName)
+			var stepUp *domain.StepUpRequiredError
+			switch {
+			case tt.wantStepUp:
+				if !errors.As(err, &stepUp) || stepUp.MaxAge != 5*time.Minute {
+					t.Errorf("BeginRegistration() err = %v, want a step up within 5m", err)
+				}
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("BeginRegistration() err = %v, want %v", err, tt.wantErr)
+				}
+			case err != nil:
+				t.Errorf("BeginRegistration() err = %v", err)
+			}
+		})
+	}
+}
+
+func TestWebAuthnRegistration(t *testing.T) {
+	ctx := context.Background()
+	f := newWebAuthnFixture(t)
+	accessToken := f.session("token", domain.AuthMethodPassword, 0, 0)
+
+	ceremonyID, options, err := f.uc.BeginRegistration(ctx, accessToken, "Laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := f.browser.Register(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credential, err := f.uc.FinishRegistration(ctx, accessToken, ceremonyID, response)
+	if err != nil {
+		t.Fatalf("FinishRegistration() err = %v", err)
+	}
+	if credential.UserID != 1 || credential.Name != "Laptop" || len(credential.PublicKey) == 0 {
+		t.Errorf("credential = %+v, want Ada's Laptop with a public key", credential)
+	}
+
+	if _, err := f.uc.FinishRegistration(ctx, accessToken, ceremonyID, response); !errors.Is(err, domain.ErrWebAuthnCeremony) {
+		t.Errorf("replayed FinishRegistration() err = %v, want %v", err, domain.ErrWebAuthnCeremony)
+	}
+	// The options exclude the passkey, so the authenticator refuses to
+	// register a second one for Ada.
+	if _, options, err = f.uc.BeginRegistration(ctx, accessToken, "Again"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.browser.Register(options); err == nil {
+		t.Error("Register() succeeded for an excluded authenticator")
+	}
+}
+
+func TestWebAuthnLogin(t *testing.T) {
+	tests := []struct {
+		name string
+		// email and secondFactor begin the login, a password login
+		// giving the second factor token.
+		email        string
+		secondFactor bool
+		wantMethod   domain.AuthMethod
+	}{
+		{name: "discoverable", wantMethod: domain.AuthMethodPasskey},
+		{name: "by email", email: "ada@example.org", wantMethod: domain.AuthMethodPasskey},
+		{name: "second factor of a password login", secondFactor: true, wantMethod: domain.AuthMethodMFA},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			f := newWebAuthnFixture(t)
+			f.register(t)
+
+			var secondFactorToken string
+			if tt.secondFactor {
+				_, err := f.auth.Login(ctx, "ada@example.org", "password")
+				var required *domain.SecondFactorRequiredError
+				if !errors.As(err, &required) {
+					t.Fatalf("Login() err = %v, want a second factor", err)
+				}
+				secondFactorToken = required.Token
+			}
+
+			accessToken, err := f.login(t, tt.email, secondFactorToken)
+			if err != nil {
+				t.Fatalf("FinishLogin() err = %v", err)
+			}
+			session := f.sessions.sessions[accessToken]
+			if session == nil || session.UserID != 1 || session.AuthMethod != tt.wantMethod {
+				t.Errorf("session = %+v, want Ada with %s", session, tt.wantMethod)
+			}
+			if tt.secondFactor {
+				if _, _, err := f.uc.BeginLogin(ctx, "", secondFactorToken); err == nil {
+					t.Error("BeginLogin() accepted a used second factor token")
+				}
+			}
+		})
+	}
+}
+
+func TestWebAuthnLoginRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		tamper  func(f *webAuthnFixture)
+		wantErr error
+	}{
+		{
+			// A clone of the authenticator replays an older counter.
+			name:    "sign count regression",
+			tamper:  func(f *webAuthnFixture) { f.browser.Credentials[0].SignCount = 0 },
+			wantErr: domain.ErrWebAuthnSignCount,
+		},
+		{
+			name:    "another origin",
+			tamper:  func(f *webAuthnFixture) { f.browser.Origin = "https://evil.example" },
+			wantErr: domain.ErrWebAuthnVerification,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newWebAuthnFixture(t)
+			f.register(t)
+			for range 2 {
+				if _, err := f.login(t, "ada@example.org", ""); err != nil {
+					t.Fatalf("FinishLogin() err = %v", err)
+				}
+			}
+
+			tt.tamper(f)
+			if _, err := f.login(t, "ada@example.org", ""); !errors.Is(err, tt.wantErr) {
+				t.Errorf("FinishLogin() err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWebAuthnReauthenticate(t *testing.T) {
+	tests := []struct {
+		name           string
+		impersonatorID int
+		wantErr        error
+	}{
+		{name: "own session"},
+		{name: "impersonated session", impersonatorID: 2, wantErr: domain.ErrPermissionDenied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			f := newWebAuthnFixture(t)
+			f.register(t)
+			accessToken := f.session("token", domain.AuthMethodMagicLink, time.Hour, tt.impersonatorID)
+
+			ceremonyID, options, err := f.uc.BeginLogin(ctx, "ada@example.org", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			response, err := f.browser.Login(options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = f.uc.Reauthenticate(ctx, accessToken, ceremonyID, response)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Reauthenticate() err = %v, want %v", err, tt.wantErr)
+			}
+			session := f.sessions.sessions[accessToken]
+			if renewed := session.AuthMethod == domain.AuthMethodPasskey && time.Since(session.AuthTime) < time.Minute; renewed != (tt.wantErr == nil) {
+				t.Errorf("session = %+v, renewed %t, want %t", session, renewed, tt.wantErr == nil)
+			}
+		})
+	}
+}
+
+func TestWebAuthnDeleteCredential(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     domain.AuthMethod
+		age        time.Duration
+		wantStepUp bool
+	}{
+		{name: "recent login", method: domain.AuthMethodPasskey},
+		{name: "stale login", method: domain.AuthMethodPasskey, age: time.Hour, wantStepUp: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			f := newWebAuthnFixture(t)
+			credential := f.register(t)
+			accessToken := f.session("token", tt.method, tt.age, 0)
+
+			err := f.uc.DeleteCredential(ctx, accessToken, credential.ID)
+			var stepUp *domain.StepUpRequiredError
+			if tt.wantStepUp != errors.As(err, &stepUp) || (!tt.wantStepUp && err != nil) {
+				t.Fatalf("DeleteCredential() err = %v, want step up %t", err, tt.wantStepUp)
+			}
+			wantLeft := 0
+			if tt.wantStepUp {
+				wantLeft = 1
+			}
+			if left, _ := f.uc.ListCredentials(ctx, accessToken); len(left) != wantLeft {
+				t.Errorf("%d passkeys left, want %d", len(left), wantLeft)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"tablelink/internal/config"
+	"tablelink/internal/domain"
+	"tablelink/internal/repository"
+	"tablelink/internal/webhook"
+)
+
+const (
+	defaultDeliveryLimit = 50
+	maxDeliveryLimit     = 500
+)
+
+type WebhookUseCase interface {
+	CreateWebhook(ctx context.Context, roleID int, section, route string, sub *domain.WebhookSubscription) (*domain.WebhookSubscription, error)
+	ListWebhooks(ctx context.Context, roleID int, section, route string) ([]*domain.WebhookSubscription, error)
+	// UpdateWebhook replaces the URL, event types and active flag. The secret
+	// is kept unless rotateSecret is set, in which case a new one is returned.
+	UpdateWebhook(ctx context.Context, roleID int, section, route string, sub *domain.WebhookSubscription, rotateSecret bool) (*domain.WebhookSubscription, error)
+	DeleteWebhook(ctx context.Context, roleID int, section, route string, id int) error
+	ListWebhookDeliveries(ctx context.Context, roleID int, section, route string, filter *domain.WebhookDeliveryFilter) ([]*domain.WebhookDelivery, error)
+	RedeliverWebhook(ctx context.Context, roleID int, section, route string, subscriptionID int, deliveryID int64) error
+}
+
+type webhookUseCase struct {
+	webhookRepo repository.WebhookRepository
+	rightRepo   repository.RoleRightRepository
+	cfg         *config.Config
+}
+
+func NewWebhookUseCase(webhookRepo repository.WebhookRepository, rightRepo repository.RoleRightRepository, cfg *config.Config) WebhookUseCase {
+	return &webhookUseCase{
+		webhookRepo: webhookRepo,
+		rightRepo:   rightRepo,
+		cfg:         cfg,
+	}
+}
+
+func (u *webhookUseCase) CreateWebhook(ctx context.Context, roleID int, section, route string, sub *domain.WebhookSubscription) (*domain.WebhookSubscription, error) {
+	if err := authorize(ctx, u.rightRepo, roleID, section, route, "create"); err != nil {
+		return nil, err
+	}
+	if err := u.validateWebhook(ctx, sub); err != nil {
+		return nil, err
+	}
+
+	if sub.Secret == "" {
+		secret, err := newWebhookSecret()
+		if err != nil {
+			return nil, err
+		}
+		sub.Secret = secret
+	}
+	sub.Active = true
+
+	return u.webhookRepo.Create(ctx, sub)
+}
+
+func (u *webhookUseCase) ListWebhooks(ctx context.Context, roleID int, section, route string) ([]*domain.WebhookSubscription, error) {
+	if err := authorize(ctx, u.rightRepo, roleID, section, route, "read"); err != nil {
+		return nil, err
+	}
+
+	return u.webhookRepo.List(ctx)
+}
+
+func (u *webhookUseCase) UpdateWebhook(ctx context.Context, roleID int, section, route string, sub *domain.WebhookSubscription, rotateSecret bool) (*domain.WebhookSubscription, error) {
+	if err := authorize(ctx, u.rightRepo, roleID, section, route, "update"); err != nil {
+		return nil, err
+	}
+	if err := u.validateWebhook(ctx, sub); err != nil {
+		return nil, err
+	}
+
+	current, err := u.webhookRepo.GetByID(ctx, sub.ID)
+	if err != nil {
+		return nil, err
+	}
+	sub.Secret = current.Secret
+	if rotateSecret {
+		if sub.Secret, err = newWebhookSecret(); err != nil {
+			return nil, err
+		}
+	}
+
+	return u.webhookRepo.Update(ctx, sub)
+}
+
+func (u *webhookUseCase) DeleteWebhook(ctx context.Context, roleID int, section, route string, id int) error {
+	if err := authorize(ctx, u.rightRepo, roleID, section, route, "delete"); err != nil {
+		return err
+	}
+
+	return u.webhookRepo.Delete(ctx, id)
+}
+
+func (u *webhookUseCase) ListWebhookDeliveries(ctx context.Context, roleID int, section, route string, filter *domain.WebhookDeliveryFilter) ([]*domain.WebhookDelivery, error) {
+	if err := authorize(ctx, u.rightRepo, roleID, section, route, "read"); err != nil {
+		return nil, err
+	}
+	if filter.Status != "" && !filter.Status.Valid() {
+		return nil, domain.ErrInvalidDeliveryStatus
+	}
+	if _, err := u.webhookRepo.GetByID(ctx, filter.SubscriptionID); err != nil {
+		return nil, err
+	}
+
+	if filter.Limit <= 0 {
+		filter.Limit = defaultDeliveryLimit
+	}
+	if filter.Limit > maxDeliveryLimit {
+		filter.Limit = maxDeliveryLimit
+	}
+
+	return u.webhookRepo.ListDeliveries(ctx, filter)
+}
+
+func (u *webhookUseCase) RedeliverWebhook(ctx context.Context, roleID int, section, route string, subscriptionID int, deliveryID int64) error {
+	if err := authorize(ctx, u.rightRepo, roleID, section, route, "update"); err != nil {
+		return err
+	}
+
+	return u.webhookRepo.Redeliver(ctx, subscriptionID, deliveryID)
+}
+
+func (u *webhookUseCase) validateWebhook(ctx context.Context, sub *domain.WebhookSubscription) error {
+	sub.URL = strings.TrimSpace(sub.URL)
+	parsed, err := url.Parse(sub.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+		return domain.ErrInvalidWebhookURL
+	}
+	if !u.cfg.WebhookAllowPrivate {
+		if err := webhook.CheckHost(ctx, parsed.Hostname()); err != nil {
+			return err
+		}
+	}
+
+	if len(sub.EventTypes) == 0 {
+		return domain.ErrInvalidEventType
+	}
+	for _, t := range sub.EventTypes {
+		if t != domain.WebhookAllEvents && !domain.EventType(t).Valid() {
+			return fmt.Errorf("%w: %s", domain.ErrInvalidEventType, t)
+		}
+	}
+	return nil
+}
+
+func newWebhookSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("Failed to generate webhook secret with err %v", err)
+	}
+	return "whsec_" + hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,65 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"tablelink/internal/config"
+	"tablelink/internal/domain"
+	"tablelink/internal/repository"
+	"testing"
+)
+
+type fakeWebhookRepo struct {
+	repository.WebhookRepository
+	subs []*domain.WebhookSubscription
+}
+
+func (f *fakeWebhookRepo) Create(ctx context.Context, sub *domain.WebhookSubscription) (*domain.WebhookSubscription, error) {
+	created := *sub
+	created.ID = len(f.subs) + 1
+	f.subs = append(f.subs, &created)
+	return &created, nil
+}
+
+func TestCreateWebhook(t *testing.T) {
+	events := []string{string(domain.EventUserCreated)}
+	tests := []struct {
+		name         string
+		url          string
+		events       []string
+		allowPrivate bool
+		wantErr      error
+	}{
+		{name: "accepts a public address", url: "https://93.184.216.34/hook", events: events},
+		{name: "refuses a relative url", url: "/hook", events: events, wantErr: domain.ErrInvalidWebhookURL},
+		{name: "refuses a loopback host", url: "http://localhost:8080/hook", events: events, wantErr: domain.ErrWebhookAddress},
+		{name: "refuses a private address", url: "http://10.0.0.5/hook", events: events, wantErr: domain.ErrWebhookAddress},
+		{name: "refuses the metadata service", url: "http://169.254.169.254/latest/meta-data", events: events, wantErr: domain.ErrWebhookAddress},
+		{name: "refuses a loopback IPv6 literal", url: "http://[::1]:8080/hook", events: events, wantErr: domain.ErrWebhookAddress},
+		{name: "accepts a private address when allowed", url: "http://localhost:8080/hook", events: events, allowPrivate: true},
+		{name: "refuses an unknown event type", url: "https://93.184.216.34/hook", events: []string{"Nope"}, wantErr: domain.ErrInvalidEventType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &fakeWebhookRepo{}
+			uc := NewWebhookUseCase(repo, &fakeRightRepo{rights: domain.RoleRight{RCreate: true}},
+				&config.Config{WebhookAllowPrivate: tt.allowPrivate})
+
+			sub, err := uc.CreateWebhook(context.Background(), 1, domain.UsersSection, domain.WebhooksRoute,
+				&domain.WebhookSubscription{URL: tt.url, EventTypes: tt.events})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CreateWebhook() err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if len(repo.subs) != 0 {
+					t.Errorf("refused webhook was stored")
+				}
+				return
+			}
+			if sub.Secret == "" || !sub.Active {
+				t.Errorf("webhook = %+v, want an active webhook with a secret", sub)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"syscall"
+	"tablelink/internal/domain"
+)
+
+// blockedPrefixes are special-purpose ranges netip has no predicate for.
+// Shared address space hosts cloud metadata services on some providers,
+// and NAT64 and 6to4 addresses embed an IPv4 address that may be private.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("2002::/16"),
+}
+
+// PublicAddr reports whether a subscriber may be reached at addr. Private,
+// loopback, link-local, unspecified and other special-purpose addresses
+// are refused so a webhook URL cannot be used to reach services inside our
+// network.
+func PublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() ||
+		addr.IsPrivate() ||
+		addr.IsLoopback() ||
+		addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() ||
+		addr.IsUnspecified() {
+		return false
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckHost resolves host and returns domain.ErrWebhookAddress when any of
+// its addresses is not public. It runs when a subscription is saved; the
+// dialer of Sender checks again on every delivery, as DNS may have changed.
+func CheckHost(ctx context.Context, host string) error {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if !PublicAddr(addr) {
+			return domain.ErrWebhookAddress
+		}
+		return nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("%w: %v", domain.ErrInvalidWebhookURL, err)
+	}
+	for _, addr := range addrs {
+		if !PublicAddr(addr) {
+			return domain.ErrWebhookAddress
+		}
+	}
+	return nil
+}
+
+// dialPublic is a net.Dialer Control hook. It sees the address actually
+// being connected to, after DNS resolution and on every redirect.
+func dialPublic(network, address string, c syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !PublicAddr(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s", domain.ErrWebhookAddress, addrPort.Addr())
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"tablelink/internal/domain"
+	"testing"
+	"time"
+)
+
+func TestPublicAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"fd00::1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"0.0.0.0", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+		{"0.1.2.3", false},
+		{"100.64.0.1", false},
+		{"100.100.100.200", false},
+		{"192.0.0.170", false},
+		{"198.18.0.1", false},
+		{"198.19.255.254", false},
+		{"240.0.0.1", false},
+		{"255.255.255.255", false},
+		{"64:ff9b::a9fe:a9fe", false},
+		{"64:ff9b:1::a00:1", false},
+		{"2002:a00:1::1", false},
+		{"::ffff:100.64.0.1", false},
+		{"100.128.0.1", true},
+		{"198.20.0.1", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			if got := PublicAddr(netip.MustParseAddr(tt.addr)); got != tt.want {
+				t.Errorf("PublicAddr(%s) = %t, want %t", tt.addr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckHost(t *testing.T) {
+	tests := []struct {
+		host    string
+		wantErr error
+	}{
+		{host: "93.184.216.34"},
+		{host: "169.254.169.254", wantErr: domain.ErrWebhookAddress},
+		{host: "::1", wantErr: domain.ErrWebhookAddress},
+		{host: "localhost", wantErr: domain.ErrWebhookAddress},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			if err := CheckHost(context.Background(), tt.host); !errors.Is(err, tt.wantErr) {
+				t.Errorf("CheckHost(%s) err = %v, want %v", tt.host, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSenderDialsOnlyPublicAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	delivery := &domain.WebhookDelivery{ID: 1, URL: server.URL, EventType: domain.EventUserCreated, Secret: "secret"}
+
+	tests := []struct {
+		name         string
+		allowPrivate bool
+		wantErr      error
+	}{
+		{name: "refuses a loopback subscriber", wantErr: domain.ErrWebhookAddress},
+		{name: "delivers when private addresses are allowed", allowPrivate: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSender(time.Second, tt.allowPrivate).Send(context.Background(), delivery)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Send() err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package webhook
+
+import (
+	"context"
+	"tablelink/internal/domain"
+	"tablelink/internal/repository"
+)
+
+// Dispatcher is the EventPublisher that feeds webhooks: it turns every
+// relayed event into deliveries for the matching subscriptions, which
+// worker.WebhookDeliverer then sends.
+type Dispatcher struct {
+	webhookRepo repository.WebhookRepository
+}
+
+func NewDispatcher(webhookRepo repository.WebhookRepository) *Dispatcher {
+	return &Dispatcher{
+		webhookRepo: webhookRepo,
+	}
+}
+
+func (d *Dispatcher) Publish(ctx context.Context, event *domain.Event) error {
+	return d.webhookRepo.Enqueue(ctx, event)
+}
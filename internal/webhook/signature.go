@@ -0,0 +1,53 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HeaderSignature = "X-Tablelink-Signature"
+	HeaderEvent     = "X-Tablelink-Event"
+	HeaderDelivery  = "X-Tablelink-Delivery"
+)
+
+// Sign returns the signature header value for body sent at ts. The MAC covers
+// the timestamp too, so receivers can reject replays of old deliveries.
+func Sign(secret string, ts time.Time, body []byte) string {
+	unix := strconv.FormatInt(ts.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", unix, mac(secret, unix, body))
+}
+
+// Verify checks a signature header produced by Sign and that it is not older
+// than tolerance. It is what receivers are expected to implement.
+func Verify(secret, header string, body []byte, tolerance time.Duration, now time.Time) bool {
+	var unix, sig string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			unix = value
+		case "v1":
+			sig = value
+		}
+	}
+
+	sec, err := strconv.ParseInt(unix, 10, 64)
+	if err != nil || now.Sub(time.Unix(sec, 0)) > tolerance {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(mac(secret, unix, body)))
+}
+
+func mac(secret, unix string, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(unix))
+	h.Write([]byte("."))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
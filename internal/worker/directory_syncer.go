@@ -0,0 +1,56 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"tablelink/internal/usecase"
+	"time"
+)
+
+// DirectorySyncer periodically syncs users from the configured directory.
+type DirectorySyncer struct {
+	syncUC   usecase.DirectorySyncUseCase
+	interval time.Duration
+}
+
+func NewDirectorySyncer(syncUC usecase.DirectorySyncUseCase, interval time.Duration) *DirectorySyncer {
+	return &DirectorySyncer{
+		syncUC:   syncUC,
+		interval: interval,
+	}
+}
+
+func (s *DirectorySyncer) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.sync(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *DirectorySyncer) sync(ctx context.Context) {
+	report, err := s.syncUC.Sync(ctx, false)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Failed to sync directory with err %v", err)
+		}
+		return
+	}
+
+	for _, action := range report.Actions {
+		if action.Err != nil {
+			log.Printf("Failed to %s directory user %s with err %v", action.Kind, action.DN, action.Err)
+		}
+	}
+	if len(report.Actions) > 0 {
+		log.Printf("Synced directory: %d changes, %d failed, %d unchanged",
+			len(report.Actions), report.Failed(), report.Unchanged)
+	}
+}
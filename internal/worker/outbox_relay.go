@@ -0,0 +1,87 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"tablelink/internal/domain"
+	"tablelink/internal/publisher"
+	"tablelink/internal/repository"
+	"time"
+)
+
+// OutboxRelay publishes events from the outbox. An event is only marked as
+// published once the publisher accepted it, so delivery is at-least-once.
+// When an event fails, later events of the same aggregate in the batch are
+// held back so each user's events are delivered in order.
+type OutboxRelay struct {
+	outboxRepo repository.OutboxRepository
+	publisher  publisher.EventPublisher
+	batchSize  int
+	interval   time.Duration
+}
+
+func NewOutboxRelay(outboxRepo repository.OutboxRepository, publisher publisher.EventPublisher, batchSize int, interval time.Duration) *OutboxRelay {
+	return &OutboxRelay{
+		outboxRepo: outboxRepo,
+		publisher:  publisher,
+		batchSize:  batchSize,
+		interval:   interval,
+	}
+}
+
+func (r *OutboxRelay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		// Keep draining while whole batches are published. A batch with
+		// a failure waits for the next tick, or the relay would retry the
+		// failing events in a busy loop.
+		for r.relay(ctx) == r.batchSize && ctx.Err() == nil {
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *OutboxRelay) relay(ctx context.Context) int {
+	n, err := r.outboxRepo.Relay(ctx, r.batchSize, func(events []*domain.Event) []int64 {
+		return r.publish(ctx, events)
+	})
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Failed to relay outbox events with err %v", err)
+		}
+		return 0
+	}
+	return n
+}
+
+func (r *OutboxRelay) publish(ctx context.Context, events []*domain.Event) []int64 {
+	type aggregate struct {
+		kind string
+		id   int
+	}
+
+	published := make([]int64, 0, len(events))
+	blocked := make(map[aggregate]bool)
+	for _, event := range events {
+		key := aggregate{event.AggregateType, event.AggregateID}
+		if blocked[key] {
+			continue
+		}
+		if err := r.publisher.Publish(ctx, event); err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Failed to publish event %d with err %v", event.ID, err)
+			}
+			blocked[key] = true
+			continue
+		}
+		published = append(published, event.ID)
+	}
+	return published
+}
@@ -0,0 +1,126 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"tablelink/internal/domain"
+	"tablelink/internal/repository"
+	"testing"
+	"time"
+)
+
+// fakeOutbox relays its unpublished events in ID order, and reports on
+// idle when a call publishes less than a full batch, which is when the
+// relay waits for its next tick.
+type fakeOutbox struct {
+	repository.OutboxRepository
+	mu        sync.Mutex
+	events    []*domain.Event
+	published map[int64]bool
+	calls     int
+	idle      chan struct{}
+}
+
+func (f *fakeOutbox) Relay(ctx context.Context, limit int, publish func([]*domain.Event) []int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	var batch []*domain.Event
+	for _, e := range f.events {
+		if !f.published[e.ID] && len(batch) < limit {
+			batch = append(batch, e)
+		}
+	}
+	ids := publish(batch)
+	for _, id := range ids {
+		f.published[id] = true
+	}
+	if len(ids) < limit {
+		select {
+		case f.idle <- struct{}{}:
+		default:
+		}
+	}
+	return len(ids), nil
+}
+
+type fakePublisher struct {
+	mu        sync.Mutex
+	failing   map[int]bool
+	published []int64
+}
+
+func (f *fakePublisher) Publish(ctx context.Context, event *domain.Event) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failing[event.AggregateID] {
+		return errors.New("broker unavailable")
+	}
+	f.published = append(f.published, event.ID)
+	return nil
+}
+
+func TestOutboxRelayRun(t *testing.T) {
+	// Events 1 to 5 belong to users 1, 2, 1, 2 and 3.
+	tests := []struct {
+		name          string
+		batchSize     int
+		failing       map[int]bool
+		wantCalls     int
+		wantPublished []int64
+	}{
+		{name: "drains full batches", batchSize: 2, wantCalls: 3, wantPublished: []int64{1, 2, 3, 4, 5}},
+		{
+			// Event 1 fails, so the first batch is short and the relay
+			// waits instead of retrying it at once.
+			name:          "waits after a failure",
+			batchSize:     2,
+			failing:       map[int]bool{1: true},
+			wantCalls:     1,
+			wantPublished: []int64{2},
+		},
+		{
+			// User 2's event 2 fails, so event 4 is held back behind it
+			// while the other users' events go on.
+			name:          "holds back later events of a failing user",
+			batchSize:     5,
+			failing:       map[int]bool{2: true},
+			wantCalls:     1,
+			wantPublished: []int64{1, 3, 5},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outbox := &fakeOutbox{published: make(map[int64]bool), idle: make(chan struct{}, 1)}
+			for i, user := range []int{1, 2, 1, 2, 3} {
+				outbox.events = append(outbox.events, &domain.Event{ID: int64(i + 1), AggregateType: "user", AggregateID: user})
+			}
+			publisher := &fakePublisher{failing: tt.failing}
+			relay := NewOutboxRelay(outbox, publisher, tt.batchSize, time.Hour)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				relay.Run(ctx)
+				close(done)
+			}()
+			select {
+			case <-outbox.idle:
+			case <-time.After(5 * time.Second):
+				t.Fatal("relay never waited for its next tick")
+			}
+			cancel()
+			<-done
+
+			if outbox.calls != tt.wantCalls {
+				t.Errorf("Relay called %d times, want %d", outbox.calls, tt.wantCalls)
+			}
+			if !slices.Equal(publisher.published, tt.wantPublished) {
+				t.Errorf("published %v, want %v", publisher.published, tt.wantPublished)
+			}
+		})
+	}
+}
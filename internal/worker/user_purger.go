@@ -0,0 +1,53 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"tablelink/internal/repository"
+	"time"
+)
+
+// UserPurger permanently removes soft-deleted users once they have been
+// deleted for longer than the retention period.
+type UserPurger struct {
+	userRepo  repository.UserRepository
+	retention time.Duration
+	interval  time.Duration
+}
+
+func NewUserPurger(userRepo repository.UserRepository, retention, interval time.Duration) *UserPurger {
+	return &UserPurger{
+		userRepo:  userRepo,
+		retention: retention,
+		interval:  interval,
+	}
+}
+
+func (p *UserPurger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		p.purge(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *UserPurger) purge(ctx context.Context) {
+	before := time.Now().UTC().Add(-p.retention)
+	purged, err := p.userRepo.PurgeDeletedBefore(ctx, before)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Failed to purge deleted users with err %v", err)
+		}
+		return
+	}
+	if purged > 0 {
+		log.Printf("Purged %d users deleted before %s", purged, before.Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,108 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"tablelink/internal/domain"
+	"tablelink/internal/repository"
+	"tablelink/internal/webhook"
+	"time"
+)
+
+// WebhookDeliverer sends pending webhook deliveries. Failed deliveries are
+// retried with exponential backoff and moved to the dead-letter state once
+// maxAttempts is reached.
+type WebhookDeliverer struct {
+	webhookRepo repository.WebhookRepository
+	sender      *webhook.Sender
+	maxAttempts int
+	backoffBase time.Duration
+	backoffMax  time.Duration
+	batchSize   int
+	interval    time.Duration
+	lease       time.Duration
+}
+
+func NewWebhookDeliverer(webhookRepo repository.WebhookRepository, sender *webhook.Sender, maxAttempts int,
+	backoffBase, backoffMax time.Duration, batchSize int, interval, lease time.Duration) *WebhookDeliverer {
+	return &WebhookDeliverer{
+		webhookRepo: webhookRepo,
+		sender:      sender,
+		maxAttempts: maxAttempts,
+		backoffBase: backoffBase,
+		backoffMax:  backoffMax,
+		batchSize:   batchSize,
+		interval:    interval,
+		lease:       lease,
+	}
+}
+
+func (d *WebhookDeliverer) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		for d.deliver(ctx) == d.batchSize && ctx.Err() == nil {
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *WebhookDeliverer) deliver(ctx context.Context) int {
+	deliveries, err := d.webhookRepo.ClaimDue(ctx, d.batchSize, d.lease)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Failed to claim webhook deliveries with err %v", err)
+		}
+		return 0
+	}
+
+	for _, delivery := range deliveries {
+		d.send(ctx, delivery)
+	}
+	return len(deliveries)
+}
+
+func (d *WebhookDeliverer) send(ctx context.Context, delivery *domain.WebhookDelivery) {
+	started := time.Now()
+	code, err := d.sender.Send(ctx, delivery)
+	if ctx.Err() != nil {
+		// Shutting down; the lease expires and the delivery is picked up
+		// again without burning an attempt.
+		return
+	}
+
+	attempt := &domain.WebhookAttempt{
+		DeliveryID: delivery.ID,
+		Attempt:    delivery.Attempts + 1,
+		DurationMs: int(time.Since(started).Milliseconds()),
+	}
+	if code != 0 {
+		attempt.StatusCode = &code
+	}
+
+	status := domain.WebhookDeliverySucceeded
+	next := time.Now()
+	if err != nil {
+		msg := err.Error()
+		attempt.Error = &msg
+		status = domain.WebhookDeliveryPending
+		next = next.Add(webhook.Backoff(attempt.Attempt, d.backoffBase, d.backoffMax))
+		if attempt.Attempt >= d.maxAttempts {
+			status = domain.WebhookDeliveryDead
+		}
+	}
+
+	if err := d.webhookRepo.RecordAttempt(ctx, attempt, status, next); err != nil {
+		log.Printf("Failed to record webhook delivery %d with err %v", delivery.ID, err)
+		return
+	}
+	if status == domain.WebhookDeliveryDead {
+		log.Printf("Webhook delivery %d is dead after %d attempts", delivery.ID, attempt.Attempt)
+	}
+}